@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// logAccess logs a record of the connection once it's closed, if the access
+// log of the app is enabled. Connections going past the slow or the bytes
+// threshold are always logged, the rest are sampled 1 in AccessLogSample.
+func (f *Frontend) logAccess(config *FrontendConfig, conn *Connection, err error) {
+	if !config.AccessLog {
+		return
+	}
+	state := conn.State(time.Now())
+	duration := time.Since(conn.Started)
+	closed := atomic.AddUint64(&f.closed, 1)
+	if !shouldLogAccess(config, closed, duration, state.BytesIn+state.BytesOut) {
+		metrics.Counter("frontend-access-log-skipped", "app", f.appId).Inc()
+		return
+	}
+
+	fields := []string{
+		"app", state.AppId,
+		"client", state.Client,
+		"backend", state.Backend,
+		"started", state.Started.UTC().Format(time.RFC3339Nano),
+		"duration", duration.String(),
+		"bytesIn", strconv.FormatInt(state.BytesIn, 10),
+		"bytesOut", strconv.FormatInt(state.BytesOut, 10),
+	}
+	if state.Protocol != "" {
+		fields = append(fields, "protocol", state.Protocol, "compression", state.Compression)
+	}
+	if err != nil {
+		fields = append(fields, "error", err.Error())
+	}
+	accessLogs.write(f.appId, "[ACCESS] "+logfmt(fields...))
+}
+
+// useAccessLog points the access log of the app to the sink of the config
+func (f *Frontend) useAccessLog(config *FrontendConfig) {
+	target := config.AccessLogSink
+	if !config.AccessLog {
+		target = ""
+	}
+	accessLogs.use(f.appId, target)
+}
+
+// shouldLogAccess decides if the nth closed connection of the app is logged
+func shouldLogAccess(config *FrontendConfig, nth uint64, duration time.Duration, bytes int64) bool {
+	if config.AccessLogSlow > 0 && duration >= config.AccessLogSlow {
+		return true
+	}
+	if config.AccessLogBytes > 0 && bytes >= int64(config.AccessLogBytes) {
+		return true
+	}
+	return config.AccessLogSample > 0 && nth%uint64(config.AccessLogSample) == 0
+}
+
+// logfmt formats the key value pairs as key=value separated by spaces,
+// quoting the values that need it
+func logfmt(keyValues ...string) string {
+	pairs := make([]string, 0, len(keyValues)/2)
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		value := keyValues[i+1]
+		if value == "" || strings.ContainsAny(value, " =\"") {
+			value = strconv.Quote(value)
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%s", keyValues[i], value))
+	}
+	return strings.Join(pairs, " ")
+}
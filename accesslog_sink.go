@@ -0,0 +1,188 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// accessLogs routes the access log of every app to it's sink
+var accessLogs = newAccessLogSinks()
+
+// accessSink is a destination of the access log other than the shared log
+type accessSink interface {
+	write(record string) error
+	// reopen reopens the file after it's rotated, a no-op for the others
+	reopen() error
+	close() error
+}
+
+// accessLogSinks keeps the sink of every app that logs to one. The apps
+// logging to the same target share the sink, which is closed once the last
+// of them is gone.
+type accessLogSinks struct {
+	lock sync.Mutex
+	// target of every app with a sink
+	apps map[string]string
+	// open sinks by their target
+	sinks map[string]*sharedSink
+}
+
+type sharedSink struct {
+	sink accessSink
+	apps int
+}
+
+func newAccessLogSinks() *accessLogSinks {
+	return &accessLogSinks{
+		apps:  make(map[string]string),
+		sinks: make(map[string]*sharedSink),
+	}
+}
+
+// use sets the target the app logs to, "" for the shared log
+func (s *accessLogSinks) use(appId, target string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.apps[appId] == target {
+		return
+	}
+	s.releaseLocked(appId)
+	if target == "" {
+		return
+	}
+	shared, present := s.sinks[target]
+	if !present {
+		shared = &sharedSink{sink: newAccessSink(target)}
+		s.sinks[target] = shared
+	}
+	shared.apps++
+	s.apps[appId] = target
+}
+
+// release drops the sink of the app once it's gone, the records that come
+// in after that go to the shared log
+func (s *accessLogSinks) release(appId string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.releaseLocked(appId)
+}
+
+func (s *accessLogSinks) releaseLocked(appId string) {
+	target, present := s.apps[appId]
+	if !present {
+		return
+	}
+	delete(s.apps, appId)
+	shared := s.sinks[target]
+	shared.apps--
+	if shared.apps == 0 {
+		delete(s.sinks, target)
+		if err := shared.sink.close(); err != nil {
+			log.Printf("[WARN] Unable to close the access log %s - %v\n", target, err)
+		}
+	}
+}
+
+// write logs the record to the sink of the app, falling back to the shared
+// log when it has none or the sink fails
+func (s *accessLogSinks) write(appId, record string) {
+	s.lock.Lock()
+	var sink accessSink
+	if shared, present := s.sinks[s.apps[appId]]; present {
+		sink = shared.sink
+	}
+	s.lock.Unlock()
+	if sink != nil {
+		err := sink.write(record)
+		if err == nil {
+			return
+		}
+		metrics.Counter("frontend-access-log-errors", "app", appId).Inc()
+	}
+	log.Println(record)
+}
+
+// reopen reopens all the sinks, on a SIGHUP once logrotate has moved the files
+func (s *accessLogSinks) reopen() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for target, shared := range s.sinks {
+		if err := shared.sink.reopen(); err != nil {
+			log.Printf("[WARN] Unable to reopen the access log %s - %v\n", target, err)
+		}
+	}
+}
+
+// newAccessSink returns the sink of the target, a syslog one for syslog://
+// targets and a file for the rest. A sink that can't be opened logs why and
+// fails the records, so they go to the shared log until it's reopened.
+func newAccessSink(target string) accessSink {
+	if target == "syslog" || strings.HasPrefix(target, "syslog://") || strings.HasPrefix(target, "syslog+tcp://") {
+		sink, err := newSyslogSink(target)
+		if err != nil {
+			log.Printf("[WARN] Unable to connect to the access log %s, using the shared log - %v\n", target, err)
+			return &failedSink{err: err}
+		}
+		return sink
+	}
+	sink := &fileSink{path: target}
+	if err := sink.reopen(); err != nil {
+		log.Printf("[WARN] Unable to open the access log %s, using the shared log - %v\n", target, err)
+	}
+	return sink
+}
+
+// fileSink appends the records to a file, in the same format as the shared log
+type fileSink struct {
+	path string
+
+	lock   sync.Mutex
+	file   *os.File
+	logger *log.Logger
+}
+
+func (f *fileSink) write(record string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.logger == nil {
+		return os.ErrClosed
+	}
+	return f.logger.Output(2, record)
+}
+
+func (f *fileSink) reopen() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file != nil {
+		f.file.Close()
+	}
+	f.file = file
+	f.logger = log.New(file, "", log.LstdFlags)
+	return nil
+}
+
+func (f *fileSink) close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file, f.logger = nil, nil
+	return err
+}
+
+// failedSink is a syslog target we couldn't connect to
+type failedSink struct {
+	err error
+}
+
+func (f *failedSink) write(string) error { return f.err }
+func (f *failedSink) reopen() error      { return f.err }
+func (f *failedSink) close() error       { return nil }
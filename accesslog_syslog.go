@@ -0,0 +1,35 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"log/syslog"
+	"strings"
+)
+
+// newSyslogSink connects to the local syslog daemon for "syslog", or to the
+// host:port of syslog:// (UDP) and syslog+tcp:// targets
+func newSyslogSink(target string) (accessSink, error) {
+	network, address := "", ""
+	if strings.HasPrefix(target, "syslog://") {
+		network, address = "udp", strings.TrimPrefix(target, "syslog://")
+	} else if strings.HasPrefix(target, "syslog+tcp://") {
+		network, address = "tcp", strings.TrimPrefix(target, "syslog+tcp://")
+	}
+	writer, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_DAEMON, "gotlb")
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{writer: writer}, nil
+}
+
+// syslogSink sends the records to syslog, which reconnects by itself when
+// the connection fails
+type syslogSink struct {
+	writer *syslog.Writer
+}
+
+func (s *syslogSink) write(record string) error { return s.writer.Info(record) }
+func (s *syslogSink) reopen() error             { return nil }
+func (s *syslogSink) close() error              { return s.writer.Close() }
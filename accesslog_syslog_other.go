@@ -0,0 +1,10 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import "errors"
+
+func newSyslogSink(target string) (accessSink, error) {
+	return nil, errors.New("syslog isn't supported on this platform")
+}
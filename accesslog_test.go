@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ashwanthkumar/golang-utils/sets"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldLogAccessToSample1InN(t *testing.T) {
+	config := NewFrontendConfig(map[string]string{"tlb.accesslog": "true", "tlb.accesslog.sample": "3"})
+	var logged []uint64
+	for nth := uint64(1); nth <= 9; nth++ {
+		if shouldLogAccess(config, nth, time.Millisecond, 10) {
+			logged = append(logged, nth)
+		}
+	}
+	assert.Equal(t, []uint64{3, 6, 9}, logged)
+}
+
+func TestShouldLogAccessToAlwaysLogSlowAndLargeConnections(t *testing.T) {
+	config := NewFrontendConfig(map[string]string{
+		"tlb.accesslog":        "true",
+		"tlb.accesslog.sample": "0",
+		"tlb.accesslog.slow":   "1s",
+		"tlb.accesslog.bytes":  "1024",
+	})
+	assert.False(t, shouldLogAccess(config, 1, time.Millisecond, 10))
+	assert.True(t, shouldLogAccess(config, 1, 2*time.Second, 10))
+	assert.True(t, shouldLogAccess(config, 1, time.Millisecond, 2048))
+}
+
+func TestLogfmt(t *testing.T) {
+	assert.Equal(t, `app=/foo client=10.0.0.1:1234 error="connection reset by peer" backend=""`,
+		logfmt("app", "/foo", "client", "10.0.0.1:1234", "error", "connection reset by peer", "backend", ""))
+}
+
+func TestAccessLogSinksToReopenTheFileAfterItsRotated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotlb-accesslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "access.log")
+	sinks := newAccessLogSinks()
+	sinks.use("/a", path)
+	sinks.use("/b", path)
+	assert.Len(t, sinks.sinks, 1)
+
+	sinks.write("/a", "[ACCESS] app=/a")
+	os.Rename(path, path+".1")
+	sinks.reopen()
+	sinks.write("/b", "[ACCESS] app=/b")
+
+	rotated, _ := ioutil.ReadFile(path + ".1")
+	assert.Contains(t, string(rotated), "[ACCESS] app=/a\n")
+	current, _ := ioutil.ReadFile(path)
+	assert.Contains(t, string(current), "[ACCESS] app=/b\n")
+	assert.NotContains(t, string(current), "app=/a")
+
+	// the sink is closed once the last app using it is gone
+	sinks.release("/a")
+	assert.Len(t, sinks.sinks, 1)
+	sinks.release("/b")
+	assert.Empty(t, sinks.sinks)
+	assert.Empty(t, sinks.apps)
+}
+
+func TestAccessLogSinksToFallbackToTheSharedLog(t *testing.T) {
+	errors := metrics.Counter("frontend-access-log-errors", "app", "/sinkless-app")
+	before := errors.Value()
+	sinks := newAccessLogSinks()
+	sinks.use("/sinkless-app", "/nonexistent/gotlb/access.log")
+	sinks.write("/sinkless-app", "[ACCESS] app=/sinkless-app")
+	assert.Equal(t, before+1, errors.Value())
+
+	// no sink at all isn't an error
+	sinks.use("/sinkless-app", "")
+	sinks.write("/sinkless-app", "[ACCESS] app=/sinkless-app")
+	assert.Equal(t, before+1, errors.Value())
+	assert.Empty(t, sinks.sinks)
+}
+
+func TestFrontendToLogToTheSinkOnlyWhenTheAccessLogIsOn(t *testing.T) {
+	frontend := NewFrontend("/sink-app", "-1", sets.Empty(), NewFrontendConfig(map[string]string{"tlb.accesslog.sink": "syslog://127.0.0.1:514"}))
+	defer accessLogs.release("/sink-app")
+	assert.Equal(t, "", accessLogs.apps["/sink-app"])
+
+	frontend.UpdateConfig(NewFrontendConfig(map[string]string{"tlb.accesslog": "true", "tlb.accesslog.sink": "syslog://127.0.0.1:514"}))
+	assert.Equal(t, "syslog://127.0.0.1:514", accessLogs.apps["/sink-app"])
+}
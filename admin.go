@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// how long a pin lasts when the request doesn't say
+	defaultPinTTL = 5 * time.Minute
+	// pins are for debugging, they shouldn't outlive it by much
+	maxPinTTL = time.Hour
+	// how long a drain waits on the connections when the request doesn't say
+	defaultDrainTimeout = 30 * time.Second
+	// the request is held open while it waits
+	maxDrainTimeout = 10 * time.Minute
+)
+
+// AdminServer exposes the internal state of GoTLB over HTTP for operators
+type AdminServer struct {
+	addr        string
+	manager     *Manager
+	metrics     *MetricsRegistry
+	connections *ConnectionTracker
+}
+
+// NewAdminServer creates a new AdminServer that would listen on addr
+func NewAdminServer(addr string, manager *Manager, metrics *MetricsRegistry, connections *ConnectionTracker) *AdminServer {
+	return &AdminServer{
+		addr:        addr,
+		manager:     manager,
+		metrics:     metrics,
+		connections: connections,
+	}
+}
+
+// Start serves the admin API, it blocks until the server fails
+func (a *AdminServer) Start() error {
+	log.Printf("Starting Admin API on %s\n", a.addr)
+	return http.ListenAndServe(a.addr, a.Handler())
+}
+
+// Handler returns the http.Handler with all the admin API routes
+func (a *AdminServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/metrics", a.metricsHandler)
+	mux.HandleFunc("/api/metrics/catalog", a.metricsCatalogHandler)
+	mux.HandleFunc("/api/conflicts", a.conflictsHandler)
+	mux.HandleFunc("/api/connections", a.connectionsHandler)
+	mux.HandleFunc("/api/frontends", a.frontendsHandler)
+	mux.HandleFunc("/api/frontends/pin", a.pinHandler)
+	mux.HandleFunc("/api/frontends/unpin", a.unpinHandler)
+	mux.HandleFunc("/api/frontends/drain", a.drainHandler)
+	return mux
+}
+
+func (a *AdminServer) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, a.metrics.Snapshot())
+}
+
+func (a *AdminServer) metricsCatalogHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, a.metrics.Catalog())
+}
+
+func (a *AdminServer) conflictsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, a.manager.Conflicts())
+}
+
+func (a *AdminServer) frontendsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, a.manager.State())
+}
+
+// pinHandler routes all the new connections of an app to one of it's backends
+// for debugging - POST /api/frontends/pin?app=/foo&backend=10.0.0.1:31000&ttl=10m
+func (a *AdminServer) pinHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "pin needs a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	ttl := defaultPinTTL
+	if param := query.Get("ttl"); param != "" {
+		parsed, err := time.ParseDuration(param)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "invalid ttl - "+param, http.StatusBadRequest)
+			return
+		}
+		ttl = parsed
+	}
+	if ttl > maxPinTTL {
+		ttl = maxPinTTL
+	}
+	if err := a.manager.Pin(query.Get("app"), query.Get("backend"), ttl); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"pinned": true, "ttl": ttl.String()})
+}
+
+// unpinHandler releases the pin of an app - POST /api/frontends/unpin?app=/foo
+func (a *AdminServer) unpinHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "unpin needs a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	released, err := a.manager.Unpin(r.URL.Query().Get("app"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"released": released})
+}
+
+// drainHandler stops routing to a backend of an app and removes it once it's
+// connections are closed - POST /api/frontends/drain?app=/foo&backend=10.0.0.1:31000&timeout=1m
+func (a *AdminServer) drainHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "drain needs a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	timeout := defaultDrainTimeout
+	if param := query.Get("timeout"); param != "" {
+		parsed, err := time.ParseDuration(param)
+		if err != nil || parsed < 0 {
+			http.Error(w, "invalid timeout - "+param, http.StatusBadRequest)
+			return
+		}
+		timeout = parsed
+	}
+	if timeout > maxDrainTimeout {
+		timeout = maxDrainTimeout
+	}
+	drained, err := a.manager.Drain(query.Get("app"), query.Get("backend"), timeout)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"drained": drained})
+}
+
+// connectionsHandler lists the active connections, a page at a time since a busy
+// GoTLB could have a lot of them - /api/connections?app=/foo&offset=0&limit=100
+func (a *AdminServer) connectionsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	offset := intParam(query.Get("offset"), 0, 0, math.MaxInt32)
+	limit := intParam(query.Get("limit"), 100, 1, 1000)
+	total, list := a.connections.List(query.Get("app"), offset, limit)
+	writeJSON(w, map[string]interface{}{
+		"total":       total,
+		"offset":      offset,
+		"limit":       limit,
+		"connections": list,
+	})
+}
+
+// intParam parses the query param, falling back to the default when it's
+// missing or invalid and keeping it within [min, max]
+func intParam(param string, defaultValue, min, max int) int {
+	value, err := strconv.Atoi(param)
+	if err != nil {
+		return defaultValue
+	}
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		log.Printf("[WARN] Unable to write the admin API response - %v\n", err)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ashwanthkumar/golang-utils/sets"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminServerToListConnectionsAPageAtATime(t *testing.T) {
+	tracker := NewConnectionTracker()
+	for i := 0; i < 3; i++ {
+		tracker.Track("/a", "client", "b:1")
+	}
+	tracker.Track("/b", "client", "b:2")
+	admin := NewAdminServer("", NewManager(), NewMetricsRegistry(), tracker)
+
+	var response struct {
+		Total       int
+		Offset      int
+		Limit       int
+		Connections []ConnectionState
+	}
+	get(t, admin, "/api/connections?app=/a&offset=1&limit=1", &response)
+	assert.Equal(t, 3, response.Total)
+	assert.Equal(t, 1, response.Offset)
+	assert.Equal(t, 1, response.Limit)
+	assert.Len(t, response.Connections, 1)
+	assert.Equal(t, uint64(2), response.Connections[0].Id)
+
+	get(t, admin, "/api/connections?limit=100000", &response)
+	assert.Equal(t, 4, response.Total)
+	assert.Equal(t, 1000, response.Limit)
+}
+
+func get(t *testing.T, admin *AdminServer, url string, response interface{}) {
+	recorder := httptest.NewRecorder()
+	admin.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", url, nil))
+	assert.Equal(t, 200, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), response))
+}
+
+func TestAdminServerToListTheMetricsCatalog(t *testing.T) {
+	registry := NewMetricsRegistry()
+	registry.Counter("frontend-failovers", "app", "/a").Inc()
+	admin := NewAdminServer("", NewManager(), registry, NewConnectionTracker())
+
+	var catalog []MetricDescription
+	get(t, admin, "/api/metrics/catalog", &catalog)
+	assert.Equal(t, []MetricDescription{{Name: "frontend-failovers", Type: Counter, Tags: []string{"app"}, Series: 1}}, catalog)
+}
+
+func TestAdminServerToPinAFrontend(t *testing.T) {
+	m := NewManager()
+	m.addFrontend(APP_ID, createFrontend(APP_ID, "-1", sets.FromSlice([]string{"b:1", "b:2"})))
+	admin := NewAdminServer("", m, NewMetricsRegistry(), NewConnectionTracker())
+
+	assert.Equal(t, 405, request(admin, "GET", "/api/frontends/pin?app="+APP_ID+"&backend=b:2").Code)
+	assert.Equal(t, 404, request(admin, "POST", "/api/frontends/pin?app="+APP_ID+"&backend=b:3").Code)
+	assert.Equal(t, 400, request(admin, "POST", "/api/frontends/pin?app="+APP_ID+"&backend=b:2&ttl=soon").Code)
+	assert.Equal(t, 200, request(admin, "POST", "/api/frontends/pin?app="+APP_ID+"&backend=b:2&ttl=10m").Code)
+
+	var state []FrontendState
+	get(t, admin, "/api/frontends", &state)
+	assert.Len(t, state, 1)
+	assert.Equal(t, "b:2", state[0].Override.Backend)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), state[0].Override.Expires, time.Minute)
+
+	assert.Equal(t, 200, request(admin, "POST", "/api/frontends/unpin?app="+APP_ID).Code)
+	var unpinned []FrontendState
+	get(t, admin, "/api/frontends", &unpinned)
+	assert.Nil(t, unpinned[0].Override)
+	assert.Equal(t, 404, request(admin, "POST", "/api/frontends/unpin?app=/unknown").Code)
+}
+
+func TestAdminServerToDrainABackend(t *testing.T) {
+	m := NewManager()
+	m.addFrontend(APP_ID, createFrontend(APP_ID, "-1", sets.FromSlice([]string{"b:1", "b:2"})))
+	admin := NewAdminServer("", m, NewMetricsRegistry(), NewConnectionTracker())
+
+	assert.Equal(t, 405, request(admin, "GET", "/api/frontends/drain?app="+APP_ID+"&backend=b:2").Code)
+	assert.Equal(t, 404, request(admin, "POST", "/api/frontends/drain?app="+APP_ID+"&backend=b:3").Code)
+	assert.Equal(t, 404, request(admin, "POST", "/api/frontends/drain?app=/unknown&backend=b:2").Code)
+	assert.Equal(t, 400, request(admin, "POST", "/api/frontends/drain?app="+APP_ID+"&backend=b:2&timeout=soon").Code)
+	recorder := request(admin, "POST", "/api/frontends/drain?app="+APP_ID+"&backend=b:2&timeout=1s")
+	assert.Equal(t, 200, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"drained":true`)
+
+	var state []FrontendState
+	get(t, admin, "/api/frontends", &state)
+	assert.Equal(t, []string{"b:1"}, state[0].Backends)
+}
+
+func request(admin *AdminServer, method, url string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	admin.Handler().ServeHTTP(recorder, httptest.NewRequest(method, url, nil))
+	return recorder
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ashwanthkumar/gotlb/types"
+)
+
+// Affinity is an implementation of Strategy that wraps a base strategy and
+// keeps sending a client IP to the backend the base picked for it, across
+// it's connections, until the client has gone a ttl without a connection.
+// Unlike iphash the pinning is only as old as the client, so it doesn't
+// need the backends to be stable, and the base still spreads the new
+// clients as it would. A client pinned to a backend that's removed (or
+// excluded, like after a failover) is picked for again by the base.
+type Affinity struct {
+	lock    sync.Mutex
+	ttl     time.Duration
+	base    LoadBalancingStrategy
+	clients map[string]*affinity
+	// the backends we've given the base, the info of an existing one only
+	// goes to a base that cares about it
+	backends map[string]bool
+	// when we last dropped the clients that expired
+	swept time.Time
+	now   func() time.Time
+}
+
+type affinity struct {
+	backend  string
+	lastSeen time.Time
+}
+
+func AffinityStrategy(ttl time.Duration, base LoadBalancingStrategy) LoadBalancingStrategy {
+	return &Affinity{
+		ttl:      ttl,
+		base:     base,
+		clients:  make(map[string]*affinity),
+		backends: make(map[string]bool),
+		swept:    time.Now(),
+		now:      time.Now,
+	}
+}
+
+func (a *Affinity) AddBackendInfo(backend *types.BackendInfo) {
+	a.UpdateBackends([]*types.BackendInfo{backend}, nil)
+}
+
+func (a *Affinity) AddBackend(backend string) {
+	a.AddBackendInfo(&types.BackendInfo{Node: backend})
+}
+
+func (a *Affinity) RemoveBackend(backend string) {
+	a.UpdateBackends(nil, []string{backend})
+}
+
+func (a *Affinity) UpdateBackends(added []*types.BackendInfo, removed []string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	_, aware := a.base.(BackendInfoAware)
+	var fresh []*types.BackendInfo
+	gone := make(map[string]bool, len(removed))
+	for _, backend := range removed {
+		delete(a.backends, backend)
+		gone[backend] = true
+	}
+	for _, backend := range added {
+		if aware || !a.backends[backend.Node] {
+			fresh = append(fresh, backend)
+		}
+		a.backends[backend.Node] = true
+	}
+	updateBackends(a.base, fresh, removed)
+	if len(gone) == 0 {
+		return
+	}
+	for client, pinned := range a.clients {
+		if gone[pinned.backend] {
+			delete(a.clients, client)
+		}
+	}
+}
+
+// Next is used when we don't know the client, the base picks any backend
+func (a *Affinity) Next() string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.base.Next()
+}
+
+func (a *Affinity) NextFor(key string, exclude map[string]bool) string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	now := a.now()
+	a.sweep(now)
+	if pinned, present := a.clients[key]; present && now.Sub(pinned.lastSeen) < a.ttl && !exclude[pinned.backend] {
+		pinned.lastSeen = now
+		return pinned.backend
+	}
+	backend := nextFor(a.base, key, exclude)
+	if backend != "" && !exclude[backend] {
+		a.clients[key] = &affinity{backend: backend, lastSeen: now}
+	}
+	return backend
+}
+
+// sweep drops the clients that have expired, at most once every ttl
+func (a *Affinity) sweep(now time.Time) {
+	if now.Sub(a.swept) < a.ttl {
+		return
+	}
+	for client, pinned := range a.clients {
+		if now.Sub(pinned.lastSeen) >= a.ttl {
+			delete(a.clients, client)
+		}
+	}
+	a.swept = now
+}
+
+func (a *Affinity) SetHealthy(backend string, healthy bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if sticky, ok := a.base.(StickyStrategy); ok {
+		sticky.SetHealthy(backend, healthy)
+	}
+}
+
+func (a *Affinity) Acquire(backend string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	acquire(a.base, backend)
+}
+
+func (a *Affinity) Release(backend string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	release(a.base, backend)
+}
+
+func (a *Affinity) Observe(backend string, latency time.Duration) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	observe(a.base, backend, latency)
+}
+
+// Clients returns the number of clients pinned right now
+func (a *Affinity) Clients() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return len(a.clients)
+}
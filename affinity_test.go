@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAffinityToKeepTheClientOnItsBackendUntilTheTTL(t *testing.T) {
+	now := time.Now()
+	s := AffinityStrategy(time.Minute, RoundRobinStrategy()).(*Affinity)
+	s.now = func() time.Time { return now }
+	s.AddBackend("a")
+	s.AddBackend("b")
+
+	assert.Equal(t, "a", s.NextFor("10.0.0.1", nil))
+	assert.Equal(t, "b", s.NextFor("10.0.0.2", nil))
+	now = now.Add(50 * time.Second)
+	assert.Equal(t, "a", s.NextFor("10.0.0.1", nil))
+	// every connection keeps it alive for another ttl
+	now = now.Add(50 * time.Second)
+	assert.Equal(t, "a", s.NextFor("10.0.0.1", nil))
+
+	// the other one expired and goes to the next backend of the strategy
+	now = now.Add(30 * time.Second)
+	assert.Equal(t, "a", s.NextFor("10.0.0.2", nil))
+	assert.Equal(t, 2, s.Clients())
+
+	// the expired ones are swept once a ttl
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, "b", s.NextFor("10.0.0.3", nil))
+	assert.Equal(t, 1, s.Clients())
+}
+
+func TestAffinityToRepickTheClientsOfARemovedBackend(t *testing.T) {
+	s := AffinityStrategy(time.Minute, RoundRobinStrategy()).(*Affinity)
+	s.AddBackend("a")
+	s.AddBackend("b")
+	assert.Equal(t, "a", s.NextFor("10.0.0.1", nil))
+	assert.Equal(t, "b", s.NextFor("10.0.0.2", nil))
+
+	s.RemoveBackend("a")
+	assert.Equal(t, 1, s.Clients())
+	assert.Equal(t, "b", s.NextFor("10.0.0.1", nil))
+	assert.Equal(t, "b", s.NextFor("10.0.0.1", nil))
+	// an excluded backend, like after a failover, is picked for again too. Round
+	// robin doesn't know of the exclusions, the request asks again like it does
+	s.AddBackend("a")
+	excluded := map[string]bool{"b": true}
+	backend := s.NextFor("10.0.0.2", excluded)
+	if backend == "b" {
+		backend = s.NextFor("10.0.0.2", excluded)
+	}
+	assert.Equal(t, "a", backend)
+	assert.Equal(t, "a", s.NextFor("10.0.0.2", nil))
+}
+
+func TestAffinityToPassTheInfoOfTheExistingBackendsOnlyToTheAwareStrategies(t *testing.T) {
+	s := AffinityStrategy(time.Minute, RoundRobinStrategy())
+	s.(*Affinity).AddBackend("a")
+	s.(*Affinity).AddBackend("a")
+	s.(*Affinity).AddBackend("b")
+	picked := map[string]int{}
+	for i := 0; i < 4; i++ {
+		picked[s.Next()]++
+	}
+	assert.Equal(t, map[string]int{"a": 2, "b": 2}, picked)
+}
+
+func TestAffinityToBeSafeForConcurrentClients(t *testing.T) {
+	s := buildStrategy(APP_ID, RoundRobinName, NewFrontendConfig(map[string]string{"tlb.affinity.ttl": "1m"})).(*Affinity)
+	s.AddBackend("a")
+	s.AddBackend("b")
+	var clients sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		clients.Add(1)
+		go func(client string) {
+			defer clients.Done()
+			first := s.NextFor(client, nil)
+			for j := 0; j < 100; j++ {
+				assert.Equal(t, first, s.NextFor(client, nil))
+			}
+		}(string(rune('a' + i)))
+	}
+	clients.Wait()
+	assert.Equal(t, 10, s.Clients())
+}
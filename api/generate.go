@@ -0,0 +1,4 @@
+// Package api has the gRPC API of GoTLB, the code is generated from gotlb.proto
+package api
+
+//go:generate protoc -I.. --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative api/gotlb.proto
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/ashwanthkumar/gotlb/types"
+)
+
+// FrontendConfig represents the per-app tunables of a Frontend, they're
+// derived from the labels of the app
+type FrontendConfig struct {
+	// Name of the load balancing strategy
+	Strategy string
+	// Window within which the changes to the backends are applied together, 0 applies them right away
+	CoalesceWindow time.Duration
+	// Strategy that only computes what it would have picked, to compare it with Strategy
+	ShadowStrategy string
+	// How sticky strategies pick a backend when the pinned one is unavailable
+	StickyFallback string
+	// Hash of the keys of the hash based strategies
+	Hash string
+	// How long a client keeps going to the same backend after it's last connection, 0 disables it
+	AffinityTTL time.Duration
+	// How the weights of the backends are read, relative or shares
+	Weights string
+	// Size of the lookup table of the maglev strategy, a prime
+	MaglevTableSize int
+	// Virtual nodes of every backend on the hash ring of the iphash strategy
+	Replicas int
+	// Size of the socket read buffer on the proxied connections, 0 leaves the OS default
+	ReadBuffer int
+	// Size of the socket write buffer on the proxied connections, 0 leaves the OS default
+	WriteBuffer int
+	// Cork the connections while copying so small writes are batched into full segments
+	Cork bool
+	// Accept backlog of the listener, 0 leaves the Go default (net.core.somaxconn)
+	Backlog int
+	// Number of backends to try for a connection before giving up
+	FailoverAttempts int
+	// Max bytes from the client we hold on to until the backend responds,
+	// so we can replay them on another backend if it fails
+	FailoverBuffer int
+	// Max connections that are accepted but not yet connected to a backend, 0 is unlimited
+	MaxPending int
+	// Max new connections per second, 0 is unlimited
+	ConnectionRate int
+	// New connections allowed at once over ConnectionRate
+	ConnectionBurst int
+	// Max open connections, 0 is unlimited
+	MaxConnections int
+	// What happens to the connections over MaxConnections, reject or queue
+	ConnectionsMode string
+	// Address family of the backends we prefer, any has no preference
+	IPFamily string
+	// Prefer the backends in the local zone of GoTLB
+	PreferLocalZone bool
+	// Minimum number of backends in the local zone below which we use all the zones
+	ZoneSpillover int
+	// Zone GoTLB is running in, set by the Manager
+	Zone string
+	// Max time a connection can go without any data, 0 is no timeout
+	IdleTimeout time.Duration
+	// TCP keepalive period of the connections, 0 leaves the Go default
+	KeepAlive time.Duration
+	// Interval at which the idle connections are probed, 0 doesn't probe them
+	ProbeInterval time.Duration
+	// Timeout of the connection to a backend
+	DialTimeout time.Duration
+	// Max time a connection stays open however active it is, 0 is no limit
+	MaxConnLifetime time.Duration
+	// Protocol of the frontend, tcp or udp
+	Protocol string
+	// How long a UDP session lives without a datagram either way
+	UDPTimeout time.Duration
+	// DSCP value of the packets to the backends, -1 leaves it as is
+	DSCP int
+	// Set the DSCP value on the packets to the clients too
+	DSCPClient bool
+	// Send a PROXY protocol header to the backends before anything from the client
+	ProxyProtocol bool
+	// Version of the PROXY protocol header, 1 or 2
+	ProxyProtocolVersion int
+	// Comma separated paths of the certificates and their keys (in the same order)
+	// the frontend terminates TLS with, empty doesn't
+	TLSCert string
+	TLSKey  string
+	// Detect the protocol and the compression from the first bytes of the client
+	Detect bool
+	// Timeout of the warmup connection to the new backends before they're routed, 0 routes them right away
+	Warmup time.Duration
+	// HTTP request header in which the clients ask for the backends with some tags, empty disables it
+	SteerHeader string
+	// Time we wait for the first bytes of the client to look for SteerHeader
+	SteerTimeout time.Duration
+	// Active health checks of the backends
+	HealthCheck HealthCheckConfig
+	// Consecutive failed dials after which a backend is pulled from the rotation, 0 doesn't pull them
+	OutlierFailures int
+	// Window within which OutlierFailures pull a backend, 0 needs them in a row
+	OutlierWindow time.Duration
+	// How long a backend pulled for it's failed dials stays out of the rotation, the first time
+	OutlierEjection time.Duration
+	// Time the connections to a backend removed by discovery get to finish before they're closed, 0 removes it right away
+	DrainTimeout time.Duration
+	// Sliding window of the success ratio of the dials to the backends
+	SLOWindow time.Duration
+	// Log a record of the connections when they're closed
+	AccessLog bool
+	// Log only 1 in AccessLogSample connections, 0 logs only the slow or large ones
+	AccessLogSample int
+	// Connections taking longer than this are always logged, 0 is no threshold
+	AccessLogSlow time.Duration
+	// Connections moving more bytes than this are always logged, 0 is no threshold
+	AccessLogBytes int
+	// Where the records go instead of the shared log, "" for the shared log
+	AccessLogSink string
+	// Labels of the app we couldn't use, and fell back to their defaults for
+	LabelErrors []types.LabelError
+}
+
+// NewFrontendConfig builds the FrontendConfig from the app labels
+func NewFrontendConfig(labels map[string]string) *FrontendConfig {
+	return newFrontendConfig(labels, DefaultHealthCheck)
+}
+
+// newFrontendConfig builds the FrontendConfig from the app labels, with the
+// health checks the app doesn't override from healthChecks. The labels that
+// can't be used fall back to their defaults and are kept in LabelErrors.
+func newFrontendConfig(labels map[string]string, healthChecks HealthCheckConfig) *FrontendConfig {
+	r := types.NewLabelReader(labels)
+	strategies := []string{RoundRobinName, WeightedName, IPHashName, MaglevName, LeastConnectionName, RandomName, P2CName, EWMAName}
+	config := &FrontendConfig{
+		Strategy:        r.OneOf(types.TLB_STRATEGY, RoundRobinName, strategies...),
+		CoalesceWindow:  r.Duration(types.TLB_COALESCE, 0),
+		ShadowStrategy:  r.OneOf(types.TLB_STRATEGY_SHADOW, "", strategies...),
+		StickyFallback:  r.OneOf(types.TLB_STICKY_FALLBACK, string(RingFallback), string(RingFallback), string(RehashFallback), string(StrategyFallback)),
+		Hash:            r.OneOf(types.TLB_HASH, FNVHash, FNVHash, CRC32Hash, Murmur3Hash, RendezvousHash),
+		AffinityTTL:     r.Duration(types.TLB_AFFINITY_TTL, 0),
+		Weights:         r.OneOf(types.TLB_WEIGHTS, RelativeWeights, RelativeWeights, ShareWeights),
+		MaglevTableSize: r.AtLeast(types.TLB_MAGLEV_TABLE, defaultMaglevTableSize, 1),
+		Replicas:        r.AtLeast(types.TLB_IPHASH_REPLICAS, defaultReplicas, 1),
+		ReadBuffer:      r.Int(types.TLB_BUFFER_READ, 0),
+		WriteBuffer:     r.Int(types.TLB_BUFFER_WRITE, 0),
+		Cork:            r.Bool(types.TLB_CORK, false),
+		Backlog:         r.Int(types.TLB_BACKLOG, 0),
+
+		FailoverAttempts: r.AtLeast(types.TLB_FAILOVER_ATTEMPTS, 1, 1),
+		FailoverBuffer:   r.Int(types.TLB_FAILOVER_BUFFER, 16*1024),
+		MaxPending:       r.Int(types.TLB_MAX_PENDING, 0),
+
+		ConnectionRate:  r.Int(r.Either(types.TLB_LIMIT_RATE, types.TLB_RATELIMIT_CPS), 0),
+		MaxConnections:  r.Int(types.TLB_LIMIT_CONNECTIONS, 0),
+		ConnectionsMode: r.OneOf(types.TLB_LIMIT_CONNECTIONS_MODE, RejectConnections, RejectConnections, QueueConnections),
+
+		IPFamily:        r.OneOf(types.TLB_IPFAMILY, AnyFamily, AnyFamily, IPv4Family, IPv6Family),
+		PreferLocalZone: r.Bool(types.TLB_ZONE_PREFER, false),
+		ZoneSpillover:   r.AtLeast(types.TLB_ZONE_SPILLOVER, 1, 1),
+
+		IdleTimeout:     r.Duration(types.TLB_TIMEOUT_IDLE, 0),
+		KeepAlive:       r.Duration(types.TLB_TIMEOUT_KEEPALIVE, 0),
+		ProbeInterval:   r.Duration(types.TLB_TIMEOUT_PROBE, 0),
+		DialTimeout:     r.Duration(types.TLB_TIMEOUT_DIAL, defaultDialTimeout),
+		MaxConnLifetime: r.Duration(types.TLB_TIMEOUT_LIFETIME, 0),
+
+		Protocol:   r.OneOf(types.TLB_PROTOCOL, TCPProtocol, TCPProtocol, UDPProtocol),
+		UDPTimeout: r.Duration(types.TLB_TIMEOUT_UDP, defaultUDPTimeout),
+
+		DSCP:       r.IntBetween(types.TLB_DSCP, -1, 0, 63),
+		DSCPClient: r.Bool(types.TLB_DSCP_CLIENT, false),
+
+		Detect: r.Bool(types.TLB_DETECT, false),
+
+		TLSCert: r.String(types.TLB_TLS_CERT, ""),
+		TLSKey:  r.String(types.TLB_TLS_KEY, ""),
+
+		ProxyProtocolVersion: r.IntBetween(types.TLB_PROXYPROTOCOL_VERSION, ProxyProtocolV1, ProxyProtocolV1, ProxyProtocolV2),
+
+		SteerHeader:  r.String(types.TLB_STEER_HEADER, ""),
+		SteerTimeout: r.Duration(types.TLB_STEER_TIMEOUT, defaultSteerTimeout),
+
+		HealthCheck: newHealthCheckConfig(r, healthChecks),
+		SLOWindow:   r.Duration(types.TLB_SLO_WINDOW, 0),
+		Warmup:      r.Duration(types.TLB_WARMUP, 0),
+
+		OutlierFailures: r.AtLeast(types.TLB_OUTLIER_FAILURES, 0, 0),
+		OutlierWindow:   r.Duration(types.TLB_OUTLIER_WINDOW, 0),
+		OutlierEjection: r.Duration(types.TLB_OUTLIER_EJECTION, defaultOutlierEjection),
+		DrainTimeout:    r.Duration(types.TLB_DRAIN_TIMEOUT, 0),
+
+		AccessLog:       r.Bool(types.TLB_ACCESSLOG, false),
+		AccessLogSample: r.Int(types.TLB_ACCESSLOG_SAMPLE, 1),
+		AccessLogSlow:   r.Duration(types.TLB_ACCESSLOG_SLOW, 0),
+		AccessLogBytes:  r.Int(types.TLB_ACCESSLOG_BYTES, 0),
+		AccessLogSink:   r.String(types.TLB_ACCESSLOG_SINK, ""),
+	}
+	config.ProxyProtocol, config.ProxyProtocolVersion = proxyProtocol(r, config.ProxyProtocolVersion)
+	config.ConnectionBurst = r.AtLeast(r.Either(types.TLB_LIMIT_BURST, types.TLB_RATELIMIT_BURST), config.ConnectionRate, 1)
+	if config.Cork && !corkSupported {
+		r.Invalid(types.TLB_CORK, "is not supported on this platform, ignoring it")
+		config.Cork = false
+	}
+	if len(splitPaths(config.TLSCert)) != len(splitPaths(config.TLSKey)) {
+		r.Invalid(types.TLB_TLS_KEY, "should have a key for every one of %s, not terminating TLS", types.TLB_TLS_CERT)
+		config.TLSCert, config.TLSKey = "", ""
+	}
+	if config.UDPTimeout <= 0 {
+		r.Invalid(types.TLB_TIMEOUT_UDP, "should be more than 0, using %v", defaultUDPTimeout)
+		config.UDPTimeout = defaultUDPTimeout
+	}
+	if config.Protocol == UDPProtocol {
+		if config.TLSCert != "" {
+			r.Invalid(types.TLB_TLS_CERT, "is not supported with %s=udp, not terminating TLS", types.TLB_PROTOCOL)
+			config.TLSCert, config.TLSKey = "", ""
+		}
+		// the checks connect over TCP
+		if _, present := labels[types.TLB_HEALTHCHECK_INTERVAL]; present && config.HealthCheck.Interval > 0 {
+			r.Invalid(types.TLB_HEALTHCHECK_INTERVAL, "is not supported with %s=udp, not checking the backends", types.TLB_PROTOCOL)
+		}
+		config.HealthCheck.Interval = 0
+	}
+	if config.SLOWindow == 0 {
+		config.SLOWindow = 5 * time.Minute
+	}
+	if config.DialTimeout <= 0 {
+		r.Invalid(types.TLB_TIMEOUT_DIAL, "should be more than 0, using %v", defaultDialTimeout)
+		config.DialTimeout = defaultDialTimeout
+	}
+	if config.OutlierEjection <= 0 {
+		r.Invalid(types.TLB_OUTLIER_EJECTION, "should be more than 0, using %v", defaultOutlierEjection)
+		config.OutlierEjection = defaultOutlierEjection
+	}
+	if config.DSCP != -1 && !dscpSupported {
+		r.Invalid(types.TLB_DSCP, "is not supported on this platform, ignoring it")
+		config.DSCP = -1
+	}
+	if max := somaxconn(); config.Backlog > 0 && max > 0 && config.Backlog > max {
+		log.Printf("[WARN] %s of %d is more than net.core.somaxconn (%d), the kernel would cap it to %d\n", types.TLB_BACKLOG, config.Backlog, max, max)
+	}
+	config.LabelErrors = r.Errors
+	return config
+}
+
+// needsRestart tells if going to the updated config needs a new frontend,
+// as opposed to what Frontend.UpdateConfig can apply in place
+func (c *FrontendConfig) needsRestart(updated *FrontendConfig) bool {
+	return c.Backlog != updated.Backlog ||
+		c.MaxPending != updated.MaxPending ||
+		c.Strategy != updated.Strategy ||
+		c.ShadowStrategy != updated.ShadowStrategy ||
+		c.StickyFallback != updated.StickyFallback ||
+		c.Hash != updated.Hash ||
+		c.AffinityTTL != updated.AffinityTTL ||
+		c.Weights != updated.Weights ||
+		c.MaglevTableSize != updated.MaglevTableSize ||
+		c.Replicas != updated.Replicas ||
+		c.IPFamily != updated.IPFamily ||
+		c.PreferLocalZone != updated.PreferLocalZone ||
+		c.ZoneSpillover != updated.ZoneSpillover ||
+		c.Zone != updated.Zone ||
+		c.SLOWindow != updated.SLOWindow ||
+		c.TLSCert != updated.TLSCert ||
+		c.TLSKey != updated.TLSKey ||
+		c.Protocol != updated.Protocol ||
+		(c.HealthCheck.Interval > 0) != (updated.HealthCheck.Interval > 0)
+}
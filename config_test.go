@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ashwanthkumar/golang-utils/sets"
+	"github.com/ashwanthkumar/gotlb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrontendConfigToFallbackToTheDefaultsOfTheInvalidLabels(t *testing.T) {
+	cases := []struct {
+		label, value, reason string
+		check                func(config *FrontendConfig)
+	}{
+		{"tlb.strategy", "fastest", `should be one of roundrobin, weighted, iphash, maglev, leastconn, random, p2c, ewma, using "roundrobin"`, func(c *FrontendConfig) { assert.Equal(t, RoundRobinName, c.Strategy) }},
+		{"tlb.hash", "md5", `should be one of fnv, crc32, murmur3, rendezvous, using "fnv"`, func(c *FrontendConfig) { assert.Equal(t, FNVHash, c.Hash) }},
+		{"tlb.weights", "percent", `should be one of relative, shares, using "relative"`, func(c *FrontendConfig) { assert.Equal(t, RelativeWeights, c.Weights) }},
+		{"tlb.ipfamily", "ipv5", `should be one of any, ipv4, ipv6, using "any"`, func(c *FrontendConfig) { assert.Equal(t, AnyFamily, c.IPFamily) }},
+		{"tlb.maxpending", "lots", "should be a number, using 0", func(c *FrontendConfig) { assert.Equal(t, 0, c.MaxPending) }},
+		{"tlb.failover.attempts", "0", "should be at least 1, using 1", func(c *FrontendConfig) { assert.Equal(t, 1, c.FailoverAttempts) }},
+		{"tlb.iphash.replicas", "0", "should be at least 1, using 100", func(c *FrontendConfig) { assert.Equal(t, defaultReplicas, c.Replicas) }},
+		{"tlb.buffer.read", "-1", "should be at least 0, using 0", func(c *FrontendConfig) { assert.Equal(t, 0, c.ReadBuffer) }},
+		{"tlb.dscp", "64", "should be between 0 and 63, using -1", func(c *FrontendConfig) { assert.Equal(t, -1, c.DSCP) }},
+		{"tlb.proxyprotocol.version", "3", "should be between 1 and 2, using 1", func(c *FrontendConfig) { assert.Equal(t, ProxyProtocolV1, c.ProxyProtocolVersion) }},
+		{"tlb.proxyprotocol", "v3", `should be one of v1, v2, off, true, false, using "off"`, func(c *FrontendConfig) { assert.False(t, c.ProxyProtocol) }},
+		{"tlb.tls.key", "/etc/gotlb/a.key", "should have a key for every one of tlb.tls.cert, not terminating TLS", func(c *FrontendConfig) { assert.Equal(t, "", c.TLSKey) }},
+		{"tlb.limit.connections.mode", "block", `should be one of reject, queue, using "reject"`, func(c *FrontendConfig) { assert.Equal(t, RejectConnections, c.ConnectionsMode) }},
+		{"tlb.accesslog", "yes", "should be true or false, using false", func(c *FrontendConfig) { assert.False(t, c.AccessLog) }},
+		{"tlb.timeout.idle", "5 minutes", "should be a duration like 10s, using 0s", func(c *FrontendConfig) { assert.Equal(t, time.Duration(0), c.IdleTimeout) }},
+		{"tlb.steer.timeout", "-1s", "can't be negative, using 100ms", func(c *FrontendConfig) { assert.Equal(t, defaultSteerTimeout, c.SteerTimeout) }},
+		{"tlb.healthcheck.timeout", "0s", "should be more than 0, using 1s", func(c *FrontendConfig) { assert.Equal(t, time.Second, c.HealthCheck.Timeout) }},
+		{"tlb.outlier.ejection", "0s", "should be more than 0, using 30s", func(c *FrontendConfig) { assert.Equal(t, 30*time.Second, c.OutlierEjection) }},
+		{"tlb.timeout.dial", "0s", "should be more than 0, using 3s", func(c *FrontendConfig) { assert.Equal(t, defaultDialTimeout, c.DialTimeout) }},
+	}
+	for _, c := range cases {
+		config := NewFrontendConfig(map[string]string{c.label: c.value})
+		assert.Equal(t, []types.LabelError{{Label: c.label, Value: c.value, Reason: c.reason}}, config.LabelErrors, c.label)
+		c.check(config)
+	}
+}
+
+func TestFrontendConfigToHaveNoErrorsForTheValidLabels(t *testing.T) {
+	config := NewFrontendConfig(map[string]string{
+		"tlb.strategy":          MaglevName,
+		"tlb.failover.attempts": "3",
+		"tlb.timeout.idle":      "5m",
+		"tlb.accesslog":         "true",
+		"tlb.dscp.client":       "false",
+	})
+	assert.Empty(t, config.LabelErrors)
+	assert.Equal(t, 3, config.FailoverAttempts)
+}
+
+func TestManagerToShowTheLabelErrorsOfTheApps(t *testing.T) {
+	m := NewManager()
+	frontend := createFrontend(APP_ID, "-1", sets.Empty())
+	m.addFrontend(APP_ID, frontend)
+	m.CreateNewFrontendIfNotExist(createAppInfo(APP_ID, map[string]string{types.TLB_PORT: "-1", types.TLB_DETECT: "maybe"}))
+
+	state := m.State()
+	assert.Equal(t, []types.LabelError{{Label: "tlb.detect", Value: "maybe", Reason: "should be true or false, using false"}}, state[0].LabelErrors)
+}
+
+func TestManagerToNotCreateTheFrontendOfAnInvalidPort(t *testing.T) {
+	m := NewManager()
+	m.CreateNewFrontendIfNotExist(createAppInfo(APP_ID, createAppLabels("http")))
+	m.CreateNewFrontendIfNotExist(createAppInfo("/other", createAppLabels("70000")))
+	assert.Empty(t, m.State())
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// connections tracks every connection proxied by GoTLB
+var connections = NewConnectionTracker()
+
+// Connection is an active proxied connection
+type Connection struct {
+	// accessed atomically, keep them first for the alignment
+	bytesIn    int64
+	bytesOut   int64
+	lastActive int64
+
+	Id      uint64
+	AppId   string
+	Client  string
+	Started time.Time
+
+	lock    sync.Mutex
+	backend string
+	// what we detected from the first bytes of the client, if asked to
+	protocol    string
+	compression string
+}
+
+// Backend returns the backend the connection is proxied to right now
+func (c *Connection) Backend() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.backend
+}
+
+// SetBackend records that the connection has moved to another backend
+func (c *Connection) SetBackend(backend string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.backend = backend
+}
+
+// SetDetected records the protocol and the compression detected on the connection
+func (c *Connection) SetDetected(protocol, compression string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.protocol = protocol
+	c.compression = compression
+}
+
+// Detected returns the protocol and the compression detected on the connection, empty if none
+func (c *Connection) Detected() (string, string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.protocol, c.compression
+}
+
+// In counts the bytes read from the client
+func (c *Connection) In(reader io.Reader) io.Reader {
+	return &countingReader{reader: reader, count: &c.bytesIn, lastActive: &c.lastActive}
+}
+
+// Out counts the bytes read from the backend
+func (c *Connection) Out(reader io.Reader) io.Reader {
+	return &countingReader{reader: reader, count: &c.bytesOut, lastActive: &c.lastActive}
+}
+
+// Idle returns how long the connection has gone without any data in either direction
+func (c *Connection) Idle(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, atomic.LoadInt64(&c.lastActive)))
+}
+
+// Transferred is the bytes moved so far, both the directions together
+func (c *Connection) Transferred() int64 {
+	return atomic.LoadInt64(&c.bytesIn) + atomic.LoadInt64(&c.bytesOut)
+}
+
+// State returns a point in time view of the connection
+func (c *Connection) State(now time.Time) ConnectionState {
+	protocol, compression := c.Detected()
+	return ConnectionState{
+		Id:       c.Id,
+		AppId:    c.AppId,
+		Client:   c.Client,
+		Backend:  c.Backend(),
+		Started:  c.Started,
+		BytesIn:  atomic.LoadInt64(&c.bytesIn),
+		BytesOut: atomic.LoadInt64(&c.bytesOut),
+		Idle:     c.Idle(now).String(),
+
+		Protocol:    protocol,
+		Compression: compression,
+	}
+}
+
+type ConnectionState struct {
+	Id       uint64    `json:"id"`
+	AppId    string    `json:"appId"`
+	Client   string    `json:"client"`
+	Backend  string    `json:"backend"`
+	Started  time.Time `json:"started"`
+	BytesIn  int64     `json:"bytesIn"`
+	BytesOut int64     `json:"bytesOut"`
+	Idle     string    `json:"idle"`
+
+	Protocol    string `json:"protocol,omitempty"`
+	Compression string `json:"compression,omitempty"`
+}
+
+// ConnectionTracker keeps track of all the active connections, it's used
+// for the active connection gauges and to debug what's flowing through GoTLB
+type ConnectionTracker struct {
+	lock        sync.Mutex
+	nextId      uint64
+	connections map[uint64]*Connection
+}
+
+func NewConnectionTracker() *ConnectionTracker {
+	return &ConnectionTracker{
+		connections: make(map[uint64]*Connection),
+	}
+}
+
+// Track starts tracking a connection from client to the backend of the app
+func (c *ConnectionTracker) Track(appId, client, backend string) *Connection {
+	now := time.Now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.nextId++
+	conn := &Connection{
+		Id:         c.nextId,
+		AppId:      appId,
+		Client:     client,
+		Started:    now,
+		backend:    backend,
+		lastActive: now.UnixNano(),
+	}
+	c.connections[conn.Id] = conn
+	metrics.Gauge("frontend-active-connections", "app", appId).Inc()
+	return conn
+}
+
+// Untrack stops tracking the connection once it's closed
+func (c *ConnectionTracker) Untrack(conn *Connection) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, present := c.connections[conn.Id]; present {
+		delete(c.connections, conn.Id)
+		metrics.Gauge("frontend-active-connections", "app", conn.AppId).Dec()
+	}
+}
+
+// List returns upto limit connections of the app (all apps when empty) from
+// offset in the order they were started, along with the total matching connections
+func (c *ConnectionTracker) List(appId string, offset, limit int) (int, []ConnectionState) {
+	c.lock.Lock()
+	matching := make([]*Connection, 0, len(c.connections))
+	for _, conn := range c.connections {
+		if appId == "" || conn.AppId == appId {
+			matching = append(matching, conn)
+		}
+	}
+	c.lock.Unlock()
+
+	sort.Slice(matching, func(i, j int) bool { return matching[i].Id < matching[j].Id })
+	total := len(matching)
+	if offset > total {
+		offset = total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	now := time.Now()
+	states := make([]ConnectionState, 0, end-offset)
+	for _, conn := range matching[offset:end] {
+		states = append(states, conn.State(now))
+	}
+	return total, states
+}
+
+type countingReader struct {
+	reader     io.Reader
+	count      *int64
+	lastActive *int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	if n > 0 {
+		atomic.AddInt64(c.count, int64(n))
+		atomic.StoreInt64(c.lastActive, time.Now().UnixNano())
+	}
+	return n, err
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectionTrackerToTrackConnections(t *testing.T) {
+	tracker := NewConnectionTracker()
+	first := tracker.Track("/a", "1.1.1.1:1000", "b:1")
+	second := tracker.Track("/b", "1.1.1.2:1000", "b:2")
+	tracker.Track("/a", "1.1.1.3:1000", "b:1")
+
+	ioutil.ReadAll(first.In(bytes.NewBufferString("hello")))
+	ioutil.ReadAll(first.Out(bytes.NewBufferString("hello world")))
+	first.SetBackend("b:3")
+
+	total, list := tracker.List("/a", 0, 10)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, "1.1.1.1:1000", list[0].Client)
+	assert.Equal(t, "b:3", list[0].Backend)
+	assert.Equal(t, int64(5), list[0].BytesIn)
+	assert.Equal(t, int64(11), list[0].BytesOut)
+	assert.Equal(t, "1.1.1.3:1000", list[1].Client)
+
+	tracker.Untrack(second)
+	total, _ = tracker.List("", 0, 10)
+	assert.Equal(t, 2, total)
+}
+
+func TestConnectionTrackerToPageThroughConnections(t *testing.T) {
+	tracker := NewConnectionTracker()
+	for i := 0; i < 5; i++ {
+		tracker.Track("/a", "client", "b:1")
+	}
+
+	total, list := tracker.List("", 3, 10)
+	assert.Equal(t, 5, total)
+	assert.Len(t, list, 2)
+	assert.Equal(t, uint64(4), list[0].Id)
+
+	total, list = tracker.List("", 10, 10)
+	assert.Equal(t, 5, total)
+	assert.Len(t, list, 0)
+}
+
+func TestConnectionToReportIdleTime(t *testing.T) {
+	tracker := NewConnectionTracker()
+	conn := tracker.Track("/a", "client", "b:1")
+	state := conn.State(time.Now().Add(time.Minute))
+	assert.Contains(t, state.Idle, "1m0")
+}
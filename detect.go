@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"io"
+)
+
+// Protocols and compressions we detect from the first bytes of a connection
+const (
+	UnknownDetected = "unknown"
+	NoCompression   = "none"
+)
+
+var httpMethods = [][]byte{
+	[]byte("GET "), []byte("POST "), []byte("PUT "), []byte("DELETE "), []byte("HEAD "),
+	[]byte("OPTIONS "), []byte("PATCH "), []byte("CONNECT "), []byte("TRACE "),
+}
+
+// detectProtocol looks at the first bytes the client sent and returns the
+// protocol and the compression of the connection. It's a best effort guess
+// used only for accounting.
+func detectProtocol(first []byte) (string, string) {
+	switch {
+	case len(first) >= 3 && first[0] == 0x16 && first[1] == 0x03:
+		// the stream is encrypted, we can't tell the compression
+		return "tls", UnknownDetected
+	case bytes.HasPrefix(first, []byte("PRI * HTTP/2.0")):
+		// compression is negotiated per stream, we don't look that far
+		return "http2", UnknownDetected
+	case isHTTP(first):
+		return "http", httpCompression(first)
+	}
+	if compression := magicCompression(first); compression != "" {
+		return UnknownDetected, compression
+	}
+	return UnknownDetected, UnknownDetected
+}
+
+func isHTTP(first []byte) bool {
+	for _, method := range httpMethods {
+		if bytes.HasPrefix(first, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// httpCompression returns the Content-Encoding of the request headers we have
+func httpCompression(first []byte) string {
+	headersEnd := bytes.Index(first, []byte("\r\n\r\n"))
+	if headersEnd < 0 {
+		headersEnd = len(first)
+	}
+	for _, line := range bytes.Split(first[:headersEnd], []byte("\r\n")) {
+		colon := bytes.IndexByte(line, ':')
+		if colon < 0 {
+			continue
+		}
+		if bytes.EqualFold(bytes.TrimSpace(line[:colon]), []byte("Content-Encoding")) {
+			return string(bytes.ToLower(bytes.TrimSpace(line[colon+1:])))
+		}
+	}
+	return NoCompression
+}
+
+// magicCompression detects the raw compressed streams from their magic bytes
+func magicCompression(first []byte) string {
+	switch {
+	case bytes.HasPrefix(first, []byte{0x1f, 0x8b}):
+		return "gzip"
+	case bytes.HasPrefix(first, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return "zstd"
+	case len(first) >= 2 && first[0] == 0x78 && (uint16(first[0])<<8|uint16(first[1]))%31 == 0:
+		return "zlib"
+	}
+	return ""
+}
+
+// detectingReader detects the protocol from the first read of the client,
+// without changing anything that's read
+type detectingReader struct {
+	reader   io.Reader
+	request  *Request
+	detected bool
+}
+
+func (p *Request) detecting(reader io.Reader) io.Reader {
+	if !p.config.Detect {
+		return reader
+	}
+	return &detectingReader{reader: reader, request: p}
+}
+
+func (d *detectingReader) Read(b []byte) (int, error) {
+	n, err := d.reader.Read(b)
+	if n > 0 && !d.detected {
+		d.detected = true
+		protocol, compression := detectProtocol(b[:n])
+		d.request.conn.SetDetected(protocol, compression)
+		metrics.Counter("frontend-detected-connections", "app", d.request.appId, "protocol", protocol, "compression", compression).Inc()
+	}
+	return n, err
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectProtocol(t *testing.T) {
+	cases := []struct {
+		first       string
+		protocol    string
+		compression string
+	}{
+		{"\x16\x03\x01\x02\x00\x01", "tls", "unknown"},
+		{"PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n", "http2", "unknown"},
+		{"GET / HTTP/1.1\r\nHost: foo\r\n\r\n", "http", "none"},
+		{"POST /upload HTTP/1.1\r\ncontent-encoding: GZIP \r\n\r\n\x1f\x8b", "http", "gzip"},
+		{"\x1f\x8b\x08\x00", "unknown", "gzip"},
+		{"\x28\xb5\x2f\xfd\x00", "unknown", "zstd"},
+		{"\x78\x9c\x01", "unknown", "zlib"},
+		{"*1\r\n$4\r\nPING\r\n", "unknown", "unknown"},
+	}
+	for _, c := range cases {
+		protocol, compression := detectProtocol([]byte(c.first))
+		assert.Equal(t, c.protocol, protocol, c.first)
+		assert.Equal(t, c.compression, compression, c.first)
+	}
+}
+
+func TestRequestToDetectTheProtocolWithoutChangingTheStream(t *testing.T) {
+	echo := startEchoBackend(t)
+	defer echo.Close()
+	frontend := createFrontendWithLabels([]string{echo.Addr().String()}, map[string]string{"tlb.detect": "true"})
+	client := proxyThrough(t, echo.Addr().String(), frontend)
+	defer client.Close()
+
+	request := "GET / HTTP/1.1\r\nHost: foo\r\n\r\n"
+	_, err := client.Write([]byte(request))
+	assert.NoError(t, err)
+	response := make([]byte, len(request))
+	_, err = io.ReadFull(client, response)
+	assert.NoError(t, err)
+	assert.Equal(t, request, string(response))
+
+	_, list := connections.List(APP_ID, 0, 1000)
+	var detected []ConnectionState
+	for _, state := range list {
+		if state.Protocol != "" {
+			detected = append(detected, state)
+		}
+	}
+	assert.Len(t, detected, 1)
+	assert.Equal(t, "http", detected[0].Protocol)
+	assert.Equal(t, "none", detected[0].Compression)
+}
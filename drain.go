@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+// DrainBackend stops routing new connections to the backend, waits upto the
+// timeout for the connections open to it to close and then removes it. It
+// returns true when they all closed in time, the ones still open after it are
+// closed and the backend is removed either way. The connections on a frontend
+// this one replaced aren't waited on.
+func (f *Frontend) DrainBackend(backend string, timeout time.Duration) (bool, error) {
+	return f.drainBackend(backend, timeout, false)
+}
+
+// drainRemoved drains the backend that discovery removed. Unlike the drains
+// of the admin API it's called off when discovery adds the backend back.
+func (f *Frontend) drainRemoved(backend string, timeout time.Duration) (bool, error) {
+	return f.drainBackend(backend, timeout, true)
+}
+
+func (f *Frontend) drainBackend(backend string, timeout time.Duration, removed bool) (bool, error) {
+	f.lock.Lock()
+	state, known := f.health[backend]
+	if !known {
+		f.lock.Unlock()
+		return false, fmt.Errorf("%s is not a backend of %s", backend, f.appId)
+	}
+	if !state.draining {
+		log.Printf("[INFO] Draining %s of %s\n", backend, f.appId)
+		state.draining = true
+		state.drain++
+		f.queueChange(backend, nil)
+	}
+	state.removed = state.removed || removed
+	drain := state.drain
+	drained, waiting := f.drains[backend]
+	if !waiting && len(f.active[backend]) > 0 {
+		drained = make(chan bool)
+		f.drains[backend] = drained
+	}
+	f.lock.Unlock()
+
+	done := true
+	if drained != nil {
+		select {
+		case <-drained:
+		case <-time.After(timeout):
+			if !f.stillDraining(backend, state, drain) {
+				return false, nil
+			}
+			done = false
+			log.Printf("[WARN] %s of %s still has connections after %v, closing them\n", backend, f.appId, timeout)
+			metrics.Counter("frontend-drain-timeouts", "app", f.appId).Inc()
+			f.closeConnections(backend)
+		}
+	}
+
+	// discovery might have removed it, or added it back, in the meantime
+	if f.stillDraining(backend, state, drain) {
+		f.RemoveBackend(backend)
+	}
+	return done, nil
+}
+
+// stillDraining tells if the backend is still drained by the drain, and not
+// a new one or called off since
+func (f *Frontend) stillDraining(backend string, state *healthState, drain int) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.health[backend] == state && state.draining && state.drain == drain
+}
+
+// isDraining tells if the backend is being drained before it's removed
+func (f *Frontend) isDraining(backend string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	state, known := f.health[backend]
+	return known && state.draining
+}
+
+// cancelDrain calls off the drain of a backend that discovery removed, as
+// it's added back. Expects the lock to be held.
+func (f *Frontend) cancelDrain(backend string, state *healthState) {
+	if !state.draining || !state.removed {
+		return
+	}
+	log.Printf("[INFO] %s of %s is back, no longer draining it\n", backend, f.appId)
+	state.draining, state.removed = false, false
+	state.drain++
+}
+
+// openConnection counts the connection to the backend
+func (f *Frontend) openConnection(backend string, conn io.Closer) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.active[backend] == nil {
+		f.active[backend] = make(map[io.Closer]bool)
+	}
+	f.active[backend][conn] = true
+}
+
+// closeConnection counts the connection to the backend as closed, and lets
+// the drain of the backend know once it has none left
+func (f *Frontend) closeConnection(backend string, conn io.Closer) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.active[backend], conn)
+	if len(f.active[backend]) > 0 {
+		return
+	}
+	delete(f.active, backend)
+	if drained, waiting := f.drains[backend]; waiting {
+		close(drained)
+		delete(f.drains, backend)
+	}
+}
+
+// closeConnections closes the connections still open to the backend, they're
+// counted as closed once their requests are done
+func (f *Frontend) closeConnections(backend string) {
+	f.lock.Lock()
+	conns := make([]io.Closer, 0, len(f.active[backend]))
+	for conn := range f.active[backend] {
+		conns = append(conns, conn)
+	}
+	f.lock.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+// activeConnections returns the connections open to the backend
+func (f *Frontend) activeConnections(backend string) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.active[backend])
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ashwanthkumar/gotlb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrontendToRemoveADrainedBackendOnceItsConnectionsClose(t *testing.T) {
+	frontend := createFrontendWithLabels([]string{"b:1", "b:2"}, nil)
+	first, second := net.Pipe()
+	frontend.acquire("b:1", first)
+	frontend.acquire("b:1", second)
+
+	result := make(chan bool)
+	go func() {
+		drained, err := frontend.DrainBackend("b:1", time.Minute)
+		assert.NoError(t, err)
+		result <- drained
+	}()
+	for i := 0; frontend.isRouted("b:1"); i++ {
+		if i == 100 {
+			t.Fatal("the backend is still routed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, "b:2", frontend.Lookup())
+	}
+	// an update from discovery doesn't route it again
+	frontend.AddBackend(&types.BackendInfo{AppId: APP_ID, Node: "b:1"})
+	assert.False(t, frontend.isRouted("b:1"))
+	assert.Len(t, frontend.Backends(), 2)
+
+	frontend.release("b:1", first)
+	select {
+	case <-result:
+		t.Fatal("drained with a connection still open")
+	case <-time.After(20 * time.Millisecond):
+	}
+	frontend.release("b:1", second)
+	assert.True(t, <-result)
+	assert.Equal(t, []string{"b:2"}, frontend.Backends())
+	assert.Equal(t, 0, frontend.activeConnections("b:1"))
+}
+
+func TestFrontendToRemoveADrainedBackendAfterTheTimeout(t *testing.T) {
+	frontend := createFrontendWithLabels([]string{"b:1", "b:2"}, nil)
+	frontend.appId = "/drain-timeout"
+	timeouts := metrics.Counter("frontend-drain-timeouts", "app", "/drain-timeout")
+	before := timeouts.Value()
+	client, conn := net.Pipe()
+	frontend.acquire("b:1", conn)
+	drained, err := frontend.DrainBackend("b:1", 10*time.Millisecond)
+	assert.NoError(t, err)
+	assert.False(t, drained)
+	assert.Equal(t, []string{"b:2"}, frontend.Backends())
+	assert.Equal(t, before+1, timeouts.Value())
+	// the connection that was still open is closed
+	_, err = client.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+
+	// one without connections goes right away
+	drained, err = frontend.DrainBackend("b:2", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, drained)
+	assert.Empty(t, frontend.Backends())
+
+	_, err = frontend.DrainBackend("b:3", time.Minute)
+	assert.Error(t, err)
+}
+
+func TestManagerToDrainTheBackendsDiscoveryRemoves(t *testing.T) {
+	m := NewManager()
+	frontend := createFrontendWithLabels([]string{"b:1", "b:2"}, map[string]string{"tlb.drain.timeout": "1m"})
+	m.addFrontend(APP_ID, frontend)
+	client, conn := net.Pipe()
+	defer client.Close()
+	frontend.acquire("b:1", conn)
+
+	assert.NoError(t, m.RemoveBackendForApp(createBackendInfo(APP_ID, "b:1")))
+	for i := 0; frontend.isRouted("b:1"); i++ {
+		if i == 100 {
+			t.Fatal("the backend is still routed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// it's kept till it's connection is done
+	assert.Len(t, frontend.Backends(), 2)
+	frontend.release("b:1", conn)
+	for i := 0; len(frontend.Backends()) != 1; i++ {
+		if i == 100 {
+			t.Fatal("the drained backend wasn't removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, []string{"b:2"}, frontend.Backends())
+}
+
+func TestManagerToDrainWithTheUpdatedTimeout(t *testing.T) {
+	m := NewManager()
+	frontend := createFrontendWithLabels([]string{"b:1", "b:2"}, nil)
+	m.addFrontend(APP_ID, frontend)
+	client, conn := net.Pipe()
+	defer client.Close()
+	frontend.acquire("b:1", conn)
+
+	frontend.UpdateConfig(NewFrontendConfig(map[string]string{"tlb.drain.timeout": "1m"}))
+	assert.NoError(t, m.RemoveBackendForApp(createBackendInfo(APP_ID, "b:1")))
+	for i := 0; frontend.isRouted("b:1"); i++ {
+		if i == 100 {
+			t.Fatal("the backend is still routed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// drained instead of removed right away
+	assert.Len(t, frontend.Backends(), 2)
+	frontend.release("b:1", conn)
+}
+
+func TestManagerToRemoveTheDrainedBackendWhenTheFrontendIsRecreated(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	l.Close()
+
+	m := NewManager()
+	labels := createAppLabels(port)
+	labels[types.TLB_DRAIN_TIMEOUT] = "1m"
+	m.CreateNewFrontendIfNotExist(createAppInfo(APP_ID, labels))
+	assert.NoError(t, m.AddBackendForApp(createBackendInfo(APP_ID, "b:1")))
+	assert.NoError(t, m.AddBackendForApp(createBackendInfo(APP_ID, "b:2")))
+	old, _ := m.getFrontend(APP_ID)
+	client, conn := net.Pipe()
+	defer client.Close()
+	old.acquire("b:1", conn)
+	defer func() {
+		f, _ := m.getFrontend(APP_ID)
+		f.Stop()
+	}()
+
+	assert.NoError(t, m.RemoveBackendForApp(createBackendInfo(APP_ID, "b:1")))
+	for i := 0; !old.isDraining("b:1"); i++ {
+		if i == 100 {
+			t.Fatal("the backend isn't drained")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	labels[types.TLB_STRATEGY] = IPHashName
+	m.CreateNewFrontendIfNotExist(createAppInfo(APP_ID, labels))
+	replacement, _ := m.getFrontend(APP_ID)
+	assert.True(t, replacement != old, "the frontend should have been recreated")
+	assert.Equal(t, []string{"b:2"}, replacement.Backends())
+
+	// the drain is done on the old one
+	old.release("b:1", conn)
+	for i := 0; len(old.Backends()) != 1; i++ {
+		if i == 100 {
+			t.Fatal("the drained backend wasn't removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, []string{"b:2"}, replacement.Backends())
+	assert.False(t, replacement.isRouted("b:1"))
+}
+
+func TestFrontendToCallOffTheDrainOfABackendDiscoveryAddsBack(t *testing.T) {
+	frontend := createFrontendWithLabels([]string{"b:1", "b:2"}, nil)
+	client, conn := net.Pipe()
+	defer client.Close()
+	frontend.acquire("b:1", conn)
+
+	result := make(chan bool)
+	go func() {
+		drained, err := frontend.drainRemoved("b:1", 50*time.Millisecond)
+		assert.NoError(t, err)
+		result <- drained
+	}()
+	for i := 0; !frontend.isDraining("b:1"); i++ {
+		if i == 100 {
+			t.Fatal("the backend isn't drained")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the task came back on the same host:port
+	frontend.AddBackend(&types.BackendInfo{AppId: APP_ID, Node: "b:1"})
+	assert.False(t, frontend.isDraining("b:1"))
+	assert.True(t, frontend.isRouted("b:1"))
+
+	assert.False(t, <-result)
+	assert.Len(t, frontend.Backends(), 2)
+	assert.True(t, frontend.isRouted("b:1"))
+	// and it's connection is left alone
+	assert.Equal(t, 1, frontend.activeConnections("b:1"))
+	frontend.release("b:1", conn)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// share of a new sample in the average of a backend
+	ewmaSmoothing = 0.3
+	// how fast the average of a backend we've not heard from decays, it halves
+	// in about 0.7 of it
+	ewmaDecay = 10 * time.Second
+	// how long a backend we've not measured yet waits on the connection that
+	// measures it before it's tried again
+	ewmaProbeTimeout = 10 * time.Second
+)
+
+// EWMA is an implementation of Strategy that routes requests to the backend
+// with the lowest exponentially weighted moving average of it's latency, the
+// time to connect to it and get the first byte back. A backend we've not
+// measured yet is as good as it gets, so it's tried right away - with one
+// connection, the others wait on it to be measured. The average of a backend
+// decays while we don't hear from it, so a backend that was slow gets tried
+// again after a while and can win back it's traffic. The backends with the
+// same score take turns.
+type EWMA struct {
+	lock     sync.Mutex
+	backends []string
+	// averages of the backends we've measured
+	latencies map[string]*ewma
+	// when we sent the connection that measures the backends we've not measured yet
+	probes map[string]time.Time
+	next   int
+	now    func() time.Time
+}
+
+type ewma struct {
+	// average latency in nanoseconds as of measured
+	average  float64
+	measured time.Time
+}
+
+func EWMAStrategy() LoadBalancingStrategy {
+	return &EWMA{latencies: make(map[string]*ewma), probes: make(map[string]time.Time), now: time.Now}
+}
+
+func (e *EWMA) AddBackend(backend string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for _, existing := range e.backends {
+		if existing == backend {
+			return
+		}
+	}
+	e.backends = append(e.backends, backend)
+}
+
+// RemoveBackend also forgets the latency of the backend, it's measured
+// afresh when it's added back
+func (e *EWMA) RemoveBackend(backend string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	delete(e.latencies, backend)
+	delete(e.probes, backend)
+	for idx, existing := range e.backends {
+		if existing == backend {
+			e.backends = append(e.backends[:idx], e.backends[idx+1:]...)
+			return
+		}
+	}
+}
+
+func (e *EWMA) Next() string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if len(e.backends) == 0 {
+		return ""
+	}
+	now := e.now()
+	picked, best := -1, 0.0
+	for i := range e.backends {
+		idx := (e.next + i) % len(e.backends)
+		if score := e.score(e.backends[idx], now); picked < 0 || score < best {
+			picked, best = idx, score
+		}
+	}
+	e.next = (picked + 1) % len(e.backends)
+	backend := e.backends[picked]
+	if _, measured := e.latencies[backend]; !measured && best == 0 {
+		e.probes[backend] = now
+	}
+	return backend
+}
+
+// Observe adds a latency sample of the backend to it's average. The
+// connections that never heard back from the backend are a sample too, of
+// how long we waited on it.
+func (e *EWMA) Observe(backend string, latency time.Duration) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	known := false
+	for _, existing := range e.backends {
+		known = known || existing == backend
+	}
+	if !known {
+		return
+	}
+	now := e.now()
+	average := float64(latency)
+	if _, measured := e.latencies[backend]; measured {
+		average = (1-ewmaSmoothing)*e.score(backend, now) + ewmaSmoothing*average
+	}
+	e.latencies[backend] = &ewma{average: average, measured: now}
+	delete(e.probes, backend)
+}
+
+// score returns the decayed average of the backend. One we've not measured
+// is 0, or the worst there is while the connection that measures it is out.
+func (e *EWMA) score(backend string, now time.Time) float64 {
+	latency, measured := e.latencies[backend]
+	if !measured {
+		if probed, probing := e.probes[backend]; probing && now.Sub(probed) < ewmaProbeTimeout {
+			return math.Inf(1)
+		}
+		return 0
+	}
+	idle := now.Sub(latency.measured)
+	if idle <= 0 {
+		return latency.average
+	}
+	return latency.average * math.Exp(-float64(idle)/float64(ewmaDecay))
+}
+
+// Latency returns the current average latency of the backend, 0 when we've not measured it
+func (e *EWMA) Latency(backend string) time.Duration {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if _, measured := e.latencies[backend]; !measured {
+		return 0
+	}
+	return time.Duration(e.score(backend, e.now()))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEWMAStrategyToPickTheFastestBackend(t *testing.T) {
+	now := time.Now()
+	s := EWMAStrategy().(*EWMA)
+	s.now = func() time.Time { return now }
+	s.AddBackend("a")
+	s.AddBackend("b")
+	s.Observe("a", 30*time.Millisecond)
+	s.Observe("b", 10*time.Millisecond)
+	assert.Equal(t, "b", s.Next())
+	assert.Equal(t, "b", s.Next())
+
+	// one slow sample moves the average only part of the way
+	s.Observe("b", 50*time.Millisecond)
+	assert.Equal(t, 22*time.Millisecond, s.Latency("b"))
+	assert.Equal(t, "b", s.Next())
+	s.Observe("b", 50*time.Millisecond)
+	assert.Equal(t, "a", s.Next())
+}
+
+func TestEWMAStrategyToTryTheBackendsNotMeasuredYet(t *testing.T) {
+	s := EWMAStrategy().(*EWMA)
+	s.AddBackend("a")
+	s.AddBackend("b")
+	// the backends take turns until they're measured
+	assert.Equal(t, "a", s.Next())
+	assert.Equal(t, "b", s.Next())
+	s.Observe("a", 10*time.Millisecond)
+	s.Observe("b", 20*time.Millisecond)
+
+	s.AddBackend("c")
+	assert.Equal(t, "c", s.Next())
+	// one connection measures it, the others don't pile on it meanwhile
+	assert.Equal(t, "a", s.Next())
+	assert.Equal(t, time.Duration(0), s.Latency("c"))
+	// the latency of a removed backend is measured afresh
+	s.RemoveBackend("b")
+	s.Observe("b", time.Millisecond)
+	s.AddBackend("b")
+	assert.Equal(t, time.Duration(0), s.Latency("b"))
+}
+
+func TestEWMAStrategyToTryABackendAgainWhenItsProbeIsNotBack(t *testing.T) {
+	now := time.Now()
+	s := EWMAStrategy().(*EWMA)
+	s.now = func() time.Time { return now }
+	s.AddBackend("a")
+	s.AddBackend("b")
+	s.Observe("a", time.Second)
+	assert.Equal(t, "b", s.Next())
+	assert.Equal(t, "a", s.Next())
+
+	now = now.Add(ewmaProbeTimeout)
+	assert.Equal(t, "b", s.Next())
+}
+
+func TestEWMAStrategyToDecayTheAverageOfASlowBackend(t *testing.T) {
+	now := time.Now()
+	s := EWMAStrategy().(*EWMA)
+	s.now = func() time.Time { return now }
+	s.AddBackend("a")
+	s.AddBackend("b")
+	s.Observe("a", 10*time.Millisecond)
+	s.Observe("b", time.Second)
+	assert.Equal(t, "a", s.Next())
+
+	// b recovered, we've kept sending to a and not heard from b since
+	now = now.Add(time.Minute)
+	s.Observe("a", 10*time.Millisecond)
+	assert.Equal(t, "b", s.Next())
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ashwanthkumar/gotlb/types"
+)
+
+const (
+	IPv4Family = "ipv4"
+	IPv6Family = "ipv6"
+	AnyFamily  = "any"
+)
+
+// familyOf returns the address family of the backend node (host:port), ""
+// when the host is not an IP address (like a hostname)
+func familyOf(node string) string {
+	host, _, err := net.SplitHostPort(node)
+	if err != nil {
+		host = node
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	if ip.To4() != nil {
+		return IPv4Family
+	}
+	return IPv6Family
+}
+
+// PreferFamily is an implementation of Strategy that wraps a base strategy
+// and routes requests only to the backends of the preferred address family,
+// as long as there's one of them available. Otherwise we route to the rest of
+// the backends, including the ones whose family is not known.
+type PreferFamily struct {
+	lock   sync.Mutex
+	family string
+	// backends and if they're of the preferred family
+	preferred map[string]bool
+	matching  LoadBalancingStrategy
+	rest      LoadBalancingStrategy
+	// number of backends of the preferred family
+	matches int
+}
+
+func PreferFamilyStrategy(family string, base func() LoadBalancingStrategy) LoadBalancingStrategy {
+	return &PreferFamily{
+		family:    family,
+		preferred: make(map[string]bool),
+		matching:  base(),
+		rest:      base(),
+	}
+}
+
+func (p *PreferFamily) AddBackendInfo(backend *types.BackendInfo) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.update([]*types.BackendInfo{backend}, nil)
+}
+
+func (p *PreferFamily) AddBackend(backend string) {
+	p.AddBackendInfo(&types.BackendInfo{Node: backend})
+}
+
+func (p *PreferFamily) RemoveBackend(backend string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.update(nil, []string{backend})
+}
+
+func (p *PreferFamily) UpdateBackends(added []*types.BackendInfo, removed []string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.update(added, removed)
+}
+
+// update splits the changes between the strategies of the preferred family
+// and the rest, expects the lock to be held. Updates to the info of the
+// existing backends go through only to the strategies that care about them.
+func (p *PreferFamily) update(added []*types.BackendInfo, removed []string) {
+	var matchingAdded, restAdded []*types.BackendInfo
+	var matchingRemoved, restRemoved []string
+	for _, backend := range removed {
+		preferred, present := p.preferred[backend]
+		if !present {
+			continue
+		}
+		delete(p.preferred, backend)
+		if preferred {
+			matchingRemoved = append(matchingRemoved, backend)
+			p.matches--
+		} else {
+			restRemoved = append(restRemoved, backend)
+		}
+	}
+	for _, backend := range added {
+		_, present := p.preferred[backend.Node]
+		preferred := familyOf(backend.Node) == p.family
+		if !present {
+			p.preferred[backend.Node] = preferred
+			if preferred {
+				p.matches++
+			}
+		}
+		target := p.rest
+		if preferred {
+			target = p.matching
+		}
+		if _, aware := target.(BackendInfoAware); present && !aware {
+			continue
+		}
+		if preferred {
+			matchingAdded = append(matchingAdded, backend)
+		} else {
+			restAdded = append(restAdded, backend)
+		}
+	}
+	updateBackends(p.matching, matchingAdded, matchingRemoved)
+	updateBackends(p.rest, restAdded, restRemoved)
+}
+
+func (p *PreferFamily) Acquire(backend string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	acquire(p.matching, backend)
+	acquire(p.rest, backend)
+}
+
+func (p *PreferFamily) Release(backend string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	release(p.matching, backend)
+	release(p.rest, backend)
+}
+
+func (p *PreferFamily) Observe(backend string, latency time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	observe(p.matching, backend, latency)
+	observe(p.rest, backend, latency)
+}
+
+func (p *PreferFamily) Next() string {
+	return p.NextFor("", nil)
+}
+
+func (p *PreferFamily) NextFor(key string, exclude map[string]bool) string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	excluded := 0
+	for backend := range exclude {
+		if p.preferred[backend] {
+			excluded++
+		}
+	}
+	if p.matches > excluded {
+		return nextFor(p.matching, key, exclude)
+	}
+	return nextFor(p.rest, key, exclude)
+}
+
+func (p *PreferFamily) SetHealthy(backend string, healthy bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, strategy := range []LoadBalancingStrategy{p.matching, p.rest} {
+		if sticky, ok := strategy.(StickyStrategy); ok {
+			sticky.SetHealthy(backend, healthy)
+		}
+	}
+}
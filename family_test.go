@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ashwanthkumar/gotlb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFamilyOf(t *testing.T) {
+	assert.Equal(t, IPv4Family, familyOf("10.0.0.1:80"))
+	assert.Equal(t, IPv6Family, familyOf("[fd00::1]:80"))
+	assert.Equal(t, IPv4Family, familyOf("[::ffff:10.0.0.1]:80"))
+	assert.Equal(t, "", familyOf("backend.local:80"))
+}
+
+func TestPreferFamilyToRouteOnlyToThePreferredFamily(t *testing.T) {
+	strategy := PreferFamilyStrategy(IPv6Family, RoundRobinStrategy)
+	strategy.AddBackend("10.0.0.1:80")
+	strategy.AddBackend("[fd00::1]:80")
+	strategy.AddBackend("[fd00::2]:80")
+
+	routed := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		routed[strategy.Next()]++
+	}
+	assert.Equal(t, map[string]int{"[fd00::1]:80": 5, "[fd00::2]:80": 5}, routed)
+}
+
+func TestPreferFamilyToFallbackToTheOtherFamily(t *testing.T) {
+	strategy := PreferFamilyStrategy(IPv4Family, RoundRobinStrategy)
+	strategy.AddBackend("[fd00::1]:80")
+	strategy.AddBackend("10.0.0.1:80")
+	assert.Equal(t, "10.0.0.1:80", strategy.Next())
+
+	strategy.RemoveBackend("10.0.0.1:80")
+	assert.Equal(t, "[fd00::1]:80", strategy.Next())
+	assert.Equal(t, "[fd00::1]:80", strategy.Next())
+
+	strategy.(BulkUpdater).UpdateBackends([]*types.BackendInfo{{Node: "10.0.0.2:80"}}, nil)
+	assert.Equal(t, "10.0.0.2:80", strategy.Next())
+}
+
+func TestPreferFamilyToFallbackWhenThePreferredBackendsAreExcluded(t *testing.T) {
+	strategy := PreferFamilyStrategy(IPv4Family, func() LoadBalancingStrategy {
+		return IPHashStrategy(APP_ID, RingFallback, defaultReplicas, FNVHash)
+	}).(StickyStrategy)
+	strategy.AddBackend("10.0.0.1:80")
+	strategy.AddBackend("[fd00::1]:80")
+
+	assert.Equal(t, "10.0.0.1:80", strategy.NextFor("192.168.1.1", nil))
+	assert.Equal(t, "[fd00::1]:80", strategy.NextFor("192.168.1.1", map[string]bool{"10.0.0.1:80": true}))
+}
+
+func TestBuildStrategyToPreferTheFamilyOfTheLabel(t *testing.T) {
+	strategy := buildStrategy(APP_ID, RoundRobinName, NewFrontendConfig(map[string]string{"tlb.ipfamily": "ipv6"}))
+	_, ok := strategy.(*PreferFamily)
+	assert.True(t, ok)
+
+	strategy = buildStrategy(APP_ID, RoundRobinName, NewFrontendConfig(map[string]string{"tlb.ipfamily": "ipv5"}))
+	_, ok = strategy.(*PreferFamily)
+	assert.False(t, ok)
+}
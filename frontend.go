@@ -1,43 +1,302 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
 	"log"
 	"net"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ashwanthkumar/golang-utils/sets"
+	"github.com/ashwanthkumar/gotlb/types"
 )
 
-// NewFrontend creates a new Frontend instance with appId, frontend
-// and array of backends.
-func NewFrontend(appId, port string, backends sets.Set) *Frontend {
-	return &Frontend{
+// NewFrontend creates a new Frontend instance with appId, frontend,
+// array of backends and the app specific config.
+func NewFrontend(appId, port string, backends sets.Set, config *FrontendConfig) *Frontend {
+	frontend := &Frontend{
 		appId:    appId,
-		backends: backends,
+		backends: sets.Empty(),
 		port:     port,
-		strategy: RoundRobinStrategy(), // TODO - Make this configurable from labels
+		config:   config,
+		strategy: newStrategy(appId, config),
+		routed:   make(map[string]bool),
+		slo:      newSuccessWindow(config.SLOWindow),
+		sli:      newSuccessWindow(config.SLOWindow),
+		changes:  make(map[string]*types.BackendInfo),
+		warming:  make(map[string]*types.BackendInfo),
+		infos:    make(map[string]*types.BackendInfo),
+		health:   make(map[string]*healthState),
+		active:   make(map[string]map[io.Closer]bool),
+		drains:   make(map[string]chan bool),
+		done:     make(chan bool),
+		open:     new(int64),
+		limiter:  newRateLimiter(config.ConnectionRate, config.ConnectionBurst),
 	}
+	frontend.setTimeouts(config)
+	frontend.useAccessLog(config)
+	if config.MaxPending > 0 {
+		frontend.pending = make(chan bool, config.MaxPending)
+	}
+	// copy the backends so frontends never share their state with the caller or each other
+	frontend.lock.Lock()
+	for _, backend := range backends.Values() {
+		frontend.addBackend(&types.BackendInfo{AppId: appId, Node: backend}, false)
+	}
+	// no point waiting on the initial backends
+	frontend.stopFlusher()
+	frontend.applyChanges()
+	frontend.lock.Unlock()
+	return frontend
 }
 
 // Frontend represents a instance for an app with a set of backends
 type Frontend struct {
+	// timeouts of the connections in nanoseconds, accessed atomically so
+	// they can be changed while the connections are being proxied
+	idleTimeout int64
+	keepAlive   int64
+	// connections closed so far, used to sample the access log
+	closed uint64
+
 	appId    string
 	lock     sync.Mutex
 	backends sets.Set
 	port     string
 	listener net.Listener
+	// socket of the frontend instead of the listener, with tlb.protocol=udp
+	packets  *net.UDPConn
+	config   *FrontendConfig
 	strategy LoadBalancingStrategy
+	// slots for the connections that are accepted but not yet connected
+	// to a backend, nil when they're not limited
+	pending chan bool
+	// new connections over the rate are rejected, nil when they're not limited
+	limiter *rateLimiter
+	// open connections of the app, shared with the frontend this one replaced
+	open    *int64
+	stopped bool
+	// closed when the frontend is stopped
+	done chan bool
+
+	// backends the strategy knows about, it lags behind backends while the
+	// changes are being coalesced
+	routed map[string]bool
+	// latest change to each backend that's not applied to the strategy yet,
+	// nil when the backend was removed, in the order they first changed
+	changes map[string]*types.BackendInfo
+	order   []string
+	flusher *time.Timer
+	// latest info of the backends waiting on their warmup connection
+	warming map[string]*types.BackendInfo
+	// latest info of all the backends
+	infos map[string]*types.BackendInfo
+	// health of every backend discovery knows about, a backend removed by
+	// discovery loses it's health along with it
+	health map[string]*healthState
+	// client side of the open connections of the backends, only the ones that have any
+	active map[string]map[io.Closer]bool
+	// closed once the backend that's being drained has no connections left
+	drains map[string]chan bool
+
+	// success ratio of the dials to the backends
+	slo *successWindow
+	// success ratio of the accepted connections, over the same window
+	sli *successWindow
+	// backend all the connections are pinned to, nil when the strategy picks them
+	override *Override
+}
+
+func (f *Frontend) isStopped() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.stopped
+}
+
+// UpdateConfig applies the config from the updated labels of the app. The
+// idle and keepalive timeouts apply to the connections in flight from their
+// next read on, the socket buffers, cork, DSCP, failover, dial timeout,
+// lifetime, detection, limits and access log apply to the next connections,
+// the coalescing window, warmup and drain timeout to the next change to the
+// backends, the outlier detection to the next failed dial. The listener and
+// strategy settings (backlog, maxpending, strategy, zone) stay as they were
+// when the frontend was created.
+func (f *Frontend) UpdateConfig(config *FrontendConfig) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	updated := *f.config
+	updated.ReadBuffer = config.ReadBuffer
+	updated.WriteBuffer = config.WriteBuffer
+	updated.Cork = config.Cork
+	updated.DSCP = config.DSCP
+	updated.DSCPClient = config.DSCPClient
+	updated.FailoverAttempts = config.FailoverAttempts
+	updated.FailoverBuffer = config.FailoverBuffer
+	updated.CoalesceWindow = config.CoalesceWindow
+	updated.Warmup = config.Warmup
+	updated.DrainTimeout = config.DrainTimeout
+	updated.HealthCheck = config.HealthCheck
+	updated.OutlierFailures = config.OutlierFailures
+	updated.OutlierEjection = config.OutlierEjection
+	updated.OutlierWindow = config.OutlierWindow
+	updated.SteerHeader = config.SteerHeader
+	updated.SteerTimeout = config.SteerTimeout
+	updated.IdleTimeout = config.IdleTimeout
+	updated.KeepAlive = config.KeepAlive
+	updated.DialTimeout = config.DialTimeout
+	updated.MaxConnLifetime = config.MaxConnLifetime
+	updated.ProbeInterval = config.ProbeInterval
+	updated.Detect = config.Detect
+	updated.ProxyProtocol = config.ProxyProtocol
+	updated.ProxyProtocolVersion = config.ProxyProtocolVersion
+	updated.AccessLog = config.AccessLog
+	updated.AccessLogSample = config.AccessLogSample
+	updated.AccessLogSlow = config.AccessLogSlow
+	updated.AccessLogBytes = config.AccessLogBytes
+	updated.AccessLogSink = config.AccessLogSink
+	if updated.ConnectionRate != config.ConnectionRate || updated.ConnectionBurst != config.ConnectionBurst {
+		f.limiter = newRateLimiter(config.ConnectionRate, config.ConnectionBurst)
+	}
+	updated.ConnectionRate = config.ConnectionRate
+	updated.ConnectionBurst = config.ConnectionBurst
+	updated.MaxConnections = config.MaxConnections
+	updated.ConnectionsMode = config.ConnectionsMode
+	updated.UDPTimeout = config.UDPTimeout
+	updated.LabelErrors = config.LabelErrors
+	f.config = &updated
+	f.setTimeouts(config)
+	f.useAccessLog(config)
+}
+
+// Config returns the config the new connections should use
+func (f *Frontend) Config() *FrontendConfig {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.config
+}
+
+func (f *Frontend) setTimeouts(config *FrontendConfig) {
+	atomic.StoreInt64(&f.idleTimeout, int64(config.IdleTimeout))
+	atomic.StoreInt64(&f.keepAlive, int64(config.KeepAlive))
+}
+
+func (f *Frontend) IdleTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&f.idleTimeout))
+}
+
+func (f *Frontend) KeepAlive() time.Duration {
+	return time.Duration(atomic.LoadInt64(&f.keepAlive))
 }
 
 func (f *Frontend) Lookup() string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if override := f.pinned(time.Now()); override != nil {
+		return override.Backend
+	}
 	return f.strategy.Next()
 }
 
-func (f *Frontend) AddBackend(backend string) {
+// LookupFor returns the backend for a connection from the client IP, skipping
+// the backends in exclude when the strategy is sticky. A pinned backend wins
+// over the strategy unless it's excluded.
+func (f *Frontend) LookupFor(client string, exclude map[string]bool) string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if override := f.pinned(time.Now()); override != nil && !exclude[override.Backend] {
+		return override.Backend
+	}
+	return nextFor(f.strategy, client, exclude)
+}
+
+// acquire tells the strategy a connection to the backend is open, conn is
+// closed if the backend is drained before it's done
+func (f *Frontend) acquire(backend string, conn io.Closer) {
+	f.openConnection(backend, conn)
+	acquire(f.strategy, backend)
+}
+
+// release tells the strategy the connection to the backend is closed
+func (f *Frontend) release(backend string, conn io.Closer) {
+	f.closeConnection(backend, conn)
+	release(f.strategy, backend)
+}
+
+// observe tells the strategy how long the backend took to connect and send the first byte
+func (f *Frontend) observe(backend string, latency time.Duration) {
+	observe(f.strategy, backend, latency)
+}
+
+func (f *Frontend) AddBackend(backend *types.BackendInfo) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.addBackend(backend, f.config.Warmup > 0)
+}
+
+// addBackend adds the backend, a new one is routed only after a warmup
+// connection to it succeeds when warmup is set
+func (f *Frontend) addBackend(backend *types.BackendInfo, warmup bool) {
+	f.backends.Add(backend.Node)
+	f.infos[backend.Node] = backend
+	if state, known := f.health[backend.Node]; !known {
+		f.health[backend.Node] = &healthState{}
+	} else {
+		f.cancelDrain(backend.Node, state)
+	}
+	if _, warming := f.warming[backend.Node]; warming {
+		f.warming[backend.Node] = backend
+		return
+	}
+	if f.health[backend.Node].pulled() {
+		// it's routed again once it's healthy
+		return
+	}
+	if warmup && !f.routed[backend.Node] && f.changes[backend.Node] == nil {
+		f.warming[backend.Node] = backend
+		go f.warmup(backend.Node, f.config.Warmup)
+		return
+	}
+	// might be an update to the info of an existing backend
+	f.queueChange(backend.Node, backend)
+}
+
+// warmup opens and closes a connection to the backend and routes it if that
+// succeeds. A backend that fails it is not routed until it's added again.
+func (f *Frontend) warmup(node string, timeout time.Duration) {
+	conn, err := net.DialTimeout("tcp", node, timeout)
+	if err == nil {
+		conn.Close()
+	}
 	f.lock.Lock()
 	defer f.lock.Unlock()
-	f.backends.Add(backend)
-	f.strategy.AddBackend(backend)
+	backend, warming := f.warming[node]
+	if !warming {
+		// removed in the meantime
+		return
+	}
+	delete(f.warming, node)
+	if err != nil {
+		log.Printf("[WARN] Warmup of %s for %s failed, not routing to it - %v\n", node, f.appId, err)
+		metrics.Counter("frontend-warmup-failures", "app", f.appId, "backend", node).Inc()
+		return
+	}
+	f.queueChange(node, backend)
+}
+
+// isRouted tells if the strategy knows about the backend or will, once the
+// queued changes are applied
+func (f *Frontend) isRouted(node string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if backend, queued := f.changes[node]; queued {
+		return backend != nil
+	}
+	return f.routed[node]
 }
 
 func (f *Frontend) RemoveBackend(backend string) {
@@ -46,10 +305,163 @@ func (f *Frontend) RemoveBackend(backend string) {
 	found := f.backends.Contains(backend)
 	if found {
 		f.backends.Remove(backend)
+		delete(f.warming, backend)
+		delete(f.infos, backend)
+		f.forgetMetrics(backend)
+		state := f.health[backend]
+		delete(f.health, backend)
+		if state.ejected {
+			f.reportUnhealthy()
+		}
+		if f.override != nil && f.override.Backend == backend {
+			f.clearOverride("backend was removed")
+		}
+		f.queueChange(backend, nil)
+		f.forgetSelections(backend)
 	} else {
 		log.Printf("[WARN] Backend %s is not part of this frontend - %s\n", backend, f.appId)
+		// usually means we missed the add event for the backend
+		metrics.Counter("frontend-unknown-backend-removes", "app", f.appId).Inc()
+	}
+}
+
+// forgetMetrics deletes the series of the backend that discovery removed, so
+// they don't pile up as the backends come and go
+func (f *Frontend) forgetMetrics(node string) {
+	metrics.Delete("backend-dials", "app", f.appId, "backend", node)
+	metrics.Delete("backend-dial-failures", "app", f.appId, "backend", node)
+	metrics.Delete("backend-resets", "app", f.appId, "backend", node)
+	metrics.Delete("frontend-outlier-ejections", "app", f.appId, "backend", node)
+	metrics.Delete("frontend-outlier-restores", "app", f.appId, "backend", node)
+}
+
+// forgetSelections deletes the shadow selections of the backend that
+// discovery removed, once the strategy doesn't have it. The queued removals
+// get here when they're applied.
+func (f *Frontend) forgetSelections(node string) {
+	if shadow, ok := f.strategy.(*Shadow); ok && !f.routed[node] && f.infos[node] == nil {
+		shadow.forget(node)
+	}
+}
+
+// countBackend increments the counter of the backend, unless discovery has
+// removed it while the connection was in flight. It's series would be
+// created again otherwise.
+func (f *Frontend) countBackend(name, node string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, known := f.health[node]; known {
+		metrics.Counter(name, "app", f.appId, "backend", node).Inc()
+	}
+}
+
+// queueChange records the change to the backend for the strategy. Changes
+// within the coalescing window of the app are applied together, so a burst
+// of them (like during a mass scale down) rebuilds the strategy only once.
+func (f *Frontend) queueChange(node string, backend *types.BackendInfo) {
+	if _, queued := f.changes[node]; !queued {
+		f.order = append(f.order, node)
+	}
+	f.changes[node] = backend
+	if f.config.CoalesceWindow <= 0 {
+		f.applyChanges()
+	} else if f.flusher == nil {
+		f.flusher = time.AfterFunc(f.config.CoalesceWindow, f.flushChanges)
+	}
+}
+
+func (f *Frontend) flushChanges() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.flusher = nil
+	f.applyChanges()
+}
+
+func (f *Frontend) stopFlusher() {
+	if f.flusher != nil {
+		f.flusher.Stop()
+		f.flusher = nil
+	}
+}
+
+// applyChanges applies the net effect of the queued changes to the strategy
+func (f *Frontend) applyChanges() {
+	if len(f.order) == 0 {
+		return
+	}
+	_, aware := f.strategy.(BackendInfoAware)
+	var added []*types.BackendInfo
+	var removed []string
+	for _, node := range f.order {
+		backend := f.changes[node]
+		if backend == nil && f.routed[node] {
+			removed = append(removed, node)
+			delete(f.routed, node)
+		} else if backend != nil && (aware || !f.routed[node]) {
+			added = append(added, backend)
+			f.routed[node] = true
+		}
+	}
+	f.changes = make(map[string]*types.BackendInfo)
+	f.order = nil
+	updateBackends(f.strategy, added, removed)
+	for _, node := range removed {
+		f.forgetSelections(node)
+	}
+	if f.config.CoalesceWindow > 0 {
+		metrics.Counter("frontend-coalesced-updates", "app", f.appId).Inc()
 	}
-	f.strategy.RemoveBackend(backend)
+}
+
+// Backends returns all the backends of the frontend in sorted order
+func (f *Frontend) Backends() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	backends := append(make([]string, 0, f.backends.Size()), f.backends.Values()...)
+	sort.Strings(backends)
+	return backends
+}
+
+// Bind returns the address the frontend is listening on, or will listen on
+// once it's started
+func (f *Frontend) Bind() string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.listener != nil {
+		return f.listener.Addr().String()
+	}
+	if f.packets != nil {
+		return f.packets.LocalAddr().String()
+	}
+	return ":" + f.port
+}
+
+// backendInfos returns the latest info of all the backends
+func (f *Frontend) backendInfos() []*types.BackendInfo {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	infos := make([]*types.BackendInfo, 0, len(f.infos))
+	for _, info := range f.infos {
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// Bound tells if the frontend is listening on it's port
+func (f *Frontend) Bound() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return (f.listener != nil || f.packets != nil) && !f.stopped
+}
+
+// reportBound sets the frontend-bound gauge of the app from the frontend,
+// for when another frontend of the app has changed it
+func (f *Frontend) reportBound() {
+	bound := 0.0
+	if f.Bound() {
+		bound = 1
+	}
+	metrics.Gauge("frontend-bound", "app", f.appId).Set(bound)
 }
 
 func (f *Frontend) LenOfBackends() int {
@@ -58,37 +470,216 @@ func (f *Frontend) LenOfBackends() int {
 	return f.backends.Size()
 }
 
-// Start listening on the frontend and start routing requests to backends
-func (f *Frontend) Start() {
-	log.Printf("Starting Frontend for %s via %s\n", f.appId, f.port)
-	l, err := net.Listen("tcp", ":"+f.port)
+// errFrontendStopped is returned by Listen when the frontend is stopped before it could listen
+var errFrontendStopped = errors.New("frontend is stopped")
+
+// Listen binds the port of the frontend, Start does it if it's not done
+// already. Where supported the port is bound with SO_REUSEPORT, so a new
+// frontend for the same port can be listening before the old one is stopped.
+// With tlb.tls.cert the clients are served TLS, the backends get the plain
+// connection. With tlb.protocol=udp it binds a UDP socket instead.
+func (f *Frontend) Listen() error {
+	f.lock.Lock()
+	bound := f.listener != nil || f.packets != nil
+	f.lock.Unlock()
+	if bound {
+		return nil
+	}
+	if f.config.Protocol == UDPProtocol {
+		return f.listenUDP()
+	}
+	var tlsConfig *tls.Config
+	if f.config.TLSCert != "" {
+		var err error
+		if tlsConfig, err = newTLSConfig(f.config.TLSCert, f.config.TLSKey); err != nil {
+			return err
+		}
+	}
+	l, err := listenReusePort(":" + f.port)
+	if err != nil {
+		return err
+	}
+	if f.config.Backlog > 0 {
+		if err := setBacklog(l, f.config.Backlog); err != nil {
+			log.Printf("[WARN] Unable to set the backlog for %s - %v\n", f.appId, err)
+		}
+	}
+	if tlsConfig != nil {
+		// the handshake is done by the first read of the request, not the accept loop
+		l = tls.NewListener(l, tlsConfig)
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.stopped {
+		l.Close()
+		return errFrontendStopped
+	}
 	f.listener = l
+	metrics.Gauge("frontend-bound", "app", f.appId).Set(1)
+	listeners := metrics.Gauge("frontend-listeners")
+	listeners.Inc()
+	// every proxied connection takes 2 more files, the listeners shouldn't leave too few for them
+	if limit := openFileLimit(); limit > 0 && int(listeners.Value())*2 > limit {
+		log.Printf("[WARN] %d frontends are listening, more than half of the open files limit of %d. Raise it with ulimit -n\n", int(listeners.Value()), limit)
+	}
+	return nil
+}
+
+// Start listening on the frontend and start routing requests to backends. It
+// returns when the frontend is stopped, or with the error when it can't
+// listen or accept, which only takes this frontend down.
+func (f *Frontend) Start() error {
+	log.Printf("Starting Frontend for %s via %s\n", f.appId, f.port)
+	err := f.Listen()
+	if err == errFrontendStopped {
+		// stopped before we got to listen
+		return nil
+	} else if err != nil {
+		return err
+	}
+	f.lock.Lock()
+	l, packets := f.listener, f.packets
+	f.lock.Unlock()
 	log.Printf("Started Frontend for %s at %s\n", f.appId, f.port)
-	if err != nil {
-		log.Fatal(err)
+	if f.Config().HealthCheck.Interval > 0 {
+		go f.healthCheck()
+	}
+	if packets != nil {
+		return NewUDPFrontend(f, packets).Serve()
 	}
 
+	var backoff time.Duration
 	for {
 		// Wait for a connection.
 		conn, err := l.Accept()
-		if err != nil {
-			log.Fatal(err)
+		if err != nil && f.isStopped() {
+			return nil
+		} else if ne, ok := err.(net.Error); ok && ne.Temporary() {
+			// like running out of files, it might get better
+			backoff = acceptBackoff(backoff)
+			log.Printf("[WARN] Unable to accept on the frontend of %s, retrying in %v - %v\n", f.appId, backoff, err)
+			time.Sleep(backoff)
+			continue
+		} else if err != nil {
+			return err
+		}
+		backoff = 0
+
+		if !f.admit(time.Now()) {
+			f.recordConnection(false)
+			conn.Close()
+			continue
+		}
+		if !f.acquirePending() {
+			// backends are slow to connect, don't pile up more goroutines on them
+			metrics.Counter("frontend-pending-rejected", "app", f.appId).Inc()
+			f.recordConnection(false)
+			conn.Close()
+			continue
 		}
 
 		// Handle the connection in a new goroutine.
 		// The loop then returns to accepting, so that
 		// multiple connections may be served concurrently.
-		go NewRequest(conn, f.Lookup(), f.appId)
+		atomic.AddInt64(f.open, 1)
+		go func(conn net.Conn, backend string) {
+			defer atomic.AddInt64(f.open, -1)
+			NewRequest(conn, backend, f)
+		}(conn, f.LookupFor(clientIP(conn), nil))
+	}
+}
+
+// acceptBackoff doubles the wait after a failed accept, from 5ms upto a second
+func acceptBackoff(backoff time.Duration) time.Duration {
+	if backoff == 0 {
+		return 5 * time.Millisecond
+	}
+	if backoff *= 2; backoff > time.Second {
+		return time.Second
+	}
+	return backoff
+}
+
+// clientIP returns the IP of the client without the port
+func clientIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
+// acquirePending takes a slot for a connection that is yet to connect to a
+// backend, returns false when all the slots are taken
+func (f *Frontend) acquirePending() bool {
+	if f.pending == nil {
+		return true
+	}
+	select {
+	case f.pending <- true:
+		return true
+	default:
+		return false
+	}
+}
+
+// releasePending gives back the slot once the connection to the backend is done
+func (f *Frontend) releasePending() {
+	if f.pending != nil {
+		<-f.pending
 	}
 }
 
 func (f *Frontend) Stop() {
 	log.Println("[INFO] Stopping the frontend - " + f.appId)
+	f.lock.Lock()
+	wasStopped := f.stopped
+	f.stopped = true
+	if !wasStopped {
+		close(f.done)
+	}
+	f.stopFlusher()
+	var listener io.Closer
 	if f.listener != nil {
-		err := f.listener.Close()
+		listener = f.listener
+	} else if f.packets != nil {
+		listener = f.packets
+	}
+	f.lock.Unlock()
+	if listener != nil && !wasStopped {
+		metrics.Gauge("frontend-listeners").Dec()
+		metrics.Gauge("frontend-bound", "app", f.appId).Set(0)
+		err := listener.Close()
 		if err != nil {
 			log.Printf("[ERR] Error occured while closing the Frontend - %v\n", err)
 		}
 	}
 	log.Println("[INFO] Stopped the frontend - " + f.appId)
 }
+
+// Shutdown stops the frontend and waits until the ctx is done for it's
+// connections to finish, along with the ones of the frontends it replaced.
+// The ones still open to it's backends after that are closed, and it returns
+// false. Those of the frontends it replaced are left to the exit.
+func (f *Frontend) Shutdown(ctx context.Context) bool {
+	if !f.isStopped() {
+		f.Stop()
+	}
+	if remaining := atomic.LoadInt64(f.open); remaining > 0 {
+		log.Printf("[INFO] Waiting for %d connections of %s to finish\n", remaining, f.appId)
+	}
+	if awaitConnections(ctx, []*int64{f.open}) {
+		return true
+	}
+	log.Printf("[WARN] %d connections of %s are still open, closing them\n", atomic.LoadInt64(f.open), f.appId)
+	f.lock.Lock()
+	backends := make([]string, 0, len(f.active))
+	for backend := range f.active {
+		backends = append(backends, backend)
+	}
+	f.lock.Unlock()
+	for _, backend := range backends {
+		f.closeConnections(backend)
+	}
+	return false
+}
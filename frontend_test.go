@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ashwanthkumar/golang-utils/sets"
+	"github.com/ashwanthkumar/gotlb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrontendToCountRemovesOfUnknownBackends(t *testing.T) {
+	frontend := createFrontend(APP_ID, "-1", sets.FromSlice([]string{"b:1"}))
+	frontend.AddBackend(createBackendInfo(APP_ID, "b:1"))
+	counter := metrics.Counter("frontend-unknown-backend-removes", "app", APP_ID)
+	before := counter.Value()
+
+	frontend.RemoveBackend("b:1")
+	assert.Equal(t, before, counter.Value())
+	frontend.RemoveBackend("b:2")
+	assert.Equal(t, before+1, counter.Value())
+}
+
+func TestFrontendToReturnTheErrorWhenItCannotListen(t *testing.T) {
+	taken, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	_, port, _ := net.SplitHostPort(taken.Addr().String())
+
+	m := NewManager()
+	failures := metrics.Counter("frontend-start-failures", "app", "/taken-port")
+	before := failures.Value()
+	frontend := createFrontend("/taken-port", port, sets.Empty())
+	assert.Error(t, frontend.Start())
+	assert.False(t, frontend.Bound())
+	m.serve(frontend)
+	assert.Equal(t, before+1, failures.Value())
+}
+
+func TestFrontendToReturnQuietlyOnceItsStopped(t *testing.T) {
+	frontend := createFrontend("/stopped-accept", "0", sets.Empty())
+	assert.NoError(t, frontend.Listen())
+	started := make(chan error)
+	go func() { started <- frontend.Start() }()
+
+	frontend.Stop()
+	select {
+	case err := <-started:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the accept loop didn't return")
+	}
+}
+
+func TestFrontendToCloseTheConnectionsStillOpenWhenTheShutdownIsOver(t *testing.T) {
+	frontend := createFrontendWithLabels([]string{"b:1"}, nil)
+	client, conn := net.Pipe()
+	atomic.AddInt64(frontend.open, 1)
+	frontend.acquire("b:1", conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.False(t, frontend.Shutdown(ctx))
+	assert.True(t, frontend.isStopped())
+	_, err := client.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+
+	// once they're done it returns right away
+	frontend.release("b:1", conn)
+	atomic.AddInt64(frontend.open, -1)
+	assert.True(t, frontend.Shutdown(context.Background()))
+}
+
+func TestFrontendToKeepAcceptingAfterATemporaryError(t *testing.T) {
+	echo := startEchoBackend(t)
+	defer echo.Close()
+	frontend := createFrontend("/flaky-accept", "0", sets.FromSlice([]string{echo.Addr().String()}))
+	assert.NoError(t, frontend.Listen())
+	flaky := &flakyListener{Listener: frontend.listener, failures: 3}
+	frontend.listener = flaky
+	started := make(chan error)
+	go func() { started <- frontend.Start() }()
+	defer frontend.Stop()
+
+	client, err := net.Dial("tcp", flaky.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	_, err = client.Write([]byte("hello"))
+	assert.NoError(t, err)
+	response := make([]byte, 5)
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadFull(client, response)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(response))
+	assert.True(t, atomic.LoadInt32(&flaky.failures) < 0)
+	select {
+	case err := <-started:
+		t.Fatalf("the accept loop returned - %v", err)
+	default:
+	}
+}
+
+// flakyListener fails it's first accepts with a temporary error
+type flakyListener struct {
+	net.Listener
+	failures int32
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if atomic.AddInt32(&l.failures, -1) >= 0 {
+		return nil, temporaryError{}
+	}
+	return l.Listener.Accept()
+}
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "too many open files" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+func TestAcceptBackoffToDoubleUptoASecond(t *testing.T) {
+	assert.Equal(t, 5*time.Millisecond, acceptBackoff(0))
+	assert.Equal(t, 10*time.Millisecond, acceptBackoff(5*time.Millisecond))
+	assert.Equal(t, time.Second, acceptBackoff(800*time.Millisecond))
+}
+
+func TestFrontendsSharingANodeToBeIsolated(t *testing.T) {
+	backends := sets.FromSlice([]string{"10.0.0.1:31000"})
+	first := createFrontend("/first", "-1", backends)
+	second := createFrontend("/second", "-1", backends)
+
+	first.RemoveBackend("10.0.0.1:31000")
+	assert.Equal(t, 0, first.LenOfBackends())
+	assert.Equal(t, 1, second.LenOfBackends())
+	assert.Equal(t, "10.0.0.1:31000", second.Lookup())
+}
+
+func TestFrontendToRouteToABackendAddedAfterAnUnknownRemove(t *testing.T) {
+	frontend := createFrontend(APP_ID, "-1", sets.FromSlice([]string{"b:1"}))
+	frontend.RemoveBackend("b:2")
+	frontend.AddBackend(createBackendInfo(APP_ID, "b:2"))
+	frontend.RemoveBackend("b:1")
+
+	assert.Equal(t, "b:2", frontend.Lookup())
+	assert.Equal(t, "b:2", frontend.Lookup())
+}
+
+func TestFrontendToKeepTheClientsOnTheirBackendWithIPHash(t *testing.T) {
+	frontend := createFrontendWithLabels([]string{"b:1", "b:2", "b:3"}, map[string]string{"tlb.strategy": IPHashName})
+	pinned := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		client := fmt.Sprintf("10.0.0.%d", i)
+		pinned[client] = frontend.LookupFor(client, nil)
+		assert.Equal(t, pinned[client], frontend.LookupFor(client, nil))
+	}
+
+	frontend.RemoveBackend("b:3")
+	for client, backend := range pinned {
+		if backend != "b:3" {
+			assert.Equal(t, backend, frontend.LookupFor(client, nil), client)
+		} else {
+			assert.NotEqual(t, "b:3", frontend.LookupFor(client, nil), client)
+		}
+	}
+}
+
+func TestFrontendToLimitPendingConnections(t *testing.T) {
+	frontend := createFrontendWithLabels([]string{"b:1"}, map[string]string{"tlb.maxpending": "2"})
+	assert.True(t, frontend.acquirePending())
+	assert.True(t, frontend.acquirePending())
+	assert.False(t, frontend.acquirePending())
+
+	frontend.releasePending()
+	assert.True(t, frontend.acquirePending())
+}
+
+func TestFrontendNotToLimitPendingConnectionsByDefault(t *testing.T) {
+	frontend := createFrontend(APP_ID, "-1", sets.Empty())
+	for i := 0; i < 100; i++ {
+		assert.True(t, frontend.acquirePending())
+	}
+}
+
+func TestFrontendToCoalesceChangesToTheBackends(t *testing.T) {
+	frontend := createFrontendWithLabels([]string{"b:1", "b:2"}, map[string]string{"tlb.coalesce": "50ms", "tlb.strategy": "maglev"})
+	strategy := &bulkCountingStrategy{LoadBalancingStrategy: frontend.strategy}
+	frontend.strategy = strategy
+
+	for i := 3; i <= 20; i++ {
+		frontend.AddBackend(createBackendInfo(APP_ID, fmt.Sprintf("b:%d", i)))
+	}
+	for i := 1; i <= 18; i++ {
+		frontend.RemoveBackend(fmt.Sprintf("b:%d", i))
+	}
+	frontend.RemoveBackend("b:19")
+	frontend.AddBackend(createBackendInfo(APP_ID, "b:19"))
+	// the backends are updated right away, the strategy only after the window
+	assert.Equal(t, []string{"b:19", "b:20"}, frontend.Backends())
+	frontend.lock.Lock()
+	assert.Equal(t, 0, strategy.updates)
+	frontend.lock.Unlock()
+
+	time.Sleep(200 * time.Millisecond)
+	frontend.lock.Lock()
+	defer frontend.lock.Unlock()
+	assert.Equal(t, 1, strategy.updates)
+	routed := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		routed[strategy.NextFor(fmt.Sprintf("10.0.0.%d", i), nil)] = true
+	}
+	assert.Equal(t, map[string]bool{"b:19": true, "b:20": true}, routed)
+}
+
+func TestFrontendToRouteABackendOnlyAfterItsWarmup(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	accepted := make(chan bool, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			conn.Close()
+			accepted <- true
+		}
+	}()
+	frontend := createFrontendWithLabels([]string{"b:1"}, map[string]string{"tlb.warmup": "1s"})
+
+	frontend.AddBackend(createBackendInfo(APP_ID, listener.Addr().String()))
+	select {
+	case <-accepted:
+	case <-time.After(time.Second):
+		t.Fatal("no warmup connection to the backend")
+	}
+	for i := 0; i < 100 && !frontend.isRouted(listener.Addr().String()); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, frontend.isRouted(listener.Addr().String()))
+}
+
+func TestFrontendNotToRouteABackendFailingItsWarmup(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	dead := listener.Addr().String()
+	listener.Close()
+	frontend := createFrontendWithLabels([]string{"b:1"}, map[string]string{"tlb.warmup": "1s"})
+	counter := metrics.Counter("frontend-warmup-failures", "app", APP_ID, "backend", dead)
+	before := counter.Value()
+
+	frontend.AddBackend(createBackendInfo(APP_ID, dead))
+	for i := 0; i < 100 && counter.Value() == before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, before+1, counter.Value())
+	assert.False(t, frontend.isRouted(dead))
+	assert.Equal(t, 2, frontend.LenOfBackends())
+	assert.Equal(t, "b:1", frontend.Lookup())
+	assert.Equal(t, "b:1", frontend.Lookup())
+}
+
+func TestFrontendNotToRouteABackendRemovedDuringItsWarmup(t *testing.T) {
+	frontend := createFrontendWithLabels([]string{"b:1"}, map[string]string{"tlb.warmup": "1s"})
+	// never completes the handshake
+	frontend.AddBackend(createBackendInfo(APP_ID, "10.255.255.1:80"))
+	frontend.RemoveBackend("10.255.255.1:80")
+
+	assert.False(t, frontend.isRouted("10.255.255.1:80"))
+	frontend.lock.Lock()
+	defer frontend.lock.Unlock()
+	assert.Equal(t, 0, len(frontend.warming))
+}
+
+// bulkCountingStrategy counts the bulk updates to the maglev strategy it wraps
+type bulkCountingStrategy struct {
+	LoadBalancingStrategy
+	updates int
+}
+
+func (b *bulkCountingStrategy) UpdateBackends(added []*types.BackendInfo, removed []string) {
+	b.updates++
+	b.LoadBalancingStrategy.(BulkUpdater).UpdateBackends(added, removed)
+}
+
+func (b *bulkCountingStrategy) NextFor(key string, exclude map[string]bool) string {
+	return b.LoadBalancingStrategy.(StickyStrategy).NextFor(key, exclude)
+}
+
+func TestFrontendToCountTheListeners(t *testing.T) {
+	listeners := metrics.Gauge("frontend-listeners")
+	before := listeners.Value()
+	frontend := createFrontend(APP_ID, "0", sets.FromSlice([]string{"b:1"}))
+	assert.NoError(t, frontend.Listen())
+	assert.Equal(t, before+1, listeners.Value())
+
+	frontend.Stop()
+	frontend.Stop()
+	assert.Equal(t, before, listeners.Value())
+}
+
+func TestFrontendToReportIfItsBound(t *testing.T) {
+	bound := metrics.Gauge("frontend-bound", "app", "/bound")
+	frontend := createFrontend("/bound", "0", sets.FromSlice([]string{"b:1"}))
+	assert.False(t, frontend.Bound())
+	assert.NoError(t, frontend.Listen())
+	assert.True(t, frontend.Bound())
+	assert.Equal(t, float64(1), bound.Value())
+
+	frontend.Stop()
+	assert.False(t, frontend.Bound())
+	assert.Equal(t, float64(0), bound.Value())
+}
+
+func TestFrontendToApplyTheUpdatedTimeoutsToTheNextConnections(t *testing.T) {
+	frontend := createFrontendWithLabels([]string{"b:1"}, map[string]string{"tlb.timeout.dial": "1s"})
+	frontend.UpdateConfig(NewFrontendConfig(map[string]string{"tlb.timeout.dial": "250ms", "tlb.timeout.lifetime": "1h"}))
+	assert.Equal(t, 250*time.Millisecond, frontend.Config().DialTimeout)
+	assert.Equal(t, time.Hour, frontend.Config().MaxConnLifetime)
+}
@@ -0,0 +1,87 @@
+//go:build grpc
+// +build grpc
+
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+
+	"github.com/ashwanthkumar/gotlb/api"
+	"github.com/ashwanthkumar/gotlb/types"
+	"google.golang.org/grpc"
+)
+
+// startGRPCServer serves the GoTLB gRPC service on addr, it blocks until the server fails
+func startGRPCServer(addr string, manager *Manager) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	server := grpc.NewServer()
+	api.RegisterGoTLBServer(server, &grpcServer{manager: manager})
+	log.Printf("Starting gRPC API on %s\n", addr)
+	return server.Serve(l)
+}
+
+// grpcServer implements api.GoTLBServer on top of the Manager, so it works
+// on the same routing table the frontends use
+type grpcServer struct {
+	api.UnimplementedGoTLBServer
+	manager *Manager
+}
+
+func (g *grpcServer) GetState(ctx context.Context, request *api.GetStateRequest) (*api.State, error) {
+	state := &api.State{}
+	for _, frontend := range g.manager.State() {
+		state.Frontends = append(state.Frontends, &api.Frontend{
+			AppId:    frontend.AppId,
+			Port:     frontend.Port,
+			Backends: frontend.Backends,
+		})
+	}
+	return state, nil
+}
+
+func (g *grpcServer) WatchRoutes(request *api.WatchRoutesRequest, stream api.GoTLB_WatchRoutesServer) error {
+	changes, cancel := g.manager.Watch()
+	defer cancel()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case change, ok := <-changes:
+			if !ok {
+				return nil
+			}
+			err := stream.Send(&api.RouteChange{
+				Type:    string(change.Type),
+				AppId:   change.AppId,
+				Port:    change.Port,
+				Backend: change.Backend,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (g *grpcServer) AddBackend(ctx context.Context, request *api.BackendRequest) (*api.BackendResponse, error) {
+	log.Printf("[INFO] Adding backend %s for %s via gRPC\n", request.Node, request.AppId)
+	err := g.manager.AddBackendForApp(&types.BackendInfo{AppId: request.AppId, Node: request.Node, Weight: int(request.Weight), Metadata: request.Tags})
+	if err != nil {
+		return nil, err
+	}
+	return &api.BackendResponse{}, nil
+}
+
+func (g *grpcServer) RemoveBackend(ctx context.Context, request *api.BackendRequest) (*api.BackendResponse, error) {
+	log.Printf("[INFO] Removing backend %s for %s via gRPC\n", request.Node, request.AppId)
+	err := g.manager.RemoveBackendForApp(&types.BackendInfo{AppId: request.AppId, Node: request.Node})
+	if err != nil {
+		return nil, err
+	}
+	return &api.BackendResponse{}, nil
+}
@@ -0,0 +1,10 @@
+//go:build !grpc
+// +build !grpc
+
+package main
+
+import "errors"
+
+func startGRPCServer(addr string, manager *Manager) error {
+	return errors.New("GoTLB was built without gRPC support, build it with -tags grpc")
+}
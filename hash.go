@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"log"
+	"math/bits"
+	"sort"
+)
+
+const (
+	FNVHash        = "fnv"
+	CRC32Hash      = "crc32"
+	Murmur3Hash    = "murmur3"
+	RendezvousHash = "rendezvous"
+)
+
+// hashFunc hashes the key of a connection (or a virtual node of a backend)
+// for the hash based strategies
+type hashFunc func(key string) uint32
+
+var hashFuncs = map[string]hashFunc{
+	FNVHash:     hashOf,
+	CRC32Hash:   crc32Hash,
+	Murmur3Hash: murmur3Hash,
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func crc32Hash(key string) uint32 {
+	return crc32.Checksum([]byte(key), castagnoli)
+}
+
+// murmur3Hash is the 32 bit MurmurHash3 with a seed of 0
+func murmur3Hash(key string) uint32 {
+	const c1, c2 = 0xcc9e2d51, 0x1b873593
+	data := []byte(key)
+	var h uint32
+	for ; len(data) >= 4; data = data[4:] {
+		k := binary.LittleEndian.Uint32(data)
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+	var k uint32
+	switch len(data) {
+	case 3:
+		k ^= uint32(data[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(data[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(data[0])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+	h ^= uint32(len(key))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// hashFuncOf returns the hash function by it's name, unknown names fall back to fnv
+func hashFuncOf(appId, name string) hashFunc {
+	hash, known := hashFuncs[name]
+	if !known {
+		log.Printf("[WARN] Unknown hash %s for %s, using %s\n", name, appId, FNVHash)
+		return hashOf
+	}
+	return hash
+}
+
+// keyMapper maps the keys to the backends for IPHash
+type keyMapper interface {
+	add(backend string)
+	remove(backend string)
+	// get returns the backend of the key, empty when there are no backends
+	get(key string) string
+	// walk goes over the backends in the order of preference for the key
+	// and returns the first one accepted, empty when none of them are
+	walk(key string, accept func(backend string) bool) string
+}
+
+// newKeyMapper returns the hash ring with the hash function by it's name, or
+// rendezvous hashing
+func newKeyMapper(appId, hash string, replicas int) keyMapper {
+	if hash == RendezvousHash {
+		return &rendezvous{}
+	}
+	return newHashRing(replicas, hashFuncOf(appId, hash))
+}
+
+// rendezvous is rendezvous (highest random weight) hashing. Every backend
+// gets a score for the key and the key goes to the one with the highest, so
+// removing a backend only moves it's keys and adding one only takes the keys
+// it now wins - like the hash ring but without the virtual nodes, at the cost
+// of scoring all the backends on every lookup.
+type rendezvous struct {
+	members []string
+}
+
+func (r *rendezvous) add(backend string) {
+	for _, member := range r.members {
+		if member == backend {
+			return
+		}
+	}
+	r.members = append(r.members, backend)
+}
+
+func (r *rendezvous) remove(backend string) {
+	for idx, member := range r.members {
+		if member == backend {
+			r.members = append(r.members[:idx], r.members[idx+1:]...)
+			return
+		}
+	}
+}
+
+func (r *rendezvous) score(key, backend string) uint32 {
+	return murmur3Hash(backend + "#" + key)
+}
+
+func (r *rendezvous) get(key string) string {
+	best := ""
+	var bestScore uint32
+	for _, member := range r.members {
+		score := r.score(key, member)
+		// ties go to the smaller backend, so the order we got them in doesn't matter
+		if best == "" || score > bestScore || (score == bestScore && member < best) {
+			best, bestScore = member, score
+		}
+	}
+	return best
+}
+
+func (r *rendezvous) walk(key string, accept func(backend string) bool) string {
+	ranked := append([]string(nil), r.members...)
+	scores := make(map[string]uint32, len(ranked))
+	for _, member := range ranked {
+		scores[member] = r.score(key, member)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if scores[ranked[i]] != scores[ranked[j]] {
+			return scores[ranked[i]] > scores[ranked[j]]
+		}
+		return ranked[i] < ranked[j]
+	})
+	for _, member := range ranked {
+		if accept(member) {
+			return member
+		}
+	}
+	return ""
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var allHashes = []string{FNVHash, CRC32Hash, Murmur3Hash, RendezvousHash}
+
+func TestMurmur3Hash(t *testing.T) {
+	assert.Equal(t, uint32(0), murmur3Hash(""))
+	assert.Equal(t, uint32(0x248bfa47), murmur3Hash("hello"))
+	assert.Equal(t, uint32(0x2e4ff723), murmur3Hash("The quick brown fox jumps over the lazy dog"))
+}
+
+func TestHashesToSpreadTheClientsAcrossTheBackends(t *testing.T) {
+	for _, hash := range allHashes {
+		s := IPHashStrategy("/app", RingFallback, defaultReplicas, hash).(StickyStrategy)
+		for i := 1; i <= 10; i++ {
+			s.AddBackend(fmt.Sprintf("10.0.0.%d:31000", i))
+		}
+		shares := make(map[string]int)
+		for i := 0; i < 10000; i++ {
+			shares[s.NextFor(fmt.Sprintf("192.168.%d.%d", i/256, i%256), nil)]++
+		}
+		min, max := 10000, 0
+		for _, share := range shares {
+			if share < min {
+				min = share
+			}
+			if share > max {
+				max = share
+			}
+		}
+		t.Logf("%s - %d to %d of 10000 clients per backend", hash, min, max)
+		assert.Len(t, shares, 10, hash)
+		// within 50% of the fair share of 1000
+		assert.True(t, min > 500 && max < 1500, hash)
+	}
+}
+
+func TestHashesToMoveOnlyTheClientsOfARemovedBackend(t *testing.T) {
+	for _, hash := range allHashes {
+		s := IPHashStrategy("/app", RingFallback, defaultReplicas, hash).(StickyStrategy)
+		for i := 1; i <= 10; i++ {
+			s.AddBackend(fmt.Sprintf("10.0.0.%d:31000", i))
+		}
+		before := make(map[string]string)
+		for i := 0; i < 1000; i++ {
+			client := fmt.Sprintf("192.168.0.%d", i)
+			before[client] = s.NextFor(client, nil)
+		}
+
+		s.RemoveBackend("10.0.0.3:31000")
+		moved := 0
+		for client, backend := range before {
+			after := s.NextFor(client, nil)
+			if backend == "10.0.0.3:31000" {
+				moved++
+				assert.NotEqual(t, backend, after, hash)
+			} else {
+				assert.Equal(t, backend, after, hash)
+			}
+		}
+		t.Logf("%s - moved %d of 1000 clients", hash, moved)
+	}
+}
+
+func TestHashesToRemapABoundedShareOfTheClientsOfAFiveBackendRing(t *testing.T) {
+	for _, hash := range allHashes {
+		s := buildStrategy("/ring", IPHashName, NewFrontendConfig(map[string]string{"tlb.hash": hash})).(StickyStrategy)
+		for i := 1; i <= 5; i++ {
+			s.AddBackend(fmt.Sprintf("10.0.0.%d:31000", i))
+		}
+		before := make(map[string]string)
+		for i := 0; i < 10000; i++ {
+			client := fmt.Sprintf("192.168.%d.%d", i/256, i%256)
+			before[client] = s.NextFor(client, nil)
+		}
+
+		// the new backend takes ~1/6 of the clients, all from the others
+		s.AddBackend("10.0.0.6:31000")
+		moved := 0
+		for client, backend := range before {
+			if after := s.NextFor(client, nil); after != backend {
+				moved++
+				assert.Equal(t, "10.0.0.6:31000", after, hash)
+			}
+		}
+		assert.True(t, moved > 0 && moved < 10000/6*3/2, fmt.Sprintf("%s moved %d of 10000 clients", hash, moved))
+
+		// and gives them back when it leaves
+		s.RemoveBackend("10.0.0.6:31000")
+		for client, backend := range before {
+			assert.Equal(t, backend, s.NextFor(client, nil), hash)
+		}
+	}
+}
+
+func TestRendezvousToWalkTheBackendsInTheOrderOfTheirScore(t *testing.T) {
+	r := &rendezvous{}
+	r.add("b:1")
+	r.add("b:2")
+	r.add("b:3")
+	r.add("b:2")
+	assert.Len(t, r.members, 3)
+
+	pinned := r.get("10.0.0.1")
+	assert.Equal(t, pinned, r.walk("10.0.0.1", func(string) bool { return true }))
+	second := r.walk("10.0.0.1", func(backend string) bool { return backend != pinned })
+	assert.NotEqual(t, pinned, second)
+
+	// the fallback of a key becomes it's backend once the pinned one is gone
+	r.remove(pinned)
+	assert.Equal(t, second, r.get("10.0.0.1"))
+}
+
+func TestIPHashToPutTheBackendsOnTheRingAsManyTimesAsTheLabel(t *testing.T) {
+	s := buildStrategy("/replicas", IPHashName, NewFrontendConfig(map[string]string{"tlb.iphash.replicas": "400"})).(*IPHash)
+	assert.Equal(t, 400, s.ring.(*hashRing).replicas)
+}
+
+func TestMaglevToFallbackToFNVForRendezvous(t *testing.T) {
+	s := MaglevStrategy("/maglev", RingFallback, 101, RendezvousHash, false).(*Maglev)
+	assert.Equal(t, hashOf("10.0.0.1"), s.hash("10.0.0.1"))
+}
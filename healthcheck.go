@@ -0,0 +1,192 @@
+package main
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ashwanthkumar/gotlb/types"
+)
+
+// HealthCheckConfig is how we check that the backends of an app accept
+// connections. The defaults come from the flags, the app can override them.
+type HealthCheckConfig struct {
+	// Interval between the checks of a backend, 0 disables them
+	Interval time.Duration
+	// Timeout of the connection of a check
+	Timeout time.Duration
+	// Consecutive failed checks after which the backend is pulled from the rotation
+	UnhealthyThreshold int
+	// Consecutive passed checks after which a pulled backend is back in the rotation
+	HealthyThreshold int
+}
+
+// DefaultHealthCheck is used when GoTLB isn't given any other defaults
+var DefaultHealthCheck = HealthCheckConfig{
+	Timeout:            time.Second,
+	UnhealthyThreshold: 3,
+	HealthyThreshold:   2,
+}
+
+// newHealthCheckConfig reads the health check labels of the app, falling back to the defaults
+func newHealthCheckConfig(r *types.LabelReader, defaults HealthCheckConfig) HealthCheckConfig {
+	config := HealthCheckConfig{
+		Interval:           r.Duration(types.TLB_HEALTHCHECK_INTERVAL, defaults.Interval),
+		Timeout:            r.Duration(types.TLB_HEALTHCHECK_TIMEOUT, defaults.Timeout),
+		UnhealthyThreshold: r.AtLeast(types.TLB_HEALTHCHECK_UNHEALTHY, defaults.UnhealthyThreshold, 1),
+		HealthyThreshold:   r.AtLeast(types.TLB_HEALTHCHECK_HEALTHY, defaults.HealthyThreshold, 1),
+	}
+	if config.Timeout <= 0 {
+		r.Invalid(types.TLB_HEALTHCHECK_TIMEOUT, "should be more than 0, using %v", defaults.Timeout)
+		config.Timeout = defaults.Timeout
+	}
+	return config
+}
+
+// healthState is the streak of the checks of a backend, and if they have
+// pulled it from the rotation. It also has the streak of the failed dials to
+// the backend, which pull it for a while on their own, and if it's drained.
+// Discovery decides which backends there are and the health checks only
+// decide which of them are routed, so a backend removed by discovery is never
+// put back by a check, and one that discovery adds again starts afresh.
+type healthState struct {
+	failures  int
+	successes int
+	ejected   bool
+	// when the failed dials (and resets) of the streak happened, and if they
+	// have pulled it from the rotation
+	dialFailures []time.Time
+	outlier      bool
+	// how long it was pulled the last time, and when it was put back
+	ejection   time.Duration
+	reinstated time.Time
+	// set while the backend is drained before it's removed, and if it's for
+	// discovery removing it
+	draining bool
+	removed  bool
+	// the drains of the backend so far, to tell a drain that was called off
+	drain int
+}
+
+// pulled tells if the backend is out of the rotation for any reason
+func (s *healthState) pulled() bool {
+	return s.ejected || s.outlier || s.draining
+}
+
+// healthCheck checks the backends of the frontend every interval until it's
+// stopped. The interval is read from the config on every round so it can be
+// changed while it's running.
+func (f *Frontend) healthCheck() {
+	for {
+		config := f.Config().HealthCheck
+		select {
+		case <-time.After(config.Interval):
+		case <-f.done:
+			return
+		}
+		f.checkBackends(config)
+	}
+}
+
+// checkBackends checks all the backends of the frontend once, in parallel.
+// The ones that are still warming up are left to their warmup.
+func (f *Frontend) checkBackends(config HealthCheckConfig) {
+	f.lock.Lock()
+	checked := make(map[string]*healthState)
+	for _, node := range f.backends.Values() {
+		if _, warming := f.warming[node]; !warming {
+			checked[node] = f.health[node]
+		}
+	}
+	f.lock.Unlock()
+
+	var checks sync.WaitGroup
+	for node, state := range checked {
+		checks.Add(1)
+		go func(node string, state *healthState) {
+			defer checks.Done()
+			conn, err := net.DialTimeout("tcp", node, config.Timeout)
+			if err == nil {
+				conn.Close()
+			}
+			f.lock.Lock()
+			defer f.lock.Unlock()
+			// a backend removed while it was checked (even if it was added
+			// again since) has a new state, the result isn't of it
+			if f.health[node] == state {
+				f.recordCheckLocked(node, state, err, config)
+			}
+		}(node, state)
+	}
+	checks.Wait()
+}
+
+// recordCheck adds the result of a check to the streak of the backend, if
+// it's still a backend of the frontend
+func (f *Frontend) recordCheck(node string, err error, config HealthCheckConfig) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if state, known := f.health[node]; known {
+		f.recordCheckLocked(node, state, err, config)
+	}
+}
+
+// recordCheckLocked pulls the backend from (or puts it back into) the
+// rotation once the streak gets to the threshold. A pulled backend is still
+// a backend of the frontend, so it's still checked and comes back when it
+// recovers.
+func (f *Frontend) recordCheckLocked(node string, state *healthState, err error, config HealthCheckConfig) {
+	if err != nil {
+		state.failures++
+		state.successes = 0
+		if !state.ejected && state.failures >= config.UnhealthyThreshold {
+			log.Printf("[WARN] %s of %s failed %d health checks, pulling it from the rotation - %v\n", node, f.appId, state.failures, err)
+			metrics.Counter("frontend-healthcheck-ejections", "app", f.appId).Inc()
+			state.ejected = true
+			f.queueChange(node, nil)
+			f.reportUnhealthy()
+		}
+		return
+	}
+	state.successes++
+	state.failures = 0
+	if state.ejected && state.successes >= config.HealthyThreshold {
+		log.Printf("[INFO] %s of %s passed %d health checks, putting it back in the rotation\n", node, f.appId, state.successes)
+		state.ejected = false
+		if !state.pulled() {
+			f.queueChange(node, f.infos[node])
+		}
+		f.reportUnhealthy()
+	}
+}
+
+// reportUnhealthy updates the gauge of the backends out of the rotation
+func (f *Frontend) reportUnhealthy() {
+	unhealthy := 0
+	for _, state := range f.health {
+		if state.ejected {
+			unhealthy++
+		}
+	}
+	metrics.Gauge("frontend-unhealthy-backends", "app", f.appId).Set(float64(unhealthy))
+}
+
+// isEjected tells if the health checks have pulled the backend from the rotation
+func (f *Frontend) isEjected(node string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	state, known := f.health[node]
+	return known && state.ejected
+}
+
+// healthStates returns a copy of the health of all the backends
+func (f *Frontend) healthStates() map[string]healthState {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	states := make(map[string]healthState, len(f.health))
+	for node, state := range f.health {
+		states[node] = *state
+	}
+	return states
+}
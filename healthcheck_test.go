@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ashwanthkumar/golang-utils/sets"
+	"github.com/ashwanthkumar/gotlb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthCheckConfigToFallbackToTheDefaults(t *testing.T) {
+	defaults := HealthCheckConfig{Interval: 10 * time.Second, Timeout: time.Second, UnhealthyThreshold: 3, HealthyThreshold: 2}
+	assert.Equal(t, defaults, newHealthCheckConfig(types.NewLabelReader(map[string]string{}), defaults))
+
+	assert.Equal(t, HealthCheckConfig{Interval: time.Minute, Timeout: 5 * time.Second, UnhealthyThreshold: 10, HealthyThreshold: 1}, newHealthCheckConfig(types.NewLabelReader(map[string]string{
+		"tlb.healthcheck.interval":           "1m",
+		"tlb.healthcheck.timeout":            "5s",
+		"tlb.healthcheck.unhealthythreshold": "10",
+		"tlb.healthcheck.healthythreshold":   "1",
+	}), defaults))
+
+	// an app can turn them off, and can't set thresholds that never trip
+	assert.Equal(t, HealthCheckConfig{Timeout: time.Second, UnhealthyThreshold: 3, HealthyThreshold: 2}, newHealthCheckConfig(types.NewLabelReader(map[string]string{
+		"tlb.healthcheck.interval":           "0s",
+		"tlb.healthcheck.unhealthythreshold": "0",
+		"tlb.healthcheck.healthythreshold":   "-1",
+	}), defaults))
+}
+
+func TestManagerToApplyTheHealthCheckDefaultsToTheApps(t *testing.T) {
+	m := NewManager()
+	m.SetHealthCheckDefaults(HealthCheckConfig{Interval: 10 * time.Second, Timeout: time.Second, UnhealthyThreshold: 3, HealthyThreshold: 2})
+	assert.Equal(t, 10*time.Second, m.frontendConfig(createAppInfo(APP_ID, nil)).HealthCheck.Interval)
+
+	lenient := m.frontendConfig(createAppInfo(APP_ID, map[string]string{"tlb.healthcheck.unhealthythreshold": "10"})).HealthCheck
+	assert.Equal(t, HealthCheckConfig{Interval: 10 * time.Second, Timeout: time.Second, UnhealthyThreshold: 10, HealthyThreshold: 2}, lenient)
+}
+
+func TestFrontendToPullTheBackendsThatFailTheHealthChecksFromTheRotation(t *testing.T) {
+	live := startEchoBackend(t)
+	defer live.Close()
+	dead := startEchoBackend(t)
+	dead.Close()
+	config := HealthCheckConfig{Timeout: time.Second, UnhealthyThreshold: 2, HealthyThreshold: 2}
+	frontend := createFrontend(APP_ID, "-1", sets.FromSlice([]string{live.Addr().String(), dead.Addr().String()}))
+
+	frontend.checkBackends(config)
+	assert.False(t, frontend.isEjected(dead.Addr().String()))
+	frontend.checkBackends(config)
+	assert.True(t, frontend.isEjected(dead.Addr().String()))
+	assert.False(t, frontend.isEjected(live.Addr().String()))
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, live.Addr().String(), frontend.Lookup())
+	}
+	// it's still a backend, only out of the rotation
+	assert.Len(t, frontend.Backends(), 2)
+	assert.Equal(t, float64(1), metrics.Gauge("frontend-unhealthy-backends", "app", APP_ID).Value())
+
+	frontend.recordCheck(dead.Addr().String(), nil, config)
+	assert.True(t, frontend.isEjected(dead.Addr().String()))
+	frontend.recordCheck(dead.Addr().String(), nil, config)
+	assert.False(t, frontend.isEjected(dead.Addr().String()))
+	assert.True(t, frontend.isRouted(dead.Addr().String()))
+	assert.Equal(t, float64(0), metrics.Gauge("frontend-unhealthy-backends", "app", APP_ID).Value())
+}
+
+func TestFrontendToKeepAnUnhealthyBackendOutOfTheRotationWhenItsAddedAgain(t *testing.T) {
+	config := HealthCheckConfig{Timeout: time.Second, UnhealthyThreshold: 1, HealthyThreshold: 1}
+	frontend := createFrontend(APP_ID, "-1", sets.FromSlice([]string{"b:1", "b:2"}))
+	frontend.recordCheck("b:1", errors.New("connection refused"), config)
+
+	frontend.AddBackend(&types.BackendInfo{AppId: APP_ID, Node: "b:1"})
+	assert.False(t, frontend.isRouted("b:1"))
+
+	// checks of a backend that's gone are ignored
+	frontend.RemoveBackend("b:1")
+	assert.False(t, frontend.isEjected("b:1"))
+	frontend.recordCheck("b:1", nil, config)
+	assert.False(t, frontend.isRouted("b:1"))
+	assert.Equal(t, []string{"b:2"}, frontend.Backends())
+}
+
+func TestFrontendToIgnoreTheChecksOfABackendRemovedWhileItWasChecked(t *testing.T) {
+	config := HealthCheckConfig{Timeout: time.Second, UnhealthyThreshold: 1, HealthyThreshold: 1}
+	frontend := createFrontend(APP_ID, "-1", sets.FromSlice([]string{"b:1", "b:2"}))
+	frontend.recordCheck("b:1", errors.New("connection refused"), config)
+	checked := frontend.health["b:1"]
+
+	// discovery removes it and adds it back while a check is in flight
+	frontend.RemoveBackend("b:1")
+	frontend.AddBackend(&types.BackendInfo{AppId: APP_ID, Node: "b:1"})
+	assert.False(t, frontend.isEjected("b:1"))
+	assert.True(t, frontend.isRouted("b:1"))
+	assert.NotEqual(t, checked, frontend.health["b:1"])
+}
+
+func TestFrontendToNotRouteABackendDiscoveryRemovedWhenItsHealthy(t *testing.T) {
+	healthy := startEchoBackend(t)
+	defer healthy.Close()
+	other := startEchoBackend(t)
+	defer other.Close()
+	config := HealthCheckConfig{Timeout: time.Second, UnhealthyThreshold: 1, HealthyThreshold: 1}
+	frontend := createFrontend(APP_ID, "-1", sets.FromSlice([]string{healthy.Addr().String(), other.Addr().String()}))
+	frontend.recordCheck(healthy.Addr().String(), errors.New("connection refused"), config)
+	frontend.RemoveBackend(healthy.Addr().String())
+
+	frontend.checkBackends(config)
+	frontend.recordCheck(healthy.Addr().String(), nil, config)
+	assert.False(t, frontend.isRouted(healthy.Addr().String()))
+	assert.Equal(t, []string{other.Addr().String()}, frontend.Backends())
+	assert.Equal(t, float64(0), metrics.Gauge("frontend-unhealthy-backends", "app", APP_ID).Value())
+}
+
+func TestManagerToKeepTheUnhealthyBackendsOutWhenTheFrontendIsRecreated(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	l.Close()
+
+	m := NewManager()
+	labels := createAppLabels(port)
+	labels[types.TLB_HEALTHCHECK_INTERVAL] = "1h"
+	labels[types.TLB_HEALTHCHECK_UNHEALTHY] = "1"
+	m.CreateNewFrontendIfNotExist(createAppInfo(APP_ID, labels))
+	assert.NoError(t, m.AddBackendForApp(createBackendInfo(APP_ID, "b:1")))
+	assert.NoError(t, m.AddBackendForApp(createBackendInfo(APP_ID, "b:2")))
+	old, _ := m.getFrontend(APP_ID)
+	old.recordCheck("b:1", errors.New("connection refused"), old.Config().HealthCheck)
+	defer func() {
+		f, _ := m.getFrontend(APP_ID)
+		f.Stop()
+	}()
+
+	labels[types.TLB_STRATEGY] = IPHashName
+	m.CreateNewFrontendIfNotExist(createAppInfo(APP_ID, labels))
+	replacement, _ := m.getFrontend(APP_ID)
+	assert.True(t, replacement != old, "the frontend should have been recreated")
+	assert.True(t, replacement.isEjected("b:1"))
+	assert.False(t, replacement.isRouted("b:1"))
+	assert.True(t, replacement.isRouted("b:2"))
+
+	// with the checks turned off nothing would put it back, so it's routed again
+	delete(labels, types.TLB_HEALTHCHECK_INTERVAL)
+	m.CreateNewFrontendIfNotExist(createAppInfo(APP_ID, labels))
+	unchecked, _ := m.getFrontend(APP_ID)
+	assert.True(t, unchecked != replacement, "the frontend should have been recreated")
+	assert.True(t, unchecked.isRouted("b:1"))
+	assert.Equal(t, float64(0), metrics.Gauge("frontend-unhealthy-backends", "app", APP_ID).Value())
+}
+
+func TestFrontendToCheckItsBackendsEveryIntervalTillItsStopped(t *testing.T) {
+	backend := startEchoBackend(t)
+	node := backend.Addr().String()
+	frontend := NewFrontend("/checked-app", "-1", sets.FromSlice([]string{node}), NewFrontendConfig(map[string]string{
+		"tlb.healthcheck.interval":           "10ms",
+		"tlb.healthcheck.unhealthythreshold": "2",
+		"tlb.healthcheck.healthythreshold":   "2",
+	}))
+	go frontend.healthCheck()
+	defer frontend.Stop()
+
+	// the process wedges while it's task is still running
+	backend.Close()
+	for i := 0; !frontend.isEjected(node); i++ {
+		if i == 100 {
+			t.Fatal("the backend that stopped accepting wasn't pulled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(t, frontend.isRouted(node))
+	assert.Equal(t, []string{node}, frontend.Backends())
+
+	// and comes back on the same port
+	recovered, err := net.Listen("tcp", node)
+	if err != nil {
+		t.Skipf("cannot listen on %s again - %v", node, err)
+	}
+	defer recovered.Close()
+	for i := 0; frontend.isEjected(node); i++ {
+		if i == 100 {
+			t.Fatal("the backend that recovered wasn't put back")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, frontend.isRouted(node))
+}
+
+func TestFrontendToStopCheckingABackendDiscoveryRemoved(t *testing.T) {
+	checks := make(chan bool, 10)
+	removed := startBackend(t, func(conn net.Conn) {
+		conn.Close()
+		checks <- true
+	})
+	defer removed.Close()
+	config := HealthCheckConfig{Timeout: time.Second, UnhealthyThreshold: 1, HealthyThreshold: 1}
+	frontend := createFrontend(APP_ID, "-1", sets.FromSlice([]string{removed.Addr().String()}))
+
+	frontend.checkBackends(config)
+	select {
+	case <-checks:
+	case <-time.After(time.Second):
+		t.Fatal("the backend wasn't checked")
+	}
+
+	frontend.RemoveBackend(removed.Addr().String())
+	frontend.checkBackends(config)
+	select {
+	case <-checks:
+		t.Fatal("the removed backend was still checked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	_, known := frontend.healthStates()[removed.Addr().String()]
+	assert.False(t, known)
+}
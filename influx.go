@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InfluxReporter is a Reporter that writes the metrics to InfluxDB using the
+// line protocol. Every metric is written as it's own measurement with the
+// metric tags (app, backend) and the static tags (host) as the tags.
+type InfluxReporter struct {
+	url    string
+	token  string
+	tags   map[string]string
+	client *http.Client
+}
+
+// NewInfluxReporter creates an InfluxReporter that writes to the database on
+// the InfluxDB at influxURL. token is optional and sent as the Authorization.
+func NewInfluxReporter(influxURL, database, token string, tags map[string]string) *InfluxReporter {
+	return &InfluxReporter{
+		url:    strings.TrimRight(influxURL, "/") + "/write?precision=ns&db=" + url.QueryEscape(database),
+		token:  token,
+		tags:   tags,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (i *InfluxReporter) Name() string {
+	return "InfluxDB"
+}
+
+func (i *InfluxReporter) Report(snapshot []MetricValue) error {
+	if len(snapshot) == 0 {
+		return nil
+	}
+	body := i.lines(snapshot, time.Now())
+	request, err := http.NewRequest("POST", i.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if i.token != "" {
+		request.Header.Set("Authorization", "Token "+i.token)
+	}
+	response, err := i.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("InfluxDB responded with %d - %s", response.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// lines formats the snapshot in the line protocol - measurement,tag=value value=1 timestamp
+func (i *InfluxReporter) lines(snapshot []MetricValue, now time.Time) []byte {
+	var buf bytes.Buffer
+	timestamp := strconv.FormatInt(now.UnixNano(), 10)
+	for _, metric := range snapshot {
+		buf.WriteString(escapeInflux(metric.Name))
+		tags := make(map[string]string)
+		for key, value := range i.tags {
+			tags[key] = value
+		}
+		for key, value := range metric.Tags {
+			tags[key] = value
+		}
+		keys := make([]string, 0, len(tags))
+		for key := range tags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if tags[key] == "" {
+				continue
+			}
+			buf.WriteString("," + escapeInflux(key) + "=" + escapeInflux(tags[key]))
+		}
+		buf.WriteString(" value=" + strconv.FormatFloat(metric.Value, 'f', -1, 64))
+		buf.WriteString(" " + timestamp + "\n")
+	}
+	return buf.Bytes()
+}
+
+// ParseInfluxTags parses the comma separated key=value pairs of static tags
+func ParseInfluxTags(pairs string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(pairs, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Invalid tag %s, expected key=value", pair)
+		}
+		tags[parts[0]] = parts[1]
+	}
+	return tags, nil
+}
+
+var influxEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+func escapeInflux(value string) string {
+	return influxEscaper.Replace(value)
+}
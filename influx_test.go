@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInfluxReporterToFormatLineProtocol(t *testing.T) {
+	reporter := NewInfluxReporter("http://localhost:8086", "gotlb", "", map[string]string{"host": "lb-1"})
+	snapshot := []MetricValue{
+		{Name: "frontend-failovers", Type: Counter, Tags: map[string]string{"app": "/my app,v1"}, Value: 3},
+		{Name: "route-changes-dropped", Type: Counter, Value: 0.5},
+	}
+
+	lines := reporter.lines(snapshot, time.Unix(1, 0))
+	assert.Equal(t, "frontend-failovers,app=/my\\ app\\,v1,host=lb-1 value=3 1000000000\n"+
+		"route-changes-dropped,host=lb-1 value=0.5 1000000000\n", string(lines))
+}
+
+func TestInfluxReporterToWriteToTheDatabase(t *testing.T) {
+	var path, auth, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.String()
+		auth = r.Header.Get("Authorization")
+		contents, _ := ioutil.ReadAll(r.Body)
+		body = string(contents)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	reporter := NewInfluxReporter(server.URL, "gotlb", "secret", nil)
+	err := reporter.Report([]MetricValue{{Name: "frontend-failovers", Type: Counter, Value: 1}})
+	assert.NoError(t, err)
+	assert.Equal(t, "/write?precision=ns&db=gotlb", path)
+	assert.Equal(t, "Token secret", auth)
+	assert.Contains(t, body, "frontend-failovers value=1 ")
+}
+
+func TestInfluxReporterToReturnAnErrorWhenInfluxFails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "database not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	reporter := NewInfluxReporter(server.URL, "gotlb", "", nil)
+	err := reporter.Report([]MetricValue{{Name: "frontend-failovers", Type: Counter, Value: 1}})
+	assert.EqualError(t, err, "InfluxDB responded with 404 - database not found")
+}
+
+func TestParseInfluxTags(t *testing.T) {
+	tags, err := ParseInfluxTags("host=lb-1, dc=us-east")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"host": "lb-1", "dc": "us-east"}, tags)
+	_, err = ParseInfluxTags("host")
+	assert.Error(t, err)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// the connections over tlb.limit.connections are closed right away
+	RejectConnections = "reject"
+	// the accept loop waits for one of the open connections to close
+	QueueConnections = "queue"
+	// how often a queued connection checks for a free slot
+	queuePoll = 5 * time.Millisecond
+)
+
+// rateLimiter is a token bucket of the new connections of an app. It fills
+// up at rate tokens a second upto burst, and every connection takes one.
+type rateLimiter struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns the limiter of rate connections a second, nil when
+// they're not limited
+func newRateLimiter(rate, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: float64(rate), burst: float64(burst), tokens: float64(burst)}
+}
+
+// allow takes a token for a new connection, false when there's none left
+func (r *rateLimiter) allow(now time.Time) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.last.IsZero() {
+		r.last = now
+	}
+	if now.After(r.last) {
+		r.tokens += now.Sub(r.last).Seconds() * r.rate
+		if r.tokens > r.burst {
+			r.tokens = r.burst
+		}
+		r.last = now
+	}
+	if r.tokens < 1 {
+		return false
+	}
+	r.tokens--
+	return true
+}
+
+// admit decides if a connection that was just accepted is let in. The rate
+// is checked first, so a connection rejected by it never counts towards the
+// open connections. Both of them reject the connection by closing it right
+// after the accept, which is cheaper than dialing a backend for it. In the
+// queue mode the connection over the open connections waits for a slot
+// instead, and the accept loop with it - the ones after it wait in the
+// backlog of the listener.
+func (f *Frontend) admit(now time.Time) bool {
+	return f.admitting(now, true)
+}
+
+// admitSession decides if a new UDP session is let in, like admit. A datagram
+// can't wait in a backlog, so the one over tlb.limit.connections is dropped in
+// the queue mode as well.
+func (f *Frontend) admitSession(now time.Time) bool {
+	return f.admitting(now, false)
+}
+
+func (f *Frontend) admitting(now time.Time, canQueue bool) bool {
+	f.lock.Lock()
+	limiter, maxConnections, mode := f.limiter, f.config.MaxConnections, f.config.ConnectionsMode
+	f.lock.Unlock()
+	if limiter != nil && !limiter.allow(now) {
+		metrics.Counter("frontend-ratelimited", "app", f.appId).Inc()
+		// deprecated, still counted for the dashboards that use the old name
+		metrics.Counter("frontend-rate-limited", "app", f.appId).Inc()
+		return false
+	}
+	if maxConnections <= 0 || atomic.LoadInt64(f.open) < int64(maxConnections) {
+		return true
+	}
+	if mode != QueueConnections || !canQueue {
+		metrics.Counter("frontend-connection-limited", "app", f.appId).Inc()
+		return false
+	}
+	metrics.Counter("frontend-connection-queued", "app", f.appId).Inc()
+	return f.awaitSlot()
+}
+
+// awaitSlot waits for the open connections to go below the limit of the app,
+// returns false if the frontend is stopped first. The limit is read again on
+// every poll so the queued connections go through once it's raised, and they
+// keep their place when the labels switch to reject - that's only for the
+// connections that come after.
+func (f *Frontend) awaitSlot() bool {
+	ticker := time.NewTicker(queuePoll)
+	defer ticker.Stop()
+	for {
+		max := f.Config().MaxConnections
+		if max <= 0 || atomic.LoadInt64(f.open) < int64(max) {
+			return true
+		}
+		select {
+		case <-ticker.C:
+		case <-f.done:
+			return false
+		}
+	}
+}
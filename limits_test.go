@@ -0,0 +1,131 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiterToAllowTheBurstThenTheRate(t *testing.T) {
+	assert.Nil(t, newRateLimiter(0, 0))
+
+	limiter := newRateLimiter(10, 2)
+	now := time.Unix(1000, 0)
+	assert.True(t, limiter.allow(now))
+	assert.True(t, limiter.allow(now))
+	assert.False(t, limiter.allow(now))
+	// a token every 100ms
+	assert.False(t, limiter.allow(now.Add(50*time.Millisecond)))
+	assert.True(t, limiter.allow(now.Add(100*time.Millisecond)))
+	assert.False(t, limiter.allow(now.Add(100*time.Millisecond)))
+	// it fills up only to the burst
+	assert.True(t, limiter.allow(now.Add(time.Hour)))
+	assert.True(t, limiter.allow(now.Add(time.Hour)))
+	assert.False(t, limiter.allow(now.Add(time.Hour)))
+}
+
+func TestLimitsConfigToDefaultTheBurstToTheRate(t *testing.T) {
+	config := NewFrontendConfig(map[string]string{"tlb.limit.rate": "100"})
+	assert.Equal(t, 100, config.ConnectionBurst)
+	config = NewFrontendConfig(map[string]string{"tlb.limit.rate": "100", "tlb.limit.burst": "500"})
+	assert.Equal(t, 500, config.ConnectionBurst)
+	config = NewFrontendConfig(map[string]string{"tlb.limit.rate": "100", "tlb.limit.burst": "0"})
+	assert.Equal(t, 100, config.ConnectionBurst)
+}
+
+func TestLimitsConfigToReadTheOtherNamesOfTheRate(t *testing.T) {
+	config := NewFrontendConfig(map[string]string{"tlb.ratelimit.cps": "100", "tlb.ratelimit.burst": "500"})
+	assert.Equal(t, 100, config.ConnectionRate)
+	assert.Equal(t, 500, config.ConnectionBurst)
+	config = NewFrontendConfig(map[string]string{"tlb.limit.rate": "10", "tlb.ratelimit.cps": "100", "tlb.ratelimit.burst": "x"})
+	assert.Equal(t, 10, config.ConnectionRate)
+	assert.Equal(t, 10, config.ConnectionBurst)
+	assert.Equal(t, "tlb.ratelimit.burst", config.LabelErrors[0].Label)
+}
+
+func TestFrontendToAdmitWithinTheRateAndTheOpenConnections(t *testing.T) {
+	frontend := createFrontendWithLabels(nil, map[string]string{"tlb.limit.rate": "1", "tlb.limit.connections": "2"})
+	frontend.appId = "/limited-app"
+	rateLimited := metrics.Counter("frontend-ratelimited", "app", "/limited-app")
+	deprecated := metrics.Counter("frontend-rate-limited", "app", "/limited-app")
+	connectionLimited := metrics.Counter("frontend-connection-limited", "app", "/limited-app")
+	rateBefore, deprecatedBefore, connectionsBefore := rateLimited.Value(), deprecated.Value(), connectionLimited.Value()
+	now := time.Unix(1000, 0)
+	assert.True(t, frontend.admit(now))
+	assert.False(t, frontend.admit(now))
+	assert.Equal(t, rateBefore+1, rateLimited.Value())
+	assert.Equal(t, deprecatedBefore+1, deprecated.Value())
+
+	atomic.StoreInt64(frontend.open, 2)
+	assert.False(t, frontend.admit(now.Add(time.Second)))
+	assert.Equal(t, connectionsBefore+1, connectionLimited.Value())
+	atomic.StoreInt64(frontend.open, 1)
+	// the connection rejected for the open connections still took a token
+	assert.False(t, frontend.admit(now.Add(time.Second)))
+	assert.Equal(t, rateBefore+2, rateLimited.Value())
+}
+
+func TestFrontendToApplyTheLimitsInPlace(t *testing.T) {
+	frontend := createFrontendWithLabels(nil, nil)
+	now := time.Unix(1000, 0)
+	assert.True(t, frontend.admit(now))
+	assert.True(t, frontend.admit(now))
+
+	frontend.UpdateConfig(NewFrontendConfig(map[string]string{"tlb.limit.rate": "1"}))
+	assert.True(t, frontend.admit(now))
+	assert.False(t, frontend.admit(now))
+	assert.False(t, frontend.Config().needsRestart(NewFrontendConfig(map[string]string{"tlb.limit.connections": "10"})))
+}
+
+func TestFrontendToQueueTheConnectionsOverTheOpenConnections(t *testing.T) {
+	frontend := createFrontendWithLabels(nil, map[string]string{"tlb.limit.connections": "1", "tlb.limit.connections.mode": "queue"})
+	frontend.appId = "/queued-app"
+	queued := metrics.Counter("frontend-connection-queued", "app", "/queued-app")
+	before := queued.Value()
+	atomic.StoreInt64(frontend.open, 1)
+	admitted := make(chan bool)
+	go func() { admitted <- frontend.admit(time.Now()) }()
+	select {
+	case <-admitted:
+		t.Fatal("the connection was let in over the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	atomic.StoreInt64(frontend.open, 0)
+	assert.True(t, <-admitted)
+	assert.Equal(t, before+1, queued.Value())
+
+	// it gives up once the frontend is stopped
+	atomic.StoreInt64(frontend.open, 1)
+	go func() { admitted <- frontend.admit(time.Now()) }()
+	frontend.Stop()
+	assert.False(t, <-admitted)
+}
+
+func TestFrontendToApplyTheUpdatedModeOfTheConnectionLimit(t *testing.T) {
+	frontend := createFrontendWithLabels(nil, map[string]string{"tlb.limit.connections": "1"})
+	atomic.StoreInt64(frontend.open, 1)
+	assert.False(t, frontend.admit(time.Now()))
+
+	frontend.UpdateConfig(NewFrontendConfig(map[string]string{"tlb.limit.connections": "1", "tlb.limit.connections.mode": "queue"}))
+	admitted := make(chan bool)
+	go func() { admitted <- frontend.admit(time.Now()) }()
+	select {
+	case <-admitted:
+		t.Fatal("the connection wasn't queued")
+	case <-time.After(50 * time.Millisecond):
+	}
+	// the queued one still gets through when it's back to reject
+	frontend.UpdateConfig(NewFrontendConfig(map[string]string{"tlb.limit.connections": "1"}))
+	assert.False(t, frontend.admit(time.Now()))
+	atomic.StoreInt64(frontend.open, 0)
+	assert.True(t, <-admitted)
+
+	// and once the limit is raised
+	atomic.StoreInt64(frontend.open, 1)
+	frontend.UpdateConfig(NewFrontendConfig(map[string]string{"tlb.limit.connections": "1", "tlb.limit.connections.mode": "queue"}))
+	go func() { admitted <- frontend.admit(time.Now()) }()
+	frontend.UpdateConfig(NewFrontendConfig(map[string]string{"tlb.limit.connections": "2", "tlb.limit.connections.mode": "queue"}))
+	assert.True(t, <-admitted)
+}
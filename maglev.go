@@ -0,0 +1,192 @@
+package main
+
+import (
+	"hash/fnv"
+	"log"
+	"sync"
+
+	"github.com/ashwanthkumar/gotlb/types"
+)
+
+// defaultMaglevTableSize is the default size of the lookup table, it has to be
+// a prime and much larger than the number of backends for an even spread
+const defaultMaglevTableSize = 65537
+
+// Maglev is an implementation of Strategy that pins every client IP to a
+// backend using the lookup table from Google's Maglev. Compared to the hash
+// ring it spreads the keys more evenly across the backends (in proportion to
+// their weight), while adding or removing a backend still moves only a few
+// keys other than the ones of that backend. The table is regenerated
+// whenever the backends change.
+type Maglev struct {
+	lock      sync.Mutex
+	tableSize int
+	// hash of the keys to their position in the table
+	hash hashFunc
+	// read the weights as percentages of the traffic
+	shares bool
+	// declared weights of the backends
+	weights map[string]int
+	// index into sticky.members for every entry, -1 while there are no backends
+	table  []int
+	sticky *stickyBackends
+}
+
+// MaglevStrategy looks the keys up in the table with the hash function by it's
+// name, the table has it's own permutations so it can't be rendezvous. With
+// shares the weights are read as percentages of the traffic.
+func MaglevStrategy(appId string, fallback StickyFallback, tableSize int, hash string, shares bool) LoadBalancingStrategy {
+	if hash == RendezvousHash {
+		log.Printf("[WARN] %s uses it's own table instead of %s hashing, using %s for %s\n", MaglevName, RendezvousHash, FNVHash, appId)
+		hash = FNVHash
+	}
+	if !isPrime(tableSize) {
+		prime := nextPrime(tableSize)
+		log.Printf("[WARN] Maglev table size of %s should be a prime, using %d instead of %d\n", appId, prime, tableSize)
+		tableSize = prime
+	}
+	return &Maglev{
+		tableSize: tableSize,
+		hash:      hashFuncOf(appId, hash),
+		shares:    shares,
+		weights:   make(map[string]int),
+		sticky:    newStickyBackends(appId, fallback),
+	}
+}
+
+func (m *Maglev) AddBackendInfo(backend *types.BackendInfo) {
+	m.UpdateBackends([]*types.BackendInfo{backend}, nil)
+}
+
+func (m *Maglev) AddBackend(backend string) {
+	m.AddBackendInfo(&types.BackendInfo{Node: backend})
+}
+
+func (m *Maglev) RemoveBackend(backend string) {
+	m.UpdateBackends(nil, []string{backend})
+}
+
+// UpdateBackends applies all the changes and regenerates the table once, if anything changed
+func (m *Maglev) UpdateBackends(added []*types.BackendInfo, removed []string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	changed := false
+	for _, backend := range removed {
+		if m.sticky.remove(backend) {
+			delete(m.weights, backend)
+			changed = true
+		}
+	}
+	for _, backend := range added {
+		if m.sticky.add(backend.Node) || m.weights[backend.Node] != backend.Weight {
+			m.weights[backend.Node] = backend.Weight
+			changed = true
+		}
+	}
+	if changed {
+		m.populate()
+	}
+}
+
+func (m *Maglev) SetHealthy(backend string, healthy bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.sticky.setHealthy(backend, healthy)
+}
+
+// Next is used when we don't know the client, any available backend would do
+func (m *Maglev) Next() string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.sticky.fromSecondary(nil)
+}
+
+func (m *Maglev) NextFor(key string, exclude map[string]bool) string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if len(m.sticky.members) == 0 {
+		return ""
+	}
+	position := int(m.hash(key) % uint32(m.tableSize))
+	pinned := m.sticky.members[m.table[position]]
+	if m.sticky.available(pinned, exclude) {
+		return pinned
+	}
+	// walk the table from the position of the key, it's a permutation of the
+	// backends so the key keeps going to the same fallback
+	return m.sticky.fallbackFor(key, exclude, func(accept func(string) bool) string {
+		for i := 1; i < m.tableSize; i++ {
+			backend := m.sticky.members[m.table[(position+i)%m.tableSize]]
+			if accept(backend) {
+				return backend
+			}
+		}
+		return ""
+	})
+}
+
+// populate regenerates the lookup table. Every backend walks the table in
+// it's own permutation (from offset, in steps of skip) and claims the next
+// free entry, weight entries at a time, until the table is full.
+func (m *Maglev) populate() {
+	members := m.sticky.members
+	m.table = make([]int, m.tableSize)
+	for i := range m.table {
+		m.table[i] = -1
+	}
+	if len(members) == 0 {
+		return
+	}
+
+	size := uint64(m.tableSize)
+	offsets := make([]uint64, len(members))
+	skips := make([]uint64, len(members))
+	next := make([]uint64, len(members))
+	for i, member := range members {
+		offsets[i] = maglevHash(member, 0) % size
+		skips[i] = maglevHash(member, 1)%(size-1) + 1
+	}
+
+	weights := effectiveWeights(m.weights, m.shares)
+	filled := 0
+	for filled < m.tableSize {
+		for i, member := range members {
+			for claimed := 0; claimed < weights[member] && filled < m.tableSize; claimed++ {
+				entry := (offsets[i] + next[i]*skips[i]) % size
+				for m.table[entry] >= 0 {
+					next[i]++
+					entry = (offsets[i] + next[i]*skips[i]) % size
+				}
+				m.table[entry] = i
+				next[i]++
+				filled++
+			}
+		}
+	}
+}
+
+func maglevHash(backend string, seed byte) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte{seed})
+	h.Write([]byte(backend))
+	return h.Sum64()
+}
+
+func isPrime(n int) bool {
+	if n < 2 {
+		return false
+	}
+	for i := 2; i*i <= n; i++ {
+		if n%i == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func nextPrime(n int) int {
+	for !isPrime(n) {
+		n++
+	}
+	return n
+}
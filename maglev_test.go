@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ashwanthkumar/gotlb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaglevStrategyToSpreadTheKeysEvenly(t *testing.T) {
+	s := MaglevStrategy("/maglev", RingFallback, defaultMaglevTableSize, FNVHash, false).(*Maglev)
+	for i := 0; i < 10; i++ {
+		s.AddBackend(fmt.Sprintf("b:%d", i))
+	}
+	for backend, share := range tableShares(s) {
+		assert.InDelta(t, 0.1, share, 0.01, backend)
+	}
+}
+
+func TestMaglevStrategyToSpreadTheKeysAsPerTheWeights(t *testing.T) {
+	s := MaglevStrategy("/maglev", RingFallback, defaultMaglevTableSize, FNVHash, false).(*Maglev)
+	s.AddBackendInfo(&types.BackendInfo{Node: "small", Weight: 1})
+	s.AddBackendInfo(&types.BackendInfo{Node: "large", Weight: 3})
+	shares := tableShares(s)
+	assert.InDelta(t, 0.25, shares["small"], 0.01)
+	assert.InDelta(t, 0.75, shares["large"], 0.01)
+}
+
+func TestMaglevStrategyToMoveFewKeysWhenABackendIsRemoved(t *testing.T) {
+	s := MaglevStrategy("/maglev", RingFallback, defaultMaglevTableSize, FNVHash, false).(*Maglev)
+	for i := 0; i < 10; i++ {
+		s.AddBackend(fmt.Sprintf("b:%d", i))
+	}
+	before := make(map[string]string)
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+		before[key] = s.NextFor(key, nil)
+	}
+
+	s.RemoveBackend("b:3")
+	moved := 0
+	for key, backend := range before {
+		after := s.NextFor(key, nil)
+		assert.NotEqual(t, "b:3", after)
+		if backend != "b:3" && backend != after {
+			moved++
+		}
+	}
+	// only the keys of b:3 should have to move, allow a little more
+	assert.True(t, float64(moved)/float64(len(before)) < 0.03, "moved %d keys of other backends", moved)
+}
+
+func TestMaglevStrategyFallbacksWhenPinnedBackendIsUnhealthy(t *testing.T) {
+	s := MaglevStrategy("/maglev", RingFallback, 101, FNVHash, false).(*Maglev)
+	s.AddBackend("a")
+	s.AddBackend("b")
+	s.AddBackend("c")
+	pinned := s.NextFor("10.0.0.1", nil)
+	s.SetHealthy(pinned, false)
+	fallback := s.NextFor("10.0.0.1", nil)
+	assert.NotEqual(t, pinned, fallback)
+	assert.Equal(t, fallback, s.NextFor("10.0.0.1", nil))
+
+	s.SetHealthy(pinned, true)
+	assert.Equal(t, pinned, s.NextFor("10.0.0.1", nil))
+}
+
+func TestMaglevStrategyToUseAPrimeTableSize(t *testing.T) {
+	s := MaglevStrategy("/maglev", RingFallback, 100, FNVHash, false).(*Maglev)
+	assert.Equal(t, 101, s.tableSize)
+	assert.Equal(t, "", s.NextFor("10.0.0.1", nil))
+}
+
+func tableShares(s *Maglev) map[string]float64 {
+	shares := make(map[string]float64)
+	for _, idx := range s.table {
+		shares[s.sticky.members[idx]] += 1 / float64(len(s.table))
+	}
+	return shares
+}
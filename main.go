@@ -1,19 +1,177 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
+	"flag"
 	"log"
+	"net/url"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/ashwanthkumar/gotlb/providers"
 )
 
+var adminAddr = flag.String("admin", "", "Address to serve the admin API on, eg - :8081. Disabled when empty")
+var grpcAddr = flag.String("grpc", "", "Address to serve the gRPC API on, eg - :8082. Disabled when empty")
+var reportInterval = flag.Duration("report-interval", 10*time.Second, "Interval at which we report the metrics to the reporters")
+var influxURL = flag.String("influx-url", "", "URL of the InfluxDB to report the metrics to, eg - http://influx:8086. Disabled when empty")
+var influxDB = flag.String("influx-db", "gotlb", "InfluxDB database (or bucket) to write the metrics to")
+var influxToken = flag.String("influx-token", "", "Token to authenticate with InfluxDB")
+var influxTags = flag.String("influx-tags", "", "Comma separated key=value pairs of static tags added to every metric, host is added by default")
+var portConflicts = flag.String("port-conflicts", string(FirstWins), "How to resolve apps claiming the same port - first-wins, provider-priority or reject-both")
+var marathonRequeryTasks = flag.Bool("marathon-requery-tasks", true, "Look up the running tasks whose status update doesn't have their address yet, instead of skipping them")
+var marathonDrainDeployments = flag.Bool("marathon-drain-deployments", false, "Pull the old tasks of an app that's being deployed from the rotation as the new ones come up, before marathon kills them")
+var marathonScanTimeout = flag.Duration("marathon-scan-timeout", time.Minute, "Max time we wait for all the apps from marathon on startup before going ahead with the events, 0 waits forever")
+var marathonEventsTimeout = flag.Duration("marathon-events-timeout", 0, "Listen to the events of marathon again when they've been quiet for this long, and scan the apps for what we missed. 0 waits forever")
+var marathonResyncInterval = flag.Duration("marathon-resync-interval", 0, "Interval at which all the apps are scanned again, to catch up with the events we missed. 0 scans them only on startup")
+var marathonDCOSToken = flag.String("marathon-dcos-token", "", "DC/OS token sent to marathon as it's Authorization header")
+var marathonDCOSTokenFile = flag.String("marathon-dcos-token-file", "", "File with the DC/OS token sent to marathon, read again on every request so it can be refreshed")
+var marathonCAFile = flag.String("marathon-ca-file", "", "PEM bundle of the CAs that sign the certificate of an https marathon, instead of the system's")
+var marathonInsecureSkipVerify = flag.Bool("marathon-insecure-skip-verify", false, "Don't verify the certificate of an https marathon, only for a dev setup")
+var staticWatchInterval = flag.Duration("static-watch-interval", 5*time.Second, "Interval at which the file:// providers read their file again to pick up the changes, 0 reads it only on startup")
+var srvPollInterval = flag.Duration("srv-poll-interval", 10*time.Second, "Interval at which the srv:// providers look up their record again to pick up the changes, 0 looks it up only on startup")
+var providerLogInterval = flag.Duration("provider-log-interval", 30*time.Second, "Interval at which the providers log a summary of the backends and apps they added / removed, 0 logs every one of them")
+var healthCheckInterval = flag.Duration("healthcheck-interval", 0, "Interval of the health checks of the backends of the apps that don't set tlb.healthcheck.interval, 0 disables them")
+var healthCheckTimeout = flag.Duration("healthcheck-timeout", DefaultHealthCheck.Timeout, "Timeout of the connection of a health check")
+var healthCheckUnhealthy = flag.Int("healthcheck-unhealthy-threshold", DefaultHealthCheck.UnhealthyThreshold, "Consecutive failed health checks after which a backend is pulled from the rotation")
+var healthCheckHealthy = flag.Int("healthcheck-healthy-threshold", DefaultHealthCheck.HealthyThreshold, "Consecutive passed health checks after which a pulled backend is back in the rotation")
+var roundRobinRandomStart = flag.Bool("roundrobin-random-start", true, "Start the round robin of every frontend at a random backend, turn it off for a deterministic order")
+var shutdownGrace = flag.Duration("shutdown-grace", 30*time.Second, "Max time we wait for the connections in flight to finish on SIGINT / SIGTERM, after we stop accepting new ones. The ones still open after it are closed")
+var zone = flag.String("zone", "", "Zone GoTLB is running in, used by apps that prefer backends in the local zone")
+var zoneCidrs = flag.String("zone-cidrs", "", "Comma separated zone=cidr pairs used to find the zone of a backend, eg - us-east-1a=10.0.1.0/24")
+
 func main() {
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.LUTC | log.Lshortfile)
 	log.SetOutput(os.Stdout)
+	flag.Parse()
 
 	log.Println("Starting gotlb ...")
-	marathonHost := os.Args[1]
 
-	provider := providers.NewMarathonProvider(marathonHost)
-	NewManager().Start(provider)
+	zones, err := ParseZones(*zone, *zoneCidrs)
+	if err != nil {
+		log.Fatalf("Invalid zone configuration - %v\n", err)
+	}
+	policy, err := ParsePortConflictPolicy(*portConflicts)
+	if err != nil {
+		log.Fatalf("Invalid -port-conflicts - %v\n", err)
+	}
+	if *healthCheckTimeout <= 0 || *healthCheckUnhealthy < 1 || *healthCheckHealthy < 1 {
+		log.Fatalln("Invalid health checks - -healthcheck-timeout should be positive and the thresholds at least 1")
+	}
+	RoundRobinRandomStart = *roundRobinRandomStart
+	manager := NewManager()
+	manager.SetZones(zones)
+	manager.SetHealthCheckDefaults(HealthCheckConfig{
+		Interval:           *healthCheckInterval,
+		Timeout:            *healthCheckTimeout,
+		UnhealthyThreshold: *healthCheckUnhealthy,
+		HealthyThreshold:   *healthCheckHealthy,
+	})
+	manager.SetPortConflictPolicy(policy)
+	manager.SetShutdownGrace(*shutdownGrace)
+	if *adminAddr != "" {
+		go func() {
+			err := NewAdminServer(*adminAddr, manager, metrics, connections).Start()
+			log.Printf("[ERR] Admin API stopped - %v\n", err)
+		}()
+	}
+
+	if *grpcAddr != "" {
+		go func() {
+			err := startGRPCServer(*grpcAddr, manager)
+			log.Printf("[ERR] gRPC API stopped - %v\n", err)
+		}()
+	}
+
+	var reporters []Reporter
+	if *influxURL != "" {
+		tags, err := ParseInfluxTags(*influxTags)
+		if err != nil {
+			log.Fatalf("Invalid -influx-tags - %v\n", err)
+		}
+		if _, present := tags["host"]; !present {
+			tags["host"], _ = os.Hostname()
+		}
+		reporters = append(reporters, NewInfluxReporter(*influxURL, *influxDB, *influxToken, tags))
+	}
+	if len(reporters) > 0 {
+		go StartReporters(metrics, *reportInterval, make(chan bool), reporters...)
+	}
+
+	var dcosToken providers.TokenSource
+	if *marathonDCOSTokenFile != "" {
+		dcosToken = providers.FileToken(*marathonDCOSTokenFile)
+	} else if *marathonDCOSToken != "" {
+		dcosToken = providers.StaticToken(*marathonDCOSToken)
+	}
+	var marathonTLS *tls.Config
+	if *marathonCAFile != "" || *marathonInsecureSkipVerify {
+		var err error
+		if marathonTLS, err = providers.NewMarathonTLSConfig(*marathonCAFile, *marathonInsecureSkipVerify); err != nil {
+			log.Fatalf("Invalid -marathon-ca-file - %v\n", err)
+		}
+		if *marathonInsecureSkipVerify {
+			log.Println("[WARN] Not verifying the certificate of marathon, -marathon-insecure-skip-verify is only meant for a dev setup")
+		}
+	}
+	// every marathon host (or file, or SRV record) is a provider, in the order of their priority
+	var providerList []providers.Provider
+	for _, marathonHost := range flag.Args() {
+		if strings.HasPrefix(marathonHost, "file://") {
+			providerList = append(providerList, providers.NewStaticProvider(strings.TrimPrefix(marathonHost, "file://"), *staticWatchInterval, *providerLogInterval))
+			continue
+		}
+		if strings.HasPrefix(marathonHost, "srv://") {
+			record, labels, err := parseSRVArg(marathonHost)
+			if err != nil {
+				log.Fatalf("Invalid %s - %v\n", marathonHost, err)
+			}
+			providerList = append(providerList, providers.NewSRVProvider(record, labels, *srvPollInterval, *providerLogInterval))
+			continue
+		}
+		providerList = append(providerList, providers.NewMarathonProvider(marathonHost, *marathonRequeryTasks, *marathonDrainDeployments, *providerLogInterval, *marathonScanTimeout, *marathonEventsTimeout, *marathonResyncInterval, dcosToken, marathonTLS))
+	}
+	if len(providerList) == 0 {
+		log.Fatalln("Usage: gotlb [flags] marathon-host|file://path|srv://record?labels [...]")
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGHUP {
+				log.Println("[INFO] Received SIGHUP, reopening the access logs")
+				accessLogs.reopen()
+				continue
+			}
+			log.Printf("[INFO] Received %v, shutting down\n", sig)
+			manager.Stop()
+			return
+		}
+	}()
+	manager.Start(providerList...)
+}
+
+// parseSRVArg splits srv://_redis._tcp.service.consul?tlb.port=6379 into the
+// record and the labels of it's app
+func parseSRVArg(arg string) (string, map[string]string, error) {
+	record := strings.TrimPrefix(arg, "srv://")
+	labels := make(map[string]string)
+	if idx := strings.Index(record, "?"); idx >= 0 {
+		query, err := url.ParseQuery(record[idx+1:])
+		if err != nil {
+			return "", nil, err
+		}
+		for label := range query {
+			labels[label] = query.Get(label)
+		}
+		record = record[:idx]
+	}
+	if record == "" {
+		return "", nil, errors.New("the SRV record is missing")
+	}
+	return record, labels, nil
 }
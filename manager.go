@@ -1,9 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
 
 	"github.com/ashwanthkumar/golang-utils/maps"
 	"github.com/ashwanthkumar/golang-utils/sets"
@@ -18,26 +27,100 @@ import (
 type Manager struct {
 	frontends map[string]*Frontend
 	lock      sync.Mutex
+	zones     *Zones
+	watchers  *routeWatchers
+	// health checks of the apps that don't set their own
+	healthChecks HealthCheckConfig
+
+	// apps claiming a port in the order of their claim
+	claims         map[string][]*types.AppInfo
+	conflictPolicy PortConflictPolicy
+	// priority of the providers by their name, lower is higher
+	priorities map[string]int
+
+	// closed to stop the manager
+	stop     chan bool
+	stopOnce sync.Once
+	// Sync waits on the loop and the forwarders of the apps through these
+	syncs    chan chan bool
+	barriers []chan chan bool
+
+	// how long Start waits on the connections in flight once it's stopped
+	shutdownGrace time.Duration
+
+	// addresses of this host, to find the backends that loop back to us
+	localAddrs func() ([]net.Addr, error)
 }
 
 // NewManager returns a new Manager instance which we can Start()
 func NewManager() *Manager {
 	return &Manager{
 		frontends: make(map[string]*Frontend),
+		zones:     &Zones{},
+		watchers:  newRouteWatchers(),
+
+		healthChecks: DefaultHealthCheck,
+
+		claims:         make(map[string][]*types.AppInfo),
+		conflictPolicy: FirstWins,
+		priorities:     make(map[string]int),
+
+		stop:       make(chan bool),
+		syncs:      make(chan chan bool),
+		localAddrs: net.InterfaceAddrs,
 	}
 }
 
-// Start starts the manager with the given provider
-func (m *Manager) Start(provider providers.Provider) {
+// SetZones configures the local zone of GoTLB and the zones of the backends
+func (m *Manager) SetZones(zones *Zones) {
+	m.zones = zones
+}
+
+// SetHealthCheckDefaults configures the health checks of the apps that don't override them
+func (m *Manager) SetHealthCheckDefaults(defaults HealthCheckConfig) {
+	m.healthChecks = defaults
+}
+
+// Start starts the manager with the given providers. The order of the
+// providers is their priority, used when apps from them claim the same port.
+// It returns once the manager is stopped, the providers are done and all the
+// frontends are stopped, and the connections in flight on them are done or
+// the shutdown grace is over.
+func (m *Manager) Start(providerList ...providers.Provider) {
 	addBackend := make(chan *types.BackendInfo)
 	removeBackend := make(chan *types.BackendInfo)
 	newApp := make(chan *types.AppInfo)
 	destroyApp := make(chan *types.AppInfo)
-	stopProvider := make(chan bool)
+	var providersDone, forwardersDone sync.WaitGroup
+	// the providers that are yet to send their initial scan, then we log the summary
+	scanning := 0
+	scanned := make(chan bool)
 
-	err := provider.Provide(addBackend, removeBackend, newApp, destroyApp, stopProvider)
-	if err != nil {
-		log.Fatalf("Unable to start the provider - %v\n", err)
+	for priority, provider := range providerList {
+		m.priorities[provider.Name()] = priority
+		providerNewApp := make(chan *types.AppInfo)
+		providerDestroyApp := make(chan *types.AppInfo)
+		providersDone.Add(1)
+		err := provider.Provide(addBackend, removeBackend, providerNewApp, providerDestroyApp, m.stop, &providersDone)
+		if err != nil {
+			// the other providers keep going without it
+			log.Printf("[ERR] Unable to start the provider %s, skipping it - %v\n", provider.Name(), err)
+			metrics.Counter("provider-start-failures", "provider", provider.Name()).Inc()
+			providersDone.Done()
+			continue
+		}
+		var providerScanned <-chan bool
+		if scanner, ok := provider.(providers.Scanner); ok {
+			providerScanned = scanner.Scanned()
+			scanning++
+		}
+		newAppBarrier, destroyAppBarrier := make(chan chan bool), make(chan chan bool)
+		m.lock.Lock()
+		m.barriers = append(m.barriers, newAppBarrier, destroyAppBarrier)
+		m.lock.Unlock()
+		forwardersDone.Add(2)
+		go m.tagApps(provider.Name(), providerNewApp, newApp, providerScanned, scanned, newAppBarrier, &forwardersDone)
+		go m.tagApps(provider.Name(), providerDestroyApp, destroyApp, nil, nil, destroyAppBarrier, &forwardersDone)
 	}
 
 	running := true
@@ -46,19 +129,184 @@ func (m *Manager) Start(provider providers.Provider) {
 		case newBackend := <-addBackend:
 			err := m.AddBackendForApp(newBackend)
 			if err != nil {
-				log.Printf("[WARN] %v\n", err)
+				// the errors have their level
+				log.Printf("%v\n", err)
 			}
 		case existingBackend := <-removeBackend:
 			err := m.RemoveBackendForApp(existingBackend)
 			if err != nil {
-				log.Printf("[WARN] %v\n", err)
+				log.Printf("%v\n", err)
 			}
 		case app := <-newApp:
 			m.CreateNewFrontendIfNotExist(app)
 		case app := <-destroyApp:
 			m.RemoveFrontend(app)
+		case <-scanned:
+			scanning--
+			if scanning == 0 {
+				m.logSummary()
+			}
+		case synced := <-m.syncs:
+			synced <- true
+		case <-m.stop:
+			running = false
+		}
+	}
+
+	// nothing is sent to the channels once the providers are done, so we
+	// can stop the frontends without racing with the providers
+	providersDone.Wait()
+	forwardersDone.Wait()
+	frontends := m.stopAllFrontends()
+	m.lock.Lock()
+	grace := m.shutdownGrace
+	m.lock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	var shutdown sync.WaitGroup
+	for _, frontend := range frontends {
+		shutdown.Add(1)
+		go func(frontend *Frontend) {
+			defer shutdown.Done()
+			frontend.Shutdown(ctx)
+		}(frontend)
+	}
+	shutdown.Wait()
+	log.Println("[INFO] Manager stopped")
+}
+
+// Sync waits until the manager has handled everything the providers sent it
+// before the call, and applies the changes to the backends the frontends are
+// still coalescing. It's meant for the tests, which can then look at the
+// frontends without sleeping for the events to get through. Returns false
+// if the manager is stopped.
+func (m *Manager) Sync() bool {
+	m.lock.Lock()
+	barriers := m.barriers
+	m.lock.Unlock()
+	// an app the forwarder got from a provider is with the loop once it's past the barrier
+	for _, barrier := range barriers {
+		if !m.await(barrier) {
+			return false
+		}
+	}
+	// the loop handles the events one by one, so everything before this is handled
+	if !m.await(m.syncs) {
+		return false
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, frontend := range m.frontends {
+		frontend.lock.Lock()
+		frontend.stopFlusher()
+		frontend.applyChanges()
+		frontend.lock.Unlock()
+	}
+	return true
+}
+
+// await sends a sync request and waits for it to be answered
+func (m *Manager) await(requests chan<- chan bool) bool {
+	synced := make(chan bool, 1)
+	select {
+	case requests <- synced:
+	case <-m.stop:
+		return false
+	}
+	select {
+	case <-synced:
+		return true
+	case <-m.stop:
+		return false
+	}
+}
+
+// Stop stops the providers and then all the frontends, Start returns once it's done
+func (m *Manager) Stop() {
+	m.stopOnce.Do(func() { close(m.stop) })
+}
+
+// stopAllFrontends stops all the frontends and returns them, so we can wait
+// on their connections
+func (m *Manager) stopAllFrontends() []*Frontend {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	frontends := make([]*Frontend, 0, len(m.frontends))
+	for appId, frontend := range m.frontends {
+		frontends = append(frontends, frontend)
+		m.stopFrontend(appId)
+	}
+	return frontends
+}
+
+// awaitConnections waits until the ctx is done for the open connections to
+// get to 0, returns false if some of them are still open
+func awaitConnections(ctx context.Context, open []*int64) bool {
+	remaining := func() int64 {
+		var total int64
+		for _, count := range open {
+			total += atomic.LoadInt64(count)
+		}
+		return total
+	}
+	if remaining() == 0 {
+		return true
+	}
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for remaining() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return false
 		}
 	}
+	return true
+}
+
+// tagApps forwards the apps with the name of their provider. The end of the
+// provider's initial scan goes through here too, so it gets to the manager
+// after the apps of the scan. A sync request on the barrier is answered once
+// the apps before it are with the manager.
+func (m *Manager) tagApps(provider string, from <-chan *types.AppInfo, to chan<- *types.AppInfo, scanned <-chan bool, synced chan<- bool, barrier <-chan chan bool, done *sync.WaitGroup) {
+	defer done.Done()
+	for {
+		select {
+		case app := <-from:
+			app.Provider = provider
+			select {
+			case to <- app:
+			case <-m.stop:
+				return
+			}
+		case <-scanned:
+			scanned = nil
+			select {
+			case synced <- true:
+			case <-m.stop:
+				return
+			}
+		case request := <-barrier:
+			request <- true
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// SetPortConflictPolicy configures how we pick the app that gets a port claimed by many apps
+func (m *Manager) SetPortConflictPolicy(policy PortConflictPolicy) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.conflictPolicy = policy
+}
+
+// SetShutdownGrace configures how long the manager waits on the connections in flight once it's stopped
+func (m *Manager) SetShutdownGrace(grace time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.shutdownGrace = grace
 }
 
 // RemoveFrontend  removes the specific frontend associated with the app
@@ -66,10 +314,11 @@ func (m *Manager) Start(provider providers.Provider) {
 func (m *Manager) RemoveFrontend(app *types.AppInfo) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
-	frontend, present := m.frontends[app.AppId]
-	if present {
-		frontend.Stop()
-		delete(m.frontends, app.AppId)
+	port, claimed := m.releasePort(app.AppId)
+	m.stopFrontend(app.AppId)
+	if claimed {
+		// someone else waiting on the port might get it now
+		m.resolvePort(port)
 	}
 }
 
@@ -81,37 +330,406 @@ func (m *Manager) CreateNewFrontendIfNotExist(app *types.AppInfo) {
 
 	frontend, _ := m.frontends[app.AppId]
 	if frontend == nil && maps.Contains(app.Labels, types.TLB_PORT) {
+		r := types.NewLabelReader(app.Labels)
+		if r.IntBetween(types.TLB_PORT, -1, 0, 65535); len(r.Errors) > 0 {
+			log.Printf("[ERR] Not creating the frontend of %s - %v\n", app.AppId, r.Errors[0])
+			return
+		}
 		port := maps.GetString(app.Labels, types.TLB_PORT, "-1")
-		frontend = NewFrontend(app.AppId, port, sets.Empty())
-		go frontend.Start() // start the frontend
-		m.frontends[app.AppId] = frontend
+		m.claimPort(port, app)
+	} else if frontend != nil {
+		// the app was updated
+		config := m.frontendConfig(app)
+		if port := maps.GetString(app.Labels, types.TLB_PORT, frontend.port); port != frontend.port {
+			log.Printf("[WARN] %s of %s changed from %s to %s, the app needs to be destroyed and created again for it\n", types.TLB_PORT, app.AppId, frontend.port, port)
+		}
+		if frontend.Config().needsRestart(config) {
+			m.recreateFrontend(app, frontend, config)
+		} else {
+			frontend.UpdateConfig(config)
+		}
 	} else {
-		log.Println("[WARN] Either frontend exist else tlb.port does not exist")
+		log.Printf("[WARN] %s does not exist for %s\n", types.TLB_PORT, app.AppId)
+	}
+}
+
+// Conflicts returns all the ports that are claimed by more than one app
+func (m *Manager) Conflicts() []PortConflict {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	conflicts := []PortConflict{}
+	for port, claims := range m.claims {
+		if len(claims) < 2 {
+			continue
+		}
+		conflict := PortConflict{Port: port, Policy: m.conflictPolicy}
+		for _, claim := range claims {
+			conflict.Apps = append(conflict.Apps, ConflictingApp{AppId: claim.AppId, Provider: claim.Provider})
+			if _, running := m.frontends[claim.AppId]; running {
+				conflict.Serving = claim.AppId
+			}
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Port < conflicts[j].Port })
+	return conflicts
+}
+
+// claimPort records that the app wants to be served on the port and
+// starts it's frontend if it gets the port
+func (m *Manager) claimPort(port string, app *types.AppInfo) {
+	for idx, claim := range m.claims[port] {
+		if claim.AppId == app.AppId {
+			// already waiting on the port, it's started with the latest labels
+			// if it gets it
+			m.claims[port][idx] = app
+			return
+		}
+	}
+	m.claims[port] = append(m.claims[port], app)
+	if len(m.claims[port]) > 1 {
+		log.Printf("[WARN] Port %s is claimed by more than one app - %v, resolving it with %s\n", port, appIds(m.claims[port]), m.conflictPolicy)
 	}
+	m.resolvePort(port)
+}
+
+// releasePort removes the claim of the app on it's port, if any
+func (m *Manager) releasePort(appId string) (string, bool) {
+	for port, claims := range m.claims {
+		for idx, claim := range claims {
+			if claim.AppId == appId {
+				m.claims[port] = append(claims[:idx], claims[idx+1:]...)
+				if len(m.claims[port]) == 0 {
+					delete(m.claims, port)
+				}
+				return port, true
+			}
+		}
+	}
+	return "", false
+}
+
+// resolvePort makes sure only the app that wins the port as per the
+// conflict policy has a running frontend on it
+func (m *Manager) resolvePort(port string) {
+	claims := m.claims[port]
+	winner := m.conflictPolicy.winner(claims, m.priorities)
+	for _, claim := range claims {
+		if winner == nil || claim.AppId != winner.AppId {
+			if _, running := m.frontends[claim.AppId]; running {
+				log.Printf("[WARN] %s lost the port %s due to a conflict\n", claim.AppId, port)
+			}
+			m.stopFrontend(claim.AppId)
+			metrics.Gauge("frontend-bound", "app", claim.AppId).Set(0)
+		}
+	}
+	if winner != nil {
+		if _, running := m.frontends[winner.AppId]; !running {
+			m.startFrontend(port, winner)
+		}
+	}
+	conflicted := 0.0
+	if len(claims) > 1 {
+		conflicted = 1
+	}
+	metrics.Gauge("port-conflicts", "port", port).Set(conflicted)
+}
+
+func (m *Manager) frontendConfig(app *types.AppInfo) *FrontendConfig {
+	config := newFrontendConfig(app.Labels, m.healthChecks)
+	for _, err := range config.LabelErrors {
+		log.Printf("[WARN] Invalid label of %s - %v\n", app.AppId, err)
+	}
+	config.Zone = m.zones.Local
+	if config.PreferLocalZone && config.Zone == "" {
+		log.Printf("[WARN] %s wants to prefer the local zone but GoTLB was started without -zone\n", app.AppId)
+	}
+	return config
+}
+
+func (m *Manager) startFrontend(port string, app *types.AppInfo) {
+	frontend := NewFrontend(app.AppId, port, sets.Empty(), m.frontendConfig(app))
+	// until it's listening
+	metrics.Gauge("frontend-bound", "app", app.AppId).Set(0)
+	go m.serve(frontend)
+	m.frontends[app.AppId] = frontend
+	m.watchers.notify(RouteChange{Type: FrontendAdded, AppId: app.AppId, Port: port})
+}
+
+// recreateFrontend replaces the frontend of the app with one built from the
+// updated config, keeping its backends. Where the port can be shared the new
+// frontend is listening before the old one is stopped, so the port never
+// refuses connections in between. The connections in flight on the old
+// frontend are not affected.
+func (m *Manager) recreateFrontend(app *types.AppInfo, old *Frontend, config *FrontendConfig) {
+	log.Printf("[INFO] Recreating the frontend of %s for the updated labels\n", app.AppId)
+	replacement := NewFrontend(app.AppId, old.port, sets.Empty(), config)
+	// the connections in flight on the old one count towards the limit of the new one
+	replacement.open = old.open
+	replacement.lock.Lock()
+	states := old.healthStates()
+	if config.HealthCheck.Interval > 0 {
+		// the unhealthy backends stay out of the rotation, unless the checks are off now
+		for node, state := range states {
+			if state.draining {
+				continue
+			}
+			state := state
+			// the ejection of the outliers is only on the old one
+			state.outlier, state.dialFailures, state.ejection = false, nil, 0
+			replacement.health[node] = &state
+		}
+	}
+	for _, backend := range old.backendInfos() {
+		if states[backend.Node].draining {
+			// the drain on the old one removes it, the new one doesn't have
+			// any connections to it to wait on
+			continue
+		}
+		// the ones that are routed already are warm
+		replacement.addBackend(backend, config.Warmup > 0 && !old.isRouted(backend.Node))
+	}
+	replacement.reportUnhealthy()
+	replacement.lock.Unlock()
+	if override := old.Override(); override != nil {
+		replacement.Pin(override.Backend, time.Until(override.Expires))
+	}
+	if reusePortSupported {
+		if err := replacement.Listen(); err != nil {
+			log.Printf("[WARN] Unable to listen for the new frontend of %s, keeping the old one - %v\n", app.AppId, err)
+			replacement.Stop()
+			old.UpdateConfig(config)
+			return
+		}
+		go m.serve(replacement)
+		old.Stop()
+		// both of them are of the app, the old one just reset the gauge
+		replacement.reportBound()
+	} else {
+		old.Stop()
+		go m.serve(replacement)
+	}
+	m.frontends[app.AppId] = replacement
+}
+
+// serve starts the frontend, a frontend that fails to is left not bound
+// without taking down the others
+func (m *Manager) serve(frontend *Frontend) {
+	if err := frontend.Start(); err != nil {
+		log.Printf("[ERR] Frontend of %s on %s stopped - %v\n", frontend.appId, frontend.port, err)
+		metrics.Counter("frontend-start-failures", "app", frontend.appId).Inc()
+	}
+}
+
+func (m *Manager) stopFrontend(appId string) {
+	frontend, present := m.frontends[appId]
+	if present {
+		frontend.Stop()
+		delete(m.frontends, appId)
+		accessLogs.release(appId)
+		m.watchers.notify(RouteChange{Type: FrontendRemoved, AppId: appId, Port: frontend.port})
+	}
+}
+
+func appIds(apps []*types.AppInfo) []string {
+	ids := make([]string, 0, len(apps))
+	for _, app := range apps {
+		ids = append(ids, app.AppId)
+	}
+	return ids
 }
 
 // AddBackendForApp adds the backend to the list of existing backends for the app
 func (m *Manager) AddBackendForApp(backend *types.BackendInfo) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 	frontend, present := m.frontends[backend.AppId]
 	if present {
-		frontend.AddBackend(backend.Node)
+		if appId, loops := m.loopsBack(backend.Node); loops {
+			metrics.Counter("frontend-loopback-rejected", "app", backend.AppId).Inc()
+			return fmt.Errorf("[ERR] Refusing %s as a backend of %s, it's the frontend of %s on this GoTLB and would proxy back to itself", backend.Node, backend.AppId, appId)
+		}
+		fromMetadata(backend)
+		if backend.Zone == "" {
+			backend.Zone = m.zones.ZoneOf(backend.Node)
+		}
+		frontend.AddBackend(backend)
+		m.watchers.notify(RouteChange{Type: BackendAdded, AppId: backend.AppId, Port: frontend.port, Backend: backend.Node})
 		return nil
 	} else {
 		return fmt.Errorf("[WARN] Frontend for %s not found. Oops!", backend.AppId)
 	}
 }
 
-// RemoveBackendForApp removes a specific backend for the app
+// fromMetadata fills the zone and the weight the provider gave only in the
+// metadata of the backend, so the strategies work the same for every provider
+func fromMetadata(backend *types.BackendInfo) {
+	if zone, present := backend.Metadata[types.MetaZone]; present && backend.Zone == "" {
+		backend.Zone = zone
+	}
+	if weight, present := backend.Metadata[types.MetaWeight]; present && backend.Weight == 0 {
+		if parsed, err := strconv.Atoi(weight); err == nil && parsed > 0 {
+			backend.Weight = parsed
+		} else {
+			log.Printf("[WARN] Invalid %s %s of %s for %s, ignoring it\n", types.MetaWeight, weight, backend.Node, backend.AppId)
+		}
+	}
+}
+
+// RemoveBackendForApp removes a specific backend for the app, after draining
+// it for tlb.drain.timeout when the app has one
 func (m *Manager) RemoveBackendForApp(backend *types.BackendInfo) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 	frontend, present := m.frontends[backend.AppId]
 	if present {
-		frontend.RemoveBackend(backend.Node)
+		if timeout := frontend.Config().DrainTimeout; timeout > 0 {
+			go func() {
+				if _, err := frontend.drainRemoved(backend.Node, timeout); err != nil {
+					// not a backend we know, RemoveBackend counts those
+					frontend.RemoveBackend(backend.Node)
+				}
+			}()
+		} else {
+			frontend.RemoveBackend(backend.Node)
+		}
+		m.watchers.notify(RouteChange{Type: BackendRemoved, AppId: backend.AppId, Port: frontend.port, Backend: backend.Node})
 		return nil
 	} else {
 		return fmt.Errorf("[WARN] Frontend for %s not found. Oops!", backend.AppId)
 	}
 }
 
+// loopsBack tells if the backend node is the listener of one of our frontends,
+// along with the app of that frontend. Hostnames other than localhost aren't
+// resolved. Expects the lock to be held.
+func (m *Manager) loopsBack(node string) (string, bool) {
+	host, port, err := net.SplitHostPort(node)
+	if err != nil {
+		return "", false
+	}
+	appId := ""
+	for id, frontend := range m.frontends {
+		if frontend.port == port {
+			appId = id
+			break
+		}
+	}
+	if appId == "" {
+		return "", false
+	}
+	if host == "localhost" {
+		return appId, true
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", false
+	}
+	if ip.IsLoopback() || ip.IsUnspecified() {
+		return appId, true
+	}
+	// the frontends listen on all the interfaces
+	addrs, err := m.localAddrs()
+	if err != nil {
+		log.Printf("[WARN] Unable to get the local addresses to check %s for a loop - %v\n", node, err)
+		return "", false
+	}
+	for _, addr := range addrs {
+		if local, ok := addr.(*net.IPNet); ok && local.IP.Equal(ip) {
+			return appId, true
+		}
+	}
+	return "", false
+}
+
+// Pin routes all the new connections of the app to the backend for the ttl
+func (m *Manager) Pin(appId, backend string, ttl time.Duration) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	frontend, present := m.frontends[appId]
+	if !present {
+		return fmt.Errorf("Frontend for %s not found", appId)
+	}
+	return frontend.Pin(backend, ttl)
+}
+
+// Drain drains the backend of the app and removes it, see Frontend.DrainBackend
+func (m *Manager) Drain(appId, backend string, timeout time.Duration) (bool, error) {
+	m.lock.Lock()
+	frontend, present := m.frontends[appId]
+	m.lock.Unlock()
+	if !present {
+		return false, fmt.Errorf("Frontend for %s not found", appId)
+	}
+	// without the lock, it can take a while
+	return frontend.DrainBackend(backend, timeout)
+}
+
+// Unpin releases the override of the app, returns false if there was none
+func (m *Manager) Unpin(appId string) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	frontend, present := m.frontends[appId]
+	if !present {
+		return false, fmt.Errorf("Frontend for %s not found", appId)
+	}
+	return frontend.Unpin(), nil
+}
+
+// State returns the current routing table - all the frontends and their backends
+func (m *Manager) State() []FrontendState {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	state := make([]FrontendState, 0, len(m.frontends))
+	for appId, frontend := range m.frontends {
+		state = append(state, FrontendState{
+			AppId:       appId,
+			Port:        frontend.port,
+			Backends:    frontend.Backends(),
+			Override:    frontend.Override(),
+			Bound:       frontend.Bound(),
+			Bind:        frontend.Bind(),
+			Strategy:    frontend.Config().Strategy,
+			IdleTimeout: frontend.IdleTimeout().String(),
+			KeepAlive:   frontend.KeepAlive().String(),
+
+			ConnectionSuccessRatio: frontend.ConnectionSuccessRatio(),
+			LabelErrors:            frontend.Config().LabelErrors,
+		})
+	}
+	sort.Slice(state, func(i, j int) bool { return state[i].AppId < state[j].AppId })
+	return state
+}
+
+// logSummary logs a table of all the frontends, once the initial scan of the
+// providers is done so it has everything that was configured at startup
+func (m *Manager) logSummary() {
+	state := m.State()
+	log.Printf("[INFO] Serving %d frontends after the initial scan\n", len(state))
+	for _, line := range summary(state) {
+		log.Printf("[INFO] %s\n", line)
+	}
+}
+
+// summary renders the frontends as the lines of a table
+func summary(state []FrontendState) []string {
+	var table bytes.Buffer
+	w := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "APP\tPORT\tBIND\tSTRATEGY\tBACKENDS\tIDLE\tKEEPALIVE")
+	for _, frontend := range state {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", frontend.AppId, frontend.Port, frontend.Bind,
+			frontend.Strategy, len(frontend.Backends), frontend.IdleTimeout, frontend.KeepAlive)
+	}
+	w.Flush()
+	return strings.Split(strings.TrimSuffix(table.String(), "\n"), "\n")
+}
+
+// Watch returns a channel of all the changes to the routing table from now on,
+// call the returned func to stop watching.
+func (m *Manager) Watch() (<-chan RouteChange, func()) {
+	return m.watchers.watch()
+}
+
 // Used only for tests
 func (m *Manager) getFrontend(appId string) (*Frontend, bool) {
 	f, exists := m.frontends[appId]
@@ -1,7 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/ashwanthkumar/golang-utils/sets"
 	"github.com/ashwanthkumar/gotlb/types"
@@ -48,6 +56,28 @@ func TestManagerToAddBackendForApps(t *testing.T) {
 	assert.Equal(t, 3, frontend.LenOfBackends())
 }
 
+func TestManagerToReadTheZoneAndTheWeightFromTheMetadata(t *testing.T) {
+	m := NewManager()
+	zones, _ := ParseZones("zone-a", "zone-a=10.0.1.0/24")
+	m.SetZones(zones)
+	m.addFrontend(APP_ID, createFrontend(APP_ID, "-1", sets.Empty()))
+
+	backend := &types.BackendInfo{AppId: APP_ID, Node: "10.0.1.5:80", Metadata: map[string]string{"zone": "zone-b", "weight": "3"}}
+	assert.NoError(t, m.AddBackendForApp(backend))
+	assert.Equal(t, "zone-b", backend.Zone)
+	assert.Equal(t, 3, backend.Weight)
+
+	// the fields the provider did set win, and the backends without any metadata still work
+	backend = &types.BackendInfo{AppId: APP_ID, Node: "10.0.1.6:80", Weight: 5, Metadata: map[string]string{"weight": "heavy"}}
+	assert.NoError(t, m.AddBackendForApp(backend))
+	assert.Equal(t, 5, backend.Weight)
+	assert.Equal(t, "zone-a", backend.Zone)
+	backend = createBackendInfo(APP_ID, "10.0.1.7:80")
+	assert.NoError(t, m.AddBackendForApp(backend))
+	assert.Equal(t, "zone-a", backend.Zone)
+	assert.Equal(t, 0, backend.Weight)
+}
+
 func TestManagerToRemoveBackendForAppShouldThrowAnErrorWhenNoFrontendIsAvailableForTheApp(t *testing.T) {
 	m := NewManager()
 	err := m.RemoveBackendForApp(createBackendInfo(APP_ID, "localhost:12345"))
@@ -65,6 +95,384 @@ func TestManagerToRemoveBackendForApps(t *testing.T) {
 	assert.Equal(t, 1, frontend.LenOfBackends())
 }
 
+func TestManagerState(t *testing.T) {
+	m := NewManager()
+	m.addFrontend("/b", createFrontend("/b", "2000", sets.FromSlice([]string{"b:2", "b:1"})))
+	m.addFrontend("/a", createFrontend("/a", "1000", sets.Empty()))
+
+	state := m.State()
+	assert.Equal(t, []FrontendState{
+		{AppId: "/a", Port: "1000", Backends: []string{}, Bind: ":1000", Strategy: RoundRobinName, IdleTimeout: "0s", KeepAlive: "0s", ConnectionSuccessRatio: 1},
+		{AppId: "/b", Port: "2000", Backends: []string{"b:1", "b:2"}, Bind: ":2000", Strategy: RoundRobinName, IdleTimeout: "0s", KeepAlive: "0s", ConnectionSuccessRatio: 1},
+	}, state)
+}
+
+func TestManagerToNotifyWatchersOfRouteChanges(t *testing.T) {
+	m := NewManager()
+	m.addFrontend(APP_ID, createFrontend(APP_ID, "-1", sets.Empty()))
+	changes, cancel := m.Watch()
+
+	assert.NoError(t, m.AddBackendForApp(createBackendInfo(APP_ID, "b:1")))
+	assert.NoError(t, m.RemoveBackendForApp(createBackendInfo(APP_ID, "b:1")))
+	m.RemoveFrontend(createAppInfo(APP_ID, createAppLabels("-1")))
+
+	assert.Equal(t, RouteChange{Type: BackendAdded, AppId: APP_ID, Port: "-1", Backend: "b:1"}, <-changes)
+	assert.Equal(t, RouteChange{Type: BackendRemoved, AppId: APP_ID, Port: "-1", Backend: "b:1"}, <-changes)
+	assert.Equal(t, RouteChange{Type: FrontendRemoved, AppId: APP_ID, Port: "-1"}, <-changes)
+
+	cancel()
+	_, open := <-changes
+	assert.False(t, open)
+}
+
+func TestManagerToLetTheFirstAppWinAConflictingPort(t *testing.T) {
+	m := NewManager()
+	m.CreateNewFrontendIfNotExist(createProviderAppInfo("/first", "p2", "0"))
+	m.CreateNewFrontendIfNotExist(createProviderAppInfo("/second", "p1", "0"))
+	assertServing(t, m, "/first", "/second")
+	assert.Equal(t, []PortConflict{{
+		Port:    "0",
+		Policy:  FirstWins,
+		Apps:    []ConflictingApp{{AppId: "/first", Provider: "p2"}, {AppId: "/second", Provider: "p1"}},
+		Serving: "/first",
+	}}, m.Conflicts())
+
+	m.RemoveFrontend(createAppInfo("/first", nil))
+	assertServing(t, m, "/second", "/first")
+	assert.Empty(t, m.Conflicts())
+	m.RemoveFrontend(createAppInfo("/second", nil))
+}
+
+func TestManagerToStartTheAppWaitingOnAPortWithItsLatestLabels(t *testing.T) {
+	m := NewManager()
+	m.CreateNewFrontendIfNotExist(createProviderAppInfo("/first", "p1", "0"))
+	m.CreateNewFrontendIfNotExist(createProviderAppInfo("/second", "p2", "0"))
+	assertServing(t, m, "/first", "/second")
+	updated := createProviderAppInfo("/second", "p2", "0")
+	updated.Labels[types.TLB_STRATEGY] = IPHashName
+	m.CreateNewFrontendIfNotExist(updated)
+	assertServing(t, m, "/first", "/second")
+
+	m.RemoveFrontend(createAppInfo("/first", nil))
+	assertServing(t, m, "/second", "/first")
+	second, _ := m.getFrontend("/second")
+	assert.Equal(t, IPHashName, second.Config().Strategy)
+	m.RemoveFrontend(createAppInfo("/second", nil))
+}
+
+func TestManagerToLetTheAppFromTheHigherPriorityProviderWinAConflictingPort(t *testing.T) {
+	m := NewManager()
+	m.SetPortConflictPolicy(ProviderPriority)
+	m.priorities["p1"] = 0
+	m.priorities["p2"] = 1
+	m.CreateNewFrontendIfNotExist(createProviderAppInfo("/first", "p2", "0"))
+	assertServing(t, m, "/first", "/second")
+	m.CreateNewFrontendIfNotExist(createProviderAppInfo("/second", "p1", "0"))
+	assertServing(t, m, "/second", "/first")
+	m.RemoveFrontend(createAppInfo("/second", nil))
+	assertServing(t, m, "/first", "/second")
+	m.RemoveFrontend(createAppInfo("/first", nil))
+}
+
+func TestManagerToRejectAllTheAppsOfAConflictingPort(t *testing.T) {
+	m := NewManager()
+	m.SetPortConflictPolicy(RejectBoth)
+	m.CreateNewFrontendIfNotExist(createProviderAppInfo("/first", "p1", "0"))
+	m.CreateNewFrontendIfNotExist(createProviderAppInfo("/second", "p2", "0"))
+	assertServing(t, m, "", "/first", "/second")
+	assert.Equal(t, float64(1), metrics.Gauge("port-conflicts", "port", "0").Value())
+	assert.Equal(t, float64(0), metrics.Gauge("frontend-bound", "app", "/first").Value())
+	assert.Equal(t, float64(0), metrics.Gauge("frontend-bound", "app", "/second").Value())
+
+	m.RemoveFrontend(createAppInfo("/first", nil))
+	assertServing(t, m, "/second", "/first")
+	assert.Equal(t, float64(0), metrics.Gauge("port-conflicts", "port", "0").Value())
+	m.RemoveFrontend(createAppInfo("/second", nil))
+}
+
+func TestManagerToStopTheProvidersBeforeTheFrontends(t *testing.T) {
+	m := NewManager()
+	provider := &fakeProvider{app: createProviderAppInfo(APP_ID, "fake", "0")}
+	stopped := make(chan bool)
+	go func() {
+		m.Start(provider)
+		close(stopped)
+	}()
+	for len(m.State()) == 0 || len(m.State()[0].Backends) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	m.Stop()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Manager didn't stop")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&provider.exited))
+	assert.Empty(t, m.State())
+}
+
+func TestManagerToWaitOnTheConnectionsInFlightWhenItStops(t *testing.T) {
+	m := NewManager()
+	m.SetShutdownGrace(5 * time.Second)
+	provider := &fakeProvider{app: createProviderAppInfo(APP_ID, "fake", "0")}
+	stopped := make(chan bool)
+	go func() {
+		m.Start(provider)
+		close(stopped)
+	}()
+	for len(m.State()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	f, _ := m.getFrontend(APP_ID)
+	atomic.AddInt64(f.open, 1)
+
+	m.Stop()
+	select {
+	case <-stopped:
+		t.Fatal("Manager stopped with a connection in flight")
+	case <-time.After(200 * time.Millisecond):
+	}
+	assert.True(t, f.isStopped())
+	atomic.AddInt64(f.open, -1)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Manager didn't stop")
+	}
+}
+
+func TestAwaitConnectionsToGiveUpOnceTheContextIsDone(t *testing.T) {
+	open := int64(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.False(t, awaitConnections(ctx, []*int64{&open}))
+	open = 0
+	assert.True(t, awaitConnections(context.Background(), []*int64{&open}))
+}
+
+func TestManagerToSkipAProviderThatCannotStart(t *testing.T) {
+	m := NewManager()
+	failures := metrics.Counter("provider-start-failures", "provider", "failing")
+	before := failures.Value()
+	provider := &fakeProvider{app: createProviderAppInfo(APP_ID, "fake", "0")}
+	stopped := make(chan bool)
+	go func() {
+		m.Start(failingProvider{}, provider)
+		close(stopped)
+	}()
+	for len(m.State()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, before+1, failures.Value())
+
+	m.Stop()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Manager didn't stop")
+	}
+}
+
+// failingProvider can't start
+type failingProvider struct{}
+
+func (failingProvider) Name() string {
+	return "failing"
+}
+
+func (failingProvider) Provide(addBackend chan<- *types.BackendInfo, removeBackend chan<- *types.BackendInfo,
+	appUpdate chan<- *types.AppInfo, dropApp chan<- *types.AppInfo, stop <-chan bool, done *sync.WaitGroup) error {
+	return errors.New("connection refused")
+}
+
+// fakeProvider sends an app and then keeps adding backends to it until it's stopped
+type fakeProvider struct {
+	app    *types.AppInfo
+	exited int32
+}
+
+func (p *fakeProvider) Name() string {
+	return "fake"
+}
+
+func (p *fakeProvider) Provide(addBackend chan<- *types.BackendInfo, removeBackend chan<- *types.BackendInfo,
+	appUpdate chan<- *types.AppInfo, dropApp chan<- *types.AppInfo, stop <-chan bool, done *sync.WaitGroup) error {
+	go func() {
+		defer done.Done()
+		defer atomic.StoreInt32(&p.exited, 1)
+		select {
+		case appUpdate <- p.app:
+		case <-stop:
+			return
+		}
+		for i := 0; ; i++ {
+			select {
+			case addBackend <- createBackendInfo(p.app.AppId, fmt.Sprintf("b:%d", i)):
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func TestManagerToSyncWithTheEventsOfTheProviders(t *testing.T) {
+	m := NewManager()
+	labels := createAppLabels("0")
+	labels[types.TLB_COALESCE] = "1h"
+	provider := &scriptedProvider{steps: make(chan func(p *scriptedProvider))}
+	stopped := make(chan bool)
+	go func() {
+		m.Start(provider)
+		close(stopped)
+	}()
+
+	// the apps and the backends get to the manager on their own channels,
+	// the backends are for the frontend only once it's synced
+	provider.run(func(p *scriptedProvider) { p.appUpdate <- createAppInfo(APP_ID, labels) })
+	assert.True(t, m.Sync())
+	provider.run(func(p *scriptedProvider) {
+		p.addBackend <- createBackendInfo(APP_ID, "b:1")
+		p.addBackend <- createBackendInfo(APP_ID, "b:2")
+		p.removeBackend <- createBackendInfo(APP_ID, "b:1")
+	})
+	assert.True(t, m.Sync())
+	f, exists := m.getFrontend(APP_ID)
+	assert.True(t, exists)
+	assert.Equal(t, []string{"b:2"}, f.Backends())
+	// the coalesced changes are applied without waiting for the window
+	f.lock.Lock()
+	assert.Equal(t, map[string]bool{"b:2": true}, f.routed)
+	f.lock.Unlock()
+
+	m.Stop()
+	<-stopped
+	assert.False(t, m.Sync())
+}
+
+// scriptedProvider runs the steps of a test with the channels of the manager
+type scriptedProvider struct {
+	addBackend    chan<- *types.BackendInfo
+	removeBackend chan<- *types.BackendInfo
+	appUpdate     chan<- *types.AppInfo
+	steps         chan func(p *scriptedProvider)
+}
+
+func (p *scriptedProvider) Name() string {
+	return "scripted"
+}
+
+func (p *scriptedProvider) Provide(addBackend chan<- *types.BackendInfo, removeBackend chan<- *types.BackendInfo,
+	appUpdate chan<- *types.AppInfo, dropApp chan<- *types.AppInfo, stop <-chan bool, done *sync.WaitGroup) error {
+	p.addBackend, p.removeBackend, p.appUpdate = addBackend, removeBackend, appUpdate
+	go func() {
+		defer done.Done()
+		for {
+			select {
+			case step := <-p.steps:
+				step(p)
+				p.steps <- nil
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// run runs the step on the provider, returns once it's sent everything
+func (p *scriptedProvider) run(step func(p *scriptedProvider)) {
+	p.steps <- step
+	<-p.steps
+}
+
+func assertServing(t *testing.T, m *Manager, serving string, notServing ...string) {
+	if serving != "" {
+		_, exists := m.getFrontend(serving)
+		assert.True(t, exists, serving+" should be serving")
+	}
+	for _, appId := range notServing {
+		_, exists := m.getFrontend(appId)
+		assert.False(t, exists, appId+" should not be serving")
+	}
+}
+
+func createProviderAppInfo(appId, provider, port string) *types.AppInfo {
+	app := createAppInfo(appId, createAppLabels(port))
+	app.Provider = provider
+	return app
+}
+
+func TestManagerToRecreateAFrontendWithoutRefusingConnections(t *testing.T) {
+	if !reusePortSupported {
+		t.Skip("the port can't be shared on this platform")
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	l.Close()
+
+	m := NewManager()
+	app := createAppInfo(APP_ID, createAppLabels(port))
+	m.CreateNewFrontendIfNotExist(app)
+	assert.NoError(t, m.AddBackendForApp(createBackendInfo(APP_ID, "127.0.0.1:1")))
+	old, _ := m.getFrontend(APP_ID)
+	defer func() {
+		f, _ := m.getFrontend(APP_ID)
+		f.Stop()
+	}()
+	for i := 0; ; i++ {
+		conn, err := net.Dial("tcp", "127.0.0.1:"+port)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if i == 100 {
+			t.Fatalf("the frontend is not listening - %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var refused int64
+	done := make(chan bool)
+	dialing := make(chan bool)
+	go func() {
+		defer close(dialing)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			conn, err := net.Dial("tcp", "127.0.0.1:"+port)
+			if err != nil {
+				// connections still queued on the old listener when it closes are
+				// reset, only a refusal means the port was not bound
+				if strings.Contains(err.Error(), "connection refused") {
+					atomic.AddInt64(&refused, 1)
+				}
+				continue
+			}
+			conn.Close()
+		}
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	labels := createAppLabels(port)
+	labels[types.TLB_MAX_PENDING] = "10"
+	m.CreateNewFrontendIfNotExist(createAppInfo(APP_ID, labels))
+	time.Sleep(20 * time.Millisecond)
+	close(done)
+	<-dialing
+
+	f, _ := m.getFrontend(APP_ID)
+	assert.True(t, f != old, "the frontend should have been recreated")
+	assert.Equal(t, 10, f.Config().MaxPending)
+	assert.Equal(t, []string{"127.0.0.1:1"}, f.Backends())
+	assert.Equal(t, int64(0), atomic.LoadInt64(&refused))
+}
+
 func createAppLabels(port string) map[string]string {
 	labels := make(map[string]string)
 	labels[types.TLB_PORT] = port
@@ -87,5 +495,107 @@ func createBackendInfo(appId string, backend string) *types.BackendInfo {
 }
 
 func createFrontend(appId, port string, backends sets.Set) *Frontend {
-	return NewFrontend(appId, port, backends)
+	return NewFrontend(appId, port, backends, NewFrontendConfig(nil))
+}
+
+func TestManagerToRejectBackendsLoopingBackToItsFrontends(t *testing.T) {
+	m := NewManager()
+	m.localAddrs = func() ([]net.Addr, error) {
+		return []net.Addr{&net.IPNet{IP: net.ParseIP("10.0.0.5"), Mask: net.CIDRMask(24, 32)}}, nil
+	}
+	m.addFrontend(APP_ID, createFrontend(APP_ID, "8000", sets.Empty()))
+	m.addFrontend("/other", createFrontend("/other", "9000", sets.Empty()))
+	counter := metrics.Counter("frontend-loopback-rejected", "app", APP_ID)
+	before := counter.Value()
+
+	for _, node := range []string{"127.0.0.1:8000", "localhost:9000", "[::1]:8000", "0.0.0.0:9000", "10.0.0.5:8000"} {
+		assert.Error(t, m.AddBackendForApp(createBackendInfo(APP_ID, node)), node)
+	}
+	assert.Equal(t, before+5, counter.Value())
+	for _, node := range []string{"127.0.0.1:8001", "10.0.0.6:8000", "backend.local:8000"} {
+		assert.NoError(t, m.AddBackendForApp(createBackendInfo(APP_ID, node)), node)
+	}
+	f, _ := m.getFrontend(APP_ID)
+	assert.Equal(t, []string{"10.0.0.6:8000", "127.0.0.1:8001", "backend.local:8000"}, f.Backends())
+}
+
+func TestTagAppsToSignalTheScanAfterItsApps(t *testing.T) {
+	m := NewManager()
+	from := make(chan *types.AppInfo, 1)
+	to := make(chan *types.AppInfo, 1)
+	scanned := make(chan bool)
+	synced := make(chan bool, 1)
+	var done sync.WaitGroup
+	done.Add(1)
+	go m.tagApps("fake", from, to, scanned, synced, nil, &done)
+
+	from <- createAppInfo(APP_ID, nil)
+	close(scanned)
+	app := <-to
+	assert.Equal(t, "fake", app.Provider)
+	select {
+	case <-synced:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The scan wasn't signalled")
+	}
+	close(m.stop)
+	done.Wait()
+}
+
+func TestSummaryToListTheFrontends(t *testing.T) {
+	m := NewManager()
+	m.addFrontend(APP_ID, createFrontendWithLabels([]string{"b:1", "b:2"}, map[string]string{"tlb.strategy": "weighted", "tlb.timeout.idle": "5m"}))
+	lines := summary(m.State())
+	assert.Len(t, lines, 2)
+	assert.Equal(t, []string{"APP", "PORT", "BIND", "STRATEGY", "BACKENDS", "IDLE", "KEEPALIVE"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{APP_ID, "-1", ":-1", "weighted", "2", "5m0s", "0s"}, strings.Fields(lines[1]))
+}
+
+func TestManagerToPickTheStrategyOfTheFrontendFromTheLabelsOfTheApp(t *testing.T) {
+	m := NewManager()
+	strategies := map[string]string{
+		RoundRobinName:      "*main.RoundRobin",
+		LeastConnectionName: "*main.LeastConnection",
+		RandomName:          "*main.Random",
+		P2CName:             "*main.P2C",
+		EWMAName:            "*main.EWMA",
+		// the unknown and the missing ones get round robin
+		"fastest": "*main.RoundRobin",
+		"":        "*main.RoundRobin",
+	}
+	for name, expected := range strategies {
+		labels := createAppLabels("-1")
+		if name != "" {
+			labels[types.TLB_STRATEGY] = name
+		}
+		config := m.frontendConfig(createAppInfo(APP_ID, labels))
+		assert.Equal(t, expected, fmt.Sprintf("%T", NewFrontend(APP_ID, "-1", sets.Empty(), config).strategy), name)
+		assert.Equal(t, name == "fastest", len(config.LabelErrors) == 1, name)
+	}
+}
+
+func TestManagerToKeepServingTheOtherAppsWhenAFrontendCannotListen(t *testing.T) {
+	taken, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	_, port, _ := net.SplitHostPort(taken.Addr().String())
+	failures := metrics.Counter("frontend-start-failures", "app", "/port-taken")
+	before := failures.Value()
+
+	m := NewManager()
+	defer m.stopAllFrontends()
+	m.CreateNewFrontendIfNotExist(createAppInfo("/port-taken", createAppLabels(port)))
+	m.CreateNewFrontendIfNotExist(createAppInfo("/port-free", createAppLabels("0")))
+	free, _ := m.getFrontend("/port-free")
+	for i := 0; failures.Value() == before || !free.Bound(); i++ {
+		if i == 100 {
+			t.Fatal("the frontends weren't started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	failed, _ := m.getFrontend("/port-taken")
+	assert.False(t, failed.Bound())
+	assert.True(t, free.Bound())
 }
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type MetricType string
+
+const (
+	// Counter is a metric that only goes up
+	Counter MetricType = "counter"
+	// Gauge is a metric that represents a value at a point in time
+	Gauge MetricType = "gauge"
+)
+
+// metrics is the registry every component of GoTLB reports into
+var metrics = NewMetricsRegistry()
+
+// Metric is a single named series in the MetricsRegistry. The tags
+// denote the dimensions of the series like the app or backend it belongs to.
+type Metric struct {
+	bits uint64 // float64 value, accessed atomically
+	Name string
+	Type MetricType
+	Tags map[string]string
+}
+
+// Inc increments the metric by 1
+func (m *Metric) Inc() {
+	m.Add(1)
+}
+
+// Dec decrements the metric by 1
+func (m *Metric) Dec() {
+	m.Add(-1)
+}
+
+// Add adds delta to the current value of the metric
+func (m *Metric) Add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&m.bits)
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&m.bits, old, updated) {
+			return
+		}
+	}
+}
+
+// Set sets the current value of the metric, meant to be used for gauges
+func (m *Metric) Set(value float64) {
+	atomic.StoreUint64(&m.bits, math.Float64bits(value))
+}
+
+// Value returns the current value of the metric
+func (m *Metric) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&m.bits))
+}
+
+// MetricValue is a point in time copy of a Metric
+type MetricValue struct {
+	Name  string            `json:"name"`
+	Type  MetricType        `json:"type"`
+	Tags  map[string]string `json:"tags,omitempty"`
+	Value float64           `json:"value"`
+}
+
+// MetricsRegistry holds all the metrics reported by GoTLB
+type MetricsRegistry struct {
+	// metric key to *Metric. The metrics are looked up on every connection of
+	// all the frontends and rarely created, a sync.Map keeps the lookups from
+	// contending with each other.
+	metrics sync.Map
+}
+
+// NewMetricsRegistry returns an empty MetricsRegistry
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{}
+}
+
+// each calls f with every metric in the registry, in no particular order
+func (r *MetricsRegistry) each(f func(key string, metric *Metric)) {
+	r.metrics.Range(func(key, metric interface{}) bool {
+		f(key.(string), metric.(*Metric))
+		return true
+	})
+}
+
+// Counter returns the counter for the name and the tags, creating it if needed.
+// Tags are given as key, value pairs - Counter("frontend-connections", "app", "/foo")
+func (r *MetricsRegistry) Counter(name string, tags ...string) *Metric {
+	return r.getOrCreate(name, Counter, tags)
+}
+
+// Gauge returns the gauge for the name and the tags, creating it if needed.
+// Tags are given as key, value pairs similar to Counter.
+func (r *MetricsRegistry) Gauge(name string, tags ...string) *Metric {
+	return r.getOrCreate(name, Gauge, tags)
+}
+
+// Delete drops the series of the name and the tags, for the ones of what's
+// gone like a backend that discovery removed. Tags are given as key, value
+// pairs similar to Counter.
+func (r *MetricsRegistry) Delete(name string, tags ...string) {
+	r.metrics.Delete(metricKey(name, tags))
+}
+
+// Snapshot returns the current value of all the metrics sorted by their name and tags
+func (r *MetricsRegistry) Snapshot() []MetricValue {
+	all := make(map[string]*Metric)
+	var keys []string
+	r.each(func(key string, metric *Metric) {
+		all[key] = metric
+		keys = append(keys, key)
+	})
+	sort.Strings(keys)
+	values := make([]MetricValue, 0, len(keys))
+	for _, key := range keys {
+		metric := all[key]
+		values = append(values, MetricValue{
+			Name:  metric.Name,
+			Type:  metric.Type,
+			Tags:  metric.Tags,
+			Value: metric.Value(),
+		})
+	}
+	return values
+}
+
+// MetricDescription describes all the series of a metric name, used by the
+// dashboard tooling to discover the metrics
+type MetricDescription struct {
+	Name string     `json:"name"`
+	Type MetricType `json:"type"`
+	// tag keys across all the series of the metric
+	Tags   []string `json:"tags"`
+	Series int      `json:"series"`
+}
+
+// Catalog describes all the metrics in the registry right now, sorted by their name
+func (r *MetricsRegistry) Catalog() []MetricDescription {
+	descriptions := make(map[string]*MetricDescription)
+	tags := make(map[string]map[string]bool)
+	r.each(func(_ string, metric *Metric) {
+		description, present := descriptions[metric.Name]
+		if !present {
+			description = &MetricDescription{Name: metric.Name, Type: metric.Type}
+			descriptions[metric.Name] = description
+			tags[metric.Name] = make(map[string]bool)
+		}
+		description.Series++
+		for tag := range metric.Tags {
+			tags[metric.Name][tag] = true
+		}
+	})
+
+	catalog := make([]MetricDescription, 0, len(descriptions))
+	for name, description := range descriptions {
+		description.Tags = make([]string, 0, len(tags[name]))
+		for tag := range tags[name] {
+			description.Tags = append(description.Tags, tag)
+		}
+		sort.Strings(description.Tags)
+		catalog = append(catalog, *description)
+	}
+	sort.Slice(catalog, func(i, j int) bool { return catalog[i].Name < catalog[j].Name })
+	return catalog
+}
+
+func (r *MetricsRegistry) getOrCreate(name string, metricType MetricType, tags []string) *Metric {
+	key := metricKey(name, tags)
+	if metric, present := r.metrics.Load(key); present {
+		return metric.(*Metric)
+	}
+	metric, _ := r.metrics.LoadOrStore(key, &Metric{
+		Name: name,
+		Type: metricType,
+		Tags: tagsToMap(tags),
+	})
+	return metric.(*Metric)
+}
+
+func metricKey(name string, tags []string) string {
+	if len(tags) < 4 {
+		// most metrics have just the app, no need to sort
+		if len(tags) < 2 {
+			return name + "{}"
+		}
+		return name + "{" + tags[0] + "=" + tags[1] + "}"
+	}
+	pairs := make([]string, 0, len(tags)/2)
+	for i := 0; i+1 < len(tags); i += 2 {
+		pairs = append(pairs, tags[i]+"="+tags[i+1])
+	}
+	sort.Strings(pairs)
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+func tagsToMap(tags []string) map[string]string {
+	if len(tags) < 2 {
+		return nil
+	}
+	m := make(map[string]string)
+	for i := 0; i+1 < len(tags); i += 2 {
+		m[tags[i]] = tags[i+1]
+	}
+	return m
+}
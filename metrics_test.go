@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsRegistryToReturnSameMetricForSameNameAndTags(t *testing.T) {
+	registry := NewMetricsRegistry()
+	registry.Counter("connections", "app", "/a", "backend", "b:1").Inc()
+	registry.Counter("connections", "backend", "b:1", "app", "/a").Inc()
+	registry.Counter("connections", "app", "/b").Inc()
+
+	assert.Equal(t, float64(2), registry.Counter("connections", "app", "/a", "backend", "b:1").Value())
+	assert.Equal(t, float64(1), registry.Counter("connections", "app", "/b").Value())
+}
+
+func TestMetricsRegistryToDeleteTheSeries(t *testing.T) {
+	registry := NewMetricsRegistry()
+	registry.Counter("connections", "app", "/a", "backend", "b:1").Inc()
+	registry.Counter("connections", "app", "/a", "backend", "b:2").Inc()
+	registry.Delete("connections", "backend", "b:1", "app", "/a")
+
+	assert.Len(t, registry.Snapshot(), 1)
+	// it starts over when it's used again
+	assert.Equal(t, float64(0), registry.Counter("connections", "app", "/a", "backend", "b:1").Value())
+}
+
+func TestMetricsRegistrySnapshot(t *testing.T) {
+	registry := NewMetricsRegistry()
+	registry.Gauge("active", "app", "/a").Set(5)
+	registry.Counter("accepted", "app", "/a").Add(3)
+
+	snapshot := registry.Snapshot()
+	assert.Len(t, snapshot, 2)
+	assert.Equal(t, "accepted", snapshot[0].Name)
+	assert.Equal(t, Counter, snapshot[0].Type)
+	assert.Equal(t, float64(3), snapshot[0].Value)
+	assert.Equal(t, "active", snapshot[1].Name)
+	assert.Equal(t, map[string]string{"app": "/a"}, snapshot[1].Tags)
+	assert.Equal(t, float64(5), snapshot[1].Value)
+}
+
+func TestMetricsRegistryCatalog(t *testing.T) {
+	registry := NewMetricsRegistry()
+	registry.Counter("failovers", "app", "/a").Inc()
+	registry.Counter("failovers", "app", "/b").Inc()
+	registry.Counter("selections", "app", "/a", "backend", "b:1").Inc()
+	registry.Counter("selections", "app", "/a", "role", "shadow").Inc()
+	registry.Gauge("listeners").Set(2)
+
+	catalog := registry.Catalog()
+	assert.Equal(t, []MetricDescription{
+		{Name: "failovers", Type: Counter, Tags: []string{"app"}, Series: 2},
+		{Name: "listeners", Type: Gauge, Tags: []string{}, Series: 1},
+		{Name: "selections", Type: Counter, Tags: []string{"app", "backend", "role"}, Series: 2},
+	}, catalog)
+}
+
+func BenchmarkMetricsRegistryToLookUpTheMetricsOfAConnection(b *testing.B) {
+	registry := NewMetricsRegistry()
+	for i := 0; i < 500; i++ {
+		registry.Counter("frontend-failovers", "app", fmt.Sprintf("/app-%d", i))
+	}
+	var next int32
+	b.RunParallel(func(pb *testing.PB) {
+		// every goroutine is a connection of a different app
+		app := fmt.Sprintf("/app-%d", atomic.AddInt32(&next, 1))
+		for pb.Next() {
+			registry.Counter("frontend-failovers", "app", app).Inc()
+			registry.Counter("backend-dials", "app", app, "backend", "10.0.0.1:31000").Inc()
+		}
+	})
+}
+
+// hasSeries tells if the shared registry has the series, without creating it
+func hasSeries(name string, tags ...string) bool {
+	_, present := metrics.metrics.Load(metricKey(name, tags))
+	return present
+}
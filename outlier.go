@@ -0,0 +1,124 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultOutlierEjection is how long a backend pulled for it's failed dials
+	// stays out of the rotation, when the app doesn't say
+	defaultOutlierEjection = 30 * time.Second
+	// the ejection of a backend that's pulled again right after it's back
+	// doubles, upto this many times
+	maxOutlierDoublings = 5
+)
+
+// recordOutlier counts the failed dials to the backend (and the connections
+// it reset), and pulls it from the rotation once they get to the threshold of
+// the app - in a row, or within the window when the app has one. Unlike the
+// health checks it costs nothing more than the connections we proxy, but it
+// only notices a backend that's down once it has failed some of them.
+func (f *Frontend) recordOutlier(now time.Time, node string, err error) {
+	config := f.Config()
+	if config.OutlierFailures <= 0 {
+		return
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	state, known := f.health[node]
+	if !known || state.outlier {
+		return
+	}
+	if err == nil {
+		if config.OutlierWindow <= 0 {
+			state.dialFailures = nil
+		}
+		return
+	}
+	failures := append(state.dialFailures, now)
+	if config.OutlierWindow > 0 {
+		// drop the ones that are out of the window
+		for len(failures) > 0 && now.Sub(failures[0]) > config.OutlierWindow {
+			failures = failures[1:]
+		}
+	}
+	state.dialFailures = failures
+	if len(failures) < config.OutlierFailures {
+		return
+	}
+	f.ejectOutlier(now, node, state, config, err)
+}
+
+// ejectOutlier pulls the backend from the rotation for the ejection of the
+// app, or twice as long as the last time when it's pulled again within it's
+// last ejection of being put back
+func (f *Frontend) ejectOutlier(now time.Time, node string, state *healthState, config *FrontendConfig, err error) {
+	ejection := config.OutlierEjection
+	if state.ejection > 0 && now.Sub(state.reinstated) < state.ejection {
+		ejection = state.ejection * 2
+		if max := config.OutlierEjection << maxOutlierDoublings; ejection > max {
+			ejection = max
+		}
+	}
+	log.Printf("[WARN] %s of %s failed %d connections, pulling it from the rotation for %v - %v\n", node, f.appId, len(state.dialFailures), ejection, err)
+	metrics.Counter("frontend-outlier-ejections", "app", f.appId, "backend", node).Inc()
+	state.outlier = true
+	state.ejection = ejection
+	state.dialFailures = nil
+	f.queueChange(node, nil)
+	time.AfterFunc(ejection, func() { f.reinstateOutlier(node, state) })
+}
+
+// reinstateOutlier puts the backend back in the rotation once it's ejection
+// is over, unless the health checks (or a drain) have pulled it too
+func (f *Frontend) reinstateOutlier(node string, state *healthState) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	// a backend removed in the meantime (even if it was added again since)
+	// has a new state
+	if f.stopped || f.health[node] != state {
+		return
+	}
+	state.outlier = false
+	state.reinstated = time.Now()
+	if state.pulled() {
+		return
+	}
+	log.Printf("[INFO] Ejection of %s of %s is over, putting it back in the rotation\n", node, f.appId)
+	metrics.Counter("frontend-outlier-restores", "app", f.appId, "backend", node).Inc()
+	if _, warming := f.warming[node]; !warming {
+		f.queueChange(node, f.infos[node])
+	}
+}
+
+// isOutlier tells if the failed dials have pulled the backend from the rotation
+func (f *Frontend) isOutlier(node string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	state, known := f.health[node]
+	return known && state.outlier
+}
+
+// recordReset counts the backend resetting a connection towards it's outlier
+// detection, like a failed dial
+func (f *Frontend) recordReset(node string, err error) {
+	if isReset(err) {
+		f.countBackend("backend-resets", node)
+		f.recordOutlier(time.Now(), node, err)
+	}
+}
+
+// isReset tells if the error is of the other side resetting the connection
+func isReset(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	return err == syscall.ECONNRESET
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ashwanthkumar/gotlb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrontendToEjectABackendThatFailsItsDialsInARow(t *testing.T) {
+	refused := errors.New("connection refused")
+	frontend := createFrontendWithLabels([]string{"b:1", "b:2"}, map[string]string{"tlb.outlier.failures": "2", "tlb.outlier.ejection": "50ms"})
+	frontend.appId = "/outlier-app"
+	ejections := metrics.Counter("frontend-outlier-ejections", "app", "/outlier-app", "backend", "b:1")
+	before := ejections.Value()
+	frontend.recordDial("b:1", refused)
+	// a successful dial breaks the streak
+	frontend.recordDial("b:1", nil)
+	frontend.recordDial("b:1", refused)
+	assert.False(t, frontend.isOutlier("b:1"))
+
+	frontend.recordDial("b:1", refused)
+	assert.True(t, frontend.isOutlier("b:1"))
+	assert.False(t, frontend.isRouted("b:1"))
+	assert.Equal(t, before+1, ejections.Value())
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, "b:2", frontend.Lookup())
+	}
+	// it stays out when it's added again
+	frontend.AddBackend(&types.BackendInfo{AppId: APP_ID, Node: "b:1"})
+	assert.False(t, frontend.isRouted("b:1"))
+
+	for i := 0; frontend.isOutlier("b:1"); i++ {
+		if i == 100 {
+			t.Fatal("the backend wasn't put back")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, frontend.isRouted("b:1"))
+}
+
+func TestFrontendToKeepAnOutlierOutWhileTheHealthChecksHaveItOut(t *testing.T) {
+	frontend := createFrontendWithLabels([]string{"b:1", "b:2"}, map[string]string{"tlb.outlier.failures": "1"})
+	frontend.recordDial("b:1", errors.New("connection refused"))
+	config := HealthCheckConfig{Timeout: time.Second, UnhealthyThreshold: 1, HealthyThreshold: 1}
+	frontend.recordCheck("b:1", errors.New("connection refused"), config)
+	frontend.recordCheck("b:1", nil, config)
+	assert.False(t, frontend.isEjected("b:1"))
+	assert.False(t, frontend.isRouted("b:1"))
+
+	frontend.recordCheck("b:1", errors.New("connection refused"), config)
+	frontend.reinstateOutlier("b:1", frontend.health["b:1"])
+	assert.False(t, frontend.isOutlier("b:1"))
+	assert.False(t, frontend.isRouted("b:1"))
+}
+
+func TestFrontendToIgnoreTheDialsWhenOutlierDetectionIsOff(t *testing.T) {
+	frontend := createFrontendWithLabels([]string{"b:1"}, nil)
+	for i := 0; i < 10; i++ {
+		frontend.recordDial("b:1", errors.New("connection refused"))
+	}
+	assert.False(t, frontend.isOutlier("b:1"))
+	assert.True(t, frontend.isRouted("b:1"))
+
+	// nor of a backend that's gone by the time it's ejection is over
+	frontend = createFrontendWithLabels([]string{"b:1"}, map[string]string{"tlb.outlier.failures": "1"})
+	frontend.recordDial("b:1", errors.New("connection refused"))
+	ejected := frontend.health["b:1"]
+	frontend.RemoveBackend("b:1")
+	frontend.AddBackend(&types.BackendInfo{AppId: APP_ID, Node: "b:1"})
+	frontend.reinstateOutlier("b:1", ejected)
+	assert.True(t, frontend.isRouted("b:1"))
+}
+
+func TestRequestToEjectTheBackendItCannotConnectTo(t *testing.T) {
+	echo := startEchoBackend(t)
+	defer echo.Close()
+	refusing := startEchoBackend(t)
+	refusing.Close()
+
+	frontend := createFrontendWithLabels([]string{echo.Addr().String(), refusing.Addr().String()}, map[string]string{"tlb.failover.attempts": "2", "tlb.outlier.failures": "1"})
+	client := proxyThrough(t, refusing.Addr().String(), frontend)
+	defer client.Close()
+	_, err := client.Write([]byte("hello"))
+	assert.NoError(t, err)
+	response := make([]byte, 5)
+	_, err = io.ReadFull(client, response)
+	assert.NoError(t, err)
+	assert.True(t, frontend.isOutlier(refusing.Addr().String()))
+	assert.False(t, frontend.isOutlier(echo.Addr().String()))
+}
+
+func TestFrontendToEjectABackendThatFailsItsDialsWithinTheWindow(t *testing.T) {
+	refused := errors.New("connection refused")
+	frontend := createFrontendWithLabels([]string{"b:1", "b:2"}, map[string]string{"tlb.outlier.failures": "2", "tlb.outlier.window": "1m"})
+	now := time.Now()
+	frontend.recordOutlier(now, "b:1", refused)
+	// the good ones in between don't reset the count
+	frontend.recordOutlier(now, "b:1", nil)
+	// and the ones out of the window don't count
+	frontend.recordOutlier(now.Add(2*time.Minute), "b:1", refused)
+	assert.False(t, frontend.isOutlier("b:1"))
+	frontend.recordOutlier(now.Add(2*time.Minute), "b:1", nil)
+	frontend.recordOutlier(now.Add(150*time.Second), "b:1", refused)
+	assert.True(t, frontend.isOutlier("b:1"))
+}
+
+func TestFrontendToDoubleTheEjectionOfABackendPulledAgainSoonAfter(t *testing.T) {
+	refused := errors.New("connection refused")
+	frontend := createFrontendWithLabels([]string{"b:1", "b:2"}, map[string]string{"tlb.outlier.failures": "1", "tlb.outlier.ejection": "1h"})
+	frontend.appId = "/outlier-backoff"
+	restores := metrics.Counter("frontend-outlier-restores", "app", "/outlier-backoff", "backend", "b:1")
+	before := restores.Value()
+	state := frontend.health["b:1"]
+	ejections := []time.Duration{}
+	for i := 0; i < 7; i++ {
+		frontend.recordOutlier(time.Now(), "b:1", refused)
+		ejections = append(ejections, state.ejection)
+		frontend.reinstateOutlier("b:1", state)
+	}
+	assert.Equal(t, []time.Duration{time.Hour, 2 * time.Hour, 4 * time.Hour, 8 * time.Hour, 16 * time.Hour, 32 * time.Hour, 32 * time.Hour}, ejections)
+	assert.Equal(t, before+7, restores.Value())
+
+	// one that's been back for as long as it was out starts over
+	frontend.recordOutlier(state.reinstated.Add(32*time.Hour), "b:1", refused)
+	assert.Equal(t, time.Hour, state.ejection)
+}
+
+func TestRequestToCountTheResetsOfTheBackend(t *testing.T) {
+	resetting := startBackend(t, func(conn net.Conn) {
+		conn.Read(make([]byte, 5))
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+	})
+	defer resetting.Close()
+
+	frontend := createFrontendWithLabels([]string{resetting.Addr().String()}, map[string]string{"tlb.outlier.failures": "1"})
+	frontend.appId = "/resets"
+	resets := metrics.Counter("backend-resets", "app", "/resets", "backend", resetting.Addr().String())
+	before := resets.Value()
+	client := proxyThrough(t, resetting.Addr().String(), frontend)
+	defer client.Close()
+	_, err := client.Write([]byte("hello"))
+	assert.NoError(t, err)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	client.Read(make([]byte, 1))
+	assert.Equal(t, before+1, resets.Value())
+	assert.True(t, frontend.isOutlier(resetting.Addr().String()))
+}
+
+func TestFrontendToDeleteTheOutlierSeriesOfARemovedBackend(t *testing.T) {
+	frontend := createFrontendWithLabels([]string{"b:1"}, map[string]string{"tlb.outlier.failures": "1", "tlb.outlier.ejection": "1h"})
+	frontend.appId = "/outlier-removed"
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	frontend.recordReset("b:1", reset)
+	assert.True(t, frontend.isOutlier("b:1"))
+	assert.True(t, hasSeries("backend-resets", "app", "/outlier-removed", "backend", "b:1"))
+
+	frontend.RemoveBackend("b:1")
+	assert.False(t, hasSeries("backend-resets", "app", "/outlier-removed", "backend", "b:1"))
+	assert.False(t, hasSeries("frontend-outlier-ejections", "app", "/outlier-removed", "backend", "b:1"))
+	// a connection that was open doesn't bring them back
+	frontend.recordReset("b:1", reset)
+	assert.False(t, hasSeries("backend-resets", "app", "/outlier-removed", "backend", "b:1"))
+}
+
+func TestIsResetToUnwrapTheErrorsOfTheConnection(t *testing.T) {
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	assert.True(t, isReset(reset))
+	assert.False(t, isReset(io.EOF))
+	assert.False(t, isReset(&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNREFUSED)}))
+}
+
+func TestFrontendToApplyTheUpdatedOutlierDetection(t *testing.T) {
+	refused := errors.New("connection refused")
+	frontend := createFrontendWithLabels([]string{"b:1", "b:2"}, nil)
+	frontend.recordDial("b:1", refused)
+	assert.False(t, frontend.isOutlier("b:1"))
+
+	frontend.UpdateConfig(NewFrontendConfig(map[string]string{"tlb.outlier.failures": "1", "tlb.outlier.ejection": "1h"}))
+	frontend.recordDial("b:1", refused)
+	assert.True(t, frontend.isOutlier("b:1"))
+	assert.Equal(t, time.Hour, frontend.health["b:1"].ejection)
+
+	// and the window, the good dials in between don't reset the count anymore
+	frontend.UpdateConfig(NewFrontendConfig(map[string]string{"tlb.outlier.failures": "2", "tlb.outlier.window": "1m"}))
+	assert.Equal(t, time.Minute, frontend.Config().OutlierWindow)
+	frontend.recordDial("b:2", refused)
+	frontend.recordDial("b:2", nil)
+	frontend.recordDial("b:2", refused)
+	assert.True(t, frontend.isOutlier("b:2"))
+}
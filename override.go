@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Override pins all the connections of a frontend to a single backend,
+// bypassing the strategy, until it expires
+type Override struct {
+	Backend string    `json:"backend"`
+	Expires time.Time `json:"expires"`
+}
+
+// Pin routes all the new connections of the frontend to the backend for the
+// ttl. The strategy takes over again when it expires, when it's released
+// with Unpin or when the backend is removed.
+func (f *Frontend) Pin(backend string, ttl time.Duration) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !f.backends.Contains(backend) {
+		return fmt.Errorf("%s is not a backend of %s", backend, f.appId)
+	}
+	f.override = &Override{Backend: backend, Expires: time.Now().Add(ttl)}
+	log.Printf("[WARN] Pinned %s to %s until %s\n", f.appId, backend, f.override.Expires.Format(time.RFC3339))
+	return nil
+}
+
+// Unpin releases the override of the frontend, returns false if there was none
+func (f *Frontend) Unpin() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.pinned(time.Now()) == nil {
+		return false
+	}
+	f.clearOverride("released")
+	return true
+}
+
+// Override returns the override of the frontend, nil when there's none
+func (f *Frontend) Override() *Override {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	override := f.pinned(time.Now())
+	if override == nil {
+		return nil
+	}
+	current := *override
+	return &current
+}
+
+// pinned returns the override if it's still valid, expects the lock to be held
+func (f *Frontend) pinned(now time.Time) *Override {
+	if f.override != nil && !now.Before(f.override.Expires) {
+		f.clearOverride("expired")
+	}
+	return f.override
+}
+
+func (f *Frontend) clearOverride(reason string) {
+	log.Printf("[INFO] Unpinned %s from %s, the override %s\n", f.appId, f.override.Backend, reason)
+	f.override = nil
+}
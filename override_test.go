@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrontendToRouteEverythingToThePinnedBackend(t *testing.T) {
+	frontend := createFrontendWithLabels([]string{"b:1", "b:2", "b:3"}, nil)
+	assert.NoError(t, frontend.Pin("b:2", time.Minute))
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, "b:2", frontend.Lookup())
+		assert.Equal(t, "b:2", frontend.LookupFor("10.0.0.1", nil))
+	}
+	assert.Equal(t, "b:2", frontend.Override().Backend)
+
+	assert.True(t, frontend.Unpin())
+	assert.False(t, frontend.Unpin())
+	assert.Nil(t, frontend.Override())
+	routed := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		routed[frontend.Lookup()] = true
+	}
+	assert.Len(t, routed, 3)
+}
+
+func TestFrontendNotToPinAnUnknownBackend(t *testing.T) {
+	frontend := createFrontendWithLabels([]string{"b:1"}, nil)
+	assert.Error(t, frontend.Pin("b:2", time.Minute))
+	assert.Nil(t, frontend.Override())
+}
+
+func TestFrontendToExpireThePin(t *testing.T) {
+	frontend := createFrontendWithLabels([]string{"b:1", "b:2"}, nil)
+	assert.NoError(t, frontend.Pin("b:2", 10*time.Millisecond))
+	assert.Equal(t, "b:2", frontend.Lookup())
+
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, frontend.Override())
+	assert.NotEqual(t, frontend.Lookup(), frontend.Lookup())
+}
+
+func TestFrontendToUnpinWhenThePinnedBackendIsRemoved(t *testing.T) {
+	frontend := createFrontendWithLabels([]string{"b:1", "b:2"}, nil)
+	assert.NoError(t, frontend.Pin("b:2", time.Minute))
+	frontend.RemoveBackend("b:2")
+
+	assert.Nil(t, frontend.Override())
+	assert.Equal(t, "b:1", frontend.Lookup())
+	assert.Equal(t, "b:1", frontend.Lookup())
+}
+
+func TestFrontendToSkipThePinnedBackendWhenItsExcluded(t *testing.T) {
+	frontend := createFrontendWithLabels([]string{"b:1", "b:2"}, map[string]string{"tlb.strategy": "iphash"})
+	assert.NoError(t, frontend.Pin("b:2", time.Minute))
+	assert.Equal(t, "b:1", frontend.LookupFor("10.0.0.1", map[string]bool{"b:2": true}))
+}
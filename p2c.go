@@ -0,0 +1,107 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// P2C is an implementation of Strategy that picks two backends at random
+// and routes to the one with fewer open connections (the power of two
+// choices). It evens out the load almost as well as LeastConnection without
+// scanning all the backends, and Next only takes the read lock. Like with
+// LeastConnection, the count of a backend outlives it's removal while it
+// still has connections.
+type P2C struct {
+	lock     sync.RWMutex
+	backends []string
+	// open connections of the backends, changed atomically
+	open map[string]*int64
+	rand *xorshift
+}
+
+func P2CStrategy() LoadBalancingStrategy {
+	return &P2C{open: make(map[string]*int64), rand: newXorshift()}
+}
+
+func (p *P2C) AddBackend(backend string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.backends = withBackend(p.backends, backend)
+}
+
+func (p *P2C) RemoveBackend(backend string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.backends = withoutBackend(p.backends, backend)
+	if open, present := p.open[backend]; present && atomic.LoadInt64(open) == 0 {
+		delete(p.open, backend)
+	}
+}
+
+func (p *P2C) Next() string {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	switch len(p.backends) {
+	case 0:
+		return ""
+	case 1:
+		return p.backends[0]
+	}
+	n := uint64(len(p.backends))
+	first := p.rand.next() % n
+	// the second is any of the others
+	second := p.rand.next() % (n - 1)
+	if second >= first {
+		second++
+	}
+	a, b := p.backends[first], p.backends[second]
+	if p.connections(b) < p.connections(a) {
+		return b
+	}
+	return a
+}
+
+// connections returns the open connections of the backend, expects the read lock to be held
+func (p *P2C) connections(backend string) int64 {
+	if open, present := p.open[backend]; present {
+		return atomic.LoadInt64(open)
+	}
+	return 0
+}
+
+func (p *P2C) Acquire(backend string) {
+	p.lock.RLock()
+	open, present := p.open[backend]
+	p.lock.RUnlock()
+	if !present {
+		p.lock.Lock()
+		if open, present = p.open[backend]; !present {
+			open = new(int64)
+			p.open[backend] = open
+		}
+		p.lock.Unlock()
+	}
+	atomic.AddInt64(open, 1)
+}
+
+func (p *P2C) Release(backend string) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	open, present := p.open[backend]
+	if !present {
+		return
+	}
+	for {
+		count := atomic.LoadInt64(open)
+		if count <= 0 || atomic.CompareAndSwapInt64(open, count, count-1) {
+			return
+		}
+	}
+}
+
+// Connections returns the open connections to the backend
+func (p *P2C) Connections(backend string) int {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return int(p.connections(backend))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestP2CStrategyToPickTheLessLoadedOfTwoBackends(t *testing.T) {
+	s := P2CStrategy().(*P2C)
+	s.AddBackend("a")
+	s.AddBackend("b")
+	s.Acquire("a")
+	// with two backends it always compares both of them
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, "b", s.Next())
+	}
+	s.Acquire("b")
+	s.Acquire("b")
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, "a", s.Next())
+	}
+	s.Release("b")
+	s.Release("b")
+	s.Release("b")
+	assert.Equal(t, 0, s.Connections("b"))
+	assert.Equal(t, "b", s.Next())
+}
+
+func TestP2CStrategyToNeverPickTheMostLoadedOfThree(t *testing.T) {
+	s := P2CStrategy().(*P2C)
+	s.AddBackend("a")
+	s.AddBackend("b")
+	s.AddBackend("c")
+	s.Acquire("c")
+	s.Acquire("c")
+	s.Acquire("b")
+	picked := map[string]int{}
+	for i := 0; i < 300; i++ {
+		picked[s.Next()]++
+	}
+	assert.Equal(t, 0, picked["c"])
+	assert.True(t, picked["a"] > picked["b"])
+}
+
+func TestP2CStrategyToHandleFewerThanTwoBackends(t *testing.T) {
+	s := P2CStrategy().(*P2C)
+	assert.Equal(t, "", s.Next())
+	s.AddBackend("a")
+	s.Acquire("a")
+	assert.Equal(t, "a", s.Next())
+
+	// the count outlives the removal while it's connections are open
+	s.RemoveBackend("a")
+	assert.Equal(t, "", s.Next())
+	assert.Equal(t, 1, s.Connections("a"))
+	s.Release("a")
+	s.RemoveBackend("a")
+	assert.Empty(t, s.open)
+}
+
+func TestP2CStrategyToBeSafeForConcurrentConnections(t *testing.T) {
+	s := P2CStrategy().(*P2C)
+	for i := 0; i < 5; i++ {
+		s.AddBackend(fmt.Sprintf("10.0.0.%d:31000", i))
+	}
+	var connections sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		connections.Add(1)
+		go func() {
+			defer connections.Done()
+			for j := 0; j < 100; j++ {
+				backend := s.Next()
+				s.Acquire(backend)
+				s.Release(backend)
+			}
+		}()
+	}
+	connections.Wait()
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, 0, s.Connections(fmt.Sprintf("10.0.0.%d:31000", i)))
+	}
+}
+
+func BenchmarkRoundRobinStrategyToPickInParallel(b *testing.B) {
+	s := RoundRobinStrategy()
+	for i := 0; i < 10; i++ {
+		s.AddBackend(fmt.Sprintf("10.0.0.%d:31000", i))
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s.Next()
+		}
+	})
+}
+
+func BenchmarkP2CStrategyToPickInParallel(b *testing.B) {
+	s := P2CStrategy().(*P2C)
+	for i := 0; i < 10; i++ {
+		s.AddBackend(fmt.Sprintf("10.0.0.%d:31000", i))
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			backend := s.Next()
+			s.Acquire(backend)
+			s.Release(backend)
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ashwanthkumar/gotlb/types"
+)
+
+// PortConflictPolicy decides which app gets a frontend port when more than
+// one app (usually from different providers) claims the same port
+type PortConflictPolicy string
+
+const (
+	// The app that claimed the port first keeps it
+	FirstWins PortConflictPolicy = "first-wins"
+	// The app from the provider that was configured first gets the port,
+	// among apps of the same provider the first one wins
+	ProviderPriority PortConflictPolicy = "provider-priority"
+	// None of the apps get the port until the conflict is resolved
+	RejectBoth PortConflictPolicy = "reject-both"
+)
+
+// ParsePortConflictPolicy returns the PortConflictPolicy for it's name
+func ParsePortConflictPolicy(name string) (PortConflictPolicy, error) {
+	switch policy := PortConflictPolicy(name); policy {
+	case FirstWins, ProviderPriority, RejectBoth:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("Unknown port conflict policy %s, expected one of %s, %s or %s", name, FirstWins, ProviderPriority, RejectBoth)
+	}
+}
+
+// winner returns the app that should be serving the port from all the apps
+// claiming it in the order of their claim, nil if none of them should
+func (p PortConflictPolicy) winner(claims []*types.AppInfo, priorities map[string]int) *types.AppInfo {
+	if len(claims) == 0 {
+		return nil
+	}
+	switch p {
+	case RejectBoth:
+		if len(claims) > 1 {
+			return nil
+		}
+	case ProviderPriority:
+		winner := claims[0]
+		for _, claim := range claims[1:] {
+			if priorityOf(claim, priorities) < priorityOf(winner, priorities) {
+				winner = claim
+			}
+		}
+		return winner
+	}
+	return claims[0]
+}
+
+func priorityOf(app *types.AppInfo, priorities map[string]int) int {
+	priority, present := priorities[app.Provider]
+	if !present {
+		return len(priorities)
+	}
+	return priority
+}
+
+// PortConflict describes a port claimed by more than one app
+type PortConflict struct {
+	Port   string             `json:"port"`
+	Policy PortConflictPolicy `json:"policy"`
+	Apps   []ConflictingApp   `json:"apps"`
+	// AppId of the app serving the port, empty when none is
+	Serving string `json:"serving"`
+}
+
+type ConflictingApp struct {
+	AppId    string `json:"appId"`
+	Provider string `json:"provider"`
+}
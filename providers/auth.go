@@ -0,0 +1,92 @@
+package providers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// NewMarathonTLSConfig returns the TLS config of an https marathon whose
+// certificate is signed by the CA (a PEM bundle) of caFile, or the system's
+// CAs when it's empty. insecureSkipVerify doesn't verify the certificate at
+// all, for a dev setup.
+func NewMarathonTLSConfig(caFile string, insecureSkipVerify bool) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if caFile == "" {
+		return config, nil
+	}
+	bundle, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	config.RootCAs = x509.NewCertPool()
+	if !config.RootCAs.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("no certificates in %s", caFile)
+	}
+	return config, nil
+}
+
+// tlsTransport is the transport of the default client with the TLS config
+func tlsTransport(config *tls.Config) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:     config,
+	}
+}
+
+// TokenSource returns the DC/OS token we send to marathon. It's asked on every
+// request, so a token that's refreshed is picked up without a restart.
+type TokenSource func() (string, error)
+
+// StaticToken always returns the same token
+func StaticToken(token string) TokenSource {
+	return func() (string, error) {
+		return token, nil
+	}
+}
+
+// FileToken reads the token from the file on every request, for a token
+// that's refreshed by something else (like the dcos cli) before it expires
+func FileToken(path string) TokenSource {
+	return func() (string, error) {
+		token, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(token)), nil
+	}
+}
+
+// tokenTransport sends the token as the Authorization header of every request
+// to marathon, the events stream included. It's what the admin router of
+// DC/OS asks for instead of basic auth.
+type tokenTransport struct {
+	token TokenSource
+	base  http.RoundTripper
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.token()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the DC/OS token - %v", err)
+	}
+	// the request isn't ours to change
+	authorized := new(http.Request)
+	*authorized = *req
+	authorized.Header = make(http.Header, len(req.Header)+1)
+	for key, values := range req.Header {
+		authorized.Header[key] = values
+	}
+	authorized.Header.Set("Authorization", "token="+token)
+	return t.base.RoundTrip(authorized)
+}
@@ -0,0 +1,87 @@
+package providers
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	marathon "github.com/gambol99/go-marathon"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarathonProviderToSendTheLatestDCOSToken(t *testing.T) {
+	tokens := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens <- r.Header.Get("Authorization")
+		w.Write([]byte("pong"))
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "gotlb")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "token")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("first\n"), 0600))
+
+	m := NewMarathonProvider(server.URL, false, false, 0, 0, 0, 0, FileToken(path), nil).(*MarathonProvider)
+	client, err := marathon.NewClient(m.clientConfig())
+	assert.NoError(t, err)
+	_, err = client.Ping()
+	assert.NoError(t, err)
+	assert.Equal(t, "token=first", <-tokens)
+
+	// the refreshed one is picked up by the next request
+	assert.NoError(t, ioutil.WriteFile(path, []byte("second\n"), 0600))
+	_, err = client.Ping()
+	assert.NoError(t, err)
+	assert.Equal(t, "token=second", <-tokens)
+
+	// and the request isn't sent without it
+	os.Remove(path)
+	_, err = client.Ping()
+	assert.Error(t, err)
+	assert.Len(t, tokens, 0)
+}
+
+func TestMarathonProviderToVerifyMarathonWithTheCA(t *testing.T) {
+	tokens := make(chan string, 1)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens <- r.Header.Get("Authorization")
+		w.Write([]byte("pong"))
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "gotlb")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	assert.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+
+	ping := func(tlsConfig *tls.Config) error {
+		m := NewMarathonProvider(server.URL, false, false, 0, 0, 0, 0, StaticToken("secret"), tlsConfig).(*MarathonProvider)
+		client, err := marathon.NewClient(m.clientConfig())
+		assert.NoError(t, err)
+		_, err = client.Ping()
+		return err
+	}
+	withCA, err := NewMarathonTLSConfig(caFile, false)
+	assert.NoError(t, err)
+	assert.NoError(t, ping(withCA))
+	// along with the token
+	assert.Equal(t, "token=secret", <-tokens)
+
+	assert.Error(t, ping(nil))
+	insecure, err := NewMarathonTLSConfig("", true)
+	assert.NoError(t, err)
+	assert.NoError(t, ping(insecure))
+	<-tokens
+
+	_, err = NewMarathonTLSConfig(filepath.Join(dir, "token"), false)
+	assert.Error(t, err)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "empty.pem"), []byte("not a certificate"), 0600))
+	_, err = NewMarathonTLSConfig(filepath.Join(dir, "empty.pem"), false)
+	assert.Error(t, err)
+}
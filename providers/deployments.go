@@ -0,0 +1,165 @@
+package providers
+
+import (
+	"sort"
+
+	"github.com/ashwanthkumar/gotlb/types"
+	marathon "github.com/gambol99/go-marathon"
+)
+
+// the action of a step that replaces the tasks of an app with the ones of it's new version
+const restartApplication = "RestartApplication"
+
+// deployment is a rolling deploy of an app we're draining the old tasks of
+type deployment struct {
+	// id of the plan, the success / failure of the deployment only has it
+	plan string
+	// version of the app the deployment is rolling out
+	version string
+	// old tasks we've pulled from the rotation, by their task id
+	drained map[string]*types.BackendInfo
+}
+
+// startDeployment notes the apps the current step of the deployment restarts,
+// so their old tasks are drained as the new ones come up
+func (m *MarathonProvider) startDeployment(step *marathon.StepActions, plan *marathon.DeploymentPlan) {
+	if step == nil || plan == nil {
+		return
+	}
+	for _, action := range step.Actions {
+		name := action.Action
+		if name == "" {
+			name = action.Type
+		}
+		if name != restartApplication || !m.containsApp(action.App) {
+			continue
+		}
+		version := appVersion(plan.Target, action.App)
+		if current, deploying := m.deployments[action.App]; deploying && current.plan == plan.ID && current.version == version {
+			continue
+		}
+		m.deployments[action.App] = &deployment{
+			plan:    plan.ID,
+			version: version,
+			drained: make(map[string]*types.BackendInfo),
+		}
+	}
+}
+
+// appVersion finds the version of the app in the group, "" when it's not there
+func appVersion(group *marathon.Group, appId string) string {
+	if group == nil {
+		return ""
+	}
+	for _, app := range group.Apps {
+		if app != nil && app.ID == appId {
+			return app.Version
+		}
+	}
+	for _, child := range group.Groups {
+		if version := appVersion(child, appId); version != "" {
+			return version
+		}
+	}
+	return ""
+}
+
+// trackTask remembers the running task, so it can be drained when the app is
+// deployed. A task of the new version of an app that's being deployed drains
+// one of the old ones, the way marathon kills one old task for every new one.
+// Returns false if we're asked to stop.
+func (m *MarathonProvider) trackTask(taskId string, backend *types.BackendInfo) bool {
+	if !m.drainDeployments {
+		return true
+	}
+	tasks, present := m.running[backend.AppId]
+	if !present {
+		tasks = make(map[string]*types.BackendInfo)
+		m.running[backend.AppId] = tasks
+	}
+	_, seen := tasks[taskId]
+	tasks[taskId] = backend
+
+	d, deploying := m.deployments[backend.AppId]
+	if seen || !deploying || d.version == "" || versionOf(backend) != d.version {
+		return true
+	}
+	taskIds := make([]string, 0, len(tasks))
+	for id, task := range tasks {
+		if _, drained := d.drained[id]; !drained && versionOf(task) != d.version {
+			taskIds = append(taskIds, id)
+		}
+	}
+	if len(taskIds) == 0 {
+		return true
+	}
+	// in the same order on every run, so the choice is predictable
+	sort.Strings(taskIds)
+	old := tasks[taskIds[0]]
+	if !m.sendBackend(m.removeBackend, old) {
+		return false
+	}
+	d.drained[taskIds[0]] = old
+	m.events.record(backendRemoved, old.AppId, "Draining %s of %s ahead of the deployment %s\n", old.Node, old.AppId, d.plan)
+	return true
+}
+
+func versionOf(backend *types.BackendInfo) string {
+	version, _ := backend.Meta(types.MetaVersion)
+	return version
+}
+
+// forgetTask stops tracking the task once it's gone, returns true if we had
+// drained it so it's already out of the rotation
+func (m *MarathonProvider) forgetTask(appId, taskId string) bool {
+	if !m.drainDeployments {
+		return false
+	}
+	delete(m.running[appId], taskId)
+	if d, deploying := m.deployments[appId]; deploying {
+		if _, drained := d.drained[taskId]; drained {
+			delete(d.drained, taskId)
+			return true
+		}
+	}
+	return false
+}
+
+// endDeployment forgets the apps of the deployment. When it failed the old
+// tasks we drained are still running, so they're put back in the rotation.
+// Returns false if we're asked to stop.
+func (m *MarathonProvider) endDeployment(plan string, failed bool) bool {
+	for appId, d := range m.deployments {
+		if d.plan != plan {
+			continue
+		}
+		delete(m.deployments, appId)
+		m.touch(appId)
+		if !failed {
+			continue
+		}
+		for _, backend := range d.drained {
+			if !m.sendBackend(m.addBackend, backend) {
+				return false
+			}
+			m.events.record(backendAdded, appId, "Putting back %s of %s as the deployment %s failed\n", backend.Node, appId, plan)
+		}
+	}
+	return true
+}
+
+// isDrained tells if we've pulled the task of the app for the deployment
+func (m *MarathonProvider) isDrained(appId, taskId string) bool {
+	d, deploying := m.deployments[appId]
+	if !deploying {
+		return false
+	}
+	_, drained := d.drained[taskId]
+	return drained
+}
+
+// forgetApp drops the tasks and the deployment of an app we've dropped
+func (m *MarathonProvider) forgetApp(appId string) {
+	delete(m.running, appId)
+	delete(m.deployments, appId)
+}
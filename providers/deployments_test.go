@@ -0,0 +1,107 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/ashwanthkumar/gotlb/types"
+	marathon "github.com/gambol99/go-marathon"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarathonProviderToDrainAnOldTaskForEveryNewOneOfTheDeployment(t *testing.T) {
+	m, addBackend, removeBackend := createDrainingProvider()
+	assert.True(t, m.handleStatusUpdate(nil, runningTask("old-1", "10.0.0.1", "v1")))
+	assert.True(t, m.handleStatusUpdate(nil, runningTask("old-2", "10.0.0.2", "v1")))
+	assert.Len(t, addBackend, 2)
+	<-addBackend
+	<-addBackend
+
+	m.startDeployment(restartStep("/app"), deploymentPlan("plan-1", "/app", "v2"))
+	assert.True(t, m.handleStatusUpdate(nil, runningTask("new-1", "10.0.0.3", "v2")))
+	assert.Equal(t, "10.0.0.3:31000", (<-addBackend).Node)
+	assert.Equal(t, "10.0.0.1:31000", (<-removeBackend).Node)
+	// the same update again doesn't drain another one
+	assert.True(t, m.handleStatusUpdate(nil, runningTask("new-1", "10.0.0.3", "v2")))
+	<-addBackend
+	assert.Len(t, removeBackend, 0)
+
+	// marathon killing the drained task doesn't remove it again
+	failed := runningTask("old-1", "10.0.0.1", "v1")
+	failed.TaskStatus = "TASK_FAILED"
+	assert.True(t, m.handleStatusUpdate(nil, failed))
+	assert.Len(t, removeBackend, 0)
+
+	assert.True(t, m.endDeployment("plan-1", false))
+	assert.Len(t, addBackend, 0)
+	assert.Empty(t, m.deployments)
+}
+
+func TestMarathonProviderToPutTheDrainedTasksBackWhenTheDeploymentFails(t *testing.T) {
+	m, addBackend, removeBackend := createDrainingProvider()
+	assert.True(t, m.handleStatusUpdate(nil, runningTask("old-1", "10.0.0.1", "v1")))
+	<-addBackend
+
+	m.startDeployment(restartStep("/app"), deploymentPlan("plan-1", "/app", "v2"))
+	assert.True(t, m.handleStatusUpdate(nil, runningTask("new-1", "10.0.0.2", "v2")))
+	<-addBackend
+	assert.Equal(t, "10.0.0.1:31000", (<-removeBackend).Node)
+
+	assert.True(t, m.endDeployment("plan-2", true))
+	assert.Len(t, addBackend, 0)
+	assert.True(t, m.endDeployment("plan-1", true))
+	assert.Equal(t, "10.0.0.1:31000", (<-addBackend).Node)
+}
+
+func TestMarathonProviderToNotDrainWithoutTheFlag(t *testing.T) {
+	m, addBackend, removeBackend := createDrainingProvider()
+	m.drainDeployments = false
+	assert.True(t, m.handleStatusUpdate(nil, runningTask("old-1", "10.0.0.1", "v1")))
+	m.startDeployment(restartStep("/app"), deploymentPlan("plan-1", "/app", "v2"))
+	assert.True(t, m.handleStatusUpdate(nil, runningTask("new-1", "10.0.0.2", "v2")))
+	assert.Len(t, addBackend, 2)
+	assert.Len(t, removeBackend, 0)
+}
+
+func TestAppVersionToLookIntoTheChildGroups(t *testing.T) {
+	group := &marathon.Group{Groups: []*marathon.Group{{Apps: []*marathon.Application{{ID: "/team/app", Version: "v2"}}}}}
+	assert.Equal(t, "v2", appVersion(group, "/team/app"))
+	assert.Equal(t, "", appVersion(group, "/other"))
+	assert.Equal(t, "", appVersion(nil, "/team/app"))
+}
+
+func createDrainingProvider() (*MarathonProvider, chan *types.BackendInfo, chan *types.BackendInfo) {
+	m := NewMarathonProvider("http://marathon:8080", false, true, 0, 0, 0, 0, nil, nil).(*MarathonProvider)
+	m.appApp("/app", map[string]string{types.TLB_ENABLED: "true"})
+	addBackend, removeBackend := make(chan *types.BackendInfo, 5), make(chan *types.BackendInfo, 5)
+	m.addBackend, m.removeBackend = addBackend, removeBackend
+	m.events = newEventLog(m.Name(), 0)
+	return m, addBackend, removeBackend
+}
+
+func runningTask(taskId, ip, version string) *marathon.EventStatusUpdate {
+	return &marathon.EventStatusUpdate{
+		AppID:       "/app",
+		TaskID:      taskId,
+		TaskStatus:  "TASK_RUNNING",
+		Version:     version,
+		IPAddresses: []*marathon.IPAddress{{IPAddress: ip}},
+		Ports:       []int{31000},
+	}
+}
+
+func restartStep(appId string) *marathon.StepActions {
+	step := &marathon.StepActions{}
+	step.Actions = append(step.Actions, struct {
+		Action string `json:"action"`
+		Type   string `json:"type"`
+		App    string `json:"app"`
+	}{Action: restartApplication, App: appId})
+	return step
+}
+
+func deploymentPlan(id, appId, version string) *marathon.DeploymentPlan {
+	return &marathon.DeploymentPlan{
+		ID:     id,
+		Target: &marathon.Group{Apps: []*marathon.Application{{ID: appId, Version: version}}},
+	}
+}
@@ -0,0 +1,88 @@
+package providers
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+type eventKind int
+
+const (
+	backendAdded eventKind = iota
+	backendRemoved
+	appUpdated
+	appDropped
+	numEventKinds
+)
+
+// how each kind of event reads in the summary, with the number of events and apps
+var eventSummaries = [numEventKinds]string{
+	backendAdded:   "added %d backends across %d apps",
+	backendRemoved: "removed %d backends across %d apps",
+	appUpdated:     "updated %d specs of %d apps",
+	appDropped:     "dropped %d specs of %d apps",
+}
+
+// eventLog logs the events of a provider. A burst of them (like a mass
+// deploy) would log a line per backend, so unless the interval is 0 we
+// only count them and log a summary once every interval.
+type eventLog struct {
+	name     string
+	interval time.Duration
+	since    time.Time
+	counts   [numEventKinds]int
+	apps     [numEventKinds]map[string]bool
+	printf   func(format string, args ...interface{})
+}
+
+func newEventLog(name string, interval time.Duration) *eventLog {
+	e := &eventLog{
+		name:     name,
+		interval: interval,
+		since:    time.Now(),
+		printf:   log.Printf,
+	}
+	e.reset()
+	return e
+}
+
+// record logs the event right away when we're not summarizing, else counts it
+func (e *eventLog) record(kind eventKind, appId string, format string, args ...interface{}) {
+	if e.interval <= 0 {
+		e.printf(format, args...)
+		return
+	}
+	e.counts[kind]++
+	e.apps[kind][appId] = true
+}
+
+// tick logs the summary if the interval has passed since the last one
+func (e *eventLog) tick(now time.Time) {
+	if e.interval > 0 && now.Sub(e.since) >= e.interval {
+		e.flush(now)
+	}
+}
+
+// flush logs the summary of the events since the last one, if there were any
+func (e *eventLog) flush(now time.Time) {
+	var parts []string
+	for kind, count := range e.counts {
+		if count > 0 {
+			parts = append(parts, fmt.Sprintf(eventSummaries[kind], count, len(e.apps[kind])))
+		}
+	}
+	if len(parts) > 0 {
+		e.printf("[INFO] %s %s in the last %v\n", e.name, strings.Join(parts, ", "), now.Sub(e.since).Round(time.Second))
+	}
+	e.since = now
+	e.reset()
+}
+
+func (e *eventLog) reset() {
+	for kind := range e.counts {
+		e.counts[kind] = 0
+		e.apps[kind] = make(map[string]bool)
+	}
+}
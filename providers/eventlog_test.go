@@ -0,0 +1,45 @@
+package providers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventLogToSummarizeTheEventsOfAnInterval(t *testing.T) {
+	events, lines := capturedEventLog(time.Minute)
+	start := events.since
+	for i := 0; i < 100; i++ {
+		events.record(backendAdded, fmt.Sprintf("/app-%d", i%3), "Adding backend %d\n", i)
+	}
+	events.record(backendRemoved, "/app-0", "Removing backend\n")
+	events.tick(start.Add(30 * time.Second))
+	assert.Empty(t, *lines)
+
+	events.tick(start.Add(time.Minute))
+	assert.Equal(t, []string{"[INFO] marathon(test) added 100 backends across 3 apps, removed 1 backends across 1 apps in the last 1m0s\n"}, *lines)
+
+	// nothing to say for a quiet interval
+	events.tick(start.Add(2 * time.Minute))
+	assert.Len(t, *lines, 1)
+}
+
+func TestEventLogToLogEveryEventWithoutAnInterval(t *testing.T) {
+	events, lines := capturedEventLog(0)
+	events.record(appUpdated, "/app", "Adding new app - %s\n", "/app")
+	events.record(backendAdded, "/app", "Adding backend for %s as %v\n", "/app", "10.0.0.1:31000")
+	events.flush(time.Now())
+
+	assert.Equal(t, []string{"Adding new app - /app\n", "Adding backend for /app as 10.0.0.1:31000\n"}, *lines)
+}
+
+func capturedEventLog(interval time.Duration) (*eventLog, *[]string) {
+	var lines []string
+	events := newEventLog("marathon(test)", interval)
+	events.printf = func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+	return events, &lines
+}
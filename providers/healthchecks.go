@@ -0,0 +1,70 @@
+package providers
+
+import (
+	"log"
+
+	marathon "github.com/gambol99/go-marathon"
+)
+
+// failHealthCheck pulls the tasks of the app whose marathon health check
+// failed from the rotation, without waiting for marathon to kill them. The
+// event of go-marathon doesn't have the task, so it's the tasks with a check
+// marathon finds not alive. Marathon sends the event for every failed check,
+// a task is removed only on the first one. Returns false if we're asked to stop.
+func (m *MarathonProvider) failHealthCheck(client marathon.Marathon, appId string) bool {
+	tasks, err := client.Tasks(appId)
+	if err != nil {
+		log.Printf("[WARN] Skipping the failed health check of %s, unable to get it's tasks - %v\n", appId, err)
+		return true
+	}
+	for _, task := range tasks.Tasks {
+		if _, pulled := m.unhealthy[task.ID]; pulled || !failsHealthCheck(task) {
+			continue
+		}
+		backend, complete := m.createBackendInfo(appId, task.Host, task.Version, task.IPAddresses, task.Ports)
+		if !complete {
+			log.Printf("[WARN] Skipping the failed health check of task %s of %s, it's address isn't known yet\n", task.ID, appId)
+			continue
+		}
+		if !m.sendBackend(m.removeBackend, backend) {
+			return false
+		}
+		m.unhealthy[task.ID] = backend
+		m.events.record(backendRemoved, appId, "Removing backend for %s as %v failed it's health check\n", appId, backend.Node)
+	}
+	return true
+}
+
+// failsHealthCheck tells if one of the health checks of the task is not alive
+func failsHealthCheck(task marathon.Task) bool {
+	for _, result := range task.HealthCheckResults {
+		if result != nil && !result.Alive {
+			return true
+		}
+	}
+	return false
+}
+
+// changeHealth puts the task we pulled for it's failed health check back in
+// the rotation once marathon finds it alive again. Returns false if we're
+// asked to stop.
+func (m *MarathonProvider) changeHealth(changed *marathon.EventHealthCheckChanged) bool {
+	backend, pulled := m.unhealthy[changed.TaskID]
+	if !pulled || !changed.Alive {
+		return true
+	}
+	delete(m.unhealthy, changed.TaskID)
+	if !m.sendBackend(m.addBackend, backend) {
+		return false
+	}
+	m.events.record(backendAdded, changed.AppID, "Adding backend for %s as %v is healthy again\n", changed.AppID, backend.Node)
+	return true
+}
+
+// forgetHealth forgets the failed health check of the task, returns true if
+// we had pulled it for one - it's already out of the rotation
+func (m *MarathonProvider) forgetHealth(taskId string) bool {
+	_, pulled := m.unhealthy[taskId]
+	delete(m.unhealthy, taskId)
+	return pulled
+}
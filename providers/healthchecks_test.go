@@ -0,0 +1,86 @@
+package providers
+
+import (
+	"encoding/json"
+	"testing"
+
+	marathon "github.com/gambol99/go-marathon"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarathonProviderToPullTheTaskThatFailedItsHealthCheck(t *testing.T) {
+	m, addBackend, removeBackend := createDrainingProvider()
+	client := &fakeMarathon{tasks: []marathon.Task{
+		failingTask("t1", "10.0.0.1"),
+		{ID: "t2", IPAddresses: []*marathon.IPAddress{{IPAddress: "10.0.0.2"}}, Ports: []int{31000}, HealthCheckResults: []*marathon.HealthCheckResult{{Alive: true}}},
+	}}
+	assert.True(t, m.failHealthCheck(client, "/app"))
+	assert.Equal(t, "10.0.0.1:31000", (<-removeBackend).Node)
+	assert.Len(t, removeBackend, 0)
+	// marathon sends one for every failed check
+	assert.True(t, m.failHealthCheck(client, "/app"))
+	assert.Len(t, removeBackend, 0)
+
+	// it's back once marathon finds it alive
+	assert.True(t, m.changeHealth(&marathon.EventHealthCheckChanged{AppID: "/app", TaskID: "t1", Alive: false}))
+	assert.Len(t, addBackend, 0)
+	assert.True(t, m.changeHealth(&marathon.EventHealthCheckChanged{AppID: "/app", TaskID: "t1", Alive: true}))
+	assert.Equal(t, "10.0.0.1:31000", (<-addBackend).Node)
+	assert.True(t, m.changeHealth(&marathon.EventHealthCheckChanged{AppID: "/app", TaskID: "t1", Alive: true}))
+	assert.Len(t, addBackend, 0)
+}
+
+func TestMarathonProviderToNotRemoveTheUnhealthyTaskAgainWhenItFails(t *testing.T) {
+	m, _, removeBackend := createDrainingProvider()
+	client := &fakeMarathon{tasks: []marathon.Task{failingTask("t1", "10.0.0.1")}}
+	assert.True(t, m.failHealthCheck(client, "/app"))
+	<-removeBackend
+
+	failed := runningTask("t1", "10.0.0.1", "v1")
+	failed.TaskStatus = "TASK_FAILED"
+	assert.True(t, m.handleStatusUpdate(nil, failed))
+	assert.Len(t, removeBackend, 0)
+	assert.Empty(t, m.unhealthy)
+
+	// nor the ones marathon doesn't have a failed check of
+	client.tasks = []marathon.Task{{ID: "t2", IPAddresses: []*marathon.IPAddress{{IPAddress: "10.0.0.2"}}, Ports: []int{31000}}}
+	assert.True(t, m.failHealthCheck(client, "/app"))
+	assert.Len(t, removeBackend, 0)
+}
+
+func TestMarathonProviderToHandleTheFailedHealthCheckEventOfMarathon(t *testing.T) {
+	m, _, removeBackend := createDrainingProvider()
+	client := &fakeMarathon{tasks: []marathon.Task{failingTask("t1", "10.0.0.1")}}
+	// decoded the way the events stream of go-marathon does
+	event, err := marathon.GetEvent("failed_health_check_event")
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal([]byte(`{"eventType": "failed_health_check_event", "appId": "/app", "taskId": "t1",
+		"healthCheck": {"protocol": "TCP", "portIndex": 0, "maxConsecutiveFailures": 3}, "timestamp": "2017-01-02T10:00:00.000Z"}`), event.Event))
+	assert.True(t, m.handleEvent(client, event))
+	assert.Equal(t, "10.0.0.1:31000", (<-removeBackend).Node)
+
+	// or of an app we don't know
+	event.Event.(*marathon.EventFailedHealthCheck).AppID = "/other"
+	assert.True(t, m.handleEvent(client, event))
+	assert.Len(t, removeBackend, 0)
+}
+
+// failingTask is a task of /app whose health check marathon finds not alive
+func failingTask(taskId, ip string) marathon.Task {
+	return marathon.Task{
+		ID:                 taskId,
+		IPAddresses:        []*marathon.IPAddress{{IPAddress: ip}},
+		Ports:              []int{31000},
+		HealthCheckResults: []*marathon.HealthCheckResult{{Alive: false, ConsecutiveFailures: 3, TaskID: taskId}},
+	}
+}
+
+// fakeMarathon answers the tasks of the apps, the rest of marathon.Marathon isn't implemented
+type fakeMarathon struct {
+	marathon.Marathon
+	tasks []marathon.Task
+}
+
+func (f *fakeMarathon) Tasks(appId string) (*marathon.Tasks, error) {
+	return &marathon.Tasks{Tasks: f.tasks}, nil
+}
@@ -1,11 +1,18 @@
 package providers
 
 import (
-	"fmt"
+	"crypto/tls"
+	"errors"
 	"log"
+	"net"
+	"net/http"
 	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/ashwanthkumar/golang-utils/maps"
 	"github.com/ashwanthkumar/gotlb/types"
 	marathon "github.com/gambol99/go-marathon"
 )
@@ -18,31 +25,103 @@ type MarathonProvider struct {
 	appUpdate     chan<- *types.AppInfo
 	dropApp       chan<- *types.AppInfo
 	stopMe        <-chan bool
+	done          *sync.WaitGroup
 	apps          map[string]Labels
 
 	marathonHost string
+	// basic auth credentials of marathon, taken out of the URL so they're never logged
+	user, password string
+	// DC/OS token sent to marathon instead, nil when we don't send one
+	dcosToken TokenSource
+	// TLS config of an https marathon, nil uses the one of the default client
+	tlsConfig *tls.Config
+	// re-query marathon for the tasks that come up without their address
+	requeryTasks bool
+	// interval of the summaries of the events, 0 logs every event
+	logInterval time.Duration
+	events      *eventLog
+	// closed once the initial scan of the apps is sent
+	scanned chan bool
+	// max time we wait for the initial scan before going ahead with the events, 0 waits forever
+	scanTimeout time.Duration
+	// apps the events have touched while the scan is late, nil when it's not
+	touched map[string]bool
+	// max time the events stream can go quiet before we listen again, 0 waits forever
+	eventsTimeout time.Duration
+	// drain the old tasks of an app while it's deployed, before marathon kills them
+	drainDeployments bool
+	// running tasks and the deployments of the apps, only when we drain them
+	running     map[string]map[string]*types.BackendInfo
+	deployments map[string]*deployment
+	// backends of the tasks we pulled for their failed health checks, by the task
+	unhealthy map[string]*types.BackendInfo
+	// backends we've added by the app and the node, for the scans to compare with
+	added map[string]map[string]*types.BackendInfo
+	// interval at which all the apps are scanned again, 0 scans them only on startup
+	resyncInterval time.Duration
 }
 
 // NewMarathonProvider creates a new marathon based provider for GoTLB to discover
-// new backends for the TCP server dynamically directly from Marathon's Event bus
-func NewMarathonProvider(marathonHost string) Provider {
+// new backends for the TCP server dynamically directly from Marathon's Event bus.
+// With requeryTasks, a running task whose status update doesn't have it's address
+// yet is looked up again from marathon instead of being skipped. The added /
+// removed backends and apps are logged as a summary once every logInterval,
+// or one by one when it's 0. If the initial scan of the apps takes longer
+// than scanTimeout we go ahead with the events and add the apps once they're here.
+// With drainDeployments, the old tasks of an app that's restarted by a deployment
+// are pulled from the rotation as the new ones come up, before marathon kills them.
+// An events stream that closes, or goes quiet for eventsTimeout, is listened to
+// again and the apps are scanned for what we've missed. They're also scanned
+// every resyncInterval when it's more than 0, for the events we might have
+// missed without noticing. The user and the password of the URL, if any, are
+// sent as the basic auth of marathon, and the token of dcosToken (when it's not
+// nil) as the Authorization header the admin router of DC/OS asks for. An https
+// marathon is verified with tlsConfig when it's not nil, see NewMarathonTLSConfig.
+func NewMarathonProvider(marathonHost string, requeryTasks, drainDeployments bool, logInterval, scanTimeout, eventsTimeout, resyncInterval time.Duration, dcosToken TokenSource, tlsConfig *tls.Config) Provider {
+	marathonHost, user, password := splitCredentials(marathonHost)
 	return &MarathonProvider{
-		marathonHost: marathonHost,
-		apps:         make(map[string]Labels),
+		marathonHost:     marathonHost,
+		user:             user,
+		password:         password,
+		dcosToken:        dcosToken,
+		tlsConfig:        tlsConfig,
+		requeryTasks:     requeryTasks,
+		drainDeployments: drainDeployments,
+		logInterval:      logInterval,
+		scanTimeout:      scanTimeout,
+		eventsTimeout:    eventsTimeout,
+		resyncInterval:   resyncInterval,
+		apps:             make(map[string]Labels),
+		scanned:          make(chan bool),
+		running:          make(map[string]map[string]*types.BackendInfo),
+		deployments:      make(map[string]*deployment),
+		unhealthy:        make(map[string]*types.BackendInfo),
+		added:            make(map[string]map[string]*types.BackendInfo),
 	}
 }
 
+func (m *MarathonProvider) Scanned() <-chan bool {
+	return m.scanned
+}
+
+func (m *MarathonProvider) Name() string {
+	return "marathon(" + m.marathonHost + ")"
+}
+
 func (m *MarathonProvider) Provide(
 	addBackend chan<- *types.BackendInfo,
 	removeBackend chan<- *types.BackendInfo,
 	appUpdate chan<- *types.AppInfo,
 	dropApp chan<- *types.AppInfo,
-	stop <-chan bool) error {
+	stop <-chan bool,
+	done *sync.WaitGroup) error {
 	m.addBackend = addBackend
 	m.removeBackend = removeBackend
 	m.appUpdate = appUpdate
 	m.dropApp = dropApp
 	m.stopMe = stop
+	m.done = done
+	m.events = newEventLog(m.Name(), m.logInterval)
 	log.Println("Starting Marathon Provider on " + m.marathonHost)
 	go m.start()
 	log.Println("Marathon Provider Started and configured to " + m.marathonHost)
@@ -50,92 +129,478 @@ func (m *MarathonProvider) Provide(
 }
 
 func (m *MarathonProvider) start() {
+	defer m.done.Done()
+	client, connected := m.connect(m.clientConfig(), marathon.NewClient)
+	if !connected {
+		return
+	}
+
+	// Scan through all the apps on starting up
+	fetched := m.fetchAllApps(client)
+	var timeout <-chan time.Time
+	if m.scanTimeout > 0 {
+		timer := time.NewTimer(m.scanTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case apps := <-fetched:
+		fetched = nil
+		if !m.scanAllApps(apps) {
+			return
+		}
+	case <-timeout:
+		log.Printf("[WARN] Marathon didn't return all the applications in %v, going ahead with the events until it does\n", m.scanTimeout)
+		m.touched = make(map[string]bool)
+	case <-m.stopMe:
+		return
+	}
+	close(m.scanned)
+
+	filter := marathon.EventIDAPIRequest | marathon.EventIDStatusUpdate | marathon.EventIDFailedHealthCheck | marathon.EventIDChangedHealthCheck | marathon.EventIDAppTerminated
+	if m.drainDeployments {
+		filter |= marathon.EventIDDeploymentInfo | marathon.EventIDDeploymentSuccess | marathon.EventIDDeploymentFailed
+	}
+	var backoff time.Duration
+	lost := false
+	for {
+		eventsChannel, err := client.AddEventsListener(filter)
+		if err == nil {
+			if lost {
+				// the events we missed in between
+				m.touched = make(map[string]bool)
+				fetched = m.fetchAllApps(client)
+			}
+			listening := time.Now()
+			lost = m.watch(client, eventsChannel, fetched)
+			client.RemoveEventsListener(eventsChannel)
+			if !lost {
+				return
+			}
+			fetched = nil
+			if time.Since(listening) > maxRetryBackoff {
+				backoff = 0
+			}
+			err = errors.New("lost the events stream")
+		}
+		backoff = retryBackoff(backoff)
+		log.Printf("[WARN] Unable to listen to the events of marathon, retrying in %v - %v\n", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-m.stopMe:
+			return
+		}
+	}
+}
+
+// clientConfig returns the config of the client of marathon. The HTTP client
+// is used for the API calls and the events stream alike.
+func (m *MarathonProvider) clientConfig() marathon.Config {
 	config := marathon.NewDefaultConfig()
 	config.URL = m.marathonHost
 	config.EventsTransport = marathon.EventsTransportSSE
-	client, err := marathon.NewClient(config)
-	if err != nil {
-		log.Fatalf("Unable to create marathon client - %v\n", err)
+	config.HTTPBasicAuthUser = m.user
+	config.HTTPBasicPassword = m.password
+	transport := http.DefaultTransport
+	if m.tlsConfig != nil {
+		transport = tlsTransport(m.tlsConfig)
 	}
+	if m.dcosToken != nil {
+		// rather than the DCOSToken of the config, which is fixed once the
+		// client is created
+		transport = &tokenTransport{token: m.dcosToken, base: transport}
+	}
+	if transport != http.DefaultTransport {
+		config.HTTPClient = &http.Client{Transport: transport}
+	}
+	return config
+}
 
-	// Scan through all the apps on starting up
-	m.scanAllApps(client)
+// splitCredentials takes the user and the password out of the URL of marathon
+func splitCredentials(marathonHost string) (string, string, string) {
+	parsed, err := url.Parse(marathonHost)
+	if err != nil || parsed.User == nil {
+		return marathonHost, "", ""
+	}
+	user := parsed.User.Username()
+	password, _ := parsed.User.Password()
+	parsed.User = nil
+	return parsed.String(), user, password
+}
 
-	eventsChannel, err := client.AddEventsListener(marathon.EventIDAPIRequest | marathon.EventIDStatusUpdate | marathon.EventIDFailedHealthCheck | marathon.EventIDAppTerminated)
-	if err != nil {
-		log.Fatalf("Unable to create events listener - %v\n", err)
+// connect creates the client of marathon, retrying until marathon is there
+// or we're asked to stop. Returns false if we are.
+func (m *MarathonProvider) connect(config marathon.Config, newClient func(marathon.Config) (marathon.Marathon, error)) (marathon.Marathon, bool) {
+	var backoff time.Duration
+	for {
+		client, err := newClient(config)
+		if err == nil {
+			return client, true
+		}
+		backoff = retryBackoff(backoff)
+		log.Printf("[WARN] Unable to create the client of %s, retrying in %v - %v\n", m.marathonHost, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-m.stopMe:
+			return nil, false
+		}
 	}
+}
+
+// maxRetryBackoff is the longest we wait before trying marathon again
+const maxRetryBackoff = time.Minute
 
-	running := true
-	for running {
+// retryBackoff doubles the wait before we try marathon again, from a second
+// upto maxRetryBackoff
+func retryBackoff(backoff time.Duration) time.Duration {
+	if backoff == 0 {
+		return time.Second
+	}
+	if backoff *= 2; backoff > maxRetryBackoff {
+		return maxRetryBackoff
+	}
+	return backoff
+}
+
+// watch handles the events of marathon until we're asked to stop, along with
+// the scan that's still on it's way. Returns true when the stream is closed or
+// has been quiet for eventsTimeout, and false when we're asked to stop.
+func (m *MarathonProvider) watch(client marathon.Marathon, eventsChannel marathon.EventsChannel, fetched chan *marathon.Applications) bool {
+	var summaries <-chan time.Time
+	if m.logInterval > 0 {
+		ticker := time.NewTicker(m.logInterval)
+		defer ticker.Stop()
+		summaries = ticker.C
+	}
+	var quiet <-chan time.Time
+	var timer *time.Timer
+	if m.eventsTimeout > 0 {
+		timer = time.NewTimer(m.eventsTimeout)
+		defer timer.Stop()
+		quiet = timer.C
+	}
+	var resyncs <-chan time.Time
+	if m.resyncInterval > 0 {
+		ticker := time.NewTicker(m.resyncInterval)
+		defer ticker.Stop()
+		resyncs = ticker.C
+	}
+	for {
 		select {
-		case event := <-eventsChannel:
-			switch event.ID {
-			case marathon.EventIDStatusUpdate:
-				update := event.Event.(*marathon.EventStatusUpdate)
-				// check if the update is for known app
-				knownApp := m.containsApp(update.AppID)
-
-				if knownApp && update.TaskStatus == "TASK_FAILED" {
-					m.removeBackend <- m.createBackendInfo(update.AppID, update.IPAddresses, update.Ports)
-				} else if knownApp && update.TaskStatus == "TASK_RUNNING" {
-					m.addBackend <- m.createBackendInfo(update.AppID, update.IPAddresses, update.Ports)
-				}
-				// fmt.Printf("app=%s, id=%s, slaveId=%s, status=%s, host:ip=%s:%d\n", update.AppID, update.TaskID, update.SlaveID, update.TaskStatus, update.IPAddresses[0].IPAddress, update.Ports[0])
-			case marathon.EventIDAPIRequest:
-				app := event.Event.(*marathon.EventAPIRequest)
-				_, err := client.Application(app.AppDefinition.ID)
-				if err != nil {
-					log.Printf("[WARN] Unable to get application - %s - %v\n", app.AppDefinition.ID, err)
-					fmt.Printf("Deleted the App spec - %v\n", app)
-					// check if the update is for known app, only then propagate
-					knownApp := m.containsApp(app.AppDefinition.ID)
-					if knownApp {
-						// most likely the app was destroyed
-						m.dropApp <- &types.AppInfo{
-							AppId:  app.AppDefinition.ID,
-							Labels: *app.AppDefinition.Labels,
-						}
-					}
-				} else {
-					fmt.Printf("New / Updated the App spec - %v\n", app)
-					m.appUpdate <- &types.AppInfo{
-						AppId:  app.AppDefinition.ID,
-						Labels: *app.AppDefinition.Labels,
-					}
+		case apps := <-fetched:
+			fetched = nil
+			log.Printf("[INFO] Got all the applications from marathon, catching up with what's changed\n")
+			if !m.scanAllApps(apps) {
+				return false
+			}
+			m.touched = nil
+		case event, open := <-eventsChannel:
+			if !open {
+				log.Printf("[WARN] The events stream of marathon is closed\n")
+				return true
+			}
+			if !m.handleEvent(client, event) {
+				return false
+			}
+			if timer != nil {
+				if !timer.Stop() {
+					<-timer.C
 				}
+				timer.Reset(m.eventsTimeout)
+			}
+		case <-quiet:
+			log.Printf("[WARN] No events from marathon in %v\n", m.eventsTimeout)
+			return true
+		case <-resyncs:
+			// unless the last one is still on it's way
+			if fetched == nil {
+				m.touched = make(map[string]bool)
+				fetched = m.fetchAllApps(client)
 			}
+		case now := <-summaries:
+			m.events.tick(now)
 		case <-m.stopMe:
-			running = false
-			client.RemoveEventsListener(eventsChannel)
+			return false
 		}
 	}
 }
 
-func (m *MarathonProvider) scanAllApps(client marathon.Marathon) {
-	v := url.Values{}
-	v.Set("embed", "apps.tasks")
-	apps, err := client.Applications(v)
-	if err != nil {
-		log.Printf("[WARN] Initializing with all applications failed - %v\n", err)
+// handleEvent applies the event of marathon, returns false if we're asked to stop
+func (m *MarathonProvider) handleEvent(client marathon.Marathon, event *marathon.Event) bool {
+	switch event.ID {
+	case marathon.EventIDStatusUpdate:
+		update := event.Event.(*marathon.EventStatusUpdate)
+		m.touch(update.AppID)
+		// check if the update is for known app
+		knownApp := m.containsApp(update.AppID)
+
+		if knownApp && !m.handleStatusUpdate(client, update) {
+			return false
+		}
+		// fmt.Printf("app=%s, id=%s, slaveId=%s, status=%s, host:ip=%s:%d\n", update.AppID, update.TaskID, update.SlaveID, update.TaskStatus, update.IPAddresses[0].IPAddress, update.Ports[0])
+	case marathon.EventIDAPIRequest:
+		app := event.Event.(*marathon.EventAPIRequest)
+		m.touch(app.AppDefinition.ID)
+		_, err := client.Application(app.AppDefinition.ID)
+		if err != nil {
+			log.Printf("[WARN] Unable to get application - %s - %v\n", app.AppDefinition.ID, err)
+			// check if the update is for known app, only then propagate
+			// most likely the app was destroyed
+			if !m.dropKnownApp(app.AppDefinition.ID, "Deleted the App spec - %s\n") {
+				return false
+			}
+		} else if !m.updateApp(app.AppDefinition.ID, app.AppDefinition.Labels) {
+			return false
+		}
+	case marathon.EventIDFailedHealthCheck:
+		failed := event.Event.(*marathon.EventFailedHealthCheck)
+		m.touch(failed.AppID)
+		if m.containsApp(failed.AppID) && !m.failHealthCheck(client, failed.AppID) {
+			return false
+		}
+	case marathon.EventIDChangedHealthCheck:
+		changed := event.Event.(*marathon.EventHealthCheckChanged)
+		m.touch(changed.AppID)
+		if !m.changeHealth(changed) {
+			return false
+		}
+	case marathon.EventIDDeploymentInfo:
+		info := event.Event.(*marathon.EventDeploymentInfo)
+		m.startDeployment(info.CurrentStep, info.Plan)
+	case marathon.EventIDDeploymentSuccess:
+		m.endDeployment(event.Event.(*marathon.EventDeploymentSuccess).ID, false)
+	case marathon.EventIDDeploymentFailed:
+		if !m.endDeployment(event.Event.(*marathon.EventDeploymentFailed).ID, true) {
+			return false
+		}
+	case marathon.EventIDAppTerminated:
+		terminated := event.Event.(*marathon.EventAppTerminated)
+		m.touch(terminated.AppID)
+		if !m.dropKnownApp(terminated.AppID, "Dropping %s as it's terminated\n") {
+			return false
+		}
+	}
+	return true
+}
+
+// handleStatusUpdate adds the backend of a running task and removes the one of
+// a failed task, unless it's already drained or pulled for it's failed health
+// check. Returns false if we're asked to stop.
+func (m *MarathonProvider) handleStatusUpdate(client marathon.Marathon, update *marathon.EventStatusUpdate) bool {
+	switch update.TaskStatus {
+	case "TASK_FAILED":
+		// a task we've pulled already isn't removed again
+		unhealthy := m.forgetHealth(update.TaskID)
+		if m.forgetTask(update.AppID, update.TaskID) || unhealthy {
+			return true
+		}
+		if backend, complete := m.backendOfUpdate(client, update); complete {
+			if !m.sendBackend(m.removeBackend, backend) {
+				return false
+			}
+			m.events.record(backendRemoved, update.AppID, "Removing backend for %s as %v\n", update.AppID, backend.Node)
+		}
+	case "TASK_RUNNING":
+		if backend, complete := m.backendOfUpdate(client, update); complete {
+			if !m.sendBackend(m.addBackend, backend) {
+				return false
+			}
+			m.events.record(backendAdded, update.AppID, "Adding backend for %s as %v\n", update.AppID, backend.Node)
+			return m.trackTask(update.TaskID, backend)
+		}
+	case "TASK_KILLED", "TASK_FINISHED", "TASK_LOST", "TASK_ERROR", "TASK_GONE":
+		m.forgetHealth(update.TaskID)
+		m.forgetTask(update.AppID, update.TaskID)
+	}
+	return true
+}
+
+// sendBackend sends the backend unless we're asked to stop, returns false if we are.
+// It keeps track of the backends we've added, for the scans to compare with.
+func (m *MarathonProvider) sendBackend(to chan<- *types.BackendInfo, backend *types.BackendInfo) bool {
+	select {
+	case to <- backend:
+	case <-m.stopMe:
+		return false
+	}
+	if to == m.addBackend {
+		if m.added[backend.AppId] == nil {
+			m.added[backend.AppId] = make(map[string]*types.BackendInfo)
+		}
+		m.added[backend.AppId][backend.Node] = backend
 	} else {
-		for _, app := range apps.Apps {
-			if maps.GetBoolean(*app.Labels, types.TLB_ENABLED, false) {
-				log.Printf("Adding new app - %s\n", app.ID)
-				m.appUpdate <- &types.AppInfo{
-					AppId:  app.ID,
-					Labels: *app.Labels,
-				}
-				// add this app to the list of known apps
-				m.appApp(app.ID, *app.Labels)
-				for _, task := range app.Tasks {
-					backendInfo := m.createBackendInfo(app.ID, task.IPAddresses, task.Ports)
-					log.Printf("[DEBUG] Adding backend for %s as %v\n", app.ID, backendInfo.Node)
-					m.addBackend <- backendInfo
-				}
+		delete(m.added[backend.AppId], backend.Node)
+	}
+	return true
+}
+
+// sendApp sends the app unless we're asked to stop, returns false if we are
+func (m *MarathonProvider) sendApp(to chan<- *types.AppInfo, app *types.AppInfo) bool {
+	select {
+	case to <- app:
+		return true
+	case <-m.stopMe:
+		return false
+	}
+}
+
+// fetchAllApps gets all the apps with their tasks in the background, the
+// channel gets them once they're here or nil if it failed
+func (m *MarathonProvider) fetchAllApps(client marathon.Marathon) chan *marathon.Applications {
+	fetched := make(chan *marathon.Applications, 1)
+	go func() {
+		v := url.Values{}
+		v.Set("embed", "apps.tasks")
+		apps, err := client.Applications(v)
+		if err != nil {
+			log.Printf("[WARN] Initializing with all applications failed - %v\n", err)
+			apps = nil
+		}
+		fetched <- apps
+	}()
+	return fetched
+}
+
+// touch notes that an event changed the app while the scan is late, so the
+// scan doesn't bring back the older state of it
+func (m *MarathonProvider) touch(appId string) {
+	if m.touched != nil {
+		m.touched[appId] = true
+	}
+}
+
+// scanAllApps brings the apps and their backends in line with the scan of
+// marathon - the new ones are added, the ones that are gone are dropped and
+// the labels or the tasks that changed are sent. What's the same already is
+// not sent again. The apps the events have touched while the scan was on it's
+// way are skipped, the scan is older than them. Returns false if we're asked
+// to stop.
+func (m *MarathonProvider) scanAllApps(apps *marathon.Applications) bool {
+	if apps == nil {
+		return true
+	}
+	scanned := make(map[string]bool, len(apps.Apps))
+	for _, app := range apps.Apps {
+		scanned[app.ID] = true
+		if m.touched[app.ID] {
+			continue
+		}
+		labels := Labels{}
+		if app.Labels != nil {
+			labels = *app.Labels
+		}
+		known, changed := m.apps[app.ID]
+		if changed {
+			changed = !reflect.DeepEqual(known, labels)
+		}
+		if !m.containsApp(app.ID) && isEnabled(app.ID, labels) {
+			if !m.sendApp(m.appUpdate, &types.AppInfo{AppId: app.ID, Labels: labels}) {
+				return false
+			}
+			m.events.record(appUpdated, app.ID, "Adding new app - %s\n", app.ID)
+			m.appApp(app.ID, labels)
+		} else if changed && !m.updateApp(app.ID, app.Labels) {
+			return false
+		}
+		if m.containsApp(app.ID) && !m.scanTasks(app.ID, app.Tasks) {
+			return false
+		}
+	}
+	for appId := range m.apps {
+		if !scanned[appId] && !m.touched[appId] && !m.dropKnownApp(appId, "Dropping %s as it's gone from marathon\n") {
+			return false
+		}
+	}
+	// how much it changed, without waiting for the interval
+	m.events.flush(time.Now())
+	return true
+}
+
+// scanTasks adds the tasks of the app we haven't added yet (or whose backend
+// changed) and removes the ones we've added that aren't there anymore. The
+// tasks we've pulled for a deployment or their failed health check stay out.
+// Returns false if we're asked to stop.
+func (m *MarathonProvider) scanTasks(appId string, tasks []*marathon.Task) bool {
+	running := make(map[string]bool, len(tasks))
+	for _, task := range tasks {
+		backend, complete := m.createBackendInfo(appId, task.Host, task.Version, task.IPAddresses, task.Ports)
+		if !complete {
+			log.Printf("[WARN] Skipping task %s of %s, it's address isn't known yet\n", task.ID, appId)
+			continue
+		}
+		running[backend.Node] = true
+		if _, unhealthy := m.unhealthy[task.ID]; unhealthy || m.isDrained(appId, task.ID) {
+			continue
+		}
+		if added, present := m.added[appId][backend.Node]; !present || !reflect.DeepEqual(added, backend) {
+			if !m.sendBackend(m.addBackend, backend) {
+				return false
 			}
+			m.events.record(backendAdded, appId, "Adding backend for %s as %v\n", appId, backend.Node)
+		}
+		if !m.trackTask(task.ID, backend) {
+			return false
+		}
+	}
+	for node, backend := range m.added[appId] {
+		if running[node] {
+			continue
+		}
+		if !m.sendBackend(m.removeBackend, backend) {
+			return false
+		}
+		m.events.record(backendRemoved, appId, "Removing backend for %s as %v is gone from marathon\n", appId, node)
+	}
+	return true
+}
+
+// updateApp sends the new spec of the app while it's enabled. An app that's not
+// enabled anymore, with tlb.enabled set to false or removed altogether, is
+// dropped just like a destroyed one. Returns false if we're asked to stop.
+func (m *MarathonProvider) updateApp(appId string, labels *map[string]string) bool {
+	appLabels := Labels{}
+	if labels != nil {
+		appLabels = *labels
+	}
+	if isEnabled(appId, appLabels) {
+		if !m.sendApp(m.appUpdate, &types.AppInfo{AppId: appId, Labels: appLabels}) {
+			return false
 		}
+		m.appApp(appId, appLabels)
+		m.events.record(appUpdated, appId, "New / Updated the App spec - %s\n", appId)
+		return true
 	}
+	return m.dropKnownApp(appId, "Dropping %s as it's not enabled anymore\n")
+}
+
+// isEnabled tells if the app wants a frontend, logging the labels we read
+// here that can't be used
+func isEnabled(appId string, labels map[string]string) bool {
+	r := types.NewLabelReader(labels)
+	enabled := r.Bool(types.TLB_ENABLED, false)
+	if enabled {
+		r.Int(types.TLB_PORTINDEX, 0)
+		r.AtLeast(types.TLB_WEIGHT, 1, 0)
+	}
+	for _, err := range r.Errors {
+		log.Printf("[WARN] Invalid label of %s - %v\n", appId, err)
+	}
+	return enabled
+}
+
+// dropKnownApp drops the app along with all it's backends, if it's one we know
+// of. The message is logged with the appId. Returns false if we're asked to stop.
+func (m *MarathonProvider) dropKnownApp(appId string, message string) bool {
+	labels, known := m.apps[appId]
+	if !known {
+		return true
+	}
+	if !m.sendApp(m.dropApp, &types.AppInfo{AppId: appId, Labels: labels}) {
+		return false
+	}
+	m.removeApp(appId)
+	m.events.record(appDropped, appId, message, appId)
+	return true
 }
 
 func (m *MarathonProvider) containsApp(appId string) bool {
@@ -147,12 +612,96 @@ func (m *MarathonProvider) appApp(appId string, labels map[string]string) {
 	m.apps[appId] = labels
 }
 
-func (m *MarathonProvider) createBackendInfo(appId string, ipAddresses []*marathon.IPAddress, ports []int) *types.BackendInfo {
+func (m *MarathonProvider) removeApp(appId string) {
+	delete(m.apps, appId)
+	delete(m.added, appId)
+	m.forgetApp(appId)
+	for taskId, backend := range m.unhealthy {
+		if backend.AppId == appId {
+			delete(m.unhealthy, taskId)
+		}
+	}
+}
+
+// backendOfUpdate returns the backend of the task in the status update. During some
+// transitions marathon sends the update before the task has it's address, we
+// either look the task up again or skip the update as per requeryTasks.
+func (m *MarathonProvider) backendOfUpdate(client marathon.Marathon, update *marathon.EventStatusUpdate) (*types.BackendInfo, bool) {
+	backend, complete := m.createBackendInfo(update.AppID, update.Host, update.Version, update.IPAddresses, update.Ports)
+	if complete {
+		return backend, true
+	}
+	// a failed task is already gone from marathon, no point asking for it
+	if m.requeryTasks && update.TaskStatus == "TASK_RUNNING" {
+		if task := m.findTask(client, update.AppID, update.TaskID); task != nil {
+			backend, complete = m.createBackendInfo(update.AppID, task.Host, task.Version, task.IPAddresses, task.Ports)
+			if complete {
+				return backend, true
+			}
+		}
+	}
+	log.Printf("[WARN] Skipping %s of task %s of %s, it's address isn't known yet\n", update.TaskStatus, update.TaskID, update.AppID)
+	return nil, false
+}
+
+func (m *MarathonProvider) findTask(client marathon.Marathon, appId, taskId string) *marathon.Task {
+	tasks, err := client.Tasks(appId)
+	if err != nil {
+		log.Printf("[WARN] Unable to get the tasks of %s - %v\n", appId, err)
+		return nil
+	}
+	for idx := range tasks.Tasks {
+		if tasks.Tasks[idx].ID == taskId {
+			return &tasks.Tasks[idx]
+		}
+	}
+	return nil
+}
+
+// createBackendInfo returns the backend at the portIndex of the app, false
+// when the address or the port isn't known yet. The metadata of the backend
+// is the host and the version of the task, along with the tlb.meta.* labels
+// of the app. The weight is the tlb.weight of the app, 0 when it's not set
+// and drained when it's 0.
+func (m *MarathonProvider) createBackendInfo(appId, host, version string, ipAddresses []*marathon.IPAddress, ports []int) (*types.BackendInfo, bool) {
 	appLabels := m.apps[appId]
-	portIndex := maps.GetInt(appLabels, types.TLB_PORTINDEX, 0)
+	r := types.NewLabelReader(appLabels)
+	portIndex := r.Int(types.TLB_PORTINDEX, 0)
+	if portIndex >= len(ipAddresses) || portIndex >= len(ports) || ipAddresses[portIndex] == nil {
+		return nil, false
+	}
+	// the slots can be there before marathon has filled them in
+	if ipAddresses[portIndex].IPAddress == "" || ports[portIndex] <= 0 {
+		return nil, false
+	}
 
-	return &types.BackendInfo{
-		AppId: appId,
-		Node:  ipAddresses[portIndex].IPAddress + ":" + fmt.Sprintf("%d", ports[portIndex]),
+	var metadata map[string]string
+	add := func(key, value string) {
+		if key != "" && value != "" {
+			if metadata == nil {
+				metadata = make(map[string]string)
+			}
+			metadata[key] = value
+		}
+	}
+	weight := 0
+	if _, present := appLabels[types.TLB_WEIGHT]; present {
+		if weight = r.AtLeast(types.TLB_WEIGHT, 1, 0); weight == 0 {
+			weight = types.DrainWeight
+		}
 	}
+
+	add(types.MetaHost, host)
+	add(types.MetaVersion, version)
+	for label, value := range appLabels {
+		if strings.HasPrefix(label, types.TLB_META_PREFIX) {
+			add(strings.TrimPrefix(label, types.TLB_META_PREFIX), value)
+		}
+	}
+	return &types.BackendInfo{
+		AppId:    appId,
+		Node:     net.JoinHostPort(ipAddresses[portIndex].IPAddress, strconv.Itoa(ports[portIndex])),
+		Weight:   weight,
+		Metadata: metadata,
+	}, true
 }
@@ -0,0 +1,215 @@
+package providers
+
+import (
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/ashwanthkumar/gotlb/types"
+)
+
+// pollingProvider is what the static and the SRV providers share. It polls
+// their source for the apps every interval, and sends what changed in them
+// since the last poll like the events of marathon would be.
+type pollingProvider struct {
+	addBackend    chan<- *types.BackendInfo
+	removeBackend chan<- *types.BackendInfo
+	appUpdate     chan<- *types.AppInfo
+	dropApp       chan<- *types.AppInfo
+	stopMe        <-chan bool
+	done          *sync.WaitGroup
+
+	name string
+	// poll returns the apps of the source, nil when they're the same as the
+	// last time. The apps we've sent are kept when it fails.
+	poll func() ([]StaticApp, error)
+	// interval at which the source is polled again, 0 polls it only on startup
+	interval    time.Duration
+	logInterval time.Duration
+	events      *eventLog
+	// closed once the apps of the first poll are sent
+	scanned chan bool
+	// apps and backends we've sent
+	apps     map[string]Labels
+	backends map[string]map[string]*types.BackendInfo
+}
+
+func newPollingProvider(name string, poll func() ([]StaticApp, error), interval, logInterval time.Duration) *pollingProvider {
+	return &pollingProvider{
+		name:        name,
+		poll:        poll,
+		interval:    interval,
+		logInterval: logInterval,
+		scanned:     make(chan bool),
+		apps:        make(map[string]Labels),
+		backends:    make(map[string]map[string]*types.BackendInfo),
+	}
+}
+
+func (p *pollingProvider) Scanned() <-chan bool {
+	return p.scanned
+}
+
+func (p *pollingProvider) Name() string {
+	return p.name
+}
+
+func (p *pollingProvider) Provide(
+	addBackend chan<- *types.BackendInfo,
+	removeBackend chan<- *types.BackendInfo,
+	appUpdate chan<- *types.AppInfo,
+	dropApp chan<- *types.AppInfo,
+	stop <-chan bool,
+	done *sync.WaitGroup) error {
+	p.addBackend = addBackend
+	p.removeBackend = removeBackend
+	p.appUpdate = appUpdate
+	p.dropApp = dropApp
+	p.stopMe = stop
+	p.done = done
+	p.events = newEventLog(p.name, p.logInterval)
+	log.Println("Starting the provider " + p.name)
+	go p.start()
+	return nil
+}
+
+func (p *pollingProvider) start() {
+	defer p.done.Done()
+	if !p.reload() {
+		return
+	}
+	close(p.scanned)
+	if p.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	var summaries <-chan time.Time
+	if p.logInterval > 0 {
+		summary := time.NewTicker(p.logInterval)
+		defer summary.Stop()
+		summaries = summary.C
+	}
+	for {
+		select {
+		case <-ticker.C:
+			if !p.reload() {
+				return
+			}
+		case now := <-summaries:
+			p.events.tick(now)
+		case <-p.stopMe:
+			return
+		}
+	}
+}
+
+// reload polls the source and sends what changed in it since we last did. A
+// poll that fails is skipped, keeping what we've sent. Returns false if we're
+// asked to stop.
+func (p *pollingProvider) reload() bool {
+	apps, err := p.poll()
+	if err != nil {
+		log.Printf("[WARN] Unable to get the apps of %s, keeping the apps we have - %v\n", p.name, err)
+		return true
+	}
+	if apps == nil {
+		return true
+	}
+	if !p.apply(apps) {
+		return false
+	}
+	// how much it changed, without waiting for the interval
+	p.events.flush(time.Now())
+	return true
+}
+
+// apply sends the apps whose labels changed and the backends that changed,
+// and drops the apps that are gone. Returns false if we're asked to stop.
+func (p *pollingProvider) apply(apps []StaticApp) bool {
+	listed := make(map[string]bool, len(apps))
+	for _, app := range apps {
+		listed[app.ID] = true
+		labels := Labels(app.Labels)
+		if labels == nil {
+			labels = Labels{}
+		}
+		if known, present := p.apps[app.ID]; !present || !reflect.DeepEqual(known, labels) {
+			if !p.sendApp(p.appUpdate, &types.AppInfo{AppId: app.ID, Labels: labels}) {
+				return false
+			}
+			p.apps[app.ID] = labels
+			p.events.record(appUpdated, app.ID, "New / Updated the App spec - %s\n", app.ID)
+		}
+		if !p.applyBackends(app) {
+			return false
+		}
+	}
+	for appId, labels := range p.apps {
+		if listed[appId] {
+			continue
+		}
+		if !p.sendApp(p.dropApp, &types.AppInfo{AppId: appId, Labels: labels}) {
+			return false
+		}
+		delete(p.apps, appId)
+		delete(p.backends, appId)
+		p.events.record(appDropped, appId, "Dropping %s as it's gone from %s\n", appId, p.name)
+	}
+	return true
+}
+
+// applyBackends sends the backends of the app that are new or changed, and
+// removes the ones that are gone. Returns false if we're asked to stop.
+func (p *pollingProvider) applyBackends(app StaticApp) bool {
+	known := p.backends[app.ID]
+	if known == nil {
+		known = make(map[string]*types.BackendInfo)
+		p.backends[app.ID] = known
+	}
+	listed := make(map[string]bool, len(app.Backends))
+	for _, backend := range app.Backends {
+		listed[backend.Node] = true
+		info := &types.BackendInfo{AppId: app.ID, Node: backend.Node, Zone: backend.Zone, Weight: backend.Weight, Metadata: backend.Metadata}
+		if sent, present := known[backend.Node]; present && reflect.DeepEqual(sent, info) {
+			continue
+		}
+		if !p.sendBackend(p.addBackend, info) {
+			return false
+		}
+		known[backend.Node] = info
+		p.events.record(backendAdded, app.ID, "Adding backend for %s as %v\n", app.ID, backend.Node)
+	}
+	for node, info := range known {
+		if listed[node] {
+			continue
+		}
+		if !p.sendBackend(p.removeBackend, info) {
+			return false
+		}
+		delete(known, node)
+		p.events.record(backendRemoved, app.ID, "Removing backend for %s as %v\n", app.ID, node)
+	}
+	return true
+}
+
+// sendBackend sends the backend unless we're asked to stop, returns false if we are
+func (p *pollingProvider) sendBackend(to chan<- *types.BackendInfo, backend *types.BackendInfo) bool {
+	select {
+	case to <- backend:
+		return true
+	case <-p.stopMe:
+		return false
+	}
+}
+
+// sendApp sends the app unless we're asked to stop, returns false if we are
+func (p *pollingProvider) sendApp(to chan<- *types.AppInfo, app *types.AppInfo) bool {
+	select {
+	case to <- app:
+		return true
+	case <-p.stopMe:
+		return false
+	}
+}
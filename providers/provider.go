@@ -1,20 +1,40 @@
 package providers
 
-import "github.com/ashwanthkumar/gotlb/types"
+import (
+	"sync"
+
+	"github.com/ashwanthkumar/gotlb/types"
+)
 
 // Provider interface defines an implementation that can be used to fetch
 // the list of servers for an App. Eg - Marathon, Consul, EtcD, etc.
 type Provider interface {
+	// Name identifies the provider in the logs and the admin API,
+	// it should be unique across the providers of a GoTLB instance
+	Name() string
 	// Provide gives a set of channels as parameters to the implementation
 	// for it to report the respecitve changes accordingly
 	// addBackend - Used to denote a particular app instance has been added
 	// removeBackend - Used to denote a particular app instance has failed
 	// appUpdate - A New app has been deployed / an update to an existing app has been deployed
 	// dropApp - An Existing app has been destroyed, we can kill the Frontend for that app
-	// stop - Send a value to shutdown the provider, used to gracefully shutdown
+	// stop - Closed to shutdown the provider, used to gracefully shutdown
+	// done - Added to by the caller before Provide, the provider calls Done()
+	// once it has stopped and will never send on any of the channels again.
+	// A provider that returns an error never starts, and doesn't call Done().
+	//
+	// After stop is closed nobody might be receiving on the channels anymore, so
+	// the provider should never block on a send without also watching stop.
 	Provide(addBackend chan<- *types.BackendInfo,
 		removeBackend chan<- *types.BackendInfo,
 		appUpdate chan<- *types.AppInfo,
 		dropApp chan<- *types.AppInfo,
-		stop <-chan bool) error
+		stop <-chan bool,
+		done *sync.WaitGroup) error
+}
+
+// Scanner is implemented by the providers that start by scanning all the
+// apps. Scanned is closed once everything the scan found has been sent.
+type Scanner interface {
+	Scanned() <-chan bool
 }
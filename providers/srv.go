@@ -0,0 +1,69 @@
+package providers
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SRVProvider provides an app whose backends are the targets of a DNS SRV
+// record, like the ones of a service in Consul DNS or a headless service of
+// Kubernetes. The record is looked up again every pollInterval, and the
+// targets that came or went since are sent as the added / removed backends.
+type SRVProvider struct {
+	*pollingProvider
+	// SRV record, with the _service._proto prefix when it has one
+	record string
+	appId  string
+	labels map[string]string
+	// net.LookupSRV, the tests change it
+	lookupSRV func(service, proto, name string) (string, []*net.SRV, error)
+}
+
+// NewSRVProvider creates a provider of an app with the labels (tlb.port at
+// least) whose backends are the targets of the SRV record, looked up every
+// pollInterval. The app is named after the record, with a leading / like the
+// apps of marathon. Only the targets of the lowest priority are used, and their weight
+// is the weight of the backend. The targets that are hostnames are passed on
+// as they are, the dial resolves them.
+func NewSRVProvider(record string, labels map[string]string, pollInterval, logInterval time.Duration) Provider {
+	s := &SRVProvider{
+		record:    record,
+		appId:     "/" + strings.TrimSuffix(record, "."),
+		labels:    labels,
+		lookupSRV: net.LookupSRV,
+	}
+	s.pollingProvider = newPollingProvider("srv("+record+")", s.lookup, pollInterval, logInterval)
+	return s
+}
+
+// lookup returns the app with the targets of the record. A record that's not
+// there (yet) is an app without backends, the other errors keep what we have.
+func (s *SRVProvider) lookup() ([]StaticApp, error) {
+	app := StaticApp{ID: s.appId, Labels: s.labels}
+	_, targets, err := s.lookupSRV("", "", s.record)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return []StaticApp{app}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	priority := -1
+	for _, target := range targets {
+		if priority == -1 || int(target.Priority) < priority {
+			priority = int(target.Priority)
+		}
+	}
+	nodes := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		node := net.JoinHostPort(strings.TrimSuffix(target.Target, "."), strconv.Itoa(int(target.Port)))
+		if int(target.Priority) != priority || nodes[node] {
+			continue
+		}
+		nodes[node] = true
+		app.Backends = append(app.Backends, StaticBackend{Node: node, Weight: int(target.Weight)})
+	}
+	return []StaticApp{app}, nil
+}
@@ -0,0 +1,60 @@
+package providers
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/ashwanthkumar/gotlb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSRVProviderToSendTheTargetsThatChanged(t *testing.T) {
+	s := NewSRVProvider("_redis._tcp.service.consul", map[string]string{"tlb.port": "6379"}, 0, 0).(*SRVProvider)
+	var targets []*net.SRV
+	var lookupErr error
+	s.lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		assert.Equal(t, "_redis._tcp.service.consul", name)
+		return "", targets, lookupErr
+	}
+	addBackend := make(chan *types.BackendInfo, 10)
+	removeBackend := make(chan *types.BackendInfo, 10)
+	appUpdate := make(chan *types.AppInfo, 10)
+	s.addBackend, s.removeBackend, s.appUpdate, s.dropApp = addBackend, removeBackend, appUpdate, make(chan *types.AppInfo, 10)
+	s.events = newEventLog(s.Name(), 0)
+
+	targets = []*net.SRV{
+		{Target: "10.0.0.1", Port: 6379, Priority: 1, Weight: 10},
+		{Target: "redis-2.node.consul.", Port: 6380, Priority: 1, Weight: 5},
+		// only used when the ones of priority 1 are gone
+		{Target: "10.0.0.9", Port: 6379, Priority: 2},
+	}
+	assert.True(t, s.reload())
+	assert.Equal(t, &types.AppInfo{AppId: "/_redis._tcp.service.consul", Labels: map[string]string{"tlb.port": "6379"}}, <-appUpdate)
+	assert.Equal(t, &types.BackendInfo{AppId: "/_redis._tcp.service.consul", Node: "10.0.0.1:6379", Weight: 10}, <-addBackend)
+	assert.Equal(t, "redis-2.node.consul:6380", (<-addBackend).Node)
+	assert.Len(t, addBackend, 0)
+
+	// the failed lookups keep what we have
+	lookupErr = errors.New("i/o timeout")
+	assert.True(t, s.reload())
+	assert.Len(t, removeBackend, 0)
+
+	lookupErr = nil
+	targets = targets[1:]
+	assert.True(t, s.reload())
+	assert.Equal(t, "10.0.0.1:6379", (<-removeBackend).Node)
+	assert.Len(t, addBackend, 0)
+	assert.Len(t, appUpdate, 0)
+
+	// only the resolver saying it's not found counts, not the text of the error
+	lookupErr = &net.DNSError{Err: "no such host", Name: "_redis._tcp.service.consul", IsTimeout: true}
+	assert.True(t, s.reload())
+	assert.Len(t, removeBackend, 0)
+
+	// a record that's gone has no backends
+	lookupErr = &net.DNSError{Err: "NXDOMAIN", Name: "_redis._tcp.service.consul", IsNotFound: true}
+	assert.True(t, s.reload())
+	assert.Equal(t, "redis-2.node.consul:6380", (<-removeBackend).Node)
+	assert.Len(t, appUpdate, 0)
+}
@@ -0,0 +1,103 @@
+package providers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// StaticFile is the file of the apps of a StaticProvider, in JSON
+//
+//	{"apps": [{"id": "/redis", "labels": {"tlb.port": "6379"}, "backends": [{"node": "10.0.0.1:6379"}]}]}
+type StaticFile struct {
+	Apps []StaticApp `json:"apps"`
+}
+
+// StaticApp is an app of the StaticFile with it's labels and backends
+type StaticApp struct {
+	ID       string            `json:"id"`
+	Labels   map[string]string `json:"labels"`
+	Backends []StaticBackend   `json:"backends"`
+}
+
+// StaticBackend is a backend of a StaticApp, only the node is required
+type StaticBackend struct {
+	Node     string            `json:"node"`
+	Zone     string            `json:"zone,omitempty"`
+	Weight   int               `json:"weight,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// StaticProvider provides the apps and the backends of a file, for local
+// testing and small setups that don't change often. The file is read again
+// every watchInterval, and what changed in it is sent like the events of
+// marathon would be.
+type StaticProvider struct {
+	*pollingProvider
+	path string
+	// contents of the file we've sent
+	contents []byte
+}
+
+// NewStaticProvider creates a provider of the apps in the JSON file at path
+// (see StaticFile), that's read again every watchInterval when it's more
+// than 0. The added / removed backends and apps are logged as a summary once
+// every logInterval, or one by one when it's 0.
+func NewStaticProvider(path string, watchInterval, logInterval time.Duration) Provider {
+	s := &StaticProvider{path: path}
+	s.pollingProvider = newPollingProvider("static("+path+")", s.read, watchInterval, logInterval)
+	return s
+}
+
+// read returns the apps of the file, nil when it's the same as the last time
+func (s *StaticProvider) read() ([]StaticApp, error) {
+	contents, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if s.contents != nil && bytes.Equal(contents, s.contents) {
+		return nil, nil
+	}
+	file, err := parseStaticFile(contents)
+	if err != nil {
+		return nil, err
+	}
+	s.contents = contents
+	if file.Apps == nil {
+		// none is still a change, from what we had
+		return []StaticApp{}, nil
+	}
+	return file.Apps, nil
+}
+
+// parseStaticFile parses the file and checks every app has an id and every
+// backend a node, with no duplicates
+func parseStaticFile(contents []byte) (*StaticFile, error) {
+	var file StaticFile
+	if err := json.Unmarshal(contents, &file); err != nil {
+		return nil, err
+	}
+	apps := make(map[string]bool, len(file.Apps))
+	for _, app := range file.Apps {
+		if app.ID == "" {
+			return nil, fmt.Errorf("an app doesn't have an id")
+		}
+		if apps[app.ID] {
+			return nil, fmt.Errorf("%s is there more than once", app.ID)
+		}
+		apps[app.ID] = true
+		nodes := make(map[string]bool, len(app.Backends))
+		for _, backend := range app.Backends {
+			if backend.Node == "" {
+				return nil, fmt.Errorf("a backend of %s doesn't have a node", app.ID)
+			}
+			if nodes[backend.Node] {
+				return nil, fmt.Errorf("%s of %s is there more than once", backend.Node, app.ID)
+			}
+			nodes[backend.Node] = true
+		}
+	}
+	return &file, nil
+}
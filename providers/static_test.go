@@ -0,0 +1,104 @@
+package providers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/ashwanthkumar/gotlb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaticProviderToSendWhatChangedInTheFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotlb")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "apps.json")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`{"apps": [
+		{"id": "/redis", "labels": {"tlb.port": "6379"}, "backends": [{"node": "10.0.0.1:6379", "zone": "a"}, {"node": "10.0.0.2:6379"}]},
+		{"id": "/web", "labels": {"tlb.port": "8080"}, "backends": [{"node": "10.0.0.3:8080"}]}
+	]}`), 0600))
+
+	addBackend := make(chan *types.BackendInfo, 10)
+	removeBackend := make(chan *types.BackendInfo, 10)
+	appUpdate := make(chan *types.AppInfo, 10)
+	dropApp := make(chan *types.AppInfo, 10)
+	stop := make(chan bool)
+	done := &sync.WaitGroup{}
+	done.Add(1)
+	s := NewStaticProvider(path, 0, 0).(*StaticProvider)
+	assert.NoError(t, s.Provide(addBackend, removeBackend, appUpdate, dropApp, stop, done))
+	<-s.Scanned()
+	done.Wait()
+	assert.Len(t, appUpdate, 2)
+	assert.Len(t, addBackend, 3)
+	assert.Equal(t, &types.AppInfo{AppId: "/redis", Labels: Labels{"tlb.port": "6379"}}, <-appUpdate)
+	assert.Equal(t, &types.BackendInfo{AppId: "/redis", Node: "10.0.0.1:6379", Zone: "a"}, <-addBackend)
+	<-appUpdate
+	<-addBackend
+	<-addBackend
+
+	// the same file sends nothing
+	assert.True(t, s.reload())
+	assert.Len(t, appUpdate, 0)
+	assert.Len(t, addBackend, 0)
+
+	// only the labels of /redis changed, one of it's backends is gone and
+	// /web is gone from the file
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`{"apps": [
+		{"id": "/redis", "labels": {"tlb.port": "6380"}, "backends": [{"node": "10.0.0.1:6379", "zone": "a"}]}
+	]}`), 0600))
+	assert.True(t, s.reload())
+	assert.Equal(t, &types.AppInfo{AppId: "/redis", Labels: Labels{"tlb.port": "6380"}}, <-appUpdate)
+	assert.Len(t, addBackend, 0)
+	assert.Equal(t, "10.0.0.2:6379", (<-removeBackend).Node)
+	assert.Equal(t, "/web", (<-dropApp).AppId)
+
+	// a backend that changed is sent again
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`{"apps": [
+		{"id": "/redis", "labels": {"tlb.port": "6380"}, "backends": [{"node": "10.0.0.1:6379", "zone": "a", "weight": 3}]}
+	]}`), 0600))
+	assert.True(t, s.reload())
+	assert.Len(t, appUpdate, 0)
+	assert.Equal(t, &types.BackendInfo{AppId: "/redis", Node: "10.0.0.1:6379", Zone: "a", Weight: 3}, <-addBackend)
+	assert.Len(t, removeBackend, 0)
+}
+
+func TestStaticProviderToKeepTheAppsWhenTheFileIsInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotlb")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "apps.json")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`{"apps": [{"id": "/redis", "backends": [{"node": "10.0.0.1:6379"}]}]}`), 0600))
+
+	addBackend := make(chan *types.BackendInfo, 10)
+	removeBackend := make(chan *types.BackendInfo, 10)
+	appUpdate := make(chan *types.AppInfo, 10)
+	dropApp := make(chan *types.AppInfo, 10)
+	s := NewStaticProvider(path, 0, 0).(*StaticProvider)
+	s.addBackend, s.removeBackend, s.appUpdate, s.dropApp = addBackend, removeBackend, appUpdate, dropApp
+	s.events = newEventLog(s.Name(), 0)
+	assert.True(t, s.reload())
+	assert.Equal(t, map[string]string{}, (<-appUpdate).Labels)
+	<-addBackend
+
+	for _, invalid := range []string{
+		`{"apps": [`,
+		`{"apps": [{"labels": {}}]}`,
+		`{"apps": [{"id": "/redis"}, {"id": "/redis"}]}`,
+		`{"apps": [{"id": "/redis", "backends": [{"zone": "a"}]}]}`,
+		`{"apps": [{"id": "/redis", "backends": [{"node": "10.0.0.1:6379"}, {"node": "10.0.0.1:6379"}]}]}`,
+	} {
+		assert.NoError(t, ioutil.WriteFile(path, []byte(invalid), 0600))
+		assert.True(t, s.reload())
+	}
+	// nor when it's gone
+	os.Remove(path)
+	assert.True(t, s.reload())
+	assert.Len(t, appUpdate, 0)
+	assert.Len(t, addBackend, 0)
+	assert.Len(t, removeBackend, 0)
+	assert.Len(t, dropApp, 0)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/ashwanthkumar/gotlb/types"
+)
+
+const (
+	// the human readable header, supported by most of the backends
+	ProxyProtocolV1 = 1
+	// the binary header, cheaper to parse
+	ProxyProtocolV2 = 2
+)
+
+// signature every v2 header starts with
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyProtocol reads if we send the PROXY protocol header and it's version
+// from tlb.proxyprotocol - v1, v2 or off, or true / false along with the
+// version of tlb.proxyprotocol.version
+func proxyProtocol(r *types.LabelReader, version int) (bool, int) {
+	value := r.String(types.TLB_PROXYPROTOCOL, "off")
+	switch value {
+	case "v1":
+		return true, ProxyProtocolV1
+	case "v2":
+		return true, ProxyProtocolV2
+	case "off":
+		return false, version
+	}
+	if enabled, err := strconv.ParseBool(value); err == nil {
+		return enabled, version
+	}
+	r.Invalid(types.TLB_PROXYPROTOCOL, "should be one of v1, v2, off, true, false, using %q", "off")
+	return false, version
+}
+
+// proxyHeader returns the PROXY protocol header of the given version we send
+// to the backend before anything else, so it knows the client of the
+// connection. src is the client and dst is the frontend it connected to.
+func proxyHeader(version int, src, dst net.Addr) []byte {
+	if version == ProxyProtocolV2 {
+		return proxyHeaderV2(src, dst)
+	}
+	return proxyHeaderV1(src, dst)
+}
+
+// proxyAddrs returns the addresses of the connection in the same family, v4
+// when both of them are, nil when they're not TCP
+func proxyAddrs(src, dst net.Addr) (srcAddr, dstAddr *net.TCPAddr, v4 bool) {
+	srcAddr, srcOk := src.(*net.TCPAddr)
+	dstAddr, dstOk := dst.(*net.TCPAddr)
+	if !srcOk || !dstOk {
+		return nil, nil, false
+	}
+	return srcAddr, dstAddr, srcAddr.IP.To4() != nil && dstAddr.IP.To4() != nil
+}
+
+func proxyHeaderV1(src, dst net.Addr) []byte {
+	srcAddr, dstAddr, v4 := proxyAddrs(src, dst)
+	if srcAddr == nil {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+	family, srcIP, dstIP := "TCP6", ipv6String(srcAddr.IP), ipv6String(dstAddr.IP)
+	if v4 {
+		family, srcIP, dstIP = "TCP4", srcAddr.IP.String(), dstAddr.IP.String()
+	}
+	return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", family, srcIP, dstIP, srcAddr.Port, dstAddr.Port))
+}
+
+// ipv6String formats the IP as v6 even when it's a v4 one, which net.IP
+// prints in the dotted form
+func ipv6String(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return "::ffff:" + v4.String()
+	}
+	return ip.String()
+}
+
+func proxyHeaderV2(src, dst net.Addr) []byte {
+	header := append([]byte(nil), proxyV2Signature...)
+	srcAddr, dstAddr, v4 := proxyAddrs(src, dst)
+	if srcAddr == nil {
+		// a LOCAL command without any addresses, the backend uses the real ones of the connection
+		return append(header, 0x20, 0x00, 0x00, 0x00)
+	}
+	family, srcIP, dstIP := byte(0x21), srcAddr.IP.To16(), dstAddr.IP.To16()
+	if v4 {
+		family, srcIP, dstIP = 0x11, srcAddr.IP.To4(), dstAddr.IP.To4()
+	}
+	// version 2 with the PROXY command, then TCP over the family
+	header = append(header, 0x21, family, 0, 0)
+	binary.BigEndian.PutUint16(header[len(header)-2:], uint16(2*len(srcIP)+4))
+	header = append(header, srcIP...)
+	header = append(header, dstIP...)
+	ports := make([]byte, 4)
+	binary.BigEndian.PutUint16(ports, uint16(srcAddr.Port))
+	binary.BigEndian.PutUint16(ports[2:], uint16(dstAddr.Port))
+	return append(header, ports...)
+}
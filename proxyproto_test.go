@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	proxyClient4   = &net.TCPAddr{IP: net.ParseIP("192.168.1.10"), Port: 51234}
+	proxyFrontend4 = &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 11000}
+	proxyClient6   = &net.TCPAddr{IP: net.ParseIP("fd00::10"), Port: 51234}
+	proxyFrontend6 = &net.TCPAddr{IP: net.ParseIP("fd00::1"), Port: 11000}
+)
+
+func TestProxyHeaderV1(t *testing.T) {
+	assert.Equal(t, "PROXY TCP4 192.168.1.10 10.0.0.1 51234 11000\r\n", string(proxyHeader(ProxyProtocolV1, proxyClient4, proxyFrontend4)))
+	assert.Equal(t, "PROXY TCP6 fd00::10 fd00::1 51234 11000\r\n", string(proxyHeader(ProxyProtocolV1, proxyClient6, proxyFrontend6)))
+	// a v4 client reaching a v6 frontend is sent as v6
+	assert.Equal(t, "PROXY TCP6 ::ffff:192.168.1.10 fd00::1 51234 11000\r\n", string(proxyHeader(ProxyProtocolV1, proxyClient4, proxyFrontend6)))
+	assert.Equal(t, "PROXY UNKNOWN\r\n", string(proxyHeader(ProxyProtocolV1, &net.UnixAddr{Name: "/tmp/sock"}, proxyFrontend4)))
+}
+
+func TestProxyHeaderV2(t *testing.T) {
+	signature := []byte{0x0d, 0x0a, 0x0d, 0x0a, 0x00, 0x0d, 0x0a, 0x51, 0x55, 0x49, 0x54, 0x0a}
+	assert.Equal(t, append(signature,
+		0x21, 0x11, 0x00, 0x0c,
+		192, 168, 1, 10,
+		10, 0, 0, 1,
+		0xc8, 0x22, 0x2a, 0xf8,
+	), proxyHeader(ProxyProtocolV2, proxyClient4, proxyFrontend4))
+	assert.Equal(t, append(signature,
+		0x21, 0x21, 0x00, 0x24,
+		0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x10,
+		0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01,
+		0xc8, 0x22, 0x2a, 0xf8,
+	), proxyHeader(ProxyProtocolV2, proxyClient6, proxyFrontend6))
+	assert.Equal(t, append(signature, 0x20, 0x00, 0x00, 0x00), proxyHeader(ProxyProtocolV2, &net.UnixAddr{Name: "/tmp/sock"}, proxyFrontend4))
+}
+
+func TestProxyProtocolVersionToDefaultToV1(t *testing.T) {
+	assert.Equal(t, ProxyProtocolV1, NewFrontendConfig(map[string]string{}).ProxyProtocolVersion)
+	assert.Equal(t, ProxyProtocolV2, NewFrontendConfig(map[string]string{"tlb.proxyprotocol.version": "2"}).ProxyProtocolVersion)
+	assert.Equal(t, ProxyProtocolV1, NewFrontendConfig(map[string]string{"tlb.proxyprotocol.version": "3"}).ProxyProtocolVersion)
+}
+
+func TestProxyProtocolToTakeTheVersionFromTheLabel(t *testing.T) {
+	cases := []struct {
+		labels  map[string]string
+		enabled bool
+		version int
+	}{
+		{map[string]string{}, false, ProxyProtocolV1},
+		{map[string]string{"tlb.proxyprotocol": "v2"}, true, ProxyProtocolV2},
+		// the version it names wins
+		{map[string]string{"tlb.proxyprotocol": "v1", "tlb.proxyprotocol.version": "2"}, true, ProxyProtocolV1},
+		{map[string]string{"tlb.proxyprotocol": "off", "tlb.proxyprotocol.version": "2"}, false, ProxyProtocolV2},
+		{map[string]string{"tlb.proxyprotocol": "true", "tlb.proxyprotocol.version": "2"}, true, ProxyProtocolV2},
+		{map[string]string{"tlb.proxyprotocol": "false"}, false, ProxyProtocolV1},
+	}
+	for _, c := range cases {
+		config := NewFrontendConfig(c.labels)
+		assert.Equal(t, c.enabled, config.ProxyProtocol, "%v", c.labels)
+		assert.Equal(t, c.version, config.ProxyProtocolVersion, "%v", c.labels)
+		assert.Empty(t, config.LabelErrors)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Reporter ships the metrics of GoTLB to an external system
+type Reporter interface {
+	// Name of the reporter used in the logs
+	Name() string
+	// Report sends the snapshot of the metrics
+	Report(snapshot []MetricValue) error
+}
+
+// StartReporters reports the metrics to all the reporters every interval
+// until stop is closed. A failing reporter is only logged, it never affects
+// the other reporters or GoTLB itself.
+func StartReporters(registry *MetricsRegistry, interval time.Duration, stop <-chan bool, reporters ...Reporter) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			snapshot := registry.Snapshot()
+			for _, reporter := range reporters {
+				if err := reporter.Report(snapshot); err != nil {
+					log.Printf("[WARN] Unable to report the metrics to %s - %v\n", reporter.Name(), err)
+				}
+			}
+		case <-stop:
+			return
+		}
+	}
+}
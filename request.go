@@ -1,47 +1,559 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
 	"io"
 	"log"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-func NewRequest(in net.Conn, backend, appId string) (err error) {
-	var p = Request{backend, appId}
+// defaultDialTimeout is how long a connection waits on a backend that's not
+// answering, when the app doesn't say
+const defaultDialTimeout = 3 * time.Second
+
+// NewRequest proxies the client connection to backend of the frontend. We
+// go back to the frontend to pick another backend when we need to fail over.
+func NewRequest(in net.Conn, backend string, frontend *Frontend) (err error) {
+	var p = Request{
+		backend:  backend,
+		appId:    frontend.appId,
+		config:   frontend.Config(),
+		frontend: frontend,
+		tried:    make(map[string]bool),
+	}
 	err = p.Accept(in)
 	return err
 }
 
 type Request struct {
-	backend string
-	appId   string
+	backend  string
+	appId    string
+	config   *FrontendConfig
+	frontend *Frontend
+
+	// lock guards out and replay while we could still fail over
+	lock     sync.Mutex
+	out      net.Conn
+	replay   *replayBuffer
+	tried    map[string]bool
+	attempts int
+	// when we started to dial the backend we're connected to
+	dialed time.Time
+	// connection of the client
+	in net.Conn
+	// IP of the client, used as the key by sticky strategies
+	client string
+	// PROXY protocol header sent to every backend we connect to, nil if we don't send one
+	header []byte
+	// backends the client asked for, nil when it didn't
+	selector Selector
+	// entry of the connection in the connection tracker
+	conn *Connection
+	// set to 1 once the backend has sent something to the client,
+	// after which it's no longer safe to fail over
+	responded int32
+	// set to 1 once we've closed the write side of the client / the backend
+	clientShut  int32
+	backendShut int32
+	// set to 1 once the connection has been open for MaxConnLifetime
+	expired int32
+	// set to 1 once the current backend has sent it's first byte
+	firstByte int32
 }
 
+// errHalfClosed is what a copy ends with when it's closed the write side of
+// it's destination, the connection is done once the other way is done too
+var errHalfClosed = errors.New("half closed")
+
 // Start the request proxy from source -> upstream backend
-func (p *Request) Accept(in net.Conn) error {
+func (p *Request) Accept(in net.Conn) (err error) {
 	defer in.Close()
+	p.client = clientIP(in)
+	if p.config.SteerHeader != "" {
+		in, p.selector = p.steer(in)
+		if p.selector != nil {
+			if backend := p.frontend.LookupMatching(p.client, p.selector, nil); backend != "" {
+				p.backend = backend
+			}
+		}
+	}
+	if p.config.ProxyProtocol {
+		p.header = proxyHeader(p.config.ProxyProtocolVersion, in.RemoteAddr(), in.LocalAddr())
+	}
 
-	out, err := net.Dial("tcp", p.backend)
-	defer out.Close()
+	out, err := p.connect()
+	p.frontend.releasePending()
 	if err != nil {
 		log.Print("[ERROR] tcp: cannot connect to upstream - ", err)
+		metrics.Counter("frontend-dial-errors", "app", p.appId).Inc()
+		p.frontend.recordConnection(false)
+		return err
+	}
+	p.out = out
+	defer func() { p.current().Close() }()
+	p.in = in
+	p.frontend.acquire(p.backend, in)
+	defer func() { p.frontend.release(p.currentBackend(), in) }()
+	p.conn = connections.Track(p.appId, in.RemoteAddr().String(), p.backend)
+	defer connections.Untrack(p.conn)
+	defer p.observeUnanswered()
+	defer func() { p.frontend.recordConnection(p.conn.Transferred() > 0) }()
+	defer func() { p.frontend.logAccess(p.config, p.conn, err) }()
+
+	p.tune(in)
+	if p.config.DSCPClient {
+		p.mark(in)
+	}
+	if p.config.ProbeInterval > 0 {
+		done := make(chan bool)
+		defer close(done)
+		go p.probe(in, done)
+	}
+	if p.config.MaxConnLifetime > 0 {
+		expiry := time.AfterFunc(p.config.MaxConnLifetime, func() { p.expire(in) })
+		defer expiry.Stop()
 	}
 
 	// capture all errors in here
 	errc := make(chan error, 2)
 
-	cp := func(dst io.Writer, src io.Reader) {
-		_, err := io.Copy(dst, src)
-		errc <- err
+	if p.canFailover() {
+		p.replay = newReplayBuffer(p.config.FailoverBuffer)
+		go func() { errc <- p.shutBackend(p.upstream(in)) }()
+		go func() { errc <- p.shutClient(in, p.downstream(in)) }()
+	} else {
+		go func() {
+			_, err := p.copy(out, p.detecting(p.conn.In(p.withTimeouts(in))))
+			errc <- p.shutBackend(err)
+		}()
+		go func() {
+			_, err := p.copy(in, p.fromBackend(out))
+			errc <- p.shutClient(in, err)
+		}()
 	}
 
-	go cp(out, in)
-	go cp(in, out)
-
 	err = <-errc
+	if err == errHalfClosed || isLifetimeOver(err) {
+		// the other way is still going, or writing what it read before the
+		// lifetime was over
+		if other := <-errc; err == errHalfClosed {
+			err = other
+		}
+	}
+	if err == errHalfClosed {
+		err = nil
+	} else if isLifetimeOver(err) {
+		metrics.Counter("frontend-lifetime-closes", "app", p.appId).Inc()
+		err = nil
+	}
 	if err != nil && err != io.EOF {
 		log.Print("[WARN]: tcp:  ", err)
 		return err
 	}
 	return nil
 }
+
+// shutBackend closes the write side of the backend once the client is done
+// sending, so the backend sees the EOF while it's response is still coming
+// back. Returns errHalfClosed when it did, the error of the copy otherwise.
+func (p *Request) shutBackend(err error) error {
+	if err != nil && err != io.EOF {
+		return err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !closeWrite(p.out) {
+		return err
+	}
+	atomic.StoreInt32(&p.backendShut, 1)
+	return errHalfClosed
+}
+
+// shutClient closes the write side of the client once the backend is done
+// sending, like shutBackend
+func (p *Request) shutClient(in net.Conn, err error) error {
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if !closeWrite(in) {
+		return err
+	}
+	atomic.StoreInt32(&p.clientShut, 1)
+	return errHalfClosed
+}
+
+// closeWrite closes the write side of the TCP connection, returns false if
+// it's not one or it can't
+func closeWrite(conn net.Conn) bool {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		// sends the close_notify, the client reads the end of the stream
+		return tlsConn.CloseWrite() == nil
+	}
+	tcpConn, ok := asTCPConn(conn)
+	return ok && tcpConn.CloseWrite() == nil
+}
+
+func (p *Request) canFailover() bool {
+	return p.config.FailoverAttempts > 1 && p.config.FailoverBuffer > 0
+}
+
+// connect dials the backend, failing over to the other backends of the app
+// as long as we have attempts left
+func (p *Request) connect() (net.Conn, error) {
+	var lastErr error
+	for p.attempts < p.maxAttempts() {
+		backend := p.backend
+		if p.attempts > 0 {
+			backend = p.nextBackend()
+			if backend == "" {
+				break
+			}
+			metrics.Counter("frontend-failovers", "app", p.appId).Inc()
+			metrics.Counter("frontend-retries", "app", p.appId).Inc()
+		}
+		p.attempts++
+		p.tried[backend] = true
+		dialed := time.Now()
+		out, err := net.DialTimeout("tcp", backend, p.config.DialTimeout)
+		if err == nil && p.header != nil {
+			if _, err = out.Write(p.header); err != nil {
+				out.Close()
+			}
+		}
+		p.frontend.recordDial(backend, err)
+		if err != nil {
+			p.observeMissed(backend, dialed)
+		}
+		if err == nil {
+			p.backend = backend
+			p.dialed = dialed
+			p.tune(out)
+			p.mark(out)
+			return out, nil
+		}
+		log.Printf("[WARN] tcp: cannot connect to upstream %s for %s - %v\n", backend, p.appId, err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (p *Request) maxAttempts() int {
+	if p.config.FailoverAttempts < 1 {
+		return 1
+	}
+	return p.config.FailoverAttempts
+}
+
+// nextBackend returns a backend we've not tried yet, empty if there's none
+func (p *Request) nextBackend() string {
+	// the strategy might return the backends we've already tried, give it a
+	// few chances before giving up
+	for i := 0; i < 2*p.maxAttempts(); i++ {
+		var backend string
+		if p.selector != nil {
+			backend = p.frontend.LookupMatching(p.client, p.selector, p.tried)
+		} else {
+			backend = p.frontend.LookupFor(p.client, p.tried)
+		}
+		if backend != "" && !p.tried[backend] {
+			return backend
+		}
+	}
+	return ""
+}
+
+func (p *Request) current() net.Conn {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.out
+}
+
+// currentBackend returns the backend we're proxying to right now
+func (p *Request) currentBackend() string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.backend
+}
+
+// observeFirstByte tells the frontend how long the backend took from our
+// dial to it's first byte
+func (p *Request) observeFirstByte() {
+	p.lock.Lock()
+	backend, dialed := p.backend, p.dialed
+	p.lock.Unlock()
+	atomic.StoreInt32(&p.firstByte, 1)
+	p.frontend.observe(backend, time.Since(dialed))
+}
+
+// observeMissed tells the frontend the backend never got back to us, as a
+// latency of what we waited on it but no less than the idle timeout (or the
+// dial timeout without one). A backend that accepts the connections but
+// doesn't answer them looks slow instead of not measured.
+func (p *Request) observeMissed(backend string, dialed time.Time) {
+	penalty := p.config.IdleTimeout
+	if penalty <= 0 {
+		penalty = p.config.DialTimeout
+	}
+	if waited := time.Since(dialed); waited > penalty {
+		penalty = waited
+	}
+	p.frontend.observe(backend, penalty)
+}
+
+// observeUnanswered is observeMissed for the current backend once the
+// connection is done, if the client sent it something it never answered
+func (p *Request) observeUnanswered() {
+	if atomic.LoadInt32(&p.firstByte) == 1 || p.conn.Transferred() == 0 {
+		return
+	}
+	p.lock.Lock()
+	backend, dialed := p.backend, p.dialed
+	p.lock.Unlock()
+	p.observeMissed(backend, dialed)
+}
+
+// fromBackend is what we read from the backend, it's accounted and timed out,
+// and the frontend hears of it's latency and resets
+func (p *Request) fromBackend(out net.Conn) io.Reader {
+	return &backendReader{reader: p.conn.Out(p.withTimeouts(out)), first: p.observeFirstByte, failed: p.backendFailed}
+}
+
+// backendFailed tells the frontend of the current backend failing the read,
+// it counts the resets towards the outlier detection
+func (p *Request) backendFailed(err error) {
+	p.frontend.recordReset(p.currentBackend(), err)
+}
+
+func (p *Request) hasResponded() bool {
+	return atomic.LoadInt32(&p.responded) == 1
+}
+
+// upstream copies client -> backend. Until the backend responds everything
+// is also recorded in the replay buffer for a fail over.
+func (p *Request) upstream(in net.Conn) error {
+	src := p.detecting(p.conn.In(p.withTimeouts(in)))
+	buf := make([]byte, 32*1024)
+	for !p.hasResponded() && p.replay.active() {
+		nr, er := src.Read(buf)
+		if nr > 0 {
+			if ew := p.writeUpstream(buf[0:nr]); ew != nil {
+				return ew
+			}
+		}
+		if er != nil {
+			return er
+		}
+	}
+	// the backend can't change anymore
+	_, err := p.copy(p.current(), src)
+	return err
+}
+
+func (p *Request) writeUpstream(data []byte) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.replay.record(data)
+	_, err := p.out.Write(data)
+	if err != nil && !p.hasResponded() && p.replay.active() {
+		// the data is safe in the replay buffer, closing the backend makes
+		// downstream notice the failure and fail over
+		p.out.Close()
+		return nil
+	}
+	return err
+}
+
+// downstream copies backend -> client. If the backend fails before sending
+// anything, we replay what the client sent so far on another backend. A
+// backend that closes the connection before we forwarded anything to it, like
+// one that accepts before it's ready, is as good as one we couldn't connect to.
+func (p *Request) downstream(in net.Conn) error {
+	buf := make([]byte, 32*1024)
+	for {
+		src := p.fromBackend(p.current())
+		nr, er := src.Read(buf)
+		if nr > 0 {
+			atomic.StoreInt32(&p.responded, 1)
+			if _, ew := in.Write(buf[0:nr]); ew != nil {
+				return ew
+			}
+			_, err := p.copy(in, src)
+			return err
+		}
+		if er == nil {
+			continue
+		}
+		if er == io.EOF && p.forwarded() {
+			return er
+		}
+		if er == io.EOF {
+			log.Printf("[WARN] tcp: upstream %s for %s closed the connection before we sent anything\n", p.backend, p.appId)
+		}
+		if isTimeout(er) || er == errLifetimeOver || !p.failover() {
+			return er
+		}
+	}
+}
+
+// forwarded tells if we've sent anything from the client to the current backend
+func (p *Request) forwarded() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.replay.data) > 0 || !p.replay.active()
+}
+
+// failover moves the request to another backend and replays the client data on it
+func (p *Request) failover() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !p.replay.active() {
+		return false
+	}
+	failed, dialed := p.backend, p.dialed
+	p.out.Close()
+	p.observeMissed(failed, dialed)
+	for {
+		out, err := p.connect()
+		if err != nil {
+			return false
+		}
+		if _, err := out.Write(p.replay.data); err != nil {
+			log.Printf("[WARN] tcp: cannot replay to upstream %s for %s - %v\n", p.backend, p.appId, err)
+			out.Close()
+			continue
+		}
+		if atomic.LoadInt32(&p.backendShut) == 1 {
+			// the client is done sending
+			closeWrite(out)
+		}
+		log.Printf("[INFO] Failed over %s from %s to %s\n", p.appId, failed, p.backend)
+		p.frontend.release(failed, p.in)
+		p.frontend.acquire(p.backend, p.in)
+		p.out = out
+		p.conn.SetBackend(p.backend)
+		return true
+	}
+}
+
+// tune applies the socket level settings from the app config on the connection
+func (p *Request) tune(conn net.Conn) {
+	tcpConn, ok := asTCPConn(conn)
+	if !ok {
+		return
+	}
+	if p.config.ReadBuffer > 0 {
+		if err := tcpConn.SetReadBuffer(p.config.ReadBuffer); err != nil {
+			log.Printf("[WARN] Unable to set the read buffer for %s - %v\n", p.appId, err)
+		}
+	}
+	if p.config.WriteBuffer > 0 {
+		if err := tcpConn.SetWriteBuffer(p.config.WriteBuffer); err != nil {
+			log.Printf("[WARN] Unable to set the write buffer for %s - %v\n", p.appId, err)
+		}
+	}
+}
+
+// mark sets the DSCP value from the app config on the packets of the connection
+func (p *Request) mark(conn net.Conn) {
+	tcpConn, ok := asTCPConn(conn)
+	if !ok || p.config.DSCP < 0 {
+		return
+	}
+	// DSCP is the upper 6 bits of the ToS byte
+	if err := setTOS(tcpConn, p.config.DSCP<<2); err != nil {
+		log.Printf("[WARN] Unable to set the DSCP for %s - %v\n", p.appId, err)
+	}
+}
+
+func (p *Request) copy(dst net.Conn, src io.Reader) (int64, error) {
+	tcpConn, ok := asTCPConn(dst)
+	if !p.config.Cork || !ok {
+		return io.Copy(dst, src)
+	}
+	return copyCorked(tcpConn, src)
+}
+
+// copyCorked copies from src to dst while keeping dst corked. Whenever src
+// hands us less than a full buffer we assume there's nothing more pending
+// right now and flush by toggling the cork off and on again.
+func copyCorked(dst *net.TCPConn, src io.Reader) (written int64, err error) {
+	if err = setCork(dst, true); err != nil {
+		return io.Copy(dst, src)
+	}
+	defer setCork(dst, false)
+
+	buf := make([]byte, 32*1024)
+	for {
+		nr, er := src.Read(buf)
+		if nr > 0 {
+			nw, ew := dst.Write(buf[0:nr])
+			written += int64(nw)
+			if ew != nil {
+				return written, ew
+			}
+			if nr < len(buf) {
+				setCork(dst, false)
+				setCork(dst, true)
+			}
+		}
+		if er != nil {
+			if er == io.EOF {
+				return written, nil
+			}
+			return written, er
+		}
+	}
+}
+
+// replayBuffer holds what the client sent before the backend responded, up
+// to a limit. Once we go past the limit we can no longer replay the request.
+type replayBuffer struct {
+	limit    int
+	data     []byte
+	overflow bool
+}
+
+func newReplayBuffer(limit int) *replayBuffer {
+	return &replayBuffer{limit: limit}
+}
+
+func (r *replayBuffer) record(data []byte) {
+	if r.overflow {
+		return
+	}
+	if len(r.data)+len(data) > r.limit {
+		r.overflow = true
+		r.data = nil
+		return
+	}
+	r.data = append(r.data, data...)
+}
+
+func (r *replayBuffer) active() bool {
+	return !r.overflow
+}
+
+// backendReader reads from the backend, calling first once the first bytes
+// are read and failed with the error the read fails with
+type backendReader struct {
+	reader io.Reader
+	first  func()
+	failed func(error)
+	read   bool
+}
+
+func (b *backendReader) Read(p []byte) (int, error) {
+	n, err := b.reader.Read(p)
+	if n > 0 && !b.read {
+		b.read = true
+		b.first()
+	}
+	if err != nil && err != io.EOF {
+		b.failed(err)
+	}
+	return n, err
+}
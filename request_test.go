@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ashwanthkumar/golang-utils/sets"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestToFailoverWhenTheBackendResetsBeforeResponding(t *testing.T) {
+	resetting := startBackend(t, func(conn net.Conn) {
+		buf := make([]byte, 5)
+		io.ReadFull(conn, buf)
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+	})
+	defer resetting.Close()
+	echo := startEchoBackend(t)
+	defer echo.Close()
+
+	frontend := createFrontendWithLabels([]string{echo.Addr().String()}, map[string]string{"tlb.failover.attempts": "2"})
+	client := proxyThrough(t, resetting.Addr().String(), frontend)
+	defer client.Close()
+
+	_, err := client.Write([]byte("hello"))
+	assert.NoError(t, err)
+	response := make([]byte, 5)
+	_, err = io.ReadFull(client, response)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(response))
+}
+
+func TestRequestToFailoverWhenTheBackendRefusesTheConnection(t *testing.T) {
+	echo := startEchoBackend(t)
+	defer echo.Close()
+	refusing := startEchoBackend(t)
+	refusing.Close()
+
+	frontend := createFrontendWithLabels([]string{echo.Addr().String()}, map[string]string{"tlb.failover.attempts": "2"})
+	client := proxyThrough(t, refusing.Addr().String(), frontend)
+	defer client.Close()
+
+	_, err := client.Write([]byte("hello"))
+	assert.NoError(t, err)
+	response := make([]byte, 5)
+	_, err = io.ReadFull(client, response)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(response))
+}
+
+func TestRequestToTryEachBackendOnceUptoTheFailoverAttempts(t *testing.T) {
+	var refusing []string
+	for i := 0; i < 3; i++ {
+		backend := startEchoBackend(t)
+		backend.Close()
+		refusing = append(refusing, backend.Addr().String())
+	}
+	failovers := metrics.Counter("frontend-failovers", "app", APP_ID)
+	retried := metrics.Counter("frontend-retries", "app", APP_ID)
+	for attempts, retries := range map[string]float64{"2": 1, "5": 2} {
+		frontend := createFrontendWithLabels(refusing, map[string]string{"tlb.failover.attempts": attempts})
+		before, retriedBefore := failovers.Value(), retried.Value()
+		client := proxyThrough(t, refusing[0], frontend)
+		client.Write([]byte("hello"))
+		// the connection is closed once it's out of attempts, or of backends it hasn't tried
+		_, err := io.ReadFull(client, make([]byte, 5))
+		assert.Error(t, err)
+		client.Close()
+		assert.Equal(t, before+retries, failovers.Value(), attempts)
+		assert.Equal(t, retriedBefore+retries, retried.Value(), attempts)
+	}
+}
+
+func TestRequestToFailoverWhenTheBackendResetsOnAccept(t *testing.T) {
+	resetting := startBackend(t, func(conn net.Conn) {
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+	})
+	defer resetting.Close()
+	echo := startEchoBackend(t)
+	defer echo.Close()
+
+	frontend := createFrontendWithLabels([]string{echo.Addr().String()}, map[string]string{"tlb.failover.attempts": "2"})
+	client := proxyThrough(t, resetting.Addr().String(), frontend)
+	defer client.Close()
+
+	_, err := client.Write([]byte("hello"))
+	assert.NoError(t, err)
+	response := make([]byte, 5)
+	_, err = io.ReadFull(client, response)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(response))
+}
+
+func TestRequestToFailoverWhenTheBackendClosesBeforeGettingAnything(t *testing.T) {
+	closing := startBackend(t, func(conn net.Conn) {
+		conn.Close()
+	})
+	defer closing.Close()
+	accepted := make(chan bool, 1)
+	echo := startBackend(t, func(conn net.Conn) {
+		defer conn.Close()
+		accepted <- true
+		io.Copy(conn, conn)
+	})
+	defer echo.Close()
+
+	frontend := createFrontendWithLabels([]string{echo.Addr().String()}, map[string]string{"tlb.failover.attempts": "2"})
+	client := proxyThrough(t, closing.Addr().String(), frontend)
+	defer client.Close()
+
+	// only write once we've moved on, so the first backend never gets anything
+	select {
+	case <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Didn't fail over to the other backend")
+	}
+	_, err := client.Write([]byte("hello"))
+	assert.NoError(t, err)
+	response := make([]byte, 5)
+	_, err = io.ReadFull(client, response)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(response))
+}
+
+func TestRequestNotToFailoverOnceTheReplayBufferOverflows(t *testing.T) {
+	resetting := startBackend(t, func(conn net.Conn) {
+		buf := make([]byte, 10)
+		io.ReadFull(conn, buf)
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+	})
+	defer resetting.Close()
+	echo := startEchoBackend(t)
+	defer echo.Close()
+
+	frontend := createFrontendWithLabels([]string{echo.Addr().String()}, map[string]string{"tlb.failover.attempts": "2", "tlb.failover.buffer": "4"})
+	client := proxyThrough(t, resetting.Addr().String(), frontend)
+	defer client.Close()
+
+	client.Write([]byte("hello"))
+	client.Write([]byte("world"))
+	_, err := io.ReadFull(client, make([]byte, 10))
+	assert.Error(t, err)
+}
+
+func TestRequestToApplyTheUpdatedIdleTimeoutMidConnection(t *testing.T) {
+	echo := startEchoBackend(t)
+	defer echo.Close()
+
+	frontend := createFrontendWithLabels([]string{echo.Addr().String()}, map[string]string{"tlb.timeout.idle": "1m"})
+	client := proxyThrough(t, echo.Addr().String(), frontend)
+	defer client.Close()
+
+	assertEchoes(t, client, "hello")
+	frontend.UpdateConfig(NewFrontendConfig(map[string]string{"tlb.timeout.idle": "100ms"}))
+	// the next read of the connection picks up the new timeout
+	assertEchoes(t, client, "world")
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	started := time.Now()
+	_, err := client.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	assert.True(t, time.Since(started) < 5*time.Second)
+}
+
+func TestTimeoutReaderToPushTheDeadlineBackOnceHalfOfItIsGone(t *testing.T) {
+	conn := &deadlineConn{}
+	r := &timeoutReader{conn: conn}
+	now := time.Now()
+	r.applyIdleTimeout(time.Minute, now)
+	r.applyIdleTimeout(time.Minute, now.Add(20*time.Second))
+	assert.Equal(t, 1, conn.set)
+	assert.Equal(t, now.Add(time.Minute), conn.deadline)
+	r.applyIdleTimeout(time.Minute, now.Add(31*time.Second))
+	assert.Equal(t, 2, conn.set)
+	assert.Equal(t, now.Add(91*time.Second), conn.deadline)
+
+	// a changed timeout applies right away
+	r.applyIdleTimeout(0, now.Add(32*time.Second))
+	r.applyIdleTimeout(0, now.Add(33*time.Second))
+	assert.Equal(t, 3, conn.set)
+	assert.True(t, conn.deadline.IsZero())
+}
+
+// deadlineConn records the read deadlines set on it
+type deadlineConn struct {
+	net.Conn
+	set      int
+	deadline time.Time
+}
+
+func (c *deadlineConn) SetReadDeadline(deadline time.Time) error {
+	c.set++
+	c.deadline = deadline
+	return nil
+}
+
+func TestRequestNotToTimeoutWhileEitherDirectionIsActive(t *testing.T) {
+	echo := startEchoBackend(t)
+	defer echo.Close()
+
+	frontend := createFrontendWithLabels([]string{echo.Addr().String()}, map[string]string{"tlb.timeout.idle": "200ms"})
+	client := proxyThrough(t, echo.Addr().String(), frontend)
+	defer client.Close()
+
+	for i := 0; i < 5; i++ {
+		assertEchoes(t, client, "hello")
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestRequestToCloseTheConnectionOnceItsLifetimeIsOver(t *testing.T) {
+	echo := startEchoBackend(t)
+	defer echo.Close()
+
+	frontend := createFrontendWithLabels([]string{echo.Addr().String()}, map[string]string{"tlb.timeout.lifetime": "300ms"})
+	frontend.appId = "/lifetime"
+	closes := metrics.Counter("frontend-lifetime-closes", "app", "/lifetime")
+	before := closes.Value()
+	client := proxyThrough(t, echo.Addr().String(), frontend)
+	defer client.Close()
+
+	// however active it is
+	started := time.Now()
+	for time.Since(started) < 200*time.Millisecond {
+		assertEchoes(t, client, "hello")
+		time.Sleep(10 * time.Millisecond)
+	}
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err := ioutil.ReadAll(client)
+	// it's closed, not reset
+	assert.NoError(t, err)
+	assert.True(t, time.Since(started) < 2*time.Second)
+	assert.Equal(t, before+1, closes.Value())
+}
+
+func assertEchoes(t *testing.T, client net.Conn, message string) {
+	_, err := client.Write([]byte(message))
+	assert.NoError(t, err)
+	response := make([]byte, len(message))
+	_, err = io.ReadFull(client, response)
+	assert.NoError(t, err)
+	assert.Equal(t, message, string(response))
+}
+
+func TestRequestToSendTheProxyHeaderBeforeTheClientData(t *testing.T) {
+	echo := startEchoBackend(t)
+	defer echo.Close()
+
+	frontend := createFrontendWithLabels([]string{echo.Addr().String()}, map[string]string{"tlb.proxyprotocol": "true"})
+	client := proxyThrough(t, echo.Addr().String(), frontend)
+	defer client.Close()
+
+	_, err := client.Write([]byte("hello"))
+	assert.NoError(t, err)
+	expected := string(proxyHeaderV1(client.LocalAddr(), client.RemoteAddr())) + "hello"
+	response := make([]byte, len(expected))
+	_, err = io.ReadFull(client, response)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(response))
+}
+
+func TestRequestToCloseTheIdleConnectionsWhoseProbeFails(t *testing.T) {
+	peers := make(chan net.Conn, 2)
+	l := startBackend(t, func(conn net.Conn) { peers <- conn })
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	defer client.Close()
+	backend, err := net.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	<-peers
+	backendPeer := <-peers
+
+	frontend := createFrontendWithLabels([]string{"b:1"}, map[string]string{"tlb.timeout.probe": "10ms"})
+	p := &Request{appId: "/probed", config: frontend.Config(), frontend: frontend, out: backend, conn: connections.Track("/probed", "client", "b:1")}
+	defer connections.Untrack(p.conn)
+	failures := metrics.Counter("frontend-probe-failures", "app", "/probed", "side", "backend")
+	before := failures.Value()
+
+	// the backend went away while the connection was idle
+	backendPeer.(*net.TCPConn).SetLinger(0)
+	backendPeer.Close()
+	done := make(chan bool)
+	probed := make(chan bool)
+	go func() {
+		p.probe(client, done)
+		close(probed)
+	}()
+	select {
+	case <-probed:
+	case <-time.After(5 * time.Second):
+		close(done)
+		t.Fatal("The failed probe didn't close the connection")
+	}
+	_, err = client.Write([]byte("hello"))
+	assert.Error(t, err)
+	assert.Equal(t, before+1, failures.Value())
+}
+
+func TestRequestToCountTheConnectionsOfTheBackendForLeastConnections(t *testing.T) {
+	echo := startEchoBackend(t)
+	defer echo.Close()
+	refusing := startEchoBackend(t)
+	refusing.Close()
+
+	frontend := createFrontendWithLabels([]string{echo.Addr().String()}, map[string]string{"tlb.strategy": "leastconn", "tlb.failover.attempts": "2"})
+	strategy := frontend.strategy.(*LeastConnection)
+	client := proxyThrough(t, refusing.Addr().String(), frontend)
+	_, err := client.Write([]byte("hello"))
+	assert.NoError(t, err)
+	response := make([]byte, 5)
+	_, err = io.ReadFull(client, response)
+	assert.NoError(t, err)
+	// only the backend we failed over to has the connection
+	assert.Equal(t, 1, strategy.Connections(echo.Addr().String()))
+	assert.Equal(t, 0, strategy.Connections(refusing.Addr().String()))
+
+	client.Close()
+	for i := 0; strategy.Connections(echo.Addr().String()) != 0; i++ {
+		if i == 100 {
+			t.Fatal("the connection wasn't released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRequestToObserveTheLatencyOfTheBackendForEWMA(t *testing.T) {
+	echo := startEchoBackend(t)
+	defer echo.Close()
+
+	for _, labels := range []map[string]string{
+		{"tlb.strategy": "ewma"},
+		{"tlb.strategy": "ewma", "tlb.failover.attempts": "2"},
+	} {
+		frontend := createFrontendWithLabels([]string{echo.Addr().String()}, labels)
+		strategy := frontend.strategy.(*EWMA)
+		client := proxyThrough(t, echo.Addr().String(), frontend)
+		_, err := client.Write([]byte("hello"))
+		assert.NoError(t, err)
+		response := make([]byte, 5)
+		_, err = io.ReadFull(client, response)
+		assert.NoError(t, err)
+		assert.True(t, strategy.Latency(echo.Addr().String()) > 0, "%v", labels)
+		client.Close()
+	}
+}
+
+func TestRequestToPenalizeTheBackendThatNeverAnswersForEWMA(t *testing.T) {
+	echo := startEchoBackend(t)
+	defer echo.Close()
+	// accepts and reads, but never writes back
+	silent := startBackend(t, func(conn net.Conn) { io.Copy(ioutil.Discard, conn) })
+	defer silent.Close()
+
+	frontend := createFrontendWithLabels([]string{echo.Addr().String(), silent.Addr().String()}, map[string]string{"tlb.strategy": "ewma", "tlb.timeout.idle": "50ms"})
+	strategy := frontend.strategy.(*EWMA)
+	client := proxyThrough(t, silent.Addr().String(), frontend)
+	defer client.Close()
+	_, err := client.Write([]byte("hello"))
+	assert.NoError(t, err)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = client.Read(make([]byte, 1))
+	assert.Error(t, err)
+	for i := 0; strategy.Latency(silent.Addr().String()) == 0; i++ {
+		if i == 100 {
+			t.Fatal("the silent backend wasn't observed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, strategy.Latency(silent.Addr().String()) >= 50*time.Millisecond)
+	assert.Equal(t, echo.Addr().String(), frontend.Lookup())
+	frontend.observe(echo.Addr().String(), time.Millisecond)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, echo.Addr().String(), frontend.Lookup())
+	}
+}
+
+func TestRequestToCloseTheClientWhenItCannotConnectToABackend(t *testing.T) {
+	refusing := startEchoBackend(t)
+	refusing.Close()
+	frontend := createFrontendWithLabels(nil, map[string]string{"tlb.timeout.dial": "50ms"})
+	frontend.appId = "/dial-errors"
+	assert.Equal(t, 50*time.Millisecond, frontend.Config().DialTimeout)
+	dialErrors := metrics.Counter("frontend-dial-errors", "app", "/dial-errors")
+	before := dialErrors.Value()
+
+	client := proxyThrough(t, refusing.Addr().String(), frontend)
+	defer client.Close()
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err := client.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, before+1, dialErrors.Value())
+}
+
+func TestRequestToDeliverTheResponseAfterTheClientHalfCloses(t *testing.T) {
+	// answers only once the client is done sending
+	backend := startBackend(t, func(conn net.Conn) {
+		defer conn.Close()
+		request, _ := ioutil.ReadAll(conn)
+		time.Sleep(20 * time.Millisecond)
+		conn.Write(bytes.Repeat(request, 1000))
+	})
+	defer backend.Close()
+
+	for _, labels := range []map[string]string{nil, {"tlb.failover.attempts": "2"}} {
+		frontend := createFrontendWithLabels([]string{backend.Addr().String()}, labels)
+		client := proxyThrough(t, backend.Addr().String(), frontend)
+		_, err := client.Write([]byte("hello"))
+		assert.NoError(t, err)
+		assert.NoError(t, client.(*net.TCPConn).CloseWrite())
+		client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		response, err := ioutil.ReadAll(client)
+		assert.NoError(t, err)
+		assert.Equal(t, 5000, len(response), "%v", labels)
+		client.Close()
+	}
+}
+
+func createFrontendWithLabels(backends []string, labels map[string]string) *Frontend {
+	return NewFrontend(APP_ID, "-1", sets.FromSlice(backends), NewFrontendConfig(labels))
+}
+
+// proxyThrough proxies a new client connection to backend of the frontend and returns the client side of it
+func proxyThrough(t *testing.T, backend string, frontend *Frontend) net.Conn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		in, err := l.Accept()
+		if err == nil {
+			NewRequest(in, backend, frontend)
+		}
+	}()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func startEchoBackend(t *testing.T) net.Listener {
+	return startBackend(t, func(conn net.Conn) {
+		defer conn.Close()
+		io.Copy(conn, conn)
+	})
+}
+
+func startBackend(t *testing.T, handle func(conn net.Conn)) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	return l
+}
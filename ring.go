@@ -0,0 +1,80 @@
+package main
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// hashRing is a consistent hash ring of backends. Every backend is placed on
+// the ring replicas times (virtual nodes) so the keys spread evenly, and adding
+// or removing a backend only moves the keys owned by that backend.
+type hashRing struct {
+	replicas int
+	hash     hashFunc
+	hashes   []uint32
+	owners   map[uint32]string
+}
+
+func newHashRing(replicas int, hash hashFunc) *hashRing {
+	if replicas < 1 {
+		replicas = 1
+	}
+	return &hashRing{
+		replicas: replicas,
+		hash:     hash,
+		owners:   make(map[uint32]string),
+	}
+}
+
+func hashOf(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
+
+func (r *hashRing) add(backend string) {
+	for i := 0; i < r.replicas; i++ {
+		hash := r.hash(strconv.Itoa(i) + "#" + backend)
+		if _, taken := r.owners[hash]; taken {
+			continue
+		}
+		r.owners[hash] = backend
+		r.hashes = append(r.hashes, hash)
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+}
+
+func (r *hashRing) remove(backend string) {
+	hashes := r.hashes[:0]
+	for _, hash := range r.hashes {
+		if r.owners[hash] == backend {
+			delete(r.owners, hash)
+		} else {
+			hashes = append(hashes, hash)
+		}
+	}
+	r.hashes = hashes
+}
+
+// get returns the backend owning the key, empty when the ring is empty
+func (r *hashRing) get(key string) string {
+	return r.walk(key, func(string) bool { return true })
+}
+
+// walk goes clockwise from the position of the key and returns the first
+// backend that is accepted, empty when none of them are
+func (r *hashRing) walk(key string, accept func(backend string) bool) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	hash := r.hash(key)
+	start := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= hash })
+	for i := 0; i < len(r.hashes); i++ {
+		backend := r.owners[r.hashes[(start+i)%len(r.hashes)]]
+		if accept(backend) {
+			return backend
+		}
+	}
+	return ""
+}
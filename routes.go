@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"github.com/ashwanthkumar/gotlb/types"
+)
+
+type RouteChangeType string
+
+const (
+	FrontendAdded   RouteChangeType = "frontend-added"
+	FrontendRemoved RouteChangeType = "frontend-removed"
+	BackendAdded    RouteChangeType = "backend-added"
+	BackendRemoved  RouteChangeType = "backend-removed"
+)
+
+// RouteChange represents a single change to the routing table of GoTLB
+type RouteChange struct {
+	Type    RouteChangeType
+	AppId   string
+	Port    string
+	Backend string
+}
+
+// FrontendState is a point in time view of a Frontend and it's backends
+type FrontendState struct {
+	AppId    string   `json:"appId"`
+	Port     string   `json:"port"`
+	Backends []string `json:"backends"`
+	// set while the frontend is pinned to a backend
+	Override    *Override `json:"override,omitempty"`
+	Bound       bool      `json:"bound"`
+	Bind        string    `json:"bind"`
+	Strategy    string    `json:"strategy"`
+	IdleTimeout string    `json:"idleTimeout"`
+	KeepAlive   string    `json:"keepAlive"`
+	// share of the connections within tlb.slo.window that connected to a backend and moved some bytes
+	ConnectionSuccessRatio float64 `json:"connectionSuccessRatio"`
+	// labels of the app we couldn't use
+	LabelErrors []types.LabelError `json:"labelErrors,omitempty"`
+}
+
+// routeWatchers fans out the RouteChanges to everyone watching the routing table.
+// A watcher that can't keep up loses changes, it should re-sync from the state.
+type routeWatchers struct {
+	lock     sync.Mutex
+	watchers map[int]chan RouteChange
+	nextId   int
+}
+
+func newRouteWatchers() *routeWatchers {
+	return &routeWatchers{
+		watchers: make(map[int]chan RouteChange),
+	}
+}
+
+func (r *routeWatchers) watch() (<-chan RouteChange, func()) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	id := r.nextId
+	r.nextId++
+	changes := make(chan RouteChange, 128)
+	r.watchers[id] = changes
+	cancel := func() {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		if _, present := r.watchers[id]; present {
+			delete(r.watchers, id)
+			close(changes)
+		}
+	}
+	return changes, cancel
+}
+
+func (r *routeWatchers) notify(change RouteChange) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, changes := range r.watchers {
+		select {
+		case changes <- change:
+		default:
+			log.Printf("[WARN] Dropping route change %v, the watcher is not keeping up\n", change)
+			metrics.Counter("route-changes-dropped").Inc()
+		}
+	}
+}
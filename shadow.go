@@ -0,0 +1,139 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ashwanthkumar/gotlb/types"
+)
+
+// Shadow is an implementation of Strategy that routes with the active
+// strategy, and on every decision also asks the shadow strategy what it
+// would have picked. We record the selections of both and how often they
+// diverge, so a new strategy can be validated on live traffic before
+// switching to it. The shadow never affects where a connection goes.
+type Shadow struct {
+	lock       sync.Mutex
+	appId      string
+	activeName string
+	shadowName string
+	active     LoadBalancingStrategy
+	shadow     LoadBalancingStrategy
+	backends   map[string]bool
+}
+
+func ShadowStrategy(appId, activeName string, active LoadBalancingStrategy, shadowName string, shadow LoadBalancingStrategy) LoadBalancingStrategy {
+	return &Shadow{
+		appId:      appId,
+		activeName: activeName,
+		shadowName: shadowName,
+		active:     active,
+		shadow:     shadow,
+		backends:   make(map[string]bool),
+	}
+}
+
+func (s *Shadow) AddBackendInfo(backend *types.BackendInfo) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	exists := s.backends[backend.Node]
+	s.backends[backend.Node] = true
+	addBackendInfo(s.active, backend, exists)
+	addBackendInfo(s.shadow, backend, exists)
+}
+
+func (s *Shadow) AddBackend(backend string) {
+	s.AddBackendInfo(&types.BackendInfo{AppId: s.appId, Node: backend})
+}
+
+func (s *Shadow) RemoveBackend(backend string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.backends, backend)
+	s.active.RemoveBackend(backend)
+	s.shadow.RemoveBackend(backend)
+}
+
+func (s *Shadow) UpdateBackends(added []*types.BackendInfo, removed []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var fresh []*types.BackendInfo
+	for _, backend := range removed {
+		delete(s.backends, backend)
+	}
+	for _, backend := range added {
+		if !s.backends[backend.Node] {
+			fresh = append(fresh, backend)
+		}
+		s.backends[backend.Node] = true
+	}
+	for _, strategy := range []LoadBalancingStrategy{s.active, s.shadow} {
+		if _, aware := strategy.(BackendInfoAware); aware {
+			updateBackends(strategy, added, removed)
+		} else {
+			updateBackends(strategy, fresh, removed)
+		}
+	}
+}
+
+func (s *Shadow) Acquire(backend string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	acquire(s.active, backend)
+	acquire(s.shadow, backend)
+}
+
+func (s *Shadow) Release(backend string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	release(s.active, backend)
+	release(s.shadow, backend)
+}
+
+func (s *Shadow) Observe(backend string, latency time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	observe(s.active, backend, latency)
+	observe(s.shadow, backend, latency)
+}
+
+func (s *Shadow) Next() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.compare(s.active.Next(), s.shadow.Next())
+}
+
+// NextFor lets the sticky strategies among the two see the key, the others just pick the next one
+func (s *Shadow) NextFor(key string, exclude map[string]bool) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.compare(nextFor(s.active, key, exclude), nextFor(s.shadow, key, exclude))
+}
+
+func (s *Shadow) SetHealthy(backend string, healthy bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, strategy := range []LoadBalancingStrategy{s.active, s.shadow} {
+		if sticky, ok := strategy.(StickyStrategy); ok {
+			sticky.SetHealthy(backend, healthy)
+		}
+	}
+}
+
+// forget deletes the selections of the backend, once discovery removed it
+// and neither of the strategies can pick it anymore
+func (s *Shadow) forget(backend string) {
+	metrics.Delete("frontend-strategy-selections", "app", s.appId, "strategy", s.activeName, "role", "active", "backend", backend)
+	metrics.Delete("frontend-strategy-selections", "app", s.appId, "strategy", s.shadowName, "role", "shadow", "backend", backend)
+}
+
+// compare records both the selections and returns the active one
+func (s *Shadow) compare(active, shadow string) string {
+	metrics.Counter("frontend-strategy-selections", "app", s.appId, "strategy", s.activeName, "role", "active", "backend", active).Inc()
+	metrics.Counter("frontend-strategy-selections", "app", s.appId, "strategy", s.shadowName, "role", "shadow", "backend", shadow).Inc()
+	metrics.Counter("frontend-shadow-decisions", "app", s.appId, "shadow", s.shadowName).Inc()
+	if active != shadow {
+		metrics.Counter("frontend-shadow-divergences", "app", s.appId, "shadow", s.shadowName).Inc()
+	}
+	return active
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ashwanthkumar/golang-utils/sets"
+	"github.com/ashwanthkumar/gotlb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShadowStrategyToRouteWithTheActiveStrategy(t *testing.T) {
+	decisions := metrics.Counter("frontend-shadow-decisions", "app", "/shadow-app", "shadow", IPHashName)
+	divergences := metrics.Counter("frontend-shadow-divergences", "app", "/shadow-app", "shadow", IPHashName)
+	decisionsBefore, divergencesBefore := decisions.Value(), divergences.Value()
+	active := RoundRobinStrategy()
+	s := ShadowStrategy("/shadow-app", RoundRobinName, active, IPHashName, IPHashStrategy("/shadow-app", RingFallback, defaultReplicas, FNVHash))
+	s.AddBackend("a")
+	s.AddBackend("b")
+
+	var selections []string
+	for i := 0; i < 4; i++ {
+		selections = append(selections, s.(StickyStrategy).NextFor("10.0.0.1", nil))
+	}
+	assert.Equal(t, []string{"a", "b", "a", "b"}, selections)
+	// iphash always picks the same backend, so it diverges on half of them
+	assert.Equal(t, decisionsBefore+4, decisions.Value())
+	assert.Equal(t, divergencesBefore+2, divergences.Value())
+}
+
+func TestShadowStrategyToRecordTheSelectionsOfBoth(t *testing.T) {
+	s := ShadowStrategy("/shadow-selections", WeightedName, WeightedRoundRobinStrategy(false), RoundRobinName, RoundRobinStrategy())
+	s.(BackendInfoAware).AddBackendInfo(&types.BackendInfo{Node: "a", Weight: 3})
+	s.(BackendInfoAware).AddBackendInfo(&types.BackendInfo{Node: "b", Weight: 1})
+	// an update to the info must not add the backend again to round robin
+	s.(BackendInfoAware).AddBackendInfo(&types.BackendInfo{Node: "b", Weight: 1})
+
+	selections := func(strategy, role, backend string) float64 {
+		return metrics.Counter("frontend-strategy-selections", "app", "/shadow-selections", "strategy", strategy, "role", role, "backend", backend).Value()
+	}
+	before := []float64{selections(WeightedName, "active", "a"), selections(WeightedName, "active", "b"), selections(RoundRobinName, "shadow", "a"), selections(RoundRobinName, "shadow", "b")}
+	for i := 0; i < 8; i++ {
+		s.Next()
+	}
+	assert.Equal(t, before[0]+6, selections(WeightedName, "active", "a"))
+	assert.Equal(t, before[1]+2, selections(WeightedName, "active", "b"))
+	assert.Equal(t, before[2]+4, selections(RoundRobinName, "shadow", "a"))
+	assert.Equal(t, before[3]+4, selections(RoundRobinName, "shadow", "b"))
+}
+
+func TestFrontendToDeleteTheSelectionsOfARemovedBackend(t *testing.T) {
+	for _, labels := range []map[string]string{
+		{"tlb.strategy.shadow": "weighted"},
+		{"tlb.strategy.shadow": "weighted", "tlb.coalesce": "1h"},
+	} {
+		frontend := NewFrontend("/shadow-removed", "-1", sets.FromSlice([]string{"b:1", "b:2"}), NewFrontendConfig(labels))
+		selected := func(backend string) bool {
+			return hasSeries("frontend-strategy-selections", "app", "/shadow-removed", "strategy", RoundRobinName, "role", "active", "backend", backend) ||
+				hasSeries("frontend-strategy-selections", "app", "/shadow-removed", "strategy", WeightedName, "role", "shadow", "backend", backend)
+		}
+		for i := 0; i < 4; i++ {
+			frontend.Lookup()
+		}
+		assert.True(t, selected("b:1"), "%v", labels)
+
+		frontend.RemoveBackend("b:1")
+		if frontend.Config().CoalesceWindow > 0 {
+			// the strategy can still pick it till the removal is applied
+			assert.True(t, selected("b:1"), "%v", labels)
+			frontend.flushChanges()
+		}
+		assert.False(t, selected("b:1"), "%v", labels)
+		assert.True(t, selected("b:2"), "%v", labels)
+		frontend.Stop()
+	}
+}
+
+func TestNewStrategyToWrapTheActiveOneWhenThereIsAShadow(t *testing.T) {
+	config := NewFrontendConfig(map[string]string{"tlb.strategy.shadow": "weighted"})
+	_, shadowed := newStrategy(APP_ID, config).(*Shadow)
+	assert.True(t, shadowed)
+	_, shadowed = newStrategy(APP_ID, NewFrontendConfig(nil)).(*Shadow)
+	assert.False(t, shadowed)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const sloBuckets = 10
+
+// successWindow keeps the count of the successful and the failed dials (or
+// connections) of an app over a sliding window. The window is split into
+// buckets so old results expire a bucket at a time.
+type successWindow struct {
+	lock       sync.Mutex
+	bucketSize time.Duration
+	buckets    [sloBuckets]successBucket
+}
+
+type successBucket struct {
+	// index of the bucket since the epoch, so we know when it's stale
+	index     int64
+	successes int
+	failures  int
+}
+
+func newSuccessWindow(window time.Duration) *successWindow {
+	bucketSize := window / sloBuckets
+	if bucketSize <= 0 {
+		bucketSize = time.Second
+	}
+	return &successWindow{bucketSize: bucketSize}
+}
+
+// record adds the result of a dial and returns the success ratio of the window
+func (w *successWindow) record(now time.Time, success bool) float64 {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	index := now.UnixNano() / int64(w.bucketSize)
+	bucket := &w.buckets[index%sloBuckets]
+	if bucket.index != index {
+		*bucket = successBucket{index: index}
+	}
+	if success {
+		bucket.successes++
+	} else {
+		bucket.failures++
+	}
+	return w.ratioAt(index)
+}
+
+// ratio returns the success ratio of the window, 1 when there were no dials
+func (w *successWindow) ratio(now time.Time) float64 {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.ratioAt(now.UnixNano() / int64(w.bucketSize))
+}
+
+func (w *successWindow) ratioAt(index int64) float64 {
+	successes, total := 0, 0
+	for _, bucket := range w.buckets {
+		if index-bucket.index < sloBuckets {
+			successes += bucket.successes
+			total += bucket.successes + bucket.failures
+		}
+	}
+	if total == 0 {
+		return 1
+	}
+	return float64(successes) / float64(total)
+}
+
+// recordDial accounts the result of a dial to the backend of the app, for
+// the per backend error counters and the success ratio of the app
+func (f *Frontend) recordDial(backend string, err error) {
+	f.countBackend("backend-dials", backend)
+	if err != nil {
+		f.countBackend("backend-dial-failures", backend)
+	}
+	now := time.Now()
+	ratio := f.slo.record(now, err == nil)
+	metrics.Gauge("frontend-success-ratio", "app", f.appId).Set(ratio)
+	f.recordOutlier(now, backend, err)
+}
+
+// recordConnection accounts an accepted connection once it's done, for the
+// connection success ratio of the app. It's a success when we connected to a
+// backend and some bytes went through, in either direction.
+func (f *Frontend) recordConnection(success bool) {
+	ratio := f.sli.record(time.Now(), success)
+	metrics.Gauge("frontend-connection-success-ratio", "app", f.appId).Set(ratio)
+}
+
+// ConnectionSuccessRatio is the share of the connections within the window that went through
+func (f *Frontend) ConnectionSuccessRatio() float64 {
+	return f.sli.ratio(time.Now())
+}
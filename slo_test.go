@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuccessWindowRatio(t *testing.T) {
+	w := newSuccessWindow(10 * time.Second)
+	now := time.Unix(1000, 0)
+	assert.Equal(t, float64(1), w.ratio(now))
+
+	for i := 0; i < 3; i++ {
+		w.record(now, true)
+	}
+	assert.Equal(t, 0.75, w.record(now.Add(2*time.Second), false))
+}
+
+func TestSuccessWindowToExpireOldResults(t *testing.T) {
+	w := newSuccessWindow(10 * time.Second)
+	now := time.Unix(1000, 0)
+	w.record(now, false)
+	w.record(now.Add(5*time.Second), true)
+	assert.Equal(t, 0.5, w.ratio(now.Add(9*time.Second)))
+	// the failure is out of the window, the success is not
+	assert.Equal(t, float64(1), w.ratio(now.Add(12*time.Second)))
+	assert.Equal(t, float64(1), w.ratio(now.Add(time.Minute)))
+}
+
+func TestFrontendToTrackTheSuccessRatioOfTheDials(t *testing.T) {
+	frontend := createFrontendWithLabels([]string{"b:1", "b:2"}, map[string]string{"tlb.slo.window": "1m"})
+	frontend.appId = "/slo-app"
+	failures := func(backend string) float64 {
+		return metrics.Counter("backend-dial-failures", "app", "/slo-app", "backend", backend).Value()
+	}
+	before1, before2 := failures("b:1"), failures("b:2")
+	frontend.recordDial("b:1", nil)
+	frontend.recordDial("b:2", errors.New("connection refused"))
+
+	assert.Equal(t, 0.5, metrics.Gauge("frontend-success-ratio", "app", "/slo-app").Value())
+	assert.Equal(t, before2+1, failures("b:2"))
+	assert.Equal(t, before1, failures("b:1"))
+}
+
+func TestFrontendToDeleteTheDialSeriesOfARemovedBackend(t *testing.T) {
+	frontend := createFrontendWithLabels([]string{"b:1", "b:2"}, nil)
+	frontend.appId = "/slo-removed"
+	frontend.recordDial("b:1", errors.New("connection refused"))
+	frontend.recordDial("b:2", nil)
+	frontend.RemoveBackend("b:1")
+	assert.False(t, hasSeries("backend-dials", "app", "/slo-removed", "backend", "b:1"))
+	assert.False(t, hasSeries("backend-dial-failures", "app", "/slo-removed", "backend", "b:1"))
+	assert.True(t, hasSeries("backend-dials", "app", "/slo-removed", "backend", "b:2"))
+
+	// a dial that was in flight doesn't bring them back
+	frontend.recordDial("b:1", errors.New("connection refused"))
+	assert.False(t, hasSeries("backend-dials", "app", "/slo-removed", "backend", "b:1"))
+}
+
+func TestRequestToTrackTheSuccessRatioOfTheConnections(t *testing.T) {
+	frontend := createFrontendWithLabels(nil, map[string]string{"tlb.slo.window": "1m"})
+	frontend.appId = "/sli-app"
+	echo := startEchoBackend(t)
+	defer echo.Close()
+	// accepts the connection but closes it without a byte
+	silent := startBackend(t, func(conn net.Conn) { conn.Close() })
+	defer silent.Close()
+
+	proxy := func(backend string, payload string) {
+		client, in := net.Pipe()
+		done := make(chan bool)
+		go func() {
+			NewRequest(in, backend, frontend)
+			close(done)
+		}()
+		client.Write([]byte(payload))
+		client.Close()
+		<-done
+	}
+	proxy(echo.Addr().String(), "ping")
+	proxy(silent.Addr().String(), "")
+
+	assert.Equal(t, 0.5, metrics.Gauge("frontend-connection-success-ratio", "app", "/sli-app").Value())
+	assert.Equal(t, 0.5, frontend.ConnectionSuccessRatio())
+	// the dials went through either way
+	assert.Equal(t, float64(1), metrics.Gauge("frontend-success-ratio", "app", "/sli-app").Value())
+}
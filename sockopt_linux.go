@@ -0,0 +1,139 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const corkSupported = true
+
+const dscpSupported = true
+
+const reusePortSupported = true
+
+// SO_REUSEPORT isn't in syscall, this is it's value on the architectures we build for
+const soReusePort = 0xf
+
+// setCork toggles TCP_CORK on the connection. While corked the kernel holds
+// back partial segments, un-corking flushes whatever is pending.
+func setCork(conn *net.TCPConn, cork bool) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	value := 0
+	if cork {
+		value = 1
+	}
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_CORK, value)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// setTOS sets the ToS (IPv4) or the traffic class (IPv6) of the packets
+// sent on the connection
+func setTOS(conn *net.TCPConn, tos int) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	level, option := syscall.IPPROTO_IP, syscall.IP_TOS
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && addr.IP.To4() == nil {
+		level, option = syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS
+	}
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), level, option, tos)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// listenReusePort listens on the address with SO_REUSEPORT, so more than one
+// listener can be bound to the same port. The kernel spreads the incoming
+// connections across all of them.
+func listenReusePort(address string) (net.Listener, error) {
+	config := net.ListenConfig{Control: reusePort}
+	return config.Listen(context.Background(), "tcp", address)
+}
+
+// listenPacketReusePort is listenReusePort for UDP, the kernel spreads the
+// datagrams across the sockets by the address of the client
+func listenPacketReusePort(address string) (*net.UDPConn, error) {
+	config := net.ListenConfig{Control: reusePort}
+	conn, err := config.ListenPacket(context.Background(), "udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+// reusePort sets SO_REUSEPORT on the socket before it's bound
+func reusePort(network, address string, conn syscall.RawConn) error {
+	var sockErr error
+	err := conn.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// setBacklog changes the accept backlog of an already listening socket.
+// Linux allows calling listen(2) again on a listening socket to do that,
+// the kernel silently caps the value to net.core.somaxconn.
+func setBacklog(l net.Listener, backlog int) error {
+	tcpListener, ok := l.(*net.TCPListener)
+	if !ok {
+		return nil
+	}
+	raw, err := tcpListener.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var listenErr error
+	err = raw.Control(func(fd uintptr) {
+		listenErr = syscall.Listen(int(fd), backlog)
+	})
+	if err != nil {
+		return err
+	}
+	return listenErr
+}
+
+// openFileLimit returns the soft limit of open files of GoTLB, 0 if we can't find it
+func openFileLimit() int {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 0
+	}
+	return int(limit.Cur)
+}
+
+// somaxconn returns the value of net.core.somaxconn, 0 if we can't find it
+func somaxconn() int {
+	contents, err := ioutil.ReadFile("/proc/sys/net/core/somaxconn")
+	if err != nil {
+		return 0
+	}
+	value, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return 0
+	}
+	return value
+}
@@ -0,0 +1,35 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetTOSToMarkTheConnection(t *testing.T) {
+	l := startEchoBackend(t)
+	defer l.Close()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	assert.NoError(t, setTOS(conn.(*net.TCPConn), 46<<2))
+	raw, _ := conn.(*net.TCPConn).SyscallConn()
+	var tos int
+	raw.Control(func(fd uintptr) {
+		tos, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 46<<2, tos)
+}
+
+func TestNewFrontendConfigToIgnoreInvalidDSCP(t *testing.T) {
+	assert.Equal(t, 46, NewFrontendConfig(map[string]string{"tlb.dscp": "46"}).DSCP)
+	assert.Equal(t, -1, NewFrontendConfig(map[string]string{"tlb.dscp": "64"}).DSCP)
+	assert.Equal(t, -1, NewFrontendConfig(nil).DSCP)
+}
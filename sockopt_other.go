@@ -0,0 +1,49 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+const corkSupported = false
+
+const dscpSupported = false
+
+const reusePortSupported = false
+
+func setCork(conn *net.TCPConn, cork bool) error {
+	return errors.New("TCP_CORK is not supported on this platform")
+}
+
+func setTOS(conn *net.TCPConn, tos int) error {
+	return errors.New("Setting IP_TOS is not supported on this platform")
+}
+
+// listenReusePort is a plain listen, the port can't be shared on this platform
+func listenReusePort(address string) (net.Listener, error) {
+	return net.Listen("tcp", address)
+}
+
+// listenPacketReusePort is a plain UDP listen, the port can't be shared on this platform
+func listenPacketReusePort(address string) (*net.UDPConn, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return net.ListenUDP("udp", addr)
+}
+
+func setBacklog(l net.Listener, backlog int) error {
+	return errors.New("Changing the listen backlog is not supported on this platform")
+}
+
+func somaxconn() int {
+	return 0
+}
+
+func openFileLimit() int {
+	return 0
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ashwanthkumar/gotlb/types"
+)
+
+// defaultSteerTimeout is how long we wait for the first bytes of the client
+// when the app steers the connections
+const defaultSteerTimeout = 100 * time.Millisecond
+
+// Selector is what the client asks of the backend it wants, like region=eu
+type Selector map[string]string
+
+// parseSelector parses comma separated key=value pairs, ignoring the invalid ones
+func parseSelector(value string) Selector {
+	selector := make(Selector)
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			continue
+		}
+		selector[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	if len(selector) == 0 {
+		return nil
+	}
+	return selector
+}
+
+// matches tells if the metadata of the backend has all the tags of the
+// selector, the zone of the backend is also it's zone tag
+func (s Selector) matches(backend *types.BackendInfo) bool {
+	if backend == nil {
+		return false
+	}
+	for key, value := range s {
+		tag, present := backend.Meta(key)
+		if !present || tag != value {
+			return false
+		}
+	}
+	return true
+}
+
+// headerSelector returns the selector in the header of the HTTP request the
+// client started with, nil if it's not there
+func headerSelector(first []byte, header string) Selector {
+	if !isHTTP(first) {
+		return nil
+	}
+	headersEnd := bytes.Index(first, []byte("\r\n\r\n"))
+	if headersEnd < 0 {
+		headersEnd = len(first)
+	}
+	for _, line := range bytes.Split(first[:headersEnd], []byte("\r\n")) {
+		colon := bytes.IndexByte(line, ':')
+		if colon < 0 {
+			continue
+		}
+		if bytes.EqualFold(bytes.TrimSpace(line[:colon]), []byte(header)) {
+			return parseSelector(string(line[colon+1:]))
+		}
+	}
+	return nil
+}
+
+// steer reads the first bytes of the client to find the backends it
+// prefers. The bytes are handed back by the returned connection, so they're
+// proxied (and counted) like the rest. A client that doesn't send anything
+// within the timeout, like one of a protocol where the server speaks first,
+// has no preference.
+func (p *Request) steer(in net.Conn) (net.Conn, Selector) {
+	timeout := p.config.SteerTimeout
+	if timeout <= 0 {
+		timeout = defaultSteerTimeout
+	}
+	in.SetReadDeadline(time.Now().Add(timeout))
+	first := make([]byte, 4*1024)
+	n, err := in.Read(first)
+	in.SetReadDeadline(time.Time{})
+	if n == 0 {
+		if err != nil && !isTimeout(err) {
+			return &prefixedConn{Conn: in, err: err}, nil
+		}
+		return in, nil
+	}
+	prefixed := &prefixedConn{Conn: in, prefix: first[:n]}
+	if err != nil && !isTimeout(err) {
+		prefixed.err = err
+	}
+	return prefixed, headerSelector(first[:n], p.config.SteerHeader)
+}
+
+// prefixedConn returns the bytes we've already read from the connection
+// (or the error we got reading them) before reading from it again
+type prefixedConn struct {
+	net.Conn
+	prefix []byte
+	err    error
+}
+
+// asTCPConn returns the TCP connection under the connection, for the socket options
+func asTCPConn(conn net.Conn) (*net.TCPConn, bool) {
+	if prefixed, ok := conn.(*prefixedConn); ok {
+		conn = prefixed.Conn
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	return tcpConn, ok
+}
+
+func (c *prefixedConn) Read(b []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := copy(b, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.Conn.Read(b)
+}
+
+// LookupMatching is LookupFor among the backends that match the selector.
+// When none of them do, it's LookupFor among all the backends.
+func (f *Frontend) LookupMatching(client string, selector Selector, exclude map[string]bool) string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if override := f.pinned(time.Now()); override != nil && !exclude[override.Backend] {
+		return override.Backend
+	}
+	var matching []string
+	skip := make(map[string]bool, len(exclude))
+	for node := range f.routed {
+		if exclude[node] || !selector.matches(f.infos[node]) {
+			skip[node] = true
+		} else {
+			matching = append(matching, node)
+		}
+	}
+	if len(matching) == 0 {
+		metrics.Counter("frontend-steered-connections", "app", f.appId, "matched", "false").Inc()
+		return nextFor(f.strategy, client, exclude)
+	}
+	metrics.Counter("frontend-steered-connections", "app", f.appId, "matched", "true").Inc()
+	if sticky, ok := f.strategy.(StickyStrategy); ok {
+		if backend := sticky.NextFor(client, skip); backend != "" && !skip[backend] {
+			return backend
+		}
+	} else {
+		// the strategy keeps it's say among the matching backends, as long as
+		// it gets to one of them soon enough
+		for i := 0; i < len(f.routed); i++ {
+			if backend := f.strategy.Next(); !skip[backend] {
+				return backend
+			}
+		}
+	}
+	sort.Strings(matching)
+	return matching[0]
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ashwanthkumar/golang-utils/sets"
+	"github.com/ashwanthkumar/gotlb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSelector(t *testing.T) {
+	assert.Equal(t, Selector{"region": "eu"}, parseSelector("region=eu"))
+	assert.Equal(t, Selector{"region": "eu", "tier": "gold"}, parseSelector(" region = eu,tier=gold,oops"))
+	assert.Nil(t, parseSelector("oops"))
+	assert.Nil(t, parseSelector(""))
+}
+
+func TestHeaderSelector(t *testing.T) {
+	request := []byte("GET / HTTP/1.1\r\nHost: foo\r\nx-route: region=eu\r\n\r\nX-Route: region=us")
+	assert.Equal(t, Selector{"region": "eu"}, headerSelector(request, "X-Route"))
+	assert.Nil(t, headerSelector(request, "X-Other"))
+	assert.Nil(t, headerSelector([]byte("\x16\x03\x01X-Route: region=eu\r\n"), "X-Route"))
+}
+
+func TestSelectorToMatchTheTagsAndTheZone(t *testing.T) {
+	backend := &types.BackendInfo{Node: "a:1", Zone: "us-east-1a", Metadata: map[string]string{"region": "eu", "tier": "gold"}}
+	assert.True(t, Selector{"region": "eu"}.matches(backend))
+	assert.True(t, Selector{"region": "eu", "zone": "us-east-1a"}.matches(backend))
+	assert.False(t, Selector{"region": "eu", "tier": "silver"}.matches(backend))
+	assert.False(t, Selector{"region": "eu"}.matches(nil))
+}
+
+func TestFrontendToLookupTheBackendsMatchingTheSelector(t *testing.T) {
+	for _, strategy := range []string{RoundRobinName, IPHashName} {
+		frontend := createFrontendWithLabels(nil, map[string]string{"tlb.strategy": strategy})
+		frontend.AddBackend(&types.BackendInfo{AppId: APP_ID, Node: "eu:1", Metadata: map[string]string{"region": "eu"}})
+		frontend.AddBackend(&types.BackendInfo{AppId: APP_ID, Node: "eu:2", Metadata: map[string]string{"region": "eu"}})
+		frontend.AddBackend(&types.BackendInfo{AppId: APP_ID, Node: "us:1", Metadata: map[string]string{"region": "us"}})
+
+		for i := 0; i < 6; i++ {
+			assert.Contains(t, []string{"eu:1", "eu:2"}, frontend.LookupMatching("10.0.0.1", Selector{"region": "eu"}, nil), strategy)
+			assert.Equal(t, "us:1", frontend.LookupMatching("10.0.0.1", Selector{"region": "us"}, nil), strategy)
+		}
+		assert.Equal(t, "eu:2", frontend.LookupMatching("10.0.0.1", Selector{"region": "eu"}, map[string]bool{"eu:1": true}), strategy)
+		// no match is no preference
+		assert.NotEmpty(t, frontend.LookupMatching("10.0.0.1", Selector{"region": "apac"}, nil), strategy)
+	}
+}
+
+func TestRequestToSteerTheConnectionToTheBackendTheClientAskedFor(t *testing.T) {
+	named := func(name string) net.Listener {
+		return startBackend(t, func(conn net.Conn) {
+			defer conn.Close()
+			conn.Write([]byte(name))
+			io.Copy(conn, conn)
+		})
+	}
+	eu, us := named("eu"), named("us")
+	defer eu.Close()
+	defer us.Close()
+
+	frontend := createFrontendWithLabels(nil, map[string]string{"tlb.steer.header": "X-Route"})
+	frontend.AddBackend(&types.BackendInfo{AppId: APP_ID, Node: eu.Addr().String(), Metadata: map[string]string{"region": "eu"}})
+	frontend.AddBackend(&types.BackendInfo{AppId: APP_ID, Node: us.Addr().String(), Metadata: map[string]string{"region": "us"}})
+	client := proxyThrough(t, us.Addr().String(), frontend)
+	defer client.Close()
+
+	request := "GET / HTTP/1.1\r\nX-Route: region=eu\r\n\r\n"
+	_, err := client.Write([]byte(request))
+	assert.NoError(t, err)
+	response := make([]byte, 2+len(request))
+	_, err = io.ReadFull(client, response)
+	assert.NoError(t, err)
+	assert.Equal(t, "eu"+request, string(response))
+}
+
+func TestRequestNotToSteerTheClientsThatDontAsk(t *testing.T) {
+	echo := startEchoBackend(t)
+	defer echo.Close()
+
+	frontend := createFrontend(APP_ID, "-1", sets.FromSlice([]string{echo.Addr().String()}))
+	frontend.UpdateConfig(NewFrontendConfig(map[string]string{"tlb.steer.header": "X-Route", "tlb.steer.timeout": "10ms"}))
+	client := proxyThrough(t, echo.Addr().String(), frontend)
+	defer client.Close()
+
+	// connected although we didn't send anything within the timeout
+	time.Sleep(50 * time.Millisecond)
+	buf := make([]byte, 5)
+	_, err := client.Write([]byte("hello"))
+	assert.NoError(t, err)
+	_, err = io.ReadFull(client, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+}
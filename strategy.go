@@ -1,7 +1,15 @@
 package main
 
 import (
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/ashwanthkumar/golang-utils/sets"
+	"github.com/ashwanthkumar/gotlb/types"
 	"github.com/oleiade/lane"
 )
 
@@ -23,19 +31,288 @@ type LoadBalancingStrategy interface {
 	RemoveBackend(backend string)
 }
 
+// BackendInfoAware is implemented by the strategies that need more than
+// just the address of a backend (like it's zone) to make their decision.
+// Frontend uses AddBackendInfo instead of AddBackend for such strategies.
+type BackendInfoAware interface {
+	AddBackendInfo(backend *types.BackendInfo)
+}
+
+// BulkUpdater is implemented by the strategies that rebuild their state on
+// every change to the backends. Frontend uses it to apply a burst of changes
+// with a single rebuild.
+type BulkUpdater interface {
+	// UpdateBackends removes and then adds (or updates the info of) the backends
+	UpdateBackends(added []*types.BackendInfo, removed []string)
+}
+
+// ConnectionAware is implemented by the strategies that pick a backend by
+// it's open connections. Frontend tells them when a connection to a backend
+// opens and closes, even when the backend has been removed in between.
+type ConnectionAware interface {
+	Acquire(backend string)
+	Release(backend string)
+}
+
+// LatencyAware is implemented by the strategies that pick a backend by how
+// fast it responds. Frontend tells them how long a connection took to get
+// the first byte back from the backend, counting from when we dialed it.
+type LatencyAware interface {
+	Observe(backend string, latency time.Duration)
+}
+
+// StickyStrategy is implemented by the strategies that pin a key of the
+// connection, like the client IP, to a backend
+type StickyStrategy interface {
+	LoadBalancingStrategy
+	// NextFor returns the backend the key is pinned to. If that backend is
+	// unhealthy or in exclude, the fallback of the strategy picks another one.
+	NextFor(key string, exclude map[string]bool) string
+	// SetHealthy marks a backend (un)healthy without changing the pinning
+	SetHealthy(backend string, healthy bool)
+}
+
+// addBackendInfo adds the backend the same way Frontend does, the info of an
+// existing backend is only given to the strategies that care about it.
+func addBackendInfo(strategy LoadBalancingStrategy, backend *types.BackendInfo, exists bool) {
+	if aware, ok := strategy.(BackendInfoAware); ok {
+		aware.AddBackendInfo(backend)
+	} else if !exists {
+		strategy.AddBackend(backend.Node)
+	}
+}
+
+// updateBackends applies the changes to the strategy, in one go if it's a
+// BulkUpdater. added should have the existing backends only for the
+// strategies that are BackendInfoAware.
+func updateBackends(strategy LoadBalancingStrategy, added []*types.BackendInfo, removed []string) {
+	if bulk, ok := strategy.(BulkUpdater); ok {
+		bulk.UpdateBackends(added, removed)
+		return
+	}
+	for _, backend := range removed {
+		strategy.RemoveBackend(backend)
+	}
+	for _, backend := range added {
+		addBackendInfo(strategy, backend, false)
+	}
+}
+
+// acquire tells the strategy a connection to the backend is open, if it cares
+func acquire(strategy LoadBalancingStrategy, backend string) {
+	if aware, ok := strategy.(ConnectionAware); ok {
+		aware.Acquire(backend)
+	}
+}
+
+// release tells the strategy a connection to the backend is closed, if it cares
+func release(strategy LoadBalancingStrategy, backend string) {
+	if aware, ok := strategy.(ConnectionAware); ok {
+		aware.Release(backend)
+	}
+}
+
+// observe tells the strategy the latency of the backend, if it cares
+func observe(strategy LoadBalancingStrategy, backend string, latency time.Duration) {
+	if aware, ok := strategy.(LatencyAware); ok {
+		aware.Observe(backend, latency)
+	}
+}
+
+// nextFor picks the backend for the key, only sticky strategies care about the key
+func nextFor(strategy LoadBalancingStrategy, key string, exclude map[string]bool) string {
+	if sticky, ok := strategy.(StickyStrategy); ok {
+		return sticky.NextFor(key, exclude)
+	}
+	return strategy.Next()
+}
+
+// StickyFallback decides how a StickyStrategy picks a backend when the pinned
+// one isn't available. Different protocols want different semantics.
+type StickyFallback string
+
+const (
+	// Pick the next available backend on the hash ring (the lookup table for
+	// maglev), the key keeps going to the same fallback as long as the
+	// pinned backend is down
+	RingFallback StickyFallback = "ring"
+	// Re-hash the key among the available backends only
+	RehashFallback StickyFallback = "rehash"
+	// Let a secondary (round robin) strategy pick any available backend
+	StrategyFallback StickyFallback = "strategy"
+)
+
+const (
+	RoundRobinName      = "roundrobin"
+	WeightedName        = "weighted"
+	IPHashName          = "iphash"
+	MaglevName          = "maglev"
+	LeastConnectionName = "leastconn"
+	RandomName          = "random"
+	P2CName             = "p2c"
+	EWMAName            = "ewma"
+)
+
+// newStrategy returns the LoadBalancingStrategy for the Frontend of the app as per it's config
+func newStrategy(appId string, config *FrontendConfig) LoadBalancingStrategy {
+	active := buildStrategy(appId, config.Strategy, config)
+	if config.ShadowStrategy == "" {
+		return active
+	}
+	return ShadowStrategy(appId, config.Strategy, active, config.ShadowStrategy, buildStrategy(appId, config.ShadowStrategy, config))
+}
+
+func buildStrategy(appId, name string, config *FrontendConfig) LoadBalancingStrategy {
+	var base func() LoadBalancingStrategy
+	switch name {
+	case RoundRobinName:
+		base = newRoundRobin
+	case WeightedName:
+		base = func() LoadBalancingStrategy {
+			return WeightedRoundRobinStrategy(config.Weights == ShareWeights)
+		}
+	case IPHashName:
+		base = func() LoadBalancingStrategy {
+			return IPHashStrategy(appId, StickyFallback(config.StickyFallback), config.Replicas, config.Hash)
+		}
+	case MaglevName:
+		base = func() LoadBalancingStrategy {
+			return MaglevStrategy(appId, StickyFallback(config.StickyFallback), config.MaglevTableSize, config.Hash, config.Weights == ShareWeights)
+		}
+	case LeastConnectionName:
+		base = LeastConnectionsStrategy
+	case RandomName:
+		base = RandomStrategy
+	case P2CName:
+		base = P2CStrategy
+	case EWMAName:
+		base = EWMAStrategy
+	default:
+		log.Printf("[WARN] Unknown strategy %s for %s, using %s\n", name, appId, RoundRobinName)
+		base = newRoundRobin
+	}
+	if config.PreferLocalZone && config.Zone != "" {
+		zoned := base
+		base = func() LoadBalancingStrategy {
+			return PreferLocalZoneStrategy(config.Zone, config.ZoneSpillover, zoned)
+		}
+	}
+	var strategy LoadBalancingStrategy
+	if config.IPFamily != "" && config.IPFamily != AnyFamily {
+		// within the preferred family we still prefer the local zone
+		strategy = PreferFamilyStrategy(config.IPFamily, base)
+	} else {
+		strategy = base()
+	}
+	if config.AffinityTTL > 0 {
+		return AffinityStrategy(config.AffinityTTL, strategy)
+	}
+	return strategy
+}
+
 // LeastConnection is an implementation of Strategy that routes
-// requests to a backend based on least number of connections
+// requests to a backend based on least number of connections. The backends
+// with the same number of connections take turns, so the connections opened
+// at once don't all go to the first of them. The count of a backend outlives
+// it's removal, so the connections still open to a backend that's added
+// back count against it.
 type LeastConnection struct {
-	// TODO - implementat LeastConnection LoadBalancingStrategy
+	lock     sync.Mutex
+	backends []string
+	// open connections of the backends, only the ones that have any
+	open map[string]int
+	next int
+}
+
+func LeastConnectionsStrategy() LoadBalancingStrategy {
+	return &LeastConnection{open: make(map[string]int)}
+}
+
+func (l *LeastConnection) AddBackend(backend string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, existing := range l.backends {
+		if existing == backend {
+			return
+		}
+	}
+	l.backends = append(l.backends, backend)
+}
+
+func (l *LeastConnection) RemoveBackend(backend string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for idx, existing := range l.backends {
+		if existing == backend {
+			l.backends = append(l.backends[:idx], l.backends[idx+1:]...)
+			return
+		}
+	}
+}
+
+func (l *LeastConnection) Next() string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if len(l.backends) == 0 {
+		return ""
+	}
+	picked := -1
+	for i := range l.backends {
+		idx := (l.next + i) % len(l.backends)
+		if picked < 0 || l.open[l.backends[idx]] < l.open[l.backends[picked]] {
+			picked = idx
+		}
+	}
+	l.next = (picked + 1) % len(l.backends)
+	return l.backends[picked]
+}
+
+func (l *LeastConnection) Acquire(backend string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.open[backend]++
+}
+
+func (l *LeastConnection) Release(backend string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.open[backend] <= 1 {
+		delete(l.open, backend)
+		return
+	}
+	l.open[backend]--
+}
+
+// Connections returns the open connections to the backend
+func (l *LeastConnection) Connections(backend string) int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.open[backend]
 }
 
 // RoundRobin is an implementation of Strategy that routes
-// requests to a backend based on round robin fashion
+// requests to a backend based on round robin fashion. It's safe for
+// concurrent use, the queue and the removals change together under the lock.
 type RoundRobin struct {
+	lock            sync.Mutex
 	backends        *lane.Queue
 	removedBackends sets.Set
+	// start at a random backend instead of the first one added
+	randomStart bool
+	started     bool
 }
 
+// RoundRobinRandomStart makes the round robin of the new frontends start at a
+// random backend, so the frontends created together (like on a restart)
+// don't all send their first connections to the first backend of their app
+var RoundRobinRandomStart = true
+
+// random picks the starts and the backends of Random, math/rand isn't seeded on it's own
+var random = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
 func RoundRobinStrategy() LoadBalancingStrategy {
 	return &RoundRobin{
 		backends:        lane.NewQueue(),
@@ -43,23 +320,530 @@ func RoundRobinStrategy() LoadBalancingStrategy {
 	}
 }
 
+// newRoundRobin is the round robin of a frontend, which starts at random
+// unless RoundRobinRandomStart is turned off
+func newRoundRobin() LoadBalancingStrategy {
+	return &RoundRobin{
+		backends:        lane.NewQueue(),
+		removedBackends: sets.Empty(),
+		randomStart:     RoundRobinRandomStart,
+	}
+}
+
 func (r *RoundRobin) AddBackend(backend string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.removedBackends.Contains(backend) {
+		// it's still in the queue
+		r.removedBackends.Remove(backend)
+		return
+	}
 	r.backends.Enqueue(backend)
 }
 
 func (r *RoundRobin) RemoveBackend(backend string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
 	r.removedBackends.Add(backend)
 }
 
+// Next returns "" once all the backends are removed
 func (r *RoundRobin) Next() string {
-	item := r.backends.Dequeue().(string)
-	if r.removedBackends.Contains(item) {
-		// remove the backlist and look again
-		r.removedBackends.Remove(item)
-		return r.Next()
-	} else {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.started {
+		r.started = true
+		if r.randomStart && r.backends.Size() > 1 {
+			random.Lock()
+			skip := random.Intn(r.backends.Size())
+			random.Unlock()
+			for i := 0; i < skip; i++ {
+				r.backends.Enqueue(r.backends.Dequeue())
+			}
+		}
+	}
+	for !r.backends.Empty() {
+		item := r.backends.Dequeue().(string)
+		if r.removedBackends.Contains(item) {
+			// remove the backlist and look again
+			r.removedBackends.Remove(item)
+			continue
+		}
 		// add it back at the end of queue so we'll come back to it a little later
 		r.backends.Enqueue(item)
 		return item
 	}
+	return ""
+}
+
+// Random is an implementation of Strategy that routes requests to a backend
+// picked at random, so the frontends of many GoTLBs don't move in lock step.
+// Next only takes the read lock, and the backends are replaced instead of
+// changed in place so it can pick from a snapshot of them.
+type Random struct {
+	lock     sync.RWMutex
+	backends []string
+	rand     *xorshift
+}
+
+func RandomStrategy() LoadBalancingStrategy {
+	return &Random{rand: newXorshift()}
+}
+
+func (r *Random) AddBackend(backend string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.backends = withBackend(r.backends, backend)
+}
+
+func (r *Random) RemoveBackend(backend string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.backends = withoutBackend(r.backends, backend)
+}
+
+func (r *Random) Next() string {
+	r.lock.RLock()
+	backends := r.backends
+	r.lock.RUnlock()
+	if len(backends) == 0 {
+		return ""
+	}
+	return backends[r.rand.next()%uint64(len(backends))]
+}
+
+// withBackend returns a copy of the backends with the backend added, the
+// strategies that pick from a snapshot never change theirs in place
+func withBackend(backends []string, backend string) []string {
+	for _, existing := range backends {
+		if existing == backend {
+			return backends
+		}
+	}
+	added := make([]string, len(backends), len(backends)+1)
+	copy(added, backends)
+	return append(added, backend)
+}
+
+// withoutBackend returns a copy of the backends without the backend
+func withoutBackend(backends []string, backend string) []string {
+	removed := make([]string, 0, len(backends))
+	for _, existing := range backends {
+		if existing != backend {
+			removed = append(removed, existing)
+		}
+	}
+	return removed
+}
+
+// xorshift is a xorshift64* the strategies pick at random with, without a
+// lock. The goroutines that race for the same state just try again.
+type xorshift struct {
+	state uint64
+}
+
+func newXorshift() *xorshift {
+	random.Lock()
+	defer random.Unlock()
+	// xorshift never leaves 0
+	return &xorshift{state: random.Uint64() | 1}
+}
+
+func (x *xorshift) next() uint64 {
+	for {
+		old := atomic.LoadUint64(&x.state)
+		state := old
+		state ^= state >> 12
+		state ^= state << 25
+		state ^= state >> 27
+		if atomic.CompareAndSwapUint64(&x.state, old, state) {
+			return state * 2685821657736338717
+		}
+	}
+}
+
+// PreferLocalZone is an implementation of Strategy that wraps a base strategy
+// and routes requests only to the backends in the local zone, as long as there
+// are at least spillover of them. Otherwise we route to the backends across all
+// the zones so a zone that is running low doesn't get overloaded.
+type PreferLocalZone struct {
+	lock      sync.Mutex
+	zone      string
+	spillover int
+	zones     map[string]string
+	local     LoadBalancingStrategy
+	all       LoadBalancingStrategy
+	// number of backends in the local zone
+	locals int
+}
+
+func PreferLocalZoneStrategy(zone string, spillover int, base func() LoadBalancingStrategy) LoadBalancingStrategy {
+	if spillover < 1 {
+		spillover = 1
+	}
+	return &PreferLocalZone{
+		zone:      zone,
+		spillover: spillover,
+		zones:     make(map[string]string),
+		local:     base(),
+		all:       base(),
+	}
+}
+
+func (p *PreferLocalZone) AddBackendInfo(backend *types.BackendInfo) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, present := p.zones[backend.Node]; present {
+		return
+	}
+	p.zones[backend.Node] = backend.Zone
+	p.all.AddBackend(backend.Node)
+	if backend.Zone == p.zone {
+		p.local.AddBackend(backend.Node)
+		p.locals++
+	}
+}
+
+// AddBackend adds a backend whose zone is not known, it's never considered local
+func (p *PreferLocalZone) AddBackend(backend string) {
+	p.AddBackendInfo(&types.BackendInfo{Node: backend})
+}
+
+func (p *PreferLocalZone) RemoveBackend(backend string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	zone, present := p.zones[backend]
+	if !present {
+		return
+	}
+	delete(p.zones, backend)
+	p.all.RemoveBackend(backend)
+	if zone == p.zone {
+		p.local.RemoveBackend(backend)
+		p.locals--
+	}
+}
+
+func (p *PreferLocalZone) UpdateBackends(added []*types.BackendInfo, removed []string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var localAdded, allAdded []*types.BackendInfo
+	var localRemoved, allRemoved []string
+	for _, backend := range removed {
+		zone, present := p.zones[backend]
+		if !present {
+			continue
+		}
+		delete(p.zones, backend)
+		allRemoved = append(allRemoved, backend)
+		if zone == p.zone {
+			localRemoved = append(localRemoved, backend)
+			p.locals--
+		}
+	}
+	for _, backend := range added {
+		if _, present := p.zones[backend.Node]; present {
+			continue
+		}
+		p.zones[backend.Node] = backend.Zone
+		allAdded = append(allAdded, backend)
+		if backend.Zone == p.zone {
+			localAdded = append(localAdded, backend)
+			p.locals++
+		}
+	}
+	updateBackends(p.all, allAdded, allRemoved)
+	updateBackends(p.local, localAdded, localRemoved)
+}
+
+func (p *PreferLocalZone) Acquire(backend string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	acquire(p.local, backend)
+	acquire(p.all, backend)
+}
+
+func (p *PreferLocalZone) Release(backend string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	release(p.local, backend)
+	release(p.all, backend)
+}
+
+func (p *PreferLocalZone) Observe(backend string, latency time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	observe(p.local, backend, latency)
+	observe(p.all, backend, latency)
+}
+
+func (p *PreferLocalZone) Next() string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.locals >= p.spillover {
+		return p.local.Next()
+	}
+	return p.all.Next()
+}
+
+// WeightedRoundRobin is an implementation of Strategy that routes requests
+// to the backends in proportion to their weight (capacity units). It uses the
+// smooth weighted round robin from nginx so the selections are interleaved
+// instead of bursting on the heavier backends. The shares are always relative
+// to the total weight of the current backends, so adding / removing a backend
+// re-normalizes the share of everyone else - unless the weights are shares,
+// see effectiveWeights.
+type WeightedRoundRobin struct {
+	lock     sync.Mutex
+	shares   bool
+	backends []*weightedBackend
+}
+
+type weightedBackend struct {
+	node string
+	// weight from the BackendInfo and the one we schedule with
+	declared int
+	weight   int
+	current  int
+}
+
+// WeightedRoundRobinStrategy reads the weights of the backends as capacity
+// units, or as percentages of the traffic with shares
+func WeightedRoundRobinStrategy(shares bool) LoadBalancingStrategy {
+	return &WeightedRoundRobin{shares: shares}
+}
+
+func (w *WeightedRoundRobin) AddBackendInfo(backend *types.BackendInfo) {
+	w.UpdateBackends([]*types.BackendInfo{backend}, nil)
+}
+
+func (w *WeightedRoundRobin) AddBackend(backend string) {
+	w.AddBackendInfo(&types.BackendInfo{Node: backend})
+}
+
+func (w *WeightedRoundRobin) RemoveBackend(backend string) {
+	w.UpdateBackends(nil, []string{backend})
+}
+
+func (w *WeightedRoundRobin) UpdateBackends(added []*types.BackendInfo, removed []string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for _, backend := range removed {
+		for idx, existing := range w.backends {
+			if existing.node == backend {
+				w.backends = append(w.backends[:idx], w.backends[idx+1:]...)
+				break
+			}
+		}
+	}
+	for _, backend := range added {
+		w.add(backend)
+	}
+	w.reset()
+}
+
+func (w *WeightedRoundRobin) add(backend *types.BackendInfo) {
+	for _, existing := range w.backends {
+		if existing.node == backend.Node {
+			existing.declared = backend.Weight
+			return
+		}
+	}
+	w.backends = append(w.backends, &weightedBackend{node: backend.Node, declared: backend.Weight})
+}
+
+func (w *WeightedRoundRobin) Next() string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	var best *weightedBackend
+	total := 0
+	for _, backend := range w.backends {
+		backend.current += backend.weight
+		total += backend.weight
+		if best == nil || backend.current > best.current {
+			best = backend
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	best.current -= total
+	return best.node
+}
+
+// reset starts a fresh schedule, so the shares follow the new total weight
+// right away instead of carrying over the skew from the old membership
+func (w *WeightedRoundRobin) reset() {
+	declared := make(map[string]int, len(w.backends))
+	for _, backend := range w.backends {
+		declared[backend.node] = backend.declared
+	}
+	effective := effectiveWeights(declared, w.shares)
+	for _, backend := range w.backends {
+		backend.weight = effective[backend.node]
+		backend.current = 0
+	}
+}
+
+const defaultReplicas = 100
+
+// IPHash is an implementation of Strategy that pins every client IP to a
+// backend using consistent hashing, so a client keeps hitting the same
+// backend as long as it's available and backends coming and going only
+// move the clients of that backend. When the pinned backend is unhealthy
+// the StickyFallback decides where the client goes instead.
+type IPHash struct {
+	lock   sync.Mutex
+	ring   keyMapper
+	sticky *stickyBackends
+}
+
+// IPHashStrategy pins the keys with a hash ring using the hash function by
+// it's name, or with rendezvous hashing. Every backend is on the ring
+// replicas times, more of them spread the keys more evenly.
+func IPHashStrategy(appId string, fallback StickyFallback, replicas int, hash string) LoadBalancingStrategy {
+	return &IPHash{
+		ring:   newKeyMapper(appId, hash, replicas),
+		sticky: newStickyBackends(appId, fallback),
+	}
+}
+
+func (h *IPHash) AddBackend(backend string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.sticky.add(backend) {
+		h.ring.add(backend)
+	}
+}
+
+func (h *IPHash) RemoveBackend(backend string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.sticky.remove(backend) {
+		h.ring.remove(backend)
+	}
+}
+
+func (h *IPHash) SetHealthy(backend string, healthy bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.sticky.setHealthy(backend, healthy)
+}
+
+// Next is used when we don't know the client, any available backend would do
+func (h *IPHash) Next() string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.sticky.fromSecondary(nil)
+}
+
+func (h *IPHash) NextFor(key string, exclude map[string]bool) string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	pinned := h.ring.get(key)
+	if pinned == "" || h.sticky.available(pinned, exclude) {
+		return pinned
+	}
+	return h.sticky.fallbackFor(key, exclude, func(accept func(string) bool) string {
+		return h.ring.walk(key, accept)
+	})
+}
+
+// stickyBackends keeps the backends of a sticky strategy along with their
+// health, and implements the fallbacks that don't depend on how the strategy
+// pins the keys.
+type stickyBackends struct {
+	appId     string
+	fallback  StickyFallback
+	members   []string
+	unhealthy map[string]bool
+	secondary LoadBalancingStrategy
+}
+
+func newStickyBackends(appId string, fallback StickyFallback) *stickyBackends {
+	switch fallback {
+	case RingFallback, RehashFallback, StrategyFallback:
+	default:
+		if fallback != "" {
+			log.Printf("[WARN] Unknown sticky fallback %s for %s, using %s\n", fallback, appId, RingFallback)
+		}
+		fallback = RingFallback
+	}
+	return &stickyBackends{
+		appId:     appId,
+		fallback:  fallback,
+		unhealthy: make(map[string]bool),
+		secondary: RoundRobinStrategy(),
+	}
+}
+
+// add returns true if the backend is new
+func (s *stickyBackends) add(backend string) bool {
+	idx := sort.SearchStrings(s.members, backend)
+	if idx < len(s.members) && s.members[idx] == backend {
+		return false
+	}
+	s.members = append(s.members, "")
+	copy(s.members[idx+1:], s.members[idx:])
+	s.members[idx] = backend
+	s.secondary.AddBackend(backend)
+	return true
+}
+
+// remove returns true if the backend was present
+func (s *stickyBackends) remove(backend string) bool {
+	idx := sort.SearchStrings(s.members, backend)
+	if idx == len(s.members) || s.members[idx] != backend {
+		return false
+	}
+	s.members = append(s.members[:idx], s.members[idx+1:]...)
+	delete(s.unhealthy, backend)
+	s.secondary.RemoveBackend(backend)
+	return true
+}
+
+func (s *stickyBackends) setHealthy(backend string, healthy bool) {
+	if healthy {
+		delete(s.unhealthy, backend)
+	} else {
+		s.unhealthy[backend] = true
+	}
+}
+
+func (s *stickyBackends) available(backend string, exclude map[string]bool) bool {
+	return !s.unhealthy[backend] && !exclude[backend]
+}
+
+// fallbackFor picks another backend for the key as per the fallback, walk
+// goes over the backends in the order the strategy would for the key and
+// returns the first one accepted
+func (s *stickyBackends) fallbackFor(key string, exclude map[string]bool, walk func(accept func(string) bool) string) string {
+	metrics.Counter("frontend-sticky-fallbacks", "app", s.appId, "fallback", string(s.fallback)).Inc()
+	switch s.fallback {
+	case RehashFallback:
+		var available []string
+		for _, member := range s.members {
+			if s.available(member, exclude) {
+				available = append(available, member)
+			}
+		}
+		if len(available) == 0 {
+			return ""
+		}
+		return available[hashOf(key)%uint32(len(available))]
+	case StrategyFallback:
+		return s.fromSecondary(exclude)
+	default:
+		return walk(func(backend string) bool { return s.available(backend, exclude) })
+	}
+}
+
+func (s *stickyBackends) fromSecondary(exclude map[string]bool) string {
+	for i := 0; i < len(s.members); i++ {
+		backend := s.secondary.Next()
+		if s.available(backend, exclude) {
+			return backend
+		}
+	}
+	return ""
 }
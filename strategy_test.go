@@ -1,8 +1,12 @@
 package main
 
 import (
+	"fmt"
+	"sort"
+	"sync"
 	"testing"
 
+	"github.com/ashwanthkumar/gotlb/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -20,6 +24,77 @@ func TestRoundRobinStrategy(t *testing.T) {
 	assert.Equal(t, "c", s.Next())
 }
 
+func TestRoundRobinStrategyToBeSafeForConcurrentChanges(t *testing.T) {
+	s := RoundRobinStrategy()
+	s.AddBackend("a")
+	s.AddBackend("b")
+	done := make(chan bool)
+	var pickers sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		pickers.Add(1)
+		go func() {
+			defer pickers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				backend := s.Next()
+				assert.Contains(t, []string{"a", "b", "c", "d"}, backend)
+			}
+		}()
+	}
+	for i := 0; i < 1000; i++ {
+		s.AddBackend("c")
+		s.AddBackend("d")
+		s.RemoveBackend("c")
+		s.AddBackend("c")
+		s.RemoveBackend("d")
+		s.RemoveBackend("c")
+	}
+	close(done)
+	pickers.Wait()
+
+	// only the ones left are picked, in turns
+	picked := map[string]int{}
+	for i := 0; i < 10; i++ {
+		picked[s.Next()]++
+	}
+	assert.Equal(t, map[string]int{"a": 5, "b": 5}, picked)
+
+	s.RemoveBackend("a")
+	s.RemoveBackend("b")
+	assert.Equal(t, "", s.Next())
+}
+
+func TestRoundRobinToStartAtARandomBackend(t *testing.T) {
+	starts := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		s := newRoundRobin()
+		s.AddBackend("a")
+		s.AddBackend("b")
+		s.AddBackend("c")
+		first := s.Next()
+		starts[first] = true
+		// and goes round from there
+		round := []string{first, s.Next(), s.Next()}
+		sort.Strings(round)
+		assert.Equal(t, []string{"a", "b", "c"}, round)
+		assert.Equal(t, first, s.Next())
+	}
+	assert.Len(t, starts, 3)
+
+	RoundRobinRandomStart = false
+	defer func() { RoundRobinRandomStart = true }()
+	for i := 0; i < 10; i++ {
+		s := newRoundRobin()
+		s.AddBackend("a")
+		s.AddBackend("b")
+		assert.Equal(t, "a", s.Next())
+	}
+}
+
 func TestRoundRobinStrategyUponRemovingBackend(t *testing.T) {
 	s := RoundRobinStrategy()
 	s.AddBackend("a")
@@ -31,3 +106,256 @@ func TestRoundRobinStrategyUponRemovingBackend(t *testing.T) {
 	assert.Equal(t, "a", s.Next())
 	assert.Equal(t, "c", s.Next())
 }
+
+func TestPreferLocalZoneStrategy(t *testing.T) {
+	s := PreferLocalZoneStrategy("zone-a", 1, RoundRobinStrategy).(*PreferLocalZone)
+	s.AddBackendInfo(&types.BackendInfo{Node: "a1", Zone: "zone-a"})
+	s.AddBackendInfo(&types.BackendInfo{Node: "b1", Zone: "zone-b"})
+	s.AddBackendInfo(&types.BackendInfo{Node: "a2", Zone: "zone-a"})
+	assert.Equal(t, "a1", s.Next())
+	assert.Equal(t, "a2", s.Next())
+	assert.Equal(t, "a1", s.Next())
+}
+
+func TestPreferLocalZoneStrategyToSpillOverWhenLocalBackendsAreBelowThreshold(t *testing.T) {
+	s := PreferLocalZoneStrategy("zone-a", 2, RoundRobinStrategy).(*PreferLocalZone)
+	s.AddBackendInfo(&types.BackendInfo{Node: "a1", Zone: "zone-a"})
+	s.AddBackendInfo(&types.BackendInfo{Node: "b1", Zone: "zone-b"})
+	s.AddBackendInfo(&types.BackendInfo{Node: "a2", Zone: "zone-a"})
+	assert.Equal(t, "a1", s.Next())
+	assert.Equal(t, "a2", s.Next())
+
+	s.RemoveBackend("a2")
+	// only 1 local backend is left, so we use all the zones
+	assert.Equal(t, "a1", s.Next())
+	assert.Equal(t, "b1", s.Next())
+	assert.Equal(t, "a1", s.Next())
+}
+
+func TestWeightedRoundRobinStrategyToInterleaveSelections(t *testing.T) {
+	s := WeightedRoundRobinStrategy(false).(*WeightedRoundRobin)
+	s.AddBackendInfo(&types.BackendInfo{Node: "a", Weight: 5})
+	s.AddBackendInfo(&types.BackendInfo{Node: "b", Weight: 1})
+	s.AddBackendInfo(&types.BackendInfo{Node: "c", Weight: 1})
+	var selections []string
+	for i := 0; i < 7; i++ {
+		selections = append(selections, s.Next())
+	}
+	assert.Equal(t, []string{"a", "a", "b", "a", "c", "a", "a"}, selections)
+}
+
+func TestWeightedRoundRobinStrategyToRenormalizeSharesUponRemovingBackend(t *testing.T) {
+	s := WeightedRoundRobinStrategy(false).(*WeightedRoundRobin)
+	s.AddBackendInfo(&types.BackendInfo{Node: "small-1", Weight: 1})
+	s.AddBackendInfo(&types.BackendInfo{Node: "small-2", Weight: 1})
+	s.AddBackendInfo(&types.BackendInfo{Node: "medium", Weight: 2})
+	s.AddBackendInfo(&types.BackendInfo{Node: "large", Weight: 4})
+
+	shares := selectionShares(s, 800)
+	assert.Equal(t, 0.125, shares["small-1"])
+	assert.Equal(t, 0.125, shares["small-2"])
+	assert.Equal(t, 0.25, shares["medium"])
+	assert.Equal(t, 0.5, shares["large"])
+
+	s.RemoveBackend("large")
+	shares = selectionShares(s, 800)
+	assert.Equal(t, 0.25, shares["small-1"])
+	assert.Equal(t, 0.25, shares["small-2"])
+	assert.Equal(t, 0.5, shares["medium"])
+	assert.Equal(t, float64(0), shares["large"])
+}
+
+func TestRandomStrategyToPickAnyOfTheBackends(t *testing.T) {
+	s := buildStrategy(APP_ID, RandomName, NewFrontendConfig(map[string]string{}))
+	assert.Equal(t, "", s.Next())
+	s.AddBackend("a")
+	s.AddBackend("b")
+	s.AddBackend("c")
+	s.AddBackend("a")
+	s.RemoveBackend("c")
+	picked := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		picked[s.Next()]++
+	}
+	assert.Len(t, picked, 2)
+	assert.True(t, picked["a"] > 0 && picked["b"] > 0)
+}
+
+func TestRandomStrategyToPickFromASnapshotOfTheBackends(t *testing.T) {
+	s := RandomStrategy()
+	s.AddBackend("a")
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			s.AddBackend(fmt.Sprintf("b:%d", i%10))
+			s.RemoveBackend(fmt.Sprintf("b:%d", (i+5)%10))
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		assert.NotEqual(t, "", s.Next())
+	}
+	<-done
+}
+
+func BenchmarkRandomStrategyToPickInParallel(b *testing.B) {
+	s := RandomStrategy()
+	for i := 0; i < 10; i++ {
+		s.AddBackend(fmt.Sprintf("10.0.0.%d:31000", i))
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s.Next()
+		}
+	})
+}
+
+func TestLeastConnectionsStrategyToPickTheBackendWithTheFewestConnections(t *testing.T) {
+	s := LeastConnectionsStrategy().(*LeastConnection)
+	s.AddBackend("a")
+	s.AddBackend("b")
+	s.AddBackend("c")
+	s.Acquire("a")
+	s.Acquire("a")
+	s.Acquire("b")
+	assert.Equal(t, "c", s.Next())
+	assert.Equal(t, "c", s.Next())
+
+	s.Acquire("c")
+	s.Acquire("c")
+	assert.Equal(t, "b", s.Next())
+	s.Release("a")
+	s.Release("a")
+	assert.Equal(t, "a", s.Next())
+}
+
+func TestLeastConnectionsStrategyToTakeTurnsAmongTheTies(t *testing.T) {
+	s := LeastConnectionsStrategy()
+	s.AddBackend("a")
+	s.AddBackend("b")
+	s.AddBackend("a")
+	assert.Equal(t, "a", s.Next())
+	assert.Equal(t, "b", s.Next())
+	assert.Equal(t, "a", s.Next())
+}
+
+func TestLeastConnectionsStrategyToKeepTheCountsOfARemovedBackend(t *testing.T) {
+	s := LeastConnectionsStrategy().(*LeastConnection)
+	s.AddBackend("a")
+	s.AddBackend("b")
+	s.Acquire("a")
+	s.RemoveBackend("a")
+	assert.Equal(t, "b", s.Next())
+	assert.Equal(t, "b", s.Next())
+
+	// the connection still open to it counts once it's back
+	s.AddBackend("a")
+	assert.Equal(t, 1, s.Connections("a"))
+	assert.Equal(t, "b", s.Next())
+	s.Release("a")
+	s.Release("a")
+	assert.Equal(t, 0, s.Connections("a"))
+
+	s.RemoveBackend("a")
+	s.RemoveBackend("b")
+	assert.Equal(t, "", s.Next())
+}
+
+func TestBuildStrategyToPassTheConnectionsThroughTheWrappers(t *testing.T) {
+	config := NewFrontendConfig(map[string]string{"tlb.strategy": "leastconn", "tlb.strategy.shadow": "roundrobin", "tlb.ipfamily": "ipv4"})
+	config.Zone, config.PreferLocalZone = "us-east-1a", true
+	s := newStrategy(APP_ID, config)
+	updateBackends(s, []*types.BackendInfo{{Node: "10.0.0.1:80", Zone: "us-east-1a"}, {Node: "10.0.0.2:80", Zone: "us-east-1a"}}, nil)
+	acquire(s, "10.0.0.1:80")
+	assert.Equal(t, "10.0.0.2:80", s.Next())
+	assert.Equal(t, "10.0.0.2:80", s.Next())
+	release(s, "10.0.0.1:80")
+	acquire(s, "10.0.0.2:80")
+	assert.Equal(t, "10.0.0.1:80", s.Next())
+}
+
+func selectionShares(s LoadBalancingStrategy, selections int) map[string]float64 {
+	counts := make(map[string]int)
+	for i := 0; i < selections; i++ {
+		counts[s.Next()]++
+	}
+	shares := make(map[string]float64)
+	for backend, count := range counts {
+		shares[backend] = float64(count) / float64(selections)
+	}
+	return shares
+}
+
+func TestHashRingToMoveOnlyTheKeysOfTheRemovedBackend(t *testing.T) {
+	ring := newHashRing(defaultReplicas, hashOf)
+	ring.add("a")
+	ring.add("b")
+	ring.add("c")
+	before := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("10.0.0.%d", i)
+		before[key] = ring.get(key)
+	}
+
+	ring.remove("b")
+	for key, owner := range before {
+		if owner != "b" {
+			assert.Equal(t, owner, ring.get(key))
+		} else {
+			assert.NotEqual(t, "b", ring.get(key))
+		}
+	}
+}
+
+func TestIPHashStrategyToPinTheClient(t *testing.T) {
+	s := IPHashStrategy("/app", RingFallback, defaultReplicas, FNVHash).(*IPHash)
+	s.AddBackend("a")
+	s.AddBackend("b")
+	s.AddBackend("c")
+	pinned := s.NextFor("10.0.0.1", nil)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, pinned, s.NextFor("10.0.0.1", nil))
+	}
+}
+
+func TestIPHashStrategyFallbacksWhenPinnedBackendIsUnhealthy(t *testing.T) {
+	for _, fallback := range []StickyFallback{RingFallback, RehashFallback, StrategyFallback} {
+		s := IPHashStrategy("/app", fallback, defaultReplicas, FNVHash).(*IPHash)
+		s.AddBackend("a")
+		s.AddBackend("b")
+		s.AddBackend("c")
+		pinned := s.NextFor("10.0.0.1", nil)
+		s.SetHealthy(pinned, false)
+
+		backend := s.NextFor("10.0.0.1", nil)
+		assert.NotEqual(t, pinned, backend, string(fallback))
+		assert.NotEqual(t, "", backend, string(fallback))
+		assert.False(t, s.NextFor("10.0.0.1", map[string]bool{backend: true}) == backend, string(fallback))
+
+		s.SetHealthy(pinned, true)
+		assert.Equal(t, pinned, s.NextFor("10.0.0.1", nil), string(fallback))
+	}
+}
+
+func TestIPHashStrategyRingFallbackToBeSticky(t *testing.T) {
+	s := IPHashStrategy("/app", RingFallback, defaultReplicas, FNVHash).(*IPHash)
+	s.AddBackend("a")
+	s.AddBackend("b")
+	s.AddBackend("c")
+	pinned := s.NextFor("10.0.0.1", nil)
+	s.SetHealthy(pinned, false)
+	fallback := s.NextFor("10.0.0.1", nil)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, fallback, s.NextFor("10.0.0.1", nil))
+	}
+	assert.True(t, metrics.Counter("frontend-sticky-fallbacks", "app", "/app", "fallback", "ring").Value() >= 11)
+}
+
+func TestIPHashStrategyToReturnEmptyWhenNothingIsAvailable(t *testing.T) {
+	s := IPHashStrategy("/app", RehashFallback, defaultReplicas, FNVHash).(*IPHash)
+	assert.Equal(t, "", s.NextFor("10.0.0.1", nil))
+	s.AddBackend("a")
+	s.SetHealthy("a", false)
+	assert.Equal(t, "", s.NextFor("10.0.0.1", nil))
+	assert.Equal(t, "", s.Next())
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// timeoutReader reads from a proxied connection applying the idle and
+// keepalive timeouts of the frontend. The timeouts are read again before
+// every read, so changing them takes effect on the connections in flight
+// from their next read on. The read deadline is only pushed back once
+// half of the idle timeout has gone by, not on every read - when it's hit
+// early we look at when the connection was last active anyway.
+type timeoutReader struct {
+	conn    net.Conn
+	request *Request
+	// keepalive period we've set on the connection
+	keepAlive time.Duration
+	// idle timeout and the read deadline we've set on the connection, zero when there's none
+	idleTimeout time.Duration
+	deadline    time.Time
+}
+
+// errLifetimeOver is what the reads of a connection that's been open for
+// tlb.timeout.lifetime end with
+var errLifetimeOver = errors.New("connection lifetime is over")
+
+// lifetimeFlush is how long the writes in flight get once the lifetime of
+// the connection is over
+const lifetimeFlush = 5 * time.Second
+
+func (p *Request) withTimeouts(conn net.Conn) *timeoutReader {
+	return &timeoutReader{conn: conn, request: p}
+}
+
+func (r *timeoutReader) Read(b []byte) (int, error) {
+	frontend := r.request.frontend
+	for {
+		r.applyKeepAlive(frontend.KeepAlive())
+		r.applyIdleTimeout(frontend.IdleTimeout(), time.Now())
+		// after the deadlines, which would push back the one expire set
+		if r.request.isExpired() {
+			return 0, errLifetimeOver
+		}
+
+		n, err := r.conn.Read(b)
+		if n == 0 && isTimeout(err) {
+			// the other direction might still be active or the timeout
+			// changed while we were waiting
+			current := frontend.IdleTimeout()
+			if r.request.isExpired() || current == 0 || r.request.conn.Idle(time.Now()) < current {
+				continue
+			}
+			metrics.Counter("frontend-idle-timeouts", "app", r.request.appId).Inc()
+		}
+		return n, err
+	}
+}
+
+// applyIdleTimeout pushes the read deadline back to the idle timeout from
+// now, when it's changed or half of it has gone by since we last did
+func (r *timeoutReader) applyIdleTimeout(idleTimeout time.Duration, now time.Time) {
+	if idleTimeout == r.idleTimeout && (idleTimeout == 0 || r.deadline.Sub(now) > idleTimeout/2) {
+		return
+	}
+	r.idleTimeout = idleTimeout
+	r.deadline = time.Time{}
+	if idleTimeout > 0 {
+		r.deadline = now.Add(idleTimeout)
+	}
+	r.conn.SetReadDeadline(r.deadline)
+}
+
+func (r *timeoutReader) applyKeepAlive(period time.Duration) {
+	if period == r.keepAlive {
+		return
+	}
+	tcpConn, ok := asTCPConn(r.conn)
+	if !ok {
+		return
+	}
+	r.keepAlive = period
+	if period == 0 {
+		// back to what we had when the connection was made
+		period = 15 * time.Second
+	}
+	if err := tcpConn.SetKeepAlive(true); err != nil {
+		log.Printf("[WARN] Unable to enable keepalive for %s - %v\n", r.request.appId, err)
+		return
+	}
+	if err := tcpConn.SetKeepAlivePeriod(period); err != nil {
+		log.Printf("[WARN] Unable to set the keepalive period for %s - %v\n", r.request.appId, err)
+	}
+}
+
+// expire ends the connection once it's been open for tlb.timeout.lifetime.
+// The reads of both sides stop right away and the copies end with what they
+// had already read written out, so neither side gets half a write - they
+// have lifetimeFlush for it.
+func (p *Request) expire(in net.Conn) {
+	atomic.StoreInt32(&p.expired, 1)
+	now := time.Now()
+	for _, conn := range []net.Conn{in, p.current()} {
+		conn.SetReadDeadline(now)
+		conn.SetWriteDeadline(now.Add(lifetimeFlush))
+	}
+}
+
+func (p *Request) isExpired() bool {
+	return atomic.LoadInt32(&p.expired) == 1
+}
+
+// isLifetimeOver tells if the copy ended as the lifetime of the connection
+// is over, the copy to a TCP connection wraps the error of the read
+func isLifetimeOver(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	return err == errLifetimeOver
+}
+
+// probe writes nothing to both sides of the connection whenever it's been
+// idle for the probe interval. A write, even an empty one, fails when the
+// socket already has an error pending - like a reset or a keepalive that
+// timed out - so a dead peer is noticed right away instead of on the next
+// write to it, which for an idle connection might never come.
+func (p *Request) probe(in net.Conn, done <-chan bool) {
+	interval := p.config.ProbeInterval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if p.conn.Idle(now) < interval {
+				continue
+			}
+			// a side we've closed the write side of fails the write
+			var side string
+			var err error
+			if atomic.LoadInt32(&p.clientShut) == 0 {
+				side, err = "client", probeConn(in)
+			}
+			if err == nil && atomic.LoadInt32(&p.backendShut) == 0 {
+				side, err = "backend", probeConn(p.current())
+			}
+			if err != nil {
+				log.Printf("[WARN] Closing the idle connection of %s from %s to %s, the probe of the %s failed - %v\n", p.appId, in.RemoteAddr(), p.conn.Backend(), side, err)
+				metrics.Counter("frontend-probe-failures", "app", p.appId, "side", side).Inc()
+				in.Close()
+				p.current().Close()
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// probeConn writes nothing to the connection, returning the error of the socket if it has one
+func probeConn(conn net.Conn) error {
+	_, err := conn.Write(nil)
+	return err
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
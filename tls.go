@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+// sniCertificates picks the certificate of the frontend for the server name
+// the client asks for
+type sniCertificates struct {
+	certs  []tls.Certificate
+	leaves []*x509.Certificate
+}
+
+// newTLSConfig loads the certificates and their keys (comma separated paths,
+// in the same order) the frontend terminates TLS with
+func newTLSConfig(certFiles, keyFiles string) (*tls.Config, error) {
+	certPaths, keyPaths := splitPaths(certFiles), splitPaths(keyFiles)
+	if len(certPaths) == 0 || len(certPaths) != len(keyPaths) {
+		return nil, fmt.Errorf("%d certificates with %d keys", len(certPaths), len(keyPaths))
+	}
+	sni := &sniCertificates{}
+	for i, certPath := range certPaths {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPaths[i])
+		if err != nil {
+			return nil, fmt.Errorf("unable to load the certificate %s - %v", certPath, err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse the certificate %s - %v", certPath, err)
+		}
+		sni.certs = append(sni.certs, cert)
+		sni.leaves = append(sni.leaves, leaf)
+	}
+	return &tls.Config{GetCertificate: sni.certificate}, nil
+}
+
+// certificate returns the first certificate that's valid for the server name,
+// or the first one of all when none are or the client doesn't send it
+func (s *sniCertificates) certificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if hello.ServerName != "" {
+		for i, leaf := range s.leaves {
+			if leaf.VerifyHostname(hello.ServerName) == nil {
+				return &s.certs[i], nil
+			}
+		}
+	}
+	return &s.certs[0], nil
+}
+
+// splitPaths splits the comma separated paths of a label
+func splitPaths(paths string) []string {
+	var split []string
+	for _, path := range strings.Split(paths, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			split = append(split, path)
+		}
+	}
+	return split
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ashwanthkumar/golang-utils/sets"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrontendToTerminateTLSWithTheCertificateOfTheServerName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotlb")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certA, keyA := writeCertificate(t, dir, "a.example.com")
+	certB, keyB := writeCertificate(t, dir, "b.example.com")
+
+	received := make(chan string, 1)
+	backend := startBackend(t, func(conn net.Conn) {
+		defer conn.Close()
+		request := make([]byte, 5)
+		io.ReadFull(conn, request)
+		received <- string(request)
+		conn.Write([]byte("world"))
+	})
+	defer backend.Close()
+	frontend := NewFrontend("/tls", "0", sets.FromSlice([]string{backend.Addr().String()}), NewFrontendConfig(map[string]string{
+		"tlb.tls.cert": certA + "," + certB,
+		"tlb.tls.key":  keyA + "," + keyB,
+	}))
+	assert.NoError(t, frontend.Listen())
+	started := make(chan error)
+	go func() { started <- frontend.Start() }()
+
+	client, err := tls.Dial("tcp", frontend.Bind(), &tls.Config{ServerName: "b.example.com", InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	assert.Equal(t, []string{"b.example.com"}, client.ConnectionState().PeerCertificates[0].DNSNames)
+	_, err = client.Write([]byte("hello"))
+	assert.NoError(t, err)
+	response := make([]byte, 5)
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadFull(client, response)
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(response))
+	// the backend gets the plain bytes
+	assert.Equal(t, "hello", <-received)
+
+	// the wrapped listener is closed with the frontend
+	address := frontend.Bind()
+	frontend.Stop()
+	select {
+	case err := <-started:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the accept loop didn't return")
+	}
+	_, err = net.Dial("tcp", address)
+	assert.Error(t, err)
+}
+
+func TestSNICertificatesToFallBackToTheFirstOne(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotlb")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certA, keyA := writeCertificate(t, dir, "a.example.com")
+	certB, keyB := writeCertificate(t, dir, "*.b.example.com")
+	config, err := newTLSConfig(certA+", "+certB, keyA+", "+keyB)
+	assert.NoError(t, err)
+
+	for serverName, expected := range map[string]string{"api.b.example.com": "*.b.example.com", "c.example.com": "a.example.com", "": "a.example.com"} {
+		cert, err := config.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+		assert.NoError(t, err)
+		leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+		assert.Equal(t, []string{expected}, leaf.DNSNames, serverName)
+	}
+
+	_, err = newTLSConfig(certA, filepath.Join(dir, "missing.key"))
+	assert.Error(t, err)
+}
+
+// writeCertificate writes a self signed certificate for the name and it's key
+// to the dir, and returns their paths
+func writeCertificate(t *testing.T, dir, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	assert.NoError(t, ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certPath, keyPath
+}
@@ -11,4 +11,178 @@ const (
 	// expose the non-first port via GoTLB. Default - 0
 	// This label is a zero-based index.
 	TLB_PORTINDEX = "tlb.portIndex"
+	// Prefix of the labels that are added to the metadata of every backend of the app, like
+	// tlb.meta.canary=true. The metadata "zone" and "weight" are the zone and the weight of the
+	// backends. Default - none
+	TLB_META_PREFIX = "tlb.meta."
+	// Label used to denote the load balancing strategy of the app - roundrobin, weighted,
+	// iphash, maglev, leastconn, random, p2c or ewma. Default - roundrobin
+	TLB_STRATEGY = "tlb.strategy"
+	// Label used to denote the size of the lookup table of the maglev strategy, it should be
+	// a prime much larger than the number of backends. Default - 65537
+	TLB_MAGLEV_TABLE = "tlb.maglev.table"
+	// Label used to denote the virtual nodes of every backend on the hash ring of the iphash
+	// strategy. Default - 100
+	TLB_IPHASH_REPLICAS = "tlb.iphash.replicas"
+	// Label used to denote the hash (fnv / crc32 / murmur3 / rendezvous) of the iphash and maglev
+	// strategies of the app. Default - fnv
+	TLB_HASH = "tlb.hash"
+	// Label used to denote how the weighted and maglev strategies of the app read the weights of
+	// the backends - relative (capacity units) or shares (percentages of the traffic). Default - relative
+	TLB_WEIGHTS = "tlb.weights"
+	// Label used to denote the weight of the backends of the app for the weighted and maglev
+	// strategies, 0 gets them no new connections. Default - 1
+	TLB_WEIGHT = "tlb.weight"
+	// Label used to denote a strategy that runs in shadow next to tlb.strategy, it never
+	// routes anything but we record what it would have picked. Default - none
+	TLB_STRATEGY_SHADOW = "tlb.strategy.shadow"
+	// Label used to denote how a sticky strategy (iphash, maglev) picks a backend when the backend
+	// the client is pinned to is not available - ring, rehash or strategy. Default - ring
+	TLB_STICKY_FALLBACK = "tlb.sticky.fallback"
+	// Label used to denote how long a client IP keeps going to the backend the strategy picked for
+	// it after it's last connection. Default - 0 (disabled)
+	TLB_AFFINITY_TTL = "tlb.affinity.ttl"
+	// Label used to denote how long (eg - 5m) a proxied connection can go without any data
+	// in either direction before we close it. Default - no timeout
+	TLB_TIMEOUT_IDLE = "tlb.timeout.idle"
+	// Label used to denote the TCP keepalive period (eg - 30s) of the proxied connections.
+	// Default - Go default
+	TLB_TIMEOUT_KEEPALIVE = "tlb.timeout.keepalive"
+	// Label used to denote the interval (eg - 30s) at which we probe both sides of the proxied
+	// connections that have been idle for as long, closing them when a probe fails. Default - none
+	TLB_TIMEOUT_PROBE = "tlb.timeout.probe"
+	// Label used to denote how long (eg - 1s) we wait on the connection to a backend before
+	// giving up on it. Default - 3s
+	TLB_TIMEOUT_DIAL = "tlb.timeout.dial"
+	// Label used to denote how long (eg - 1h) a proxied connection can stay open, however active
+	// it is, before we close it so the client reconnects. Default - no limit
+	TLB_TIMEOUT_LIFETIME = "tlb.timeout.lifetime"
+	// Label used to denote the protocol (tcp / udp) of the frontend of the app, the backends are
+	// sent the same. Default - tcp
+	TLB_PROTOCOL = "tlb.protocol"
+	// Label used to denote how long (eg - 1m) a UDP session of a client goes without a datagram
+	// either way before we drop it, and the next one from the client picks a backend again. Default - 30s
+	TLB_TIMEOUT_UDP = "tlb.timeout.udp"
+	// Label used to denote the DSCP value (0 - 63) set on the packets of the backend connections,
+	// for the network to prioritize the app. Default - none
+	TLB_DSCP = "tlb.dscp"
+	// Label used to denote if the DSCP value is also set on the client connections. Default - false
+	TLB_DSCP_CLIENT = "tlb.dscp.client"
+	// Label used to denote if we should look at the first bytes from the client to detect the
+	// protocol and the compression of the connection, for accounting only. Default - false
+	TLB_DETECT = "tlb.detect"
+	// Label used to denote if we should log a record of every proxied connection of the app
+	// when it's closed. Default - false
+	TLB_ACCESSLOG = "tlb.accesslog"
+	// Label used to denote that only 1 in N connections are logged, 0 logs only the connections
+	// going past tlb.accesslog.slow or tlb.accesslog.bytes. Default - 1
+	TLB_ACCESSLOG_SAMPLE = "tlb.accesslog.sample"
+	// Label used to denote the duration (eg - 10s) beyond which a connection is always logged.
+	// Default - none
+	TLB_ACCESSLOG_SLOW = "tlb.accesslog.slow"
+	// Label used to denote the bytes (both the directions together) beyond which a connection is
+	// always logged. Default - none
+	TLB_ACCESSLOG_BYTES = "tlb.accesslog.bytes"
+	// Label used to denote where the access log of the app goes instead of the shared log - a file
+	// path, "syslog" for the local daemon or syslog://host:port (syslog+tcp:// for TCP). Default - none
+	TLB_ACCESSLOG_SINK = "tlb.accesslog.sink"
+	// Label used to denote the window (eg - 100ms) within which the changes to the backends of the
+	// app are applied together, so a burst of them rebuilds the strategy only once. Default - none
+	TLB_COALESCE = "tlb.coalesce"
+	// Label used to denote the sliding window (eg - 5m) over which we compute the success ratio
+	// of the connections to the backends of the app. Default - 5m
+	TLB_SLO_WINDOW = "tlb.slo.window"
+	// Label used to denote the timeout (eg - 1s) of a warmup connection we open to the new backends
+	// of the app, they get connections only once it succeeds. Default - none (routed right away)
+	TLB_WARMUP = "tlb.warmup"
+	// Label used to denote the HTTP request header (eg - X-Route) in which the clients of the app
+	// can ask for the backends with some tags (eg - region=eu). Default - none
+	TLB_STEER_HEADER = "tlb.steer.header"
+	// Label used to denote how long (eg - 50ms) we wait for the first bytes of the client to look
+	// for tlb.steer.header. Default - 100ms
+	TLB_STEER_TIMEOUT = "tlb.steer.timeout"
+	// Label used to denote the interval (eg - 10s) of the health checks of the backends of the app,
+	// 0 disables them. Default - -healthcheck-interval
+	TLB_HEALTHCHECK_INTERVAL = "tlb.healthcheck.interval"
+	// Label used to denote the timeout (eg - 1s) of the connection of a health check.
+	// Default - -healthcheck-timeout
+	TLB_HEALTHCHECK_TIMEOUT = "tlb.healthcheck.timeout"
+	// Label used to denote the consecutive failed health checks after which a backend of the app
+	// is pulled from the rotation. Default - -healthcheck-unhealthy-threshold
+	TLB_HEALTHCHECK_UNHEALTHY = "tlb.healthcheck.unhealthythreshold"
+	// Label used to denote the consecutive passed health checks after which a pulled backend of
+	// the app is back in the rotation. Default - -healthcheck-healthy-threshold
+	TLB_HEALTHCHECK_HEALTHY = "tlb.healthcheck.healthythreshold"
+	// Label used to denote the consecutive failed (or reset) connections to a backend of the app
+	// after which it's pulled from the rotation for tlb.outlier.ejection, 0 doesn't pull them.
+	// Default - 0
+	TLB_OUTLIER_FAILURES = "tlb.outlier.failures"
+	// Label used to denote the window (eg - 10s) within which tlb.outlier.failures pull a backend,
+	// instead of them being in a row. Default - none (in a row)
+	TLB_OUTLIER_WINDOW = "tlb.outlier.window"
+	// Label used to denote how long (eg - 30s) a backend pulled for it's failed connections stays
+	// out of the rotation, doubling when it's pulled again soon after. Default - 30s
+	TLB_OUTLIER_EJECTION = "tlb.outlier.ejection"
+	// Label used to denote how long (eg - 1m) the connections to a backend that discovery removes
+	// get to finish before they're closed, no new connections go to it meanwhile.
+	// Default - none (removed right away)
+	TLB_DRAIN_TIMEOUT = "tlb.drain.timeout"
+	// Label used to denote the address family (ipv4 / ipv6 / any) of the backends we prefer for the
+	// app, we route to the other backends only when there are none of that family. Default - any
+	TLB_IPFAMILY = "tlb.ipfamily"
+	// Label used to denote if we send a PROXY protocol header (v1 / v2 / off, or true / false) to the
+	// backends of the app before anything from the client, so they know the address of the client. Default - off
+	TLB_PROXYPROTOCOL = "tlb.proxyprotocol"
+	// Label used to denote the version (1 - text / 2 - binary) of the PROXY protocol header we send
+	// to the backends of the app, when tlb.proxyprotocol is true. Default - 1
+	TLB_PROXYPROTOCOL_VERSION = "tlb.proxyprotocol.version"
+	// Label used to denote the certificates (comma separated paths) the frontend of the app terminates
+	// TLS with, the one matching the server name (SNI) the client asks for is used. Default - none
+	TLB_TLS_CERT = "tlb.tls.cert"
+	// Label used to denote the keys (comma separated paths) of the certificates of tlb.tls.cert, in
+	// the same order. Default - none
+	TLB_TLS_KEY = "tlb.tls.key"
+	// Label used to denote the size (in bytes) of the socket read buffer (SO_RCVBUF) on
+	// both sides of a proxied connection. Default - 0 (OS default)
+	TLB_BUFFER_READ = "tlb.buffer.read"
+	// Label used to denote the size (in bytes) of the socket write buffer (SO_SNDBUF) on
+	// both sides of a proxied connection. Default - 0 (OS default)
+	TLB_BUFFER_WRITE = "tlb.buffer.write"
+	// Label used to denote if TCP_CORK should be used while proxying to batch small writes.
+	// Supported only on Linux. Default - false
+	TLB_CORK = "tlb.cork"
+	// Label used to denote the accept backlog of the frontend listener. It's capped by the
+	// net.core.somaxconn sysctl, which is also what Go uses by default. Supported only on Linux.
+	TLB_BACKLOG = "tlb.backlog"
+	// Label used to denote the number of backends we try for a connection before giving up.
+	// Default - 1 (no failover)
+	TLB_FAILOVER_ATTEMPTS = "tlb.failover.attempts"
+	// Label used to denote the max bytes of the client we buffer until the backend responds,
+	// so we can replay them on another backend if it fails. Default - 16384
+	TLB_FAILOVER_BUFFER = "tlb.failover.buffer"
+	// Label used to denote the max connections that are accepted but still waiting to connect
+	// to a backend. New connections beyond it are closed right away. Default - 0 (unlimited)
+	TLB_MAX_PENDING = "tlb.maxpending"
+	// Label used to denote the max new connections per second we accept for the app, the ones
+	// beyond it are closed right away. Default - 0 (unlimited)
+	TLB_LIMIT_RATE = "tlb.limit.rate"
+	// Label used to denote how many new connections can come in at once over tlb.limit.rate.
+	// Default - tlb.limit.rate
+	TLB_LIMIT_BURST = "tlb.limit.burst"
+	// Other names of tlb.limit.rate and tlb.limit.burst, the tlb.limit ones win when both are set
+	TLB_RATELIMIT_CPS   = "tlb.ratelimit.cps"
+	TLB_RATELIMIT_BURST = "tlb.ratelimit.burst"
+	// Label used to denote the max open connections of the app, new connections beyond it are
+	// closed right away. Default - 0 (unlimited)
+	TLB_LIMIT_CONNECTIONS = "tlb.limit.connections"
+	// Label used to denote what happens to the new connections beyond tlb.limit.connections -
+	// reject closes them right away, queue holds off accepting until one of them closes.
+	// Default - reject
+	TLB_LIMIT_CONNECTIONS_MODE = "tlb.limit.connections.mode"
+	// Label used to denote if the backends in the same zone as GoTLB should be preferred.
+	// Needs GoTLB to be started with -zone. Default - false
+	TLB_ZONE_PREFER = "tlb.zone.prefer"
+	// Label used to denote the minimum number of backends in the local zone below which we
+	// spill over to the backends in all the zones. Default - 1
+	TLB_ZONE_SPILLOVER = "tlb.zone.spillover"
 )
@@ -0,0 +1,142 @@
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LabelError is a label of an app with a value we can't use
+type LabelError struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+	// what's wrong with it and what we use instead
+	Reason string `json:"reason"`
+}
+
+func (e LabelError) Error() string {
+	return fmt.Sprintf("%s=%q %s", e.Label, e.Value, e.Reason)
+}
+
+// LabelReader reads the labels of an app. A value it can't use falls back to
+// the default, and what was wrong with it is kept in Errors so it can be
+// logged and shown in the admin API.
+type LabelReader struct {
+	labels map[string]string
+	Errors []LabelError
+}
+
+func NewLabelReader(labels map[string]string) *LabelReader {
+	return &LabelReader{labels: labels}
+}
+
+// Invalid records that the value of the label can't be used
+func (r *LabelReader) Invalid(label, reason string, args ...interface{}) {
+	r.Errors = append(r.Errors, LabelError{Label: label, Value: r.labels[label], Reason: fmt.Sprintf(reason, args...)})
+}
+
+// String returns the label, or the fallback when it's not set
+func (r *LabelReader) String(label, fallback string) string {
+	if value, present := r.labels[label]; present {
+		return value
+	}
+	return fallback
+}
+
+// Either returns the label when it's set, or the alias of it when only that
+// one is, so the value is read from (and it's errors are of) the one in use
+func (r *LabelReader) Either(label, alias string) string {
+	if _, present := r.labels[label]; !present {
+		if _, aliased := r.labels[alias]; aliased {
+			return alias
+		}
+	}
+	return label
+}
+
+// OneOf returns the label if it's one of the allowed values
+func (r *LabelReader) OneOf(label, fallback string, allowed ...string) string {
+	value, present := r.labels[label]
+	if !present {
+		return fallback
+	}
+	for _, valid := range allowed {
+		if value == valid {
+			return value
+		}
+	}
+	r.Invalid(label, "should be one of %s, using %q", strings.Join(allowed, ", "), fallback)
+	return fallback
+}
+
+// Bool returns the label as a boolean
+func (r *LabelReader) Bool(label string, fallback bool) bool {
+	value, present := r.labels[label]
+	if !present {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		r.Invalid(label, "should be true or false, using %t", fallback)
+		return fallback
+	}
+	return parsed
+}
+
+// Int returns the label as a number that can't be negative
+func (r *LabelReader) Int(label string, fallback int) int {
+	return r.AtLeast(label, fallback, 0)
+}
+
+// AtLeast returns the label as a number that's at least min
+func (r *LabelReader) AtLeast(label string, fallback, min int) int {
+	parsed, ok := r.number(label, fallback)
+	if ok && parsed < min {
+		r.Invalid(label, "should be at least %d, using %d", min, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+// IntBetween returns the label as a number between min and max, both included
+func (r *LabelReader) IntBetween(label string, fallback, min, max int) int {
+	parsed, ok := r.number(label, fallback)
+	if ok && (parsed < min || parsed > max) {
+		r.Invalid(label, "should be between %d and %d, using %d", min, max, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+// number parses the label, false when it's not set or not a number
+func (r *LabelReader) number(label string, fallback int) (int, bool) {
+	value, present := r.labels[label]
+	if !present {
+		return fallback, false
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		r.Invalid(label, "should be a number, using %d", fallback)
+		return fallback, false
+	}
+	return parsed, true
+}
+
+// Duration returns the label as a duration like 10s that can't be negative
+func (r *LabelReader) Duration(label string, fallback time.Duration) time.Duration {
+	value, present := r.labels[label]
+	if !present || value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		r.Invalid(label, "should be a duration like 10s, using %v", fallback)
+		return fallback
+	}
+	if parsed < 0 {
+		r.Invalid(label, "can't be negative, using %v", fallback)
+		return fallback
+	}
+	return parsed
+}
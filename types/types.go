@@ -5,10 +5,50 @@ package types
 type BackendInfo struct {
 	AppId string
 	Node  string
+	// Zone the backend is running in, empty when the provider doesn't know it
+	Zone string
+	// Capacity units of the backend used by the weighted strategies, the share of
+	// traffic a backend gets is it's weight over the total weight of the live backends.
+	// 0 means the provider doesn't know it and is treated as 1, DrainWeight keeps
+	// the backend without sending it any new connections.
+	Weight int
+	// Metadata of the backend from the provider, like version=v2 or canary=true.
+	// The strategies and the clients (with tlb.steer.header) can pick the
+	// backends by it, nil is fine for the providers that don't have any.
+	Metadata map[string]string
+}
+
+// DrainWeight is the weight of a backend that should get no new connections
+// from the weighted strategies, while it's still a backend of the app
+const DrainWeight = -1
+
+// Well known keys of the metadata of a backend
+const (
+	// Zone of the backend, when the provider doesn't set Zone
+	MetaZone = "zone"
+	// Weight of the backend, when the provider doesn't set Weight
+	MetaWeight = "weight"
+	// Version of the app the backend is running
+	MetaVersion = "version"
+	// Host the backend is running on
+	MetaHost = "host"
+)
+
+// Meta returns the metadata of the backend for the key, the Zone included
+func (b *BackendInfo) Meta(key string) (string, bool) {
+	if value, present := b.Metadata[key]; present {
+		return value, true
+	}
+	if key == MetaZone && b.Zone != "" {
+		return b.Zone, true
+	}
+	return "", false
 }
 
 // AppInfo represents the information related to the app
 type AppInfo struct {
 	AppId  string
 	Labels map[string]string
+	// Name of the provider that discovered the app, set by the Manager
+	Provider string
 }
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// TCPProtocol and UDPProtocol are the values of tlb.protocol
+	TCPProtocol = "tcp"
+	UDPProtocol = "udp"
+	// defaultUDPTimeout is how long a UDP session lives without a datagram
+	// either way, when the app doesn't say
+	defaultUDPTimeout = 30 * time.Second
+	// largest datagram we can read
+	maxDatagram = 64 * 1024
+)
+
+// UDPFrontend serves the frontend of an app with tlb.protocol=udp. The first
+// datagram from a client address goes to the backend the strategy of the
+// frontend picks, and starts a session - the ones after it from the same
+// address go to the same backend, and what the backend sends back goes to the
+// client, until the session is idle for tlb.timeout.udp. The backends, their
+// health and the strategy are all of the Frontend.
+type UDPFrontend struct {
+	*Frontend
+	conn *net.UDPConn
+
+	sessionsLock sync.Mutex
+	// sessions by the address of their client
+	sessions map[string]*udpSession
+}
+
+// NewUDPFrontend serves the frontend on the socket, see Serve
+func NewUDPFrontend(frontend *Frontend, conn *net.UDPConn) *UDPFrontend {
+	return &UDPFrontend{
+		Frontend: frontend,
+		conn:     conn,
+		sessions: make(map[string]*udpSession),
+	}
+}
+
+// udpSession is the flow of a client to it's backend. It has a socket of it's
+// own to the backend, so the replies of the backend get back to the client.
+type udpSession struct {
+	client  *net.UDPAddr
+	backend string
+	out     *net.UDPConn
+	// last time a datagram went either way, in unix nanos
+	lastSeen int64
+	// set once it's closed, the reply loop drops it from the table after
+	closed int32
+}
+
+func (s *udpSession) touch() {
+	atomic.StoreInt64(&s.lastSeen, time.Now().UnixNano())
+}
+
+// Close closes the socket of the session, which ends it. It's how a drain
+// of the backend closes the sessions to it.
+func (s *udpSession) Close() error {
+	atomic.StoreInt32(&s.closed, 1)
+	return s.out.Close()
+}
+
+func (s *udpSession) isClosed() bool {
+	return atomic.LoadInt32(&s.closed) == 1
+}
+
+// Serve forwards the datagrams of the clients until the frontend is stopped,
+// or with the error when it can't read from the socket
+func (u *UDPFrontend) Serve() error {
+	defer u.closeSessions()
+	buffer := make([]byte, maxDatagram)
+	for {
+		n, client, err := u.conn.ReadFromUDP(buffer)
+		if err != nil && u.isStopped() {
+			return nil
+		} else if ne, ok := err.(net.Error); ok && ne.Temporary() {
+			log.Printf("[WARN] Unable to read on the frontend of %s - %v\n", u.appId, err)
+			continue
+		} else if err != nil {
+			return err
+		}
+		session := u.session(client)
+		if session == nil {
+			metrics.Counter("frontend-udp-dropped", "app", u.appId).Inc()
+			continue
+		}
+		session.touch()
+		if _, err := session.out.Write(buffer[:n]); err != nil {
+			metrics.Counter("frontend-udp-dropped", "app", u.appId).Inc()
+		}
+	}
+}
+
+// session returns the session of the client, starting one with the backend
+// the strategy picks if it has none (or it was closed, like by a drain of
+// it's backend). Returns nil when there's no backend to send it to, or the
+// limits of the app don't let a new session in.
+func (u *UDPFrontend) session(client *net.UDPAddr) *udpSession {
+	key := client.String()
+	u.sessionsLock.Lock()
+	session := u.sessions[key]
+	if session != nil && !session.isClosed() {
+		u.sessionsLock.Unlock()
+		return session
+	}
+	u.sessionsLock.Unlock()
+	// only the Serve loop starts them, so no one else can for the client in the meantime
+	if !u.admitSession(time.Now()) {
+		return nil
+	}
+	backend := u.LookupFor(client.IP.String(), nil)
+	if backend == "" {
+		return nil
+	}
+	out, err := dialUDP(backend)
+	u.recordDial(backend, err)
+	if err != nil {
+		log.Printf("[ERROR] udp: cannot connect to upstream - %v\n", err)
+		metrics.Counter("frontend-dial-errors", "app", u.appId).Inc()
+		return nil
+	}
+	session = &udpSession{client: client, backend: backend, out: out}
+	session.touch()
+	u.sessionsLock.Lock()
+	u.sessions[key] = session
+	u.sessionsLock.Unlock()
+	// a session counts as an open connection of the app for tlb.limit.connections
+	atomic.AddInt64(u.open, 1)
+	u.acquire(backend, session)
+	metrics.Counter("frontend-udp-sessions", "app", u.appId).Inc()
+	go u.reply(key, session)
+	return session
+}
+
+// dialUDP opens a socket connected to the backend
+func dialUDP(backend string) (*net.UDPConn, error) {
+	addr, err := net.ResolveUDPAddr("udp", backend)
+	if err != nil {
+		return nil, err
+	}
+	return net.DialUDP("udp", nil, addr)
+}
+
+// reply sends what the backend of the session sends back to it's client,
+// until the session is idle for the timeout of the app or it's closed
+func (u *UDPFrontend) reply(key string, session *udpSession) {
+	defer u.closeSession(key, session)
+	buffer := make([]byte, maxDatagram)
+	for {
+		// the timeout might have changed with the labels
+		idleUntil := time.Unix(0, atomic.LoadInt64(&session.lastSeen)).Add(u.Config().UDPTimeout)
+		if !time.Now().Before(idleUntil) {
+			return
+		}
+		session.out.SetReadDeadline(idleUntil)
+		n, err := session.out.Read(buffer)
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			// the client might have sent something since
+			continue
+		} else if err != nil {
+			return
+		}
+		session.touch()
+		if _, err := u.conn.WriteToUDP(buffer[:n], session.client); err != nil {
+			metrics.Counter("frontend-udp-dropped", "app", u.appId).Inc()
+		}
+	}
+}
+
+// closeSession drops the session from the table and closes it
+func (u *UDPFrontend) closeSession(key string, session *udpSession) {
+	u.sessionsLock.Lock()
+	if u.sessions[key] == session {
+		delete(u.sessions, key)
+	}
+	u.sessionsLock.Unlock()
+	session.Close()
+	u.release(session.backend, session)
+	atomic.AddInt64(u.open, -1)
+}
+
+// closeSessions closes all the sessions, their reply loops drop them
+func (u *UDPFrontend) closeSessions() {
+	u.sessionsLock.Lock()
+	defer u.sessionsLock.Unlock()
+	for _, session := range u.sessions {
+		session.Close()
+	}
+}
+
+// activeSessions returns the number of sessions in the table
+func (u *UDPFrontend) activeSessions() int {
+	u.sessionsLock.Lock()
+	defer u.sessionsLock.Unlock()
+	return len(u.sessions)
+}
+
+// listenUDP binds the port of a frontend with tlb.protocol=udp, the UDP
+// counterpart of Listen
+func (f *Frontend) listenUDP() error {
+	conn, err := listenPacketReusePort(":" + f.port)
+	if err != nil {
+		return err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.stopped {
+		conn.Close()
+		return errFrontendStopped
+	}
+	f.packets = conn
+	metrics.Gauge("frontend-bound", "app", f.appId).Set(1)
+	metrics.Gauge("frontend-listeners").Inc()
+	return nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ashwanthkumar/golang-utils/sets"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUDPFrontendToKeepAClientOnItsBackendTillTheSessionIsIdle(t *testing.T) {
+	first := startUDPBackend(t, "first")
+	defer first.Close()
+	second := startUDPBackend(t, "second")
+	defer second.Close()
+
+	frontend := NewFrontend("/udp-app", "0", sets.FromSlice([]string{first.LocalAddr().String(), second.LocalAddr().String()}),
+		NewFrontendConfig(map[string]string{"tlb.protocol": "udp", "tlb.timeout.udp": "100ms"}))
+	sessions := metrics.Counter("frontend-udp-sessions", "app", "/udp-app")
+	before := sessions.Value()
+	udp := serveUDP(t, frontend)
+	defer frontend.Stop()
+
+	client := dialUDPFrontend(t, frontend)
+	defer client.Close()
+	backend := exchange(t, client, "hello")
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, backend, exchange(t, client, "hello"))
+	}
+	// round robin gives the next client the other backend
+	other := dialUDPFrontend(t, frontend)
+	defer other.Close()
+	assert.NotEqual(t, backend, exchange(t, other, "hello"))
+	assert.Equal(t, before+2, sessions.Value())
+	assert.Equal(t, 2, udp.activeSessions())
+
+	for i := 0; udp.activeSessions() > 0; i++ {
+		if i == 100 {
+			t.Fatal("the idle sessions weren't dropped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// and the client gets a new one
+	exchange(t, client, "hello")
+	assert.Equal(t, before+3, sessions.Value())
+}
+
+func TestUDPFrontendToCloseTheSessionsOfADrainedBackend(t *testing.T) {
+	backend := startUDPBackend(t, "only")
+	defer backend.Close()
+	frontend := NewFrontend("/udp-drain", "0", sets.FromSlice([]string{backend.LocalAddr().String()}),
+		NewFrontendConfig(map[string]string{"tlb.protocol": "udp"}))
+	udp := serveUDP(t, frontend)
+	defer frontend.Stop()
+
+	client := dialUDPFrontend(t, frontend)
+	defer client.Close()
+	assert.Equal(t, "only", exchange(t, client, "hello"))
+	assert.Equal(t, 1, frontend.activeConnections(backend.LocalAddr().String()))
+	drained, err := frontend.DrainBackend(backend.LocalAddr().String(), 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.False(t, drained)
+	for i := 0; udp.activeSessions() > 0; i++ {
+		if i == 100 {
+			t.Fatal("the session of the drained backend wasn't closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 0, frontend.activeConnections(backend.LocalAddr().String()))
+}
+
+func TestUDPFrontendToApplyTheLimitsToTheNewSessions(t *testing.T) {
+	backend := startUDPBackend(t, "only")
+	defer backend.Close()
+	frontend := NewFrontend("/udp-limited", "0", sets.FromSlice([]string{backend.LocalAddr().String()}),
+		NewFrontendConfig(map[string]string{"tlb.protocol": "udp", "tlb.limit.connections": "1", "tlb.limit.connections.mode": "queue"}))
+	limited := metrics.Counter("frontend-connection-limited", "app", "/udp-limited")
+	before := limited.Value()
+	udp := serveUDP(t, frontend)
+	defer frontend.Stop()
+
+	client := dialUDPFrontend(t, frontend)
+	defer client.Close()
+	assert.Equal(t, "only", exchange(t, client, "hello"))
+	// the one over the limit is dropped instead of queued, so the session
+	// already in keeps going
+	other := dialUDPFrontend(t, frontend)
+	defer other.Close()
+	assertNoReply(t, other, "hello")
+	assert.Equal(t, "only", exchange(t, client, "hello"))
+	assert.Equal(t, 1, udp.activeSessions())
+	assert.Equal(t, before+1, limited.Value())
+
+	frontend.UpdateConfig(NewFrontendConfig(map[string]string{"tlb.protocol": "udp", "tlb.limit.rate": "1", "tlb.limit.burst": "1"}))
+	rateLimited := metrics.Counter("frontend-ratelimited", "app", "/udp-limited")
+	before = rateLimited.Value()
+	assert.Equal(t, "only", exchange(t, other, "hello"))
+	third := dialUDPFrontend(t, frontend)
+	defer third.Close()
+	assertNoReply(t, third, "hello")
+	assert.Equal(t, before+1, rateLimited.Value())
+}
+
+func TestUDPFrontendToStartANewSessionInPlaceOfAClosedOne(t *testing.T) {
+	backend := startUDPBackend(t, "only")
+	defer backend.Close()
+	frontend := NewFrontend("/udp-closed", "0", sets.FromSlice([]string{backend.LocalAddr().String()}),
+		NewFrontendConfig(map[string]string{"tlb.protocol": "udp"}))
+	udp := serveUDP(t, frontend)
+	defer frontend.Stop()
+
+	client := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	session := udp.session(client)
+	assert.NotNil(t, session)
+	assert.Equal(t, session, udp.session(client))
+	// like a drain of the backend, before the reply loop drops it
+	session.Close()
+	fresh := udp.session(client)
+	assert.NotNil(t, fresh)
+	assert.NotEqual(t, session, fresh)
+	assert.False(t, fresh.isClosed())
+}
+
+func TestFrontendConfigToTurnOffWhatUDPCannotDo(t *testing.T) {
+	config := newFrontendConfig(map[string]string{"tlb.protocol": "udp", "tlb.tls.cert": "a.pem", "tlb.tls.key": "a.key"}, HealthCheckConfig{Interval: time.Second, Timeout: time.Second})
+	assert.Equal(t, UDPProtocol, config.Protocol)
+	assert.Equal(t, "", config.TLSCert)
+	// the checks of the flags are turned off quietly
+	assert.Equal(t, time.Duration(0), config.HealthCheck.Interval)
+	assert.Len(t, config.LabelErrors, 1)
+
+	config = newFrontendConfig(map[string]string{"tlb.protocol": "udp", "tlb.healthcheck.interval": "5s", "tlb.timeout.udp": "0s"}, DefaultHealthCheck)
+	assert.Equal(t, time.Duration(0), config.HealthCheck.Interval)
+	assert.Equal(t, defaultUDPTimeout, config.UDPTimeout)
+	assert.Len(t, config.LabelErrors, 2)
+	assert.True(t, NewFrontendConfig(nil).needsRestart(config))
+}
+
+// startUDPBackend answers every datagram with the name
+func startUDPBackend(t *testing.T, name string) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buffer := make([]byte, 1024)
+		for {
+			_, client, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDP([]byte(name), client)
+		}
+	}()
+	return conn
+}
+
+// serveUDP binds the frontend and serves it in the background
+func serveUDP(t *testing.T, frontend *Frontend) *UDPFrontend {
+	if err := frontend.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	udp := NewUDPFrontend(frontend, frontend.packets)
+	go udp.Serve()
+	return udp
+}
+
+func dialUDPFrontend(t *testing.T, frontend *Frontend) *net.UDPConn {
+	_, port, _ := net.SplitHostPort(frontend.Bind())
+	addr, _ := net.ResolveUDPAddr("udp", "127.0.0.1:"+port)
+	client, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// exchange sends the datagram and returns the reply
+func exchange(t *testing.T, client *net.UDPConn, datagram string) string {
+	_, err := client.Write([]byte(datagram))
+	assert.NoError(t, err)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reply := make([]byte, 1024)
+	n, err := client.Read(reply)
+	assert.NoError(t, err)
+	return string(reply[:n])
+}
+
+// assertNoReply sends the datagram and fails if anything comes back
+func assertNoReply(t *testing.T, client *net.UDPConn, datagram string) {
+	_, err := client.Write([]byte(datagram))
+	assert.NoError(t, err)
+	client.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, err = client.Read(make([]byte, 1024))
+	assert.Error(t, err)
+}
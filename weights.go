@@ -0,0 +1,84 @@
+package main
+
+import "math"
+
+const (
+	// the weights are the capacity units of the backends, the share of a
+	// backend shifts with the total weight of the others
+	RelativeWeights = "relative"
+	// the weights are the percentages of the traffic a backend should get,
+	// the backends without one share what's left
+	ShareWeights = "shares"
+)
+
+// shareUnits is what all the shares add up to once normalized, in per mille
+// so the strategies can work with integer weights
+const shareUnits = 1000
+
+// effectiveWeights returns the weights the strategies should use for the
+// declared weights of the backends. With shares, the backends with a weight
+// get that percentage of the traffic no matter how many backends there are,
+// and the rest split what's left equally. When the shares add up to more than
+// 100% (or nobody is left to take the rest) they're scaled to fit. Every
+// backend gets a weight of at least 1, so none of them gets starved - except
+// the drained ones, which get 0 as long as there's someone else to route to.
+func effectiveWeights(declared map[string]int, shares bool) map[string]int {
+	routed := make(map[string]int, len(declared))
+	for backend, weight := range declared {
+		if weight >= 0 {
+			routed[backend] = weight
+		}
+	}
+	if len(routed) == 0 {
+		// all of them are drained, they're better than none at all
+		for backend := range declared {
+			routed[backend] = 0
+		}
+	}
+	effective := weighted(routed, shares)
+	for backend := range declared {
+		if _, present := effective[backend]; !present {
+			effective[backend] = 0
+		}
+	}
+	return effective
+}
+
+// weighted is effectiveWeights for the backends that aren't drained
+func weighted(declared map[string]int, shares bool) map[string]int {
+	effective := make(map[string]int, len(declared))
+	if !shares {
+		for backend, weight := range declared {
+			if weight < 1 {
+				weight = 1
+			}
+			effective[backend] = weight
+		}
+		return effective
+	}
+
+	total, unset := 0, 0
+	for _, weight := range declared {
+		if weight > 0 {
+			total += weight
+		} else {
+			unset++
+		}
+	}
+	scale := float64(shareUnits) / 100
+	remaining := float64(shareUnits) - float64(total)*scale
+	if total > 100 || (unset == 0 && total > 0) {
+		scale = float64(shareUnits) / float64(total)
+		remaining = 0
+	}
+	for backend, weight := range declared {
+		units := 0.0
+		if weight > 0 {
+			units = float64(weight) * scale
+		} else {
+			units = remaining / float64(unset)
+		}
+		effective[backend] = int(math.Max(1, math.Round(units)))
+	}
+	return effective
+}
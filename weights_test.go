@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/ashwanthkumar/gotlb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEffectiveWeightsToKeepRelativeWeights(t *testing.T) {
+	assert.Equal(t, map[string]int{"a": 3, "b": 1}, effectiveWeights(map[string]int{"a": 3, "b": 0}, false))
+}
+
+func TestEffectiveWeightsToSplitWhatsLeftOfTheShares(t *testing.T) {
+	assert.Equal(t, map[string]int{"canary": 100, "a": 450, "b": 450}, effectiveWeights(map[string]int{"canary": 10, "a": 0, "b": 0}, true))
+	// without a share for anyone they're all equal
+	assert.Equal(t, map[string]int{"a": 500, "b": 500}, effectiveWeights(map[string]int{"a": 0, "b": 0}, true))
+}
+
+func TestEffectiveWeightsToScaleTheSharesThatDontFit(t *testing.T) {
+	assert.Equal(t, map[string]int{"a": 750, "b": 250, "c": 1}, effectiveWeights(map[string]int{"a": 90, "b": 30, "c": 0}, true))
+	// nobody to take the rest
+	assert.Equal(t, map[string]int{"a": 1000}, effectiveWeights(map[string]int{"a": 10}, true))
+}
+
+func TestEffectiveWeightsToStarveTheDrainedBackends(t *testing.T) {
+	drained := types.DrainWeight
+	assert.Equal(t, map[string]int{"a": 3, "b": 1, "c": 0}, effectiveWeights(map[string]int{"a": 3, "b": 0, "c": drained}, false))
+	assert.Equal(t, map[string]int{"a": 100, "b": 900, "c": 0}, effectiveWeights(map[string]int{"a": 10, "b": 0, "c": drained}, true))
+	// with only the drained ones left they're all routed
+	assert.Equal(t, map[string]int{"a": 1, "b": 1}, effectiveWeights(map[string]int{"a": drained, "b": drained}, false))
+}
+
+func TestWeightedStrategiesToSendNoNewConnectionsToTheDrainedBackends(t *testing.T) {
+	for _, name := range []string{WeightedName, MaglevName} {
+		s := buildStrategy("/drained", name, NewFrontendConfig(map[string]string{}))
+		updateBackends(s, []*types.BackendInfo{{Node: "a"}, {Node: "b", Weight: types.DrainWeight}, {Node: "c", Weight: 2}}, nil)
+		routed := make(map[string]int)
+		for i := 0; i < 300; i++ {
+			routed[nextFor(s, fmt.Sprintf("10.0.0.%d", i), nil)]++
+		}
+		assert.Equal(t, 0, routed["b"], name)
+		assert.True(t, routed["a"] > 0 && routed["c"] > routed["a"], name)
+	}
+}
+
+func TestWeightedStrategiesToKeepTheShareOfTheCanaryAcrossMembershipChanges(t *testing.T) {
+	for _, name := range []string{WeightedName, MaglevName} {
+		s := buildStrategy("/canary", name, NewFrontendConfig(map[string]string{"tlb.weights": "shares"}))
+		updateBackends(s, []*types.BackendInfo{{Node: "canary", Weight: 10}}, nil)
+		for stable := 1; stable <= 20; stable++ {
+			updateBackends(s, []*types.BackendInfo{{Node: fmt.Sprintf("stable-%d", stable)}}, nil)
+			if stable%5 != 0 {
+				continue
+			}
+			shares := make(map[string]float64)
+			for i := 0; i < 10000; i++ {
+				shares[nextFor(s, fmt.Sprintf("10.0.%d.%d", i/256, i%256), nil)] += 1.0 / 10000
+			}
+			assert.True(t, math.Abs(shares["canary"]-0.1) < 0.02, fmt.Sprintf("%s with %d stable backends gave the canary %v", name, stable, shares["canary"]))
+		}
+	}
+}
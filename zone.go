@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Zones knows the zone GoTLB is running in and how to find the zone of a
+// backend from its address, using the subnets that belong to each zone.
+type Zones struct {
+	Local string
+	cidrs []*net.IPNet
+	names []string
+}
+
+// ParseZones creates Zones for the local zone and a comma separated list
+// of zone=cidr pairs - "us-east-1a=10.0.1.0/24,us-east-1b=10.0.2.0/24"
+func ParseZones(local, cidrs string) (*Zones, error) {
+	zones := &Zones{Local: local}
+	for _, pair := range strings.Split(cidrs, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid zone mapping %s, expected zone=cidr", pair)
+		}
+		_, cidr, err := net.ParseCIDR(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid cidr for zone %s - %v", parts[0], err)
+		}
+		zones.names = append(zones.names, parts[0])
+		zones.cidrs = append(zones.cidrs, cidr)
+	}
+	return zones, nil
+}
+
+// ZoneOf returns the zone of the backend node (host:port), empty when we don't know
+func (z *Zones) ZoneOf(node string) string {
+	host, _, err := net.SplitHostPort(node)
+	if err != nil {
+		host = node
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	for idx, cidr := range z.cidrs {
+		if cidr.Contains(ip) {
+			return z.names[idx]
+		}
+	}
+	return ""
+}
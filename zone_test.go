@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZonesToFindTheZoneOfABackend(t *testing.T) {
+	zones, err := ParseZones("zone-a", "zone-a=10.0.1.0/24, zone-b=10.0.2.0/24,zone-b=fd00::/64")
+	assert.NoError(t, err)
+	assert.Equal(t, "zone-a", zones.ZoneOf("10.0.1.10:8080"))
+	assert.Equal(t, "zone-b", zones.ZoneOf("10.0.2.10:8080"))
+	assert.Equal(t, "zone-b", zones.ZoneOf("[fd00::1]:8080"))
+	assert.Equal(t, "", zones.ZoneOf("10.0.3.10:8080"))
+	assert.Equal(t, "", zones.ZoneOf("some-host:8080"))
+}
+
+func TestParseZonesShouldFailOnInvalidMappings(t *testing.T) {
+	_, err := ParseZones("zone-a", "zone-a")
+	assert.Error(t, err)
+	_, err = ParseZones("zone-a", "zone-a=10.0.1.0")
+	assert.Error(t, err)
+}